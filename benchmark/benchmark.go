@@ -7,11 +7,11 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/database"
 )
 
 const (
+	dbPath           = "testDB"
 	numPutOperations = 1000000
 	numGetOperations = 1000
 	numRounds        = 5
@@ -25,7 +25,11 @@ func main() {
 	var totalWriteNs int64
 	var totalReadNs int64
 
-	db := database.Open("testDB")
+	db, err := database.Open(dbPath, nil)
+	if err != nil {
+		fmt.Printf("Open error: %v\n", err)
+		return
+	}
 	kvMap := make(map[string][]byte, numPutOperations*numRounds)
 	keys := make([]string, numPutOperations*numRounds)
 
@@ -73,7 +77,7 @@ func main() {
 
 	// 平均输出
 	fmt.Println("==============================================")
-	fmt.Printf(" 测试目录   : %s\n", config.GetRootPath())
+	fmt.Printf(" 测试目录   : %s\n", dbPath)
 	fmt.Printf(" 循环轮数   : %d\n", numRounds)
 	fmt.Printf(" 写入总数   : %d\n", totalWriteOps)
 	fmt.Printf(" 写入耗时   : %s (平均)\n", totalWriteTime/time.Duration(numRounds))
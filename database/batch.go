@@ -0,0 +1,150 @@
+// WriteBatch 将多次写操作打包为一次原子写入。
+// Database.Write 会为批次中的所有操作分配连续的序列号，作为一条 WAL 记录写入并应用到同一个 MemTable，
+// 崩溃恢复时批次要么全部生效，要么全部不生效。
+// 批次的序列化格式如下，所有整数均为小端编码，key 和 value 使用 4 字节长度前缀：
+// 记录的 type 即 kv.Kind。
+/*
+┌─────────────┬──────────┬──────────┬─────┐
+│ count (4B)  │ record 1 │ record 2 │ ... │
+└─────────────┴──────────┴──────────┴─────┘
+Put/Merge:           │ type (1B) │ key │ value   │
+Delete/SingleDelete: │ type (1B) │ key │
+DeleteRange:         │ type (1B) │ begin key │ end key │
+*/
+
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const batchHeaderSize = 4
+
+// WriteBatch 按写入顺序记录一组 Put/Delete/SingleDelete/Merge/DeleteRange 操作，不是并发安全的
+type WriteBatch struct {
+	rep []byte
+}
+
+// NewWriteBatch 创建一个空的 WriteBatch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		rep: make([]byte, batchHeaderSize),
+	}
+}
+
+// LoadWriteBatch 从 Data 返回的序列化数据中还原 WriteBatch
+func LoadWriteBatch(data []byte) (*WriteBatch, error) {
+	if len(data) < batchHeaderSize {
+		return nil, fmt.Errorf("invalid write batch size: %d", len(data))
+	}
+	batch := &WriteBatch{
+		rep: append([]byte(nil), data...),
+	}
+	// 提前校验每条记录，避免写入时才发现格式错误
+	if err := batch.iterate(func(kv.Kind, kv.Key, kv.Value) {}); err != nil {
+		log.Errorf("load write batch error: %s", err.Error())
+		return nil, fmt.Errorf("load write batch error: %w", err)
+	}
+	return batch, nil
+}
+
+// Put 记录写入 key/value
+func (b *WriteBatch) Put(key string, value []byte) {
+	b.append(kv.KindPut, kv.Key(key), value)
+}
+
+// Delete 记录删除 key
+func (b *WriteBatch) Delete(key string) {
+	b.append(kv.KindDelete, kv.Key(key), nil)
+}
+
+// SingleDelete 记录删除一个自上次删除以来只写入过一次的 key。
+// 合并时 SingleDelete 会与其下的 Put 一起被丢弃；对被多次写入的 key 使用 SingleDelete 的结果是未定义的。
+func (b *WriteBatch) SingleDelete(key string) {
+	b.append(kv.KindSingleDelete, kv.Key(key), nil)
+}
+
+// Merge 记录 key 的一个合并操作数，读取时由 Database 的 MergeOperator 与旧值合并
+func (b *WriteBatch) Merge(key string, operand []byte) {
+	b.append(kv.KindMerge, kv.Key(key), operand)
+}
+
+// DeleteRange 记录删除 [begin, end) 区间内的所有 key，只影响批次写入时已经存在的数据以及批次中排在它之前的操作
+func (b *WriteBatch) DeleteRange(begin, end string) {
+	b.append(kv.KindRangeDelete, kv.Key(begin), kv.Value(end))
+}
+
+// Clear 清空批次中的所有操作
+func (b *WriteBatch) Clear() {
+	b.rep = b.rep[:batchHeaderSize]
+	binary.LittleEndian.PutUint32(b.rep, 0)
+}
+
+// Count 返回批次中的操作数量
+func (b *WriteBatch) Count() int {
+	return int(binary.LittleEndian.Uint32(b.rep))
+}
+
+// Data 返回批次的序列化数据，可通过 LoadWriteBatch 还原
+func (b *WriteBatch) Data() []byte {
+	return append([]byte(nil), b.rep...)
+}
+
+// hasMerge 返回批次中是否包含 Merge 操作
+func (b *WriteBatch) hasMerge() bool {
+	found := false
+	_ = b.iterate(func(kind kv.Kind, _ kv.Key, _ kv.Value) {
+		found = found || kind == kv.KindMerge
+	})
+	return found
+}
+
+func (b *WriteBatch) append(kind kv.Kind, key kv.Key, value kv.Value) {
+	b.rep = append(b.rep, byte(kind))
+	b.rep = binary.LittleEndian.AppendUint32(b.rep, uint32(len(key)))
+	b.rep = append(b.rep, key...)
+	if kind != kv.KindDelete && kind != kv.KindSingleDelete {
+		b.rep = binary.LittleEndian.AppendUint32(b.rep, uint32(len(value)))
+		b.rep = append(b.rep, value...)
+	}
+	binary.LittleEndian.PutUint32(b.rep, uint32(b.Count()+1))
+}
+
+// iterate 按写入顺序解码批次中的每一条操作，DeleteRange 的 value 为区间的结束 key
+func (b *WriteBatch) iterate(fn func(kind kv.Kind, key kv.Key, value kv.Value)) error {
+	r := bytes.NewReader(b.rep[batchHeaderSize:])
+	for i := 0; i < b.Count(); i++ {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("decode write batch record %d type: %w", i, err)
+		}
+		kind := kv.Kind(op)
+
+		var key kv.Key
+		if _, err = key.DecodeFrom(r); err != nil {
+			return fmt.Errorf("decode write batch record %d key: %w", i, err)
+		}
+
+		var value kv.Value
+		switch kind {
+		case kv.KindPut, kv.KindMerge, kv.KindRangeDelete:
+			if err = value.DecodeFrom(r); err != nil {
+				return fmt.Errorf("decode write batch record %d value: %w", i, err)
+			}
+		case kv.KindDelete, kv.KindSingleDelete:
+		default:
+			return fmt.Errorf("unknown write batch record %d type: %d", i, op)
+		}
+
+		fn(kind, key, value)
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("write batch has %d trailing bytes", r.Len())
+	}
+	return nil
+}
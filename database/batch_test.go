@@ -0,0 +1,115 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+func TestWriteBatchEncoding(t *testing.T) {
+	batch := NewWriteBatch()
+	assert.Equal(t, 0, batch.Count())
+
+	batch.Put("a", []byte("1"))
+	batch.Delete("b")
+	batch.DeleteRange("c", "d")
+	batch.SingleDelete("e")
+	batch.Merge("f", []byte("+1"))
+	assert.Equal(t, 5, batch.Count())
+
+	loaded, err := LoadWriteBatch(batch.Data())
+	assert.NoError(t, err)
+	assert.Equal(t, batch.Data(), loaded.Data())
+
+	var kinds []kv.Kind
+	var keys, values []string
+	assert.NoError(t, loaded.iterate(func(kind kv.Kind, key kv.Key, value kv.Value) {
+		kinds = append(kinds, kind)
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+	}))
+	assert.Equal(t, []kv.Kind{kv.KindPut, kv.KindDelete, kv.KindRangeDelete, kv.KindSingleDelete, kv.KindMerge}, kinds)
+	assert.Equal(t, []string{"a", "b", "c", "e", "f"}, keys)
+	assert.Equal(t, []string{"1", "", "d", "", "+1"}, values)
+
+	batch.Clear()
+	assert.Equal(t, 0, batch.Count())
+	assert.Len(t, batch.Data(), batchHeaderSize)
+
+	// 截断的数据无法还原
+	data := loaded.Data()
+	_, err = LoadWriteBatch(data[:len(data)-1])
+	assert.Error(t, err)
+	_, err = LoadWriteBatch(nil)
+	assert.Error(t, err)
+
+	// 未知的记录类型无法还原
+	data[batchHeaderSize] = 0xff
+	_, err = LoadWriteBatch(data)
+	assert.Error(t, err)
+}
+
+func TestDatabaseWrite(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	assert.NoError(t, db.Put("batch_a", []byte("old_a")))
+	assert.NoError(t, db.Put("batch_c", []byte("old_c")))
+	assert.NoError(t, db.Put("batch_e", []byte("old_e")))
+	before, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	defer db.ReleaseSnapshot(before)
+
+	batch := NewWriteBatch()
+	batch.Put("batch_a", []byte("new_a"))
+	batch.Put("batch_b", []byte("new_b"))
+	// 删除区间覆盖已有的 batch_c 和批次中先写入的 batch_b
+	batch.DeleteRange("batch_b", "batch_d")
+	batch.Put("batch_c", []byte("new_c"))
+	batch.Delete("batch_e")
+	assert.NoError(t, db.Write(batch))
+
+	it := db.NewIterator("batch_", "batch_~")
+	keys, values := collect(it)
+	it.Close()
+	assert.Equal(t, []string{"batch_a", "batch_c"}, keys)
+	assert.Equal(t, []string{"new_a", "new_c"}, values)
+
+	// 批次中的所有操作都在快照之后，快照完全看不到它们
+	it = before.NewIterator("batch_", "batch_~")
+	keys, values = collect(it)
+	it.Close()
+	assert.Equal(t, []string{"batch_a", "batch_c", "batch_e"}, keys)
+	assert.Equal(t, []string{"old_a", "old_c", "old_e"}, values)
+
+	// 空批次不分配序列号
+	seq := db.MemTables.LastSequence()
+	assert.NoError(t, db.Write(NewWriteBatch()))
+	assert.NoError(t, db.Write(nil))
+	assert.Equal(t, seq, db.MemTables.LastSequence())
+}
+
+func TestDatabaseWriteRecovery(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	batch := NewWriteBatch()
+	batch.Put("batch_recover_a", []byte("a"))
+	batch.Put("batch_recover_b", []byte("b"))
+	batch.Delete("batch_recover_a")
+	assert.NoError(t, db.Write(batch))
+
+	// 重启数据库
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Recover())
+
+	val, err := db2.Get("batch_recover_a")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	val, err = db2.Get("batch_recover_b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
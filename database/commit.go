@@ -0,0 +1,167 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+	"github.com/xmh1011/go-lsm/memtable"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+// maxGroupCommitBytes 限制一次组提交合并的数据量，避免 leader 的写入时延被过大的组拉长
+const maxGroupCommitBytes = 1 << 20
+
+// WriteOptions 控制单次写入的持久化方式
+type WriteOptions struct {
+	// Sync 为 true 时写入返回之前 WAL 已经 fsync 到磁盘，不受数据库的落盘策略限制
+	Sync bool
+	// DisableWAL 为 true 时跳过 WAL，写入在刷盘之前崩溃会丢失
+	DisableWAL bool
+}
+
+// writer 是一个等待提交的写入请求
+type writer struct {
+	pairs []kv.KeyValuePair
+	opts  WriteOptions
+	flush bool // 不写入数据，封存当前 MemTable 交给后台刷盘
+	err   error
+	done  bool
+	wake  chan struct{} // 提交完成或成为 leader 时被唤醒
+}
+
+// commit 使用 leader/follower 模式提交写入：排在队首的写入者成为 leader，
+// 将队列中兼容的写入合并为一组，作为一条 WAL 记录写入并只 fsync 一次，然后唤醒组内的 follower；
+// 队列中剩余的第一个写入者成为下一个 leader。同一组内的写入分配连续的序列号，组的提交对读者是原子的。
+func (d *Database) commit(w *writer) error {
+	d.commitMu.Lock()
+	d.writers = append(d.writers, w)
+	leader := len(d.writers) == 1
+	d.commitMu.Unlock()
+
+	if !leader {
+		<-w.wake
+		if w.done {
+			return w.err
+		}
+	}
+
+	// 当前写入者位于队首，成为 leader
+	group, pairs, opts := d.buildGroup()
+	var imem *memtable.IMemTable
+	var err error
+	if group[0].flush {
+		imem = d.MemTables.Seal()
+	} else {
+		imem, err = d.MemTables.InsertBatchWithOptions(pairs, memtable.WriteOptions{
+			Sync:       opts.Sync,
+			DisableWAL: opts.DisableWAL,
+		})
+	}
+	if err != nil {
+		log.Errorf("commit group of %d writes error: %s", len(group), err.Error())
+		err = fmt.Errorf("commit group of %d writes error: %w", len(group), err)
+	}
+
+	// 封存的 IMemTable 交给后台刷盘，不阻塞当前写入
+	d.scheduleFlush(imem)
+
+	d.commitMu.Lock()
+	for _, follower := range group[1:] {
+		follower.err = err
+		follower.done = true
+		follower.wake <- struct{}{}
+	}
+	d.writers = d.writers[len(group):]
+	if len(d.writers) > 0 {
+		d.writers[0].wake <- struct{}{}
+	}
+	d.commitMu.Unlock()
+
+	return err
+}
+
+// buildGroup 从队首开始收集与 leader 兼容的写入，返回组内的写入者、合并后的记录以及组的写入选项。
+// 跳过 WAL 的写入与写 WAL 的写入不能合并；组内任意一个写入要求 Sync 时整组都会 fsync。
+func (d *Database) buildGroup() ([]*writer, []kv.KeyValuePair, WriteOptions) {
+	d.commitMu.Lock()
+	defer d.commitMu.Unlock()
+
+	leader := d.writers[0]
+	opts := leader.opts
+	pairs := append([]kv.KeyValuePair(nil), leader.pairs...)
+	size := estimatePairsSize(leader.pairs)
+	group := []*writer{leader}
+	// 封存请求单独成组
+	if leader.flush {
+		return group, pairs, opts
+	}
+	for _, w := range d.writers[1:] {
+		if w.flush || w.opts.DisableWAL != opts.DisableWAL {
+			break
+		}
+		size += estimatePairsSize(w.pairs)
+		if size > maxGroupCommitBytes {
+			break
+		}
+		opts.Sync = opts.Sync || w.opts.Sync
+		pairs = append(pairs, w.pairs...)
+		group = append(group, w)
+	}
+	return group, pairs, opts
+}
+
+func estimatePairsSize(pairs []kv.KeyValuePair) uint64 {
+	var size uint64
+	for i := range pairs {
+		size += pairs[i].EstimateSize()
+	}
+	return size
+}
+
+// SetSyncPolicy 设置数据库级别的 WAL 落盘策略。SyncInterval 模式下由后台协程定时 fsync。
+func (d *Database) SetSyncPolicy(policy wal.SyncPolicy) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := policy.Validate(); err != nil {
+		log.Errorf("invalid sync policy: %s", err.Error())
+		return fmt.Errorf("invalid sync policy: %w", err)
+	}
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
+
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+
+	if d.syncStop != nil {
+		close(d.syncStop)
+		d.syncStop = nil
+	}
+	d.MemTables.SetSyncPolicy(policy)
+	if policy.Mode == wal.SyncInterval {
+		d.syncStop = make(chan struct{})
+		go d.syncLoop(policy.Interval, d.syncStop)
+	}
+	return nil
+}
+
+// syncLoop 每隔 interval 将当前 WAL 中尚未落盘的数据 fsync 到磁盘，直到 stop 被关闭
+func (d *Database) syncLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := d.MemTables.SyncWAL(); err != nil {
+				log.Errorf("periodic wal sync error: %s", err.Error())
+			}
+		}
+	}
+}
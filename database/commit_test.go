@@ -0,0 +1,93 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+func TestGroupCommitConcurrentWriters(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	start := db.MemTables.LastSequence()
+
+	const writers, writes = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				batch := NewWriteBatch()
+				batch.Put(fmt.Sprintf("group_%d_%03d", w, i), []byte(fmt.Sprintf("%d", i)))
+				assert.NoError(t, db.WriteWithOptions(&WriteOptions{Sync: i%2 == 0}, batch))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// 每个写入分配且只分配一个序列号，写入全部可见
+	assert.Equal(t, start+writers*writes, db.MemTables.LastSequence())
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writes; i++ {
+			val, err := db.Get(fmt.Sprintf("group_%d_%03d", w, i))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("%d", i)), val)
+		}
+	}
+	assert.Empty(t, db.writers)
+}
+
+func TestWriteDisableWAL(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	batch := NewWriteBatch()
+	batch.Put("nowal_a", []byte("a"))
+	assert.NoError(t, db.WriteWithOptions(&WriteOptions{DisableWAL: true}, batch))
+	assert.NoError(t, db.Put("nowal_b", []byte("b")))
+
+	val, err := db.Get("nowal_a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// 跳过 WAL 的写入在关闭时刷盘，恢复后仍然存在
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
+	defer db2.Close()
+	assert.NoError(t, db2.Recover())
+	assert.Equal(t, 1, db2.SSTables.Level0FileCount())
+	val, err = db2.Get("nowal_a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = db2.Get("nowal_b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), val)
+}
+
+func TestSetSyncPolicy(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	assert.Error(t, db.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncInterval}))
+	assert.Error(t, db.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncBytes, Bytes: -1}))
+
+	// 定时落盘模式启动后台协程，切换策略时停止
+	assert.NoError(t, db.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncInterval, Interval: 5 * time.Millisecond}))
+	assert.NotNil(t, db.syncStop)
+	assert.NoError(t, db.Put("sync_a", []byte("a")))
+	time.Sleep(20 * time.Millisecond)
+
+	for _, policy := range []wal.SyncPolicy{
+		{Mode: wal.SyncEveryWrite},
+		{Mode: wal.SyncBytes, Bytes: 4096},
+		wal.DefaultSyncPolicy(),
+	} {
+		assert.NoError(t, db.SetSyncPolicy(policy))
+		assert.Nil(t, db.syncStop)
+		assert.NoError(t, db.Put("sync_"+policy.Mode.String(), []byte(policy.Mode.String())))
+	}
+}
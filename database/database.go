@@ -1,86 +1,537 @@
 package database
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/memtable"
 	"github.com/xmh1011/go-lsm/sstable"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+var (
+	// ErrNoMergeOperator 表示写入或读取 Merge 记录时没有设置 MergeOperator
+	ErrNoMergeOperator = errors.New("merge operator is not set")
+	// ErrClosed 表示数据库已经关闭
+	ErrClosed = errors.New("database is closed")
+	// ErrLocked 表示数据库目录已被其他进程或同一进程中的其他实例以读写模式打开
+	ErrLocked = errors.New("database is locked by another instance")
+	// ErrReadOnly 表示数据库以只读模式打开，不允许写入
+	ErrReadOnly = errors.New("database is opened in read-only mode")
 )
 
+// lockFileName 是数据库目录下用于互斥的锁文件
+const lockFileName = "LOCK"
+
 type Database struct {
-	name      string
+	path      string
+	opts      *Options
+	lock      *os.File // 目录锁，只读模式下为 nil
 	MemTables *memtable.Manager
 	SSTables  *sstable.Manager
+	snapshots *snapshotList
+
+	// mergeOperator 用于在读取时合并 Merge 操作数，需要在读写之前通过 SetMergeOperator 设置
+	mergeOperator kv.MergeOperator
+
+	// commitMu 保护等待组提交的写入队列 writers，队首的写入者是当前的 leader
+	commitMu sync.Mutex
+	writers  []*writer
+
+	// syncMu 保护定时落盘协程的停止信号
+	syncMu   sync.Mutex
+	syncStop chan struct{}
+
+	// flushMu 保护后台刷盘队列及写入减速的状态，flushCond 在刷盘进度变化时广播
+	flushMu             sync.Mutex
+	flushCond           *sync.Cond
+	flushQueue          []*memtable.IMemTable // 等待刷盘的 IMemTable，按封存顺序排列
+	compactionRequested bool                  // 停写的写入者请求后台执行 Level0 合并
+	compactionRuns      uint64                // 后台为停写的写入者执行合并的次数
+	bgErr               error                 // 后台刷盘遇到的错误
+	stall               WriteStallOptions
+	flushClosing        bool          // Close 要求刷盘协程处理完队列后退出
+	flushDone           chan struct{} // 刷盘协程退出时关闭
+
+	// closeMu 保证 Close 与读写互斥：读写持有读锁，Close 持有写锁，之后的读写返回 ErrClosed
+	closeMu sync.RWMutex
+	closed  bool
 }
 
-func Open(name string) *Database {
-	return &Database{
-		name:      name,
-		MemTables: memtable.NewMemTableManager(),
-		SSTables:  sstable.NewSSTableManager(),
+// Open 打开位于 path 目录的数据库，opts 为 nil 时使用 DefaultOptions。
+// 读写模式下目录不存在时会被创建，并通过目录下的 LOCK 文件保证同一时间只有一个实例写入，
+// 目录已被其他实例打开时返回 ErrLocked。只读模式不加锁，也不创建或修改任何文件，写入返回 ErrReadOnly。
+// 已有的数据需要调用 Recover 加载。
+func Open(path string, opts *Options) (*Database, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	opts = opts.withDirs(path)
+	if err := opts.Validate(); err != nil {
+		log.Errorf("invalid options for database %s: %s", path, err.Error())
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	var lock *os.File
+	if opts.ReadOnly {
+		if _, err := os.Stat(path); err != nil {
+			log.Errorf("open read-only database %s error: %s", path, err.Error())
+			return nil, fmt.Errorf("open read-only database %s error: %w", path, err)
+		}
+	} else {
+		for _, dir := range []string{path, opts.WALDir, opts.SSTableDir} {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				log.Errorf("create directory %s error: %s", dir, err.Error())
+				return nil, fmt.Errorf("create directory %s error: %w", dir, err)
+			}
+		}
+		var err error
+		if lock, err = lockFile(filepath.Join(path, lockFileName)); err != nil {
+			log.Errorf("lock database %s error: %s", path, err.Error())
+			return nil, fmt.Errorf("lock database %s error: %w", path, err)
+		}
+	}
+
+	db := &Database{
+		path:          path,
+		opts:          opts,
+		lock:          lock,
+		SSTables:      sstable.NewSSTableManager(opts.sstableOptions()),
+		snapshots:     newSnapshotList(),
+		mergeOperator: opts.MergeOperator,
+		stall:         opts.WriteStall,
+		flushDone:     make(chan struct{}),
+	}
+	if opts.ReadOnly {
+		db.MemTables = memtable.NewReadOnlyMemTableManager(opts.WALDir, opts.MemTableSize)
+	} else {
+		db.MemTables = memtable.NewMemTableManager(opts.WALDir, opts.MemTableSize)
+	}
+	db.flushCond = sync.NewCond(&db.flushMu)
+	db.SSTables.SetSnapshots(db.snapshots.sequences)
+	go db.flushLoop()
+	if opts.ReadOnly {
+		return db, nil
+	}
+	if err := db.SetSyncPolicy(opts.SyncPolicy); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
+	// FIFO 合并的 TTL 需要定期检查，空闲的数据库中过期的数据也会被删除
+	db.SSTables.StartTTLCheck()
+	return db, nil
+}
+
+// Options 返回数据库打开时使用的配置，目录已经补全
+func (d *Database) Options() Options {
+	return *d.opts
 }
 
 func (d *Database) Get(key string) ([]byte, error) {
-	value := d.MemTables.Search(kv.Key(key))
-	if value != nil {
-		return value, nil
+	if err := d.acquire(); err != nil {
+		return nil, err
 	}
+	defer d.release()
 
-	value, err := d.SSTables.Search(kv.Key(key))
-	if err != nil {
-		log.Errorf("search key %s in sstable error: %s", key, err.Error())
+	return d.getWithSeq(kv.Key(key), kv.MaxSequence)
+}
+
+// GetSnapshot 基于当前已写入的最新序列号创建快照，数据库关闭之后返回 ErrClosed
+func (d *Database) GetSnapshot() (*Snapshot, error) {
+	if err := d.acquire(); err != nil {
 		return nil, err
 	}
-	if value == nil {
+	defer d.release()
+
+	snapshot := &Snapshot{
+		db:  d,
+		seq: d.MemTables.LastSequence(),
+	}
+	d.snapshots.acquire(snapshot.seq)
+	return snapshot, nil
+}
+
+// ReleaseSnapshot 释放快照，之后合并可以丢弃仅对该快照可见的历史版本。重复或并发释放是安全的。
+func (d *Database) ReleaseSnapshot(snapshot *Snapshot) {
+	if snapshot == nil || !snapshot.released.CompareAndSwap(false, true) {
+		return
+	}
+	d.snapshots.release(snapshot.seq)
+}
+
+// SetMergeOperator 设置合并 Merge 操作数使用的算子，需要在打开数据库后、读写之前调用
+func (d *Database) SetMergeOperator(operator kv.MergeOperator) {
+	d.mergeOperator = operator
+}
+
+// getWithSeq 查找序列号不大于 seq 的最新版本
+func (d *Database) getWithSeq(key kv.Key, seq uint64) ([]byte, error) {
+	// 命中 MemTable 中的记录（包括删除标记）时不再查找 SSTable
+	pair, found := d.MemTables.SearchPair(key, seq)
+	if !found {
+		sstPair, err := d.SSTables.SearchPair(key, seq)
+		if err != nil {
+			log.Errorf("search key %s in sstable error: %s", key, err.Error())
+			return nil, err
+		}
+		if sstPair == nil {
+			return nil, nil
+		}
+		pair = *sstPair
+	}
+
+	// 序列号更大的区间删除标记覆盖了找到的版本，只检查 key 范围包含 key 的 SSTable
+	covering := max(d.MemTables.CoveringSequence(key, seq), d.SSTables.CoveringSequence(key, seq))
+	if pair.IsDeleted() || pair.Seq < covering {
 		return nil, nil
 	}
+	if pair.Kind == kv.KindMerge {
+		return d.getMergedWithSeq(key, seq)
+	}
 
-	return value, nil
+	return pair.Value, nil
+}
+
+// getMergedWithSeq 最新版本为 Merge 操作数时，通过合并迭代器收集该 key 的所有操作数并合并
+func (d *Database) getMergedWithSeq(key kv.Key, seq uint64) ([]byte, error) {
+	it := d.newIteratorWithSeq(key, key+"\x00", seq)
+	defer it.Close()
+
+	if err := it.Error(); err != nil {
+		log.Errorf("merge key %s error: %s", key, err.Error())
+		return nil, err
+	}
+	if !it.Valid() || it.Key() != key {
+		return nil, nil
+	}
+	return it.Value(), nil
 }
 
 func (d *Database) Put(key string, value []byte) error {
-	imem, err := d.MemTables.Insert(kv.KeyValuePair{Key: kv.Key(key), Value: value})
-	if err != nil {
-		log.Errorf("insert key %s error: %s", key, err.Error())
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return d.Write(batch)
+}
+
+func (d *Database) Delete(key string) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return d.Write(batch)
+}
+
+// SingleDelete 删除一个自上次删除以来只写入过一次的 key，语义见 WriteBatch.SingleDelete
+func (d *Database) SingleDelete(key string) error {
+	batch := NewWriteBatch()
+	batch.SingleDelete(key)
+	return d.Write(batch)
+}
+
+// Merge 写入 key 的一个合并操作数，需要先通过 SetMergeOperator 设置合并算子
+func (d *Database) Merge(key string, operand []byte) error {
+	batch := NewWriteBatch()
+	batch.Merge(key, operand)
+	return d.Write(batch)
+}
+
+// DeleteRange 删除 [begin, end) 区间内的所有 key
+func (d *Database) DeleteRange(begin, end string) error {
+	batch := NewWriteBatch()
+	batch.DeleteRange(begin, end)
+	return d.Write(batch)
+}
+
+// Write 使用默认的写入选项原子地应用批次中的所有操作，见 WriteWithOptions
+func (d *Database) Write(batch *WriteBatch) error {
+	return d.WriteWithOptions(nil, batch)
+}
+
+// WriteWithOptions 原子地应用批次中的所有操作：批次作为一条 WAL 记录写入，
+// 并分配连续的序列号写入同一个 MemTable，读者和快照要么看到整个批次，要么完全看不到。
+// 并发的写入会通过组提交合并，共享一次 WAL 写入和 fsync。opts 为 nil 时使用默认选项。
+func (d *Database) WriteWithOptions(opts *WriteOptions, batch *WriteBatch) error {
+	if batch == nil || batch.Count() == 0 {
+		return nil
+	}
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.mergeOperator == nil && batch.hasMerge() {
+		return ErrNoMergeOperator
+	}
+	if err := d.acquire(); err != nil {
 		return err
 	}
-	d.createNewSSTable(imem)
-	return nil
+	defer d.release()
+
+	if err := d.makeRoomForWrite(); err != nil {
+		return err
+	}
+
+	pairs, err := d.resolveBatch(batch)
+	if err != nil {
+		log.Errorf("resolve write batch error: %s", err.Error())
+		return fmt.Errorf("resolve write batch error: %w", err)
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	return d.commit(&writer{
+		pairs: pairs,
+		opts:  *opts,
+		wake:  make(chan struct{}, 1),
+	})
 }
 
-func (d *Database) Delete(key string) error {
-	imem, err := d.MemTables.Delete(kv.Key(key))
+// resolveBatch 将批次转换为按顺序写入的记录。
+// DeleteRange 写入一条区间删除标记，Key 为起始 key，Value 为结束 key，空区间被忽略。
+func (d *Database) resolveBatch(batch *WriteBatch) ([]kv.KeyValuePair, error) {
+	pairs := make([]kv.KeyValuePair, 0, batch.Count())
+	err := batch.iterate(func(kind kv.Kind, key kv.Key, value kv.Value) {
+		if kind == kv.KindRangeDelete && key >= kv.Key(value) {
+			return
+		}
+		pairs = append(pairs, kv.KeyValuePair{Key: key, Value: value, Kind: kind})
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	d.createNewSSTable(imem)
-	return nil
+	return pairs, nil
+}
+
+// NewIterator 返回遍历 [lower, upper) 区间的有序迭代器，空字符串表示该侧不设边界。
+// 迭代器合并了 MemTable、所有 IMemTable 以及各层 SSTable，同一 key 只返回最新的值，
+// 并隐藏已被删除的 key。迭代器只能看到创建时已经完成的写入，之后的写入（包括正在写入的批次）对其不可见。
+// 返回的迭代器已定位到第一个 key，使用完毕后需要调用 Close。
+func (d *Database) NewIterator(lower, upper string) Iterator {
+	return d.openIterator(kv.Key(lower), kv.Key(upper), kv.MaxSequence)
+}
+
+// openIterator 在数据库未关闭时创建迭代器，已关闭时返回 Error 为 ErrClosed 的空迭代器
+func (d *Database) openIterator(lower, upper kv.Key, seq uint64) Iterator {
+	if err := d.acquire(); err != nil {
+		return &MergeIterator{err: err}
+	}
+	defer d.release()
+
+	if seq == kv.MaxSequence {
+		// 固定为已经完整写入 MemTable 的最新序列号，迭代期间并发写入的数据不会只出现一部分
+		seq = d.MemTables.LastSequence()
+	}
+	return d.newIteratorWithSeq(lower, upper, seq)
 }
 
+// newIteratorWithSeq 返回只能看到序列号不大于 seq 的版本的迭代器
+func (d *Database) newIteratorWithSeq(lower, upper kv.Key, seq uint64) Iterator {
+	iters := make([]internalIterator, 0)
+	for _, it := range d.MemTables.NewIterators() {
+		iters = append(iters, memTableIterator{it})
+	}
+	for _, it := range d.SSTables.NewIterators(lower, upper) {
+		iters = append(iters, it)
+	}
+	tombstones := append(d.MemTables.RangeTombstones(), d.SSTables.RangeTombstones()...)
+	return newMergeIterator(iters, tombstones, d.mergeOperator, lower, upper, seq)
+}
+
+// Recover 使用默认的 wal.TolerateCorruptedTailRecords 模式恢复数据库
 func (d *Database) Recover() error {
+	_, err := d.RecoverWithMode(wal.TolerateCorruptedTailRecords)
+	return err
+}
+
+// RecoverWithMode 按指定的 WAL 恢复模式恢复数据库，返回丢弃了数据的 WAL 文件的恢复报告
+func (d *Database) RecoverWithMode(mode wal.RecoveryMode) ([]*wal.RecoveryReport, error) {
 	// 1. 恢复内存中的 MemTable
-	if err := d.MemTables.Recover(); err != nil {
+	reports, err := d.MemTables.RecoverWithMode(mode)
+	if err != nil {
 		log.Errorf("recover memtable error: %s", err.Error())
-		return err
+		return reports, err
+	}
+	for _, report := range reports {
+		log.Warnf("wal %s dropped %d records (%d bytes) in %s mode", report.Path, report.DroppedRecords, report.DroppedBytes, mode)
 	}
 
 	// 2. 恢复磁盘中的 SSTable
-	if err := d.SSTables.Recover(); err != nil {
+	if err = d.SSTables.Recover(); err != nil {
 		log.Errorf("recover sstable error: %s", err.Error())
+		return reports, err
+	}
+
+	// 3. 已刷盘的 WAL 会被删除，序列号需要从 SSTable 中接续
+	d.MemTables.SetLastSequence(d.SSTables.MaxSequence())
+
+	// 4. 从旧 WAL 恢复的 IMemTable 在恢复完成之前写入 Level0，旧 WAL 随之删除；只读模式下它们留在内存中
+	if d.opts.ReadOnly {
+		return reports, nil
+	}
+	for _, imem := range d.MemTables.GetAll() {
+		d.scheduleFlush(imem)
+	}
+	if err = d.waitForFlush(); err != nil {
+		log.Errorf("flush recovered memtables error: %s", err.Error())
+		return reports, fmt.Errorf("flush recovered memtables error: %w", err)
+	}
+
+	return reports, nil
+}
+
+// Flush 将当前 MemTable 封存并写入 Level0，等待所有已封存的 IMemTable 刷盘完成。
+// 使用 WriteOptions.DisableWAL 写入的数据只有刷盘之后才能在重启后恢复。
+func (d *Database) Flush() error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := d.acquire(); err != nil {
 		return err
 	}
+	defer d.release()
 
-	return nil
+	// 封存经过写入队列，与并发写入的封存按顺序进入刷盘队列
+	if err := d.commit(&writer{flush: true, wake: make(chan struct{}, 1)}); err != nil {
+		return err
+	}
+	return d.waitForFlush()
 }
 
-func (d *Database) createNewSSTable(imem *memtable.IMemTable) {
-	if imem == nil {
-		return
+// CompactRangeOptions 是手动合并的参数，含义见 sstable.CompactRangeOptions
+type CompactRangeOptions struct {
+	// TargetLevel 是合并的目标层级，为 0 时合并到最后一层
+	TargetLevel int
+	// Exclusive 为 true 时等待正在进行的自动合并完成之后开始，手动合并期间不运行自动合并
+	Exclusive bool
+}
+
+// CompactRange 先将当前 MemTable 刷盘，再把 [begin, end] 范围内的 SSTable 逐层合并到目标层级，
+// 用于批量删除之后回收空间，或者在备份之前整理数据。begin 或 end 为 nil 时该方向不设边界，opts 为 nil 时使用默认参数。
+// 独占的手动合并期间 Level0 的文件不会被自动合并，写入可能因此减速或停写，直到手动合并结束
+func (d *Database) CompactRange(begin, end *string, opts *CompactRangeOptions) error {
+	if d.opts.ReadOnly {
+		return ErrReadOnly
 	}
-	err := d.SSTables.CreateNewSSTable(imem)
-	if err != nil {
-		log.Errorf("create new sstable error: %s", err.Error())
+	if opts == nil {
+		opts = &CompactRangeOptions{}
+	}
+	if err := d.Flush(); err != nil {
+		log.Errorf("flush before compact range error: %s", err.Error())
+		return fmt.Errorf("flush before compact range error: %w", err)
+	}
+	if err := d.acquire(); err != nil {
+		return err
+	}
+	defer d.release()
+
+	var beginKey, endKey *kv.Key
+	if begin != nil {
+		key := kv.Key(*begin)
+		beginKey = &key
+	}
+	if end != nil {
+		key := kv.Key(*end)
+		endKey = &key
+	}
+	err := d.SSTables.CompactRange(beginKey, endKey, sstable.CompactRangeOptions(*opts))
+
+	// 独占的手动合并期间停写的写入不请求合并，结束之后唤醒它们重新检查
+	d.flushMu.Lock()
+	d.flushCond.Broadcast()
+	d.flushMu.Unlock()
+	return err
+}
+
+// Close 关闭数据库：等待进行中的读写完成，停止定时落盘，刷完已封存的 IMemTable，
+// 等待后台合并结束，将 WAL 落盘并关闭，最后释放目录锁。当前 MemTable 通常不刷盘，重启后从 WAL 恢复；
+// 如果其中有使用 WriteOptions.DisableWAL 写入的数据，则先封存并刷盘，刷盘失败时这些数据会丢失，Close 返回错误。
+// 关闭之后所有读写返回 ErrClosed，迭代器需要在 Close 之前关闭。
+func (d *Database) Close() error {
+	d.closeMu.Lock()
+	if d.closed {
+		d.closeMu.Unlock()
+		return ErrClosed
+	}
+	d.closed = true
+	d.closeMu.Unlock()
+	defer d.unlock()
+
+	// 1. 停止定时落盘协程
+	d.syncMu.Lock()
+	if d.syncStop != nil {
+		close(d.syncStop)
+		d.syncStop = nil
+	}
+	d.syncMu.Unlock()
+
+	// 2. 未写 WAL 的数据无法从 WAL 恢复，交给刷盘协程写入 Level0
+	unlogged := d.MemTables.SealUnlogged()
+	d.scheduleFlush(unlogged)
+
+	// 3. 刷盘协程处理完队列后退出
+	d.flushMu.Lock()
+	d.flushClosing = true
+	d.flushCond.Broadcast()
+	d.flushMu.Unlock()
+	<-d.flushDone
+	// 刷盘协程在第一次失败后停止，留在内存中的 unlogged 说明其数据没有写入 Level0
+	lost := unlogged != nil && d.hasIMemTable(unlogged)
+
+	// 4. 等待异步合并结束
+	d.SSTables.Close()
+
+	// 5. 落盘并关闭 WAL
+	if err := d.MemTables.Close(); err != nil {
+		log.Errorf("close memtables error: %s", err.Error())
+		return fmt.Errorf("close memtables error: %w", err)
+	}
+
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+	if lost {
+		log.Errorf("flush memtable %d written without WAL failed, its data is lost: %s", unlogged.ID(), d.bgErr.Error())
+		return fmt.Errorf("flush memtable %d written without WAL failed, its data is lost: %w", unlogged.ID(), d.bgErr)
+	}
+	return d.bgErr
+}
+
+// hasIMemTable 判断 imem 是否仍未刷盘
+func (d *Database) hasIMemTable(imem *memtable.IMemTable) bool {
+	for _, t := range d.MemTables.GetAll() {
+		if t == imem {
+			return true
+		}
+	}
+	return false
+}
+
+// unlock 释放目录锁，之后其他实例可以以读写模式打开数据库
+func (d *Database) unlock() {
+	if d.lock == nil {
 		return
 	}
+	if err := unlockFile(d.lock); err != nil {
+		log.Errorf("unlock database %s error: %s", d.path, err.Error())
+	}
+	d.lock = nil
+}
+
+// acquire 在数据库未关闭时持有 closeMu 的读锁，之后需要调用 release 释放
+func (d *Database) acquire() error {
+	d.closeMu.RLock()
+	if d.closed {
+		d.closeMu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (d *Database) release() {
+	d.closeMu.RUnlock()
 }
@@ -2,8 +2,11 @@ package database
 
 import (
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/xmh1011/go-lsm/wal"
 )
 
 // BenchmarkPut 测试 Put 操作的吞吐量和平均时延
@@ -11,7 +14,7 @@ func BenchmarkPut(b *testing.B) {
 	// 使用临时目录作为数据库存储目录
 	dir := b.TempDir()
 
-	db := Open(dir)
+	db := openTestDB(b, dir)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -30,7 +33,7 @@ func BenchmarkGet(b *testing.B) {
 	// 使用临时目录作为数据库存储目录
 	dir := b.TempDir()
 
-	db := Open(dir)
+	db := openTestDB(b, dir)
 
 	// 预先写入固定数量的 key-value，后续循环中周期性获取这些 key 的数据
 	keyCount := 100000
@@ -66,7 +69,7 @@ func BenchmarkDelete(b *testing.B) {
 	// 使用临时目录作为数据库存储目录
 	dir := b.TempDir()
 
-	db := Open(dir)
+	db := openTestDB(b, dir)
 
 	keyCount := 100000
 	for i := 0; i < keyCount; i++ {
@@ -95,3 +98,44 @@ func BenchmarkDelete(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// BenchmarkWriteSyncPolicies 对比不同落盘策略下并发写入的吞吐量，并发写入通过组提交共享 fsync
+func BenchmarkWriteSyncPolicies(b *testing.B) {
+	cases := []struct {
+		name   string
+		policy wal.SyncPolicy
+		opts   *WriteOptions
+	}{
+		{"Never", wal.SyncPolicy{Mode: wal.SyncNever}, nil},
+		{"EveryWrite", wal.SyncPolicy{Mode: wal.SyncEveryWrite}, nil},
+		{"Interval10ms", wal.SyncPolicy{Mode: wal.SyncInterval, Interval: 10 * time.Millisecond}, nil},
+		{"Bytes64KB", wal.SyncPolicy{Mode: wal.SyncBytes, Bytes: 64 << 10}, nil},
+		{"WriteOptionsSync", wal.SyncPolicy{Mode: wal.SyncNever}, &WriteOptions{Sync: true}},
+		{"DisableWAL", wal.SyncPolicy{Mode: wal.SyncNever}, &WriteOptions{DisableWAL: true}},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			db := openTestDB(b, b.TempDir())
+			if err := db.SetSyncPolicy(c.policy); err != nil {
+				b.Fatalf("SetSyncPolicy error: %v", err)
+			}
+			defer func() { _ = db.SetSyncPolicy(wal.DefaultSyncPolicy()) }()
+
+			var counter atomic.Int64
+			value := []byte("value")
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					batch := NewWriteBatch()
+					batch.Put("sync_bench_"+strconv.FormatInt(counter.Add(1), 10), value)
+					if err := db.WriteWithOptions(c.opts, batch); err != nil {
+						b.Fatalf("Write error: %v", err)
+					}
+				}
+			})
+			b.StopTimer()
+		})
+	}
+}
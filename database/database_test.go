@@ -1,15 +1,35 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/memtable"
+	"github.com/xmh1011/go-lsm/sstable"
+	"github.com/xmh1011/go-lsm/wal"
 )
 
+// openTestDB 使用默认配置打开 dir 下的数据库，重启类的测试使用同一个 dir 重新打开
+func openTestDB(tb testing.TB, dir string) *Database {
+	tb.Helper()
+	db, err := Open(dir, nil)
+	if err != nil {
+		tb.Fatalf("open database %s error: %v", dir, err)
+	}
+	return db
+}
+
 // TestDatabasePutGetDelete 测试 Put、Get 和 Delete 的功能
 func TestDatabasePutGetDelete(t *testing.T) {
-	db := Open("test")
+	db := openTestDB(t, t.TempDir())
 
 	// 测试查询不存在的 key，预期返回 nil
 	val, err := db.Get("nonexistent")
@@ -44,7 +64,7 @@ func TestDatabasePutGetDelete(t *testing.T) {
 }
 
 func TestDatabaseDeleteNonExistentKey(t *testing.T) {
-	db := Open("test")
+	db := openTestDB(t, t.TempDir())
 
 	err := db.Delete("ghostKey")
 	assert.NoError(t, err)
@@ -55,13 +75,15 @@ func TestDatabaseDeleteNonExistentKey(t *testing.T) {
 }
 
 func TestDatabasePersistenceAcrossRecovery(t *testing.T) {
-	db := Open("test")
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
 
 	err := db.Put("hello", []byte("world"))
 	assert.NoError(t, err)
 
 	// 重启数据库
-	db2 := Open("test")
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
 	err = db2.Recover()
 	assert.NoError(t, err)
 
@@ -71,7 +93,7 @@ func TestDatabasePersistenceAcrossRecovery(t *testing.T) {
 }
 
 func TestDatabaseRecoveryOnEmpty(t *testing.T) {
-	db := Open("test")
+	db := openTestDB(t, t.TempDir())
 
 	// 不放数据，直接 Recover 应无异常
 	err := db.Recover()
@@ -79,24 +101,484 @@ func TestDatabaseRecoveryOnEmpty(t *testing.T) {
 }
 
 func TestDatabaseFlushToSSTable(t *testing.T) {
-	db := Open("test")
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
 
 	// 构造足够大的数据使 MemTable 满而触发 Flush
 	value := make([]byte, 1024*1024) // 1MB，2条即可超限
 	for i := 0; i < 3; i++ {
-		err := db.Put(fmt.Sprintf("key%d", i), value)
+		err := db.Put(fmt.Sprintf("flush_key%d", i), value)
 		assert.NoError(t, err)
 	}
+	// 等待后台刷盘完成
+	assert.NoError(t, db.waitForFlush())
+	assert.Equal(t, 0, db.MemTables.IMemTableCount())
 
 	// 模拟“重启”并只恢复 SSTable 内容
-	db2 := Open("tmp")
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
 	err := db2.Recover()
 	assert.NoError(t, err)
 
 	// 所有 key 应可查到
 	for i := 0; i < 3; i++ {
-		val, err := db2.Get(fmt.Sprintf("key%d", i))
+		val, err := db2.Get(fmt.Sprintf("flush_key%d", i))
 		assert.NoError(t, err)
 		assert.Equal(t, value, val)
 	}
 }
+
+// TestDatabaseTombstoneLikeValue 测试与旧版删除标记字符串相同的用户值不会被当作删除
+func TestDatabaseTombstoneLikeValue(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	value := []byte("～DELETED～")
+	assert.NoError(t, db.Put("kind_magic", value))
+
+	val, err := db.Get("kind_magic")
+	assert.NoError(t, err)
+	assert.Equal(t, value, val)
+
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Recover())
+	val, err = db2.Get("kind_magic")
+	assert.NoError(t, err)
+	assert.Equal(t, value, val)
+}
+
+// TestDatabaseDeleteRange 测试区间删除对读取、快照和恢复的影响
+func TestDatabaseDeleteRange(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	for _, key := range []string{"kind_r1", "kind_r2", "kind_r3"} {
+		assert.NoError(t, db.Put(key, []byte(key)))
+	}
+	snapshot, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	defer db.ReleaseSnapshot(snapshot)
+
+	assert.NoError(t, db.DeleteRange("kind_r1", "kind_r3"))
+	assert.NoError(t, db.Put("kind_r2", []byte("again")))
+
+	check := func(db *Database) {
+		val, err := db.Get("kind_r1")
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+		val, err = db.Get("kind_r2")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("again"), val)
+		val, err = db.Get("kind_r3")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("kind_r3"), val)
+
+		it := db.NewIterator("kind_r", "kind_r~")
+		keys, _ := collect(it)
+		it.Close()
+		assert.Equal(t, []string{"kind_r2", "kind_r3"}, keys)
+	}
+	check(db)
+
+	// 快照不受之后的区间删除影响
+	val, err := snapshot.Get("kind_r1")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("kind_r1"), val)
+
+	// 区间删除标记写入 WAL，恢复后仍然生效
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Recover())
+	check(db2)
+}
+
+// TestDatabaseRangeTombstoneInSSTable 测试 SSTable 中的区间删除标记覆盖 MemTable 中的旧版本
+func TestDatabaseRangeTombstoneInSSTable(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	assert.NoError(t, db.Put("kind_s1", []byte("v")))
+
+	seq := db.MemTables.LastSequence() + 1
+	mem := memtable.NewMemTable(0, t.TempDir())
+	assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: "kind_s1", Value: kv.Value("kind_s2"), Seq: seq, Kind: kv.KindRangeDelete}))
+	assert.NoError(t, db.SSTables.CreateNewSSTable(memtable.NewIMemTable(mem)))
+	db.MemTables.SetLastSequence(seq)
+
+	val, err := db.Get("kind_s1")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+// TestDatabaseMergeAndSingleDelete 测试 Merge 与 SingleDelete
+func TestDatabaseMergeAndSingleDelete(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	assert.ErrorIs(t, db.Merge("kind_m", []byte("x")), ErrNoMergeOperator)
+
+	db.SetMergeOperator(appendOperator{})
+	assert.NoError(t, db.Put("kind_m", []byte("a")))
+	assert.NoError(t, db.Merge("kind_m", []byte("b")))
+	assert.NoError(t, db.Merge("kind_m", []byte("c")))
+	val, err := db.Get("kind_m")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abc"), val)
+
+	// 删除之后的操作数从空值开始合并
+	assert.NoError(t, db.Delete("kind_m"))
+	assert.NoError(t, db.Merge("kind_m", []byte("x")))
+	val, err = db.Get("kind_m")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("x"), val)
+
+	assert.NoError(t, db.Put("kind_sd", []byte("v")))
+	assert.NoError(t, db.SingleDelete("kind_sd"))
+	val, err = db.Get("kind_sd")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+// TestDatabaseFlush 测试 Flush 将当前 MemTable 写入 SSTable
+func TestDatabaseFlush(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	assert.NoError(t, db.Flush())
+
+	batch := NewWriteBatch()
+	batch.Put("close_flush", []byte("v"))
+	assert.NoError(t, db.WriteWithOptions(&WriteOptions{DisableWAL: true}, batch))
+	assert.NoError(t, db.Flush())
+	assert.Equal(t, 0, db.MemTables.IMemTableCount())
+	_, found := db.MemTables.SearchPair("close_flush", kv.MaxSequence)
+	assert.False(t, found)
+
+	val, err := db.Get("close_flush")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), val)
+	assert.NoError(t, db.Close())
+
+	// 跳过 WAL 的写入刷盘之后在重启后可见
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Recover())
+	val, err = db2.Get("close_flush")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), val)
+	assert.NoError(t, db2.Close())
+}
+
+// TestDatabaseVerifyChecksums 测试开启校验之后读取损坏的 SSTable 返回 sstable.ErrCorruption
+func TestDatabaseVerifyChecksums(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.VerifyChecksums = true
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	value := []byte("checksummed-value")
+	assert.NoError(t, db.Put("key", value))
+	assert.NoError(t, db.Flush())
+
+	files, err := filepath.Glob(filepath.Join(dir, sstableDirectory, "0-level", "*.sst"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	pos := bytes.Index(data, value)
+	assert.GreaterOrEqual(t, pos, 0)
+	data[pos] ^= 0x01
+	assert.NoError(t, os.WriteFile(files[0], data, 0644))
+
+	_, err = db.Get("key")
+	assert.ErrorIs(t, err, sstable.ErrCorruption)
+}
+
+// TestDatabaseBlockCache 测试默认配置下重复读取同一个数据块命中块缓存
+func TestDatabaseBlockCache(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	defer db.Close()
+
+	assert.NoError(t, db.Put("key", []byte("value")))
+	assert.NoError(t, db.Flush())
+	for i := 0; i < 3; i++ {
+		value, err := db.Get("key")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+	}
+	stats := db.SSTables.BlockCacheStats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, int64(defaultBlockCacheSize), stats.Capacity)
+}
+
+// TestDatabaseClose 测试关闭之后的读写返回 ErrClosed，且数据可以从 WAL 恢复
+func TestDatabaseClose(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	assert.NoError(t, db.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncInterval, Interval: time.Millisecond}))
+	assert.NoError(t, db.Put("close_a", []byte("a")))
+	snapshot, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	assert.ErrorIs(t, db.Close(), ErrClosed)
+	assert.ErrorIs(t, db.Put("close_b", []byte("b")), ErrClosed)
+	assert.ErrorIs(t, db.Flush(), ErrClosed)
+	assert.ErrorIs(t, db.SetSyncPolicy(wal.DefaultSyncPolicy()), ErrClosed)
+	_, err = db.Get("close_a")
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = db.GetSnapshot()
+	assert.ErrorIs(t, err, ErrClosed)
+	_, err = snapshot.Get("close_a")
+	assert.ErrorIs(t, err, ErrClosed)
+	it := db.NewIterator("", "")
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Error(), ErrClosed)
+	it.Close()
+
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Recover())
+	val, err := db2.Get("close_a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.NoError(t, db2.Close())
+}
+
+// TestDatabaseLock 测试同一个目录同一时间只能被一个实例以读写模式打开
+func TestDatabaseLock(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	assert.FileExists(t, filepath.Join(dir, lockFileName))
+
+	_, err := Open(dir, nil)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// 关闭之后释放锁，可以再次打开
+	assert.NoError(t, db.Close())
+	db2 := openTestDB(t, dir)
+	assert.NoError(t, db2.Close())
+}
+
+// TestDatabaseReadOnly 测试只读模式可以与读写实例同时打开，能读取已有数据且不修改任何文件
+func TestDatabaseReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+	assert.NoError(t, db.Put("ro_flushed", []byte("a")))
+	assert.NoError(t, db.Flush())
+	assert.NoError(t, db.Put("ro_wal", []byte("b")))
+	assert.NoError(t, db.Close())
+
+	writer := openTestDB(t, dir)
+	defer writer.Close()
+
+	walFiles, err := os.ReadDir(writer.Options().WALDir)
+	assert.NoError(t, err)
+	sstFiles, err := os.ReadDir(filepath.Join(writer.Options().SSTableDir, "0-level"))
+	assert.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	reader, err := Open(dir, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, reader.Recover())
+
+	val, err := reader.Get("ro_flushed")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+	val, err = reader.Get("ro_wal")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	assert.ErrorIs(t, reader.Put("ro_new", []byte("c")), ErrReadOnly)
+	assert.ErrorIs(t, reader.Delete("ro_wal"), ErrReadOnly)
+	assert.ErrorIs(t, reader.Flush(), ErrReadOnly)
+	assert.ErrorIs(t, reader.SetSyncPolicy(wal.DefaultSyncPolicy()), ErrReadOnly)
+	assert.NoError(t, reader.Close())
+
+	// 只读实例没有创建、截断或删除任何文件
+	afterWAL, err := os.ReadDir(writer.Options().WALDir)
+	assert.NoError(t, err)
+	assert.Equal(t, walFiles, afterWAL)
+	afterSST, err := os.ReadDir(filepath.Join(writer.Options().SSTableDir, "0-level"))
+	assert.NoError(t, err)
+	assert.Equal(t, sstFiles, afterSST)
+
+	_, err = Open(filepath.Join(dir, "missing"), opts)
+	assert.Error(t, err)
+}
+
+// TestDatabaseRandomizedModel 随机执行写入、删除和刷盘，经过多次 Level0 合并和重启之后结果与 map 模型一致
+func TestDatabaseRandomizedModel(t *testing.T) {
+	seed := time.Now().UnixNano()
+	t.Logf("seed: %d", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.SSTableSize = 512
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+
+	model := make(map[string][]byte)
+	verify := func(db *Database) {
+		for i := 0; i < 64; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			val, err := db.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, model[key], val, "key %s, seed %d", key, seed)
+		}
+	}
+
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("key%02d", rnd.Intn(64))
+			if rnd.Intn(4) == 0 {
+				assert.NoError(t, db.Delete(key))
+				delete(model, key)
+				continue
+			}
+			value := []byte(fmt.Sprintf("%s-%d-%d", key, round, i))
+			assert.NoError(t, db.Put(key, value))
+			model[key] = value
+		}
+		// 每一轮刷盘一次，超过 Level0 上限后触发合并
+		assert.NoError(t, db.Flush())
+		verify(db)
+	}
+	// 已经发生过合并
+	assert.Less(t, db.SSTables.Level0FileCount(), 20)
+	assert.NoError(t, db.Close())
+
+	reopened, err := Open(dir, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Recover())
+	verify(reopened)
+	assert.NoError(t, reopened.Close())
+}
+
+// TestDatabaseUniversalCompaction 测试使用 universal 合并时多次刷盘和重启之后数据完整
+func TestDatabaseUniversalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.CompactionStyle = sstable.CompactionStyleUniversal.String()
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%02d", (round*13+i)%50)
+			assert.NoError(t, db.Put(key, []byte(key)))
+		}
+		assert.NoError(t, db.Flush())
+	}
+	stats := db.SSTables.CompactionStats()
+	assert.Positive(t, stats.Compactions)
+	assert.GreaterOrEqual(t, stats.WriteAmplification(), 1.0)
+	assert.NoError(t, db.Close())
+
+	reopened, err := Open(dir, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Recover())
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		val, err := reopened.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+	assert.NoError(t, reopened.Close())
+}
+
+// TestDatabaseFIFOCompaction 测试使用 FIFO 合并时文件都保留在 Level0，Level0 的文件数量不会导致停写，
+// 总大小超出上限时最旧的数据被删除
+func TestDatabaseFIFOCompaction(t *testing.T) {
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.CompactionStyle = sstable.CompactionStyleFIFO.String()
+	opts.WriteStall.SlowdownL0Files = 2
+	opts.WriteStall.StopL0Files = 2
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("key%d", i), []byte("value")))
+		assert.NoError(t, db.Flush())
+	}
+	assert.Equal(t, 6, db.SSTables.Level0FileCount())
+	stats := db.SSTables.CompactionStats()
+	assert.Zero(t, stats.Compactions)
+	assert.NoError(t, db.Close())
+
+	// 缩小总大小上限之后重新打开，下一次刷盘删除最旧的文件，每个文件的大小相同
+	opts.FIFO.MaxTableFilesSize = stats.LiveBytes / 2
+	reopened, err := Open(dir, opts)
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Recover())
+	assert.NoError(t, reopened.Put("key6", []byte("value")))
+	assert.NoError(t, reopened.Flush())
+	assert.Equal(t, 3, reopened.SSTables.Level0FileCount())
+	for i := 0; i < 7; i++ {
+		val, err := reopened.Get(fmt.Sprintf("key%d", i))
+		assert.NoError(t, err)
+		if i < 4 {
+			assert.Nil(t, val)
+		} else {
+			assert.Equal(t, []byte("value"), val)
+		}
+	}
+	assert.NoError(t, reopened.Close())
+}
+
+// TestDatabaseFIFOCompactionTTL 测试过期之后没有新的写入时，过期的数据也会被删除
+func TestDatabaseFIFOCompactionTTL(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CompactionStyle = sstable.CompactionStyleFIFO.String()
+	opts.FIFO.TTL = 200 * time.Millisecond
+	db, err := Open(t.TempDir(), opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("ttl_key", []byte("value")))
+	assert.NoError(t, db.Flush())
+	val, err := db.Get("ttl_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	assert.Eventually(t, func() bool {
+		return db.SSTables.Level0FileCount() == 0
+	}, 2*time.Second, 20*time.Millisecond)
+	val, err = db.Get("ttl_key")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+// TestDatabaseCompactRange 测试批量删除之后手动合并到最后一层，删除标记和被删除的数据一并丢弃
+func TestDatabaseCompactRange(t *testing.T) {
+	db, err := Open(t.TempDir(), nil)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("key%03d", i), []byte("value")))
+	}
+	assert.NoError(t, db.Flush())
+	for i := 0; i < 90; i++ {
+		assert.NoError(t, db.Delete(fmt.Sprintf("key%03d", i)))
+	}
+	before := db.SSTables.CompactionStats().LiveBytes
+
+	// 未刷盘的删除标记在合并之前刷盘
+	assert.NoError(t, db.CompactRange(nil, nil, &CompactRangeOptions{Exclusive: true}))
+	assert.Zero(t, db.SSTables.Level0FileCount())
+	after := db.SSTables.CompactionStats().LiveBytes
+	assert.Less(t, after, before)
+
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(fmt.Sprintf("key%03d", i))
+		assert.NoError(t, err)
+		if i < 90 {
+			assert.Nil(t, val)
+		} else {
+			assert.Equal(t, []byte("value"), val)
+		}
+	}
+
+	begin, end := "key100", "key000"
+	assert.Error(t, db.CompactRange(&begin, &end, nil))
+	assert.Error(t, db.CompactRange(nil, nil, &CompactRangeOptions{TargetLevel: -1}))
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xmh1011/go-lsm/log"
+	"github.com/xmh1011/go-lsm/memtable"
+	"github.com/xmh1011/go-lsm/sstable"
+)
+
+// slowdownDelay 是触发写入减速时每次写入额外等待的时间
+const slowdownDelay = time.Millisecond
+
+// WriteStallOptions 配置写入减速和停写的触发条件。
+// 后台刷盘跟不上写入时，未刷盘的 IMemTable 或 Level0 文件会不断累积：
+// 达到 Slowdown 阈值时每次写入延迟 1ms，把 CPU 让给后台刷盘和合并；达到 Stop 阈值时写入阻塞，直到数量回落。
+type WriteStallOptions struct {
+	SlowdownIMemTables int `ini:"slowdown_imemtables"` // 未刷盘的 IMemTable 数量达到该值时减速
+	StopIMemTables     int `ini:"stop_imemtables"`     // 未刷盘的 IMemTable 数量达到该值时停写
+	SlowdownL0Files    int `ini:"slowdown_l0_files"`   // Level0 文件数量达到该值时减速
+	StopL0Files        int `ini:"stop_l0_files"`       // Level0 文件数量达到该值时停写
+}
+
+// DefaultWriteStallOptions 返回默认的写入减速和停写阈值
+func DefaultWriteStallOptions() WriteStallOptions {
+	return WriteStallOptions{
+		SlowdownIMemTables: 6,
+		StopIMemTables:     10,
+		SlowdownL0Files:    8,
+		StopL0Files:        12,
+	}
+}
+
+// Validate 检查阈值是否合法：阈值必须为正数，且减速阈值不大于停写阈值
+func (o WriteStallOptions) Validate() error {
+	if o.SlowdownIMemTables <= 0 || o.StopIMemTables <= 0 || o.SlowdownL0Files <= 0 || o.StopL0Files <= 0 {
+		return fmt.Errorf("write stall triggers must be positive: %+v", o)
+	}
+	if o.SlowdownIMemTables > o.StopIMemTables || o.SlowdownL0Files > o.StopL0Files {
+		return fmt.Errorf("slowdown trigger must not exceed stop trigger: %+v", o)
+	}
+	return nil
+}
+
+// SetWriteStallOptions 设置写入减速和停写的触发条件
+func (d *Database) SetWriteStallOptions(opts WriteStallOptions) error {
+	if err := opts.Validate(); err != nil {
+		log.Errorf("invalid write stall options: %s", err.Error())
+		return fmt.Errorf("invalid write stall options: %w", err)
+	}
+
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.stall = opts
+	// 阈值放宽后唤醒被阻塞的写入
+	d.flushCond.Broadcast()
+	return nil
+}
+
+// scheduleFlush 将封存的 IMemTable 交给后台刷盘协程
+func (d *Database) scheduleFlush(imem *memtable.IMemTable) {
+	if imem == nil {
+		return
+	}
+
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.flushQueue = append(d.flushQueue, imem)
+	d.flushCond.Broadcast()
+}
+
+// flushLoop 是后台刷盘协程：按封存顺序将 IMemTable 写为 Level0 SSTable，提交之后将其移出读取路径，
+// 然后执行 Level0 合并，并唤醒被阻塞的写入。刷盘失败的 IMemTable 留在内存中继续参与读取，其 WAL 保留在磁盘上。
+// Close 之后处理完队列中剩余的 IMemTable 再退出。
+func (d *Database) flushLoop() {
+	defer close(d.flushDone)
+
+	for {
+		d.flushMu.Lock()
+		for len(d.flushQueue) == 0 && !d.compactionRequested && !d.flushClosing {
+			d.flushCond.Wait()
+		}
+		if len(d.flushQueue) == 0 && d.flushClosing {
+			d.flushMu.Unlock()
+			return
+		}
+		var imem *memtable.IMemTable
+		if len(d.flushQueue) > 0 {
+			imem = d.flushQueue[0]
+		}
+		d.compactionRequested = false
+		d.flushMu.Unlock()
+
+		var err error
+		if imem != nil {
+			if err = d.SSTables.FlushIMemTable(imem); err == nil {
+				d.MemTables.RemoveIMemTable(imem)
+			}
+		}
+		if err == nil {
+			err = d.SSTables.Compaction()
+		}
+
+		d.flushMu.Lock()
+		if imem != nil {
+			d.flushQueue = d.flushQueue[1:]
+		} else {
+			d.compactionRuns++
+		}
+		if err != nil {
+			log.Errorf("background flush error: %s", err.Error())
+			d.bgErr = fmt.Errorf("background flush error: %w", err)
+		}
+		d.flushCond.Broadcast()
+		d.flushMu.Unlock()
+	}
+}
+
+// makeRoomForWrite 在写入之前检查后台刷盘的积压情况，必要时减速或阻塞写入。
+// 后台刷盘出错之后拒绝所有写入，避免内存中的数据无限增长。
+func (d *Database) makeRoomForWrite() error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	delayed := false
+	for {
+		if d.bgErr != nil {
+			return d.bgErr
+		}
+		imems := d.MemTables.IMemTableCount()
+		l0Files := d.SSTables.Level0FileCount()
+		if d.SSTables.Options().CompactionStyle == sstable.CompactionStyleFIFO {
+			// FIFO 合并的所有文件都保留在 Level0，文件数量由总大小上限和 TTL 控制，不据此减速或停写
+			l0Files = 0
+		}
+		switch {
+		case imems >= d.stall.StopIMemTables || l0Files >= d.stall.StopL0Files:
+			// 没有待刷盘的 IMemTable 时 Level0 只能通过合并回落，请求后台执行一次合并。
+			// 独占的手动合并期间自动合并直接返回，请求只会让刷盘协程空转，等待手动合并结束之后被唤醒
+			if len(d.flushQueue) == 0 && !d.compactionRequested && !d.SSTables.IsExclusiveManual() {
+				d.compactionRequested = true
+				d.flushCond.Broadcast()
+			}
+			log.Debugf("write stopped: %d immutable memtables, %d level0 files", imems, l0Files)
+			d.flushCond.Wait()
+		case !delayed && (imems >= d.stall.SlowdownIMemTables || l0Files >= d.stall.SlowdownL0Files):
+			// 每次写入最多减速一次
+			delayed = true
+			d.flushMu.Unlock()
+			time.Sleep(slowdownDelay)
+			d.flushMu.Lock()
+		default:
+			return nil
+		}
+	}
+}
+
+// waitForFlush 等待所有已封存的 IMemTable 刷盘完成，返回后台刷盘遇到的错误
+func (d *Database) waitForFlush() error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	for len(d.flushQueue) > 0 && d.bgErr == nil {
+		d.flushCond.Wait()
+	}
+	return d.bgErr
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteStallOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultWriteStallOptions().Validate())
+
+	opts := DefaultWriteStallOptions()
+	opts.StopL0Files = 0
+	assert.Error(t, opts.Validate())
+
+	opts = DefaultWriteStallOptions()
+	opts.SlowdownIMemTables = opts.StopIMemTables + 1
+	assert.Error(t, opts.Validate())
+
+	db := openTestDB(t, t.TempDir())
+	assert.Error(t, db.SetWriteStallOptions(opts))
+	assert.Equal(t, DefaultWriteStallOptions(), db.stall)
+}
+
+// TestBackgroundFlush 测试封存的 IMemTable 由后台协程刷盘，刷盘后仍可读取
+func TestBackgroundFlush(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	value := make([]byte, 1024*1024)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("flush_bg_%d", i), value))
+	}
+	assert.NoError(t, db.waitForFlush())
+	assert.Equal(t, 0, db.MemTables.IMemTableCount())
+
+	for i := 0; i < 4; i++ {
+		val, err := db.Get(fmt.Sprintf("flush_bg_%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// TestWriteStopWaitsForFlush 测试达到停写阈值时写入等待刷盘完成，而不是丢弃 IMemTable
+func TestWriteStopWaitsForFlush(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	assert.NoError(t, db.SetWriteStallOptions(WriteStallOptions{
+		SlowdownIMemTables: 1,
+		StopIMemTables:     1,
+		SlowdownL0Files:    100,
+		StopL0Files:        100,
+	}))
+
+	value := make([]byte, 1024*1024)
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("flush_stop_%d", i), value))
+		// 每次写入之前都等到积压的 IMemTable 刷盘，积压量不超过一次封存
+		assert.LessOrEqual(t, db.MemTables.IMemTableCount(), 1)
+	}
+	assert.NoError(t, db.waitForFlush())
+
+	for i := 0; i < 6; i++ {
+		val, err := db.Get(fmt.Sprintf("flush_stop_%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// TestBackgroundErrorRejectsWrites 测试后台刷盘出错后拒绝写入
+func TestBackgroundErrorRejectsWrites(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	bgErr := errors.New("disk failure")
+	db.flushMu.Lock()
+	db.bgErr = bgErr
+	db.flushMu.Unlock()
+
+	assert.ErrorIs(t, db.Put("flush_err", []byte("v")), bgErr)
+	assert.ErrorIs(t, db.waitForFlush(), bgErr)
+}
+
+// TestFlushFailureKeepsWAL 测试刷盘失败时 IMemTable 留在内存中，WAL 保留在磁盘上，重启之后数据不丢失
+func TestFlushFailureKeepsWAL(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	// 用普通文件占据 Level0 目录的位置，使写 SSTable 失败
+	level0Dir := filepath.Join(db.opts.SSTableDir, "0-level")
+	assert.NoError(t, os.WriteFile(level0Dir, nil, 0644))
+
+	assert.NoError(t, db.Put("flush_fail", []byte("v")))
+	assert.Error(t, db.Flush())
+	assert.Equal(t, 1, db.MemTables.IMemTableCount())
+	walFiles, err := filepath.Glob(filepath.Join(db.opts.WALDir, "*.wal"))
+	assert.NoError(t, err)
+	assert.Len(t, walFiles, 2)
+
+	// 刷盘失败的数据仍然可以读到
+	val, err := db.Get("flush_fail")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), val)
+	assert.Error(t, db.Close())
+
+	assert.NoError(t, os.Remove(level0Dir))
+	reopened := openTestDB(t, dir)
+	defer reopened.Close()
+	assert.NoError(t, reopened.Recover())
+	val, err = reopened.Get("flush_fail")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v"), val)
+	assert.Equal(t, 1, reopened.SSTables.Level0FileCount())
+}
+
+func TestCloseFlushFailureLosesUnloggedData(t *testing.T) {
+	dir := t.TempDir()
+	db := openTestDB(t, dir)
+
+	level0Dir := filepath.Join(db.opts.SSTableDir, "0-level")
+	assert.NoError(t, os.WriteFile(level0Dir, nil, 0644))
+
+	batch := NewWriteBatch()
+	batch.Put("nowal", []byte("v"))
+	assert.NoError(t, db.WriteWithOptions(&WriteOptions{DisableWAL: true}, batch))
+
+	// 未写 WAL 的数据刷盘失败，Close 报告数据丢失
+	err := db.Close()
+	assert.ErrorContains(t, err, "its data is lost")
+
+	assert.NoError(t, os.Remove(level0Dir))
+	reopened := openTestDB(t, dir)
+	defer reopened.Close()
+	assert.NoError(t, reopened.Recover())
+	val, err := reopened.Get("nowal")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+// TestWriteStopDuringExclusiveCompactRange 测试独占的手动合并期间停写的写入等待手动合并结束，
+// 而不是反复请求无法执行的自动合并让刷盘协程空转
+func TestWriteStopDuringExclusiveCompactRange(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Level0CompactionTrigger = 2
+	opts.WriteStall.SlowdownL0Files = 2
+	opts.WriteStall.StopL0Files = 2
+	db, err := Open(t.TempDir(), opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Put("stall_a", []byte("a")))
+	assert.NoError(t, db.Flush())
+
+	// 合并读取存活快照时阻塞，使独占的手动合并停在合并过程中
+	entered := make(chan struct{})
+	block := make(chan struct{})
+	var once sync.Once
+	db.SSTables.SetSnapshots(func() []uint64 {
+		once.Do(func() { close(entered) })
+		<-block
+		return nil
+	})
+	compacted := make(chan error, 1)
+	go func() {
+		compacted <- db.CompactRange(nil, nil, &CompactRangeOptions{Exclusive: true})
+	}()
+	<-entered
+
+	// 手动合并期间新的 Level0 文件不会被自动合并，下一次写入停写
+	assert.NoError(t, db.Put("stall_b", []byte("b")))
+	assert.NoError(t, db.Flush())
+	written := make(chan error, 1)
+	go func() {
+		written <- db.Put("stall_c", []byte("c"))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-written:
+		t.Fatal("write should be stopped during exclusive compact range")
+	default:
+	}
+	db.flushMu.Lock()
+	assert.Zero(t, db.compactionRuns)
+	db.flushMu.Unlock()
+
+	// 手动合并结束之后唤醒停写的写入
+	close(block)
+	select {
+	case err := <-compacted:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("compact range did not finish")
+	}
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped write was not woken after compact range")
+	}
+
+	for _, key := range []string{"stall_a", "stall_b", "stall_c"} {
+		val, err := db.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key[len(key)-1:]), val)
+	}
+}
@@ -1,7 +1,11 @@
 package database
 
 import (
+	"fmt"
+	"slices"
+
 	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/memtable"
 )
 
 type Iterator interface {
@@ -9,6 +13,8 @@ type Iterator interface {
 
 	Key() kv.Key
 
+	Value() kv.Value
+
 	Next()
 
 	Seek(key kv.Key)
@@ -17,5 +23,271 @@ type Iterator interface {
 
 	SeekToFirst()
 
+	// Error 返回迭代过程中遇到的第一个错误，出错后迭代器失效
+	Error() error
+
+	Close()
+}
+
+// internalIterator 是合并迭代器的数据源，需要原样返回删除标记和所有历史版本，
+// 并按 key 升序、同一 key 内按序列号降序排列。
+type internalIterator interface {
+	Valid() bool
+	Key() kv.Key
+	Seq() uint64
+	Kind() kv.Kind
+	Value() (kv.Value, error)
+	Next()
+	SeekGE(key kv.Key)
+	SeekLT(key kv.Key)
+	SeekToFirst()
+	SeekToLast()
+	// Error 返回读取数据源时遇到的错误，出错的数据源变为无效
+	Error() error
 	Close()
 }
+
+// memTableIterator 将 memtable.Iterator 适配为 internalIterator
+type memTableIterator struct {
+	*memtable.Iterator
+}
+
+func (i memTableIterator) Value() (kv.Value, error) {
+	return i.Iterator.Value(), nil
+}
+
+func (i memTableIterator) Error() error {
+	return nil
+}
+
+func (i memTableIterator) SeekGE(key kv.Key) {
+	i.Seek(key)
+}
+
+// MergeIterator 对多个有序数据源做多路归并，输出有序、去重且隐藏删除标记的结果。
+// 数据源按新旧排序，下标越小数据越新；同一 key 出现在多个数据源中时，
+// 以最新的数据源中序列号不大于 seq 的最新版本为准，序列号更大的版本对本迭代器不可见。
+// 最新版本之上的连续 Merge 操作数会通过 MergeOperator 合并到该版本上；被区间删除标记覆盖的版本视为已删除。
+// 迭代范围为 [lower, upper)，upper 为空表示没有上界。
+type MergeIterator struct {
+	iters      []internalIterator
+	tombstones []kv.KeyValuePair
+	merger     kv.MergeOperator
+	lower      kv.Key
+	upper      kv.Key
+	seq        uint64
+
+	// 当前位置的 key/value。所有数据源均已越过当前 key，Next 只需继续归并。
+	key   kv.Key
+	value kv.Value
+	valid bool
+	err   error
+}
+
+// NewMergeIterator 创建合并迭代器并定位到第一个 key，seq 为 kv.MaxSequence 时读取最新版本
+func NewMergeIterator(iters []internalIterator, lower, upper kv.Key, seq uint64) *MergeIterator {
+	return newMergeIterator(iters, nil, nil, lower, upper, seq)
+}
+
+// newMergeIterator 创建带区间删除标记和合并算子的合并迭代器
+func newMergeIterator(iters []internalIterator, tombstones []kv.KeyValuePair, merger kv.MergeOperator, lower, upper kv.Key, seq uint64) *MergeIterator {
+	it := &MergeIterator{
+		iters:      iters,
+		tombstones: tombstones,
+		merger:     merger,
+		lower:      lower,
+		upper:      upper,
+		seq:        seq,
+	}
+	it.SeekToFirst()
+	return it
+}
+
+// Valid 返回迭代器是否处于有效位置
+func (m *MergeIterator) Valid() bool {
+	return m.valid
+}
+
+// Key 返回当前 key
+func (m *MergeIterator) Key() kv.Key {
+	if !m.valid {
+		return ""
+	}
+	return m.key
+}
+
+// Value 返回当前 value
+func (m *MergeIterator) Value() kv.Value {
+	if !m.valid {
+		return nil
+	}
+	return m.value
+}
+
+// Error 返回迭代过程中遇到的错误
+func (m *MergeIterator) Error() error {
+	return m.err
+}
+
+// Next 移动到下一个有效的 key
+func (m *MergeIterator) Next() {
+	if !m.valid {
+		return
+	}
+	m.findNext()
+}
+
+// Seek 定位到第一个大于或等于 key 的有效位置
+func (m *MergeIterator) Seek(key kv.Key) {
+	if key < m.lower {
+		key = m.lower
+	}
+	for _, it := range m.iters {
+		it.SeekGE(key)
+	}
+	m.findNext()
+}
+
+// SeekToFirst 定位到区间内第一个有效的 key
+func (m *MergeIterator) SeekToFirst() {
+	m.Seek(m.lower)
+}
+
+// SeekToLast 定位到区间内最后一个有效的 key。
+// 数据源只支持正向遍历，因此先找出各数据源中小于上界的最大 key 作为候选，
+// 再正向定位到该候选以按新旧规则解析；若候选已被删除，则以它为新的上界继续向前查找。
+func (m *MergeIterator) SeekToLast() {
+	bound := m.upper
+	for {
+		var candidate kv.Key
+		found := false
+		for _, it := range m.iters {
+			if bound == "" {
+				it.SeekToLast()
+			} else {
+				it.SeekLT(bound)
+			}
+			if it.Valid() && (!found || it.Key() > candidate) {
+				candidate = it.Key()
+				found = true
+			}
+		}
+		if !found || candidate < m.lower {
+			m.valid = false
+			return
+		}
+
+		m.Seek(candidate)
+		if m.err != nil || (m.valid && m.key == candidate) {
+			return
+		}
+		bound = candidate
+	}
+}
+
+// Close 关闭所有数据源
+func (m *MergeIterator) Close() {
+	for _, it := range m.iters {
+		it.Close()
+	}
+	m.iters = nil
+	m.valid = false
+}
+
+// findNext 从各数据源的当前位置开始归并，找到下一个可见且未被删除的 key
+func (m *MergeIterator) findNext() {
+	m.valid = false
+	for {
+		// 出错的数据源提前变为无效，继续归并会遗漏其中的数据
+		for _, it := range m.iters {
+			if err := it.Error(); err != nil {
+				m.err = err
+				return
+			}
+		}
+
+		// 1. 找出所有数据源中最小的 key
+		found := false
+		var key kv.Key
+		for _, it := range m.iters {
+			if it.Valid() && (!found || it.Key() < key) {
+				key = it.Key()
+				found = true
+			}
+		}
+		if !found || (m.upper != "" && key >= m.upper) {
+			return
+		}
+
+		// 2. 从新到旧解析当前 key，跳过对快照不可见的新版本
+		value, found, err := m.resolve(key)
+		if err != nil {
+			m.err = err
+			return
+		}
+
+		// 3. 所有数据源越过当前 key，旧版本被最新的可见版本覆盖
+		for _, it := range m.iters {
+			for it.Valid() && it.Key() == key {
+				it.Next()
+			}
+		}
+
+		if !found {
+			continue
+		}
+		m.key, m.value, m.valid = key, value, true
+		return
+	}
+}
+
+// resolve 从新到旧遍历 key 的可见版本：Merge 操作数被收集起来，遇到 Put 或删除标记时停止，
+// 序列号小于覆盖它的区间删除标记的版本视为删除标记。返回合并后的值以及 key 是否存在。
+func (m *MergeIterator) resolve(key kv.Key) (kv.Value, bool, error) {
+	coverSeq := kv.CoveringSequence(m.tombstones, key, m.seq)
+
+	var base kv.Value
+	var operands []kv.Value
+	found, done := false, false
+	for _, it := range m.iters {
+		for ; !done && it.Valid() && it.Key() == key; it.Next() {
+			if it.Seq() > m.seq {
+				continue
+			}
+			if it.Seq() < coverSeq {
+				done = true
+				break
+			}
+			switch it.Kind() {
+			case kv.KindPut, kv.KindMerge:
+				value, err := it.Value()
+				if err != nil {
+					return nil, false, err
+				}
+				if it.Kind() == kv.KindMerge {
+					operands = append(operands, value)
+					continue
+				}
+				base, found = value, true
+			}
+			done = true
+		}
+		if done {
+			break
+		}
+	}
+
+	if len(operands) == 0 {
+		return base, found, nil
+	}
+	if m.merger == nil {
+		return nil, false, ErrNoMergeOperator
+	}
+	// 操作数按从旧到新的顺序交给合并算子
+	slices.Reverse(operands)
+	value, err := m.merger.FullMerge(key, base, operands)
+	if err != nil {
+		return nil, false, fmt.Errorf("merge key %s with %s: %w", key, m.merger.Name(), err)
+	}
+	return value, true, nil
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/memtable"
+)
+
+// newMemSource 构造一个只存在于内存中的数据源
+func newMemSource(pairs ...kv.KeyValuePair) internalIterator {
+	mem := memtable.NewMemTableWithoutWAL()
+	mem.AddPairs(pairs)
+	return memTableIterator{mem.NewIterator()}
+}
+
+// collect 从当前位置开始收集迭代器输出的所有 key/value
+func collect(it Iterator) ([]string, []string) {
+	var keys, values []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+		values = append(values, string(it.Value()))
+	}
+	return keys, values
+}
+
+func TestMergeIteratorNewestWinsAndHidesTombstones(t *testing.T) {
+	newest := newMemSource(
+		kv.KeyValuePair{Key: "b", Value: []byte("b2")},
+		kv.KeyValuePair{Key: "d", Kind: kv.KindDelete},
+	)
+	oldest := newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("a1")},
+		kv.KeyValuePair{Key: "b", Value: []byte("b1")},
+		kv.KeyValuePair{Key: "c", Value: []byte("c1")},
+		kv.KeyValuePair{Key: "d", Value: []byte("d1")},
+	)
+
+	it := NewMergeIterator([]internalIterator{newest, oldest}, "", "", kv.MaxSequence)
+	defer it.Close()
+
+	keys, values := collect(it)
+	assert.NoError(t, it.Error())
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.Equal(t, []string{"a1", "b2", "c1"}, values)
+}
+
+func TestMergeIteratorBounds(t *testing.T) {
+	source := newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("1")},
+		kv.KeyValuePair{Key: "b", Value: []byte("2")},
+		kv.KeyValuePair{Key: "c", Value: []byte("3")},
+		kv.KeyValuePair{Key: "d", Value: []byte("4")},
+	)
+
+	it := NewMergeIterator([]internalIterator{source}, "b", "d", kv.MaxSequence)
+	defer it.Close()
+
+	keys, _ := collect(it)
+	assert.Equal(t, []string{"b", "c"}, keys)
+
+	// Seek 到下界之前时被截断到下界
+	it.Seek("a")
+	assert.True(t, it.Valid())
+	assert.Equal(t, kv.Key("b"), it.Key())
+
+	// Seek 到不存在的 key 时定位到下一个 key
+	it.Seek("bb")
+	assert.True(t, it.Valid())
+	assert.Equal(t, kv.Key("c"), it.Key())
+
+	// Seek 到上界及之后时失效
+	it.Seek("d")
+	assert.False(t, it.Valid())
+
+	it.SeekToLast()
+	assert.True(t, it.Valid())
+	assert.Equal(t, kv.Key("c"), it.Key())
+}
+
+func TestMergeIteratorSeekToLastSkipsTombstones(t *testing.T) {
+	newest := newMemSource(
+		kv.KeyValuePair{Key: "c", Kind: kv.KindDelete},
+		kv.KeyValuePair{Key: "d", Kind: kv.KindDelete},
+	)
+	oldest := newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("1")},
+		kv.KeyValuePair{Key: "b", Value: []byte("2")},
+		kv.KeyValuePair{Key: "c", Value: []byte("3")},
+	)
+
+	it := NewMergeIterator([]internalIterator{newest, oldest}, "", "", kv.MaxSequence)
+	defer it.Close()
+
+	it.SeekToLast()
+	assert.True(t, it.Valid())
+	assert.Equal(t, kv.Key("b"), it.Key())
+	assert.Equal(t, kv.Value("2"), it.Value())
+
+	// SeekToLast 之后可以继续正向遍历
+	it.Next()
+	assert.False(t, it.Valid())
+
+	empty := NewMergeIterator([]internalIterator{newMemSource(
+		kv.KeyValuePair{Key: "x", Kind: kv.KindDelete},
+	)}, "", "", kv.MaxSequence)
+	defer empty.Close()
+	empty.SeekToLast()
+	assert.False(t, empty.Valid())
+}
+
+func TestDatabaseNewIterator(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	// 先写入一批数据并 flush 到 SSTable
+	mem := memtable.NewMemTable(0, t.TempDir())
+	for _, pair := range []kv.KeyValuePair{
+		{Key: "iter_a", Value: []byte("old_a")},
+		{Key: "iter_b", Value: []byte("old_b")},
+		{Key: "iter_c", Value: []byte("old_c")},
+	} {
+		assert.NoError(t, mem.Insert(pair))
+	}
+	assert.NoError(t, db.SSTables.CreateNewSSTable(memtable.NewIMemTable(mem)))
+
+	// 再通过 MemTable 覆盖、删除和新增
+	assert.NoError(t, db.Put("iter_b", []byte("new_b")))
+	assert.NoError(t, db.Delete("iter_c"))
+	assert.NoError(t, db.Put("iter_d", []byte("new_d")))
+
+	it := db.NewIterator("iter_", "iter_~")
+	defer it.Close()
+
+	keys, values := collect(it)
+	assert.NoError(t, it.Error())
+	assert.Equal(t, []string{"iter_a", "iter_b", "iter_d"}, keys)
+	assert.Equal(t, []string{"old_a", "new_b", "new_d"}, values)
+
+	// Get 与迭代器的结果保持一致
+	val, err := db.Get("iter_c")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+// TestDatabaseIteratorConcurrentWrites 测试迭代与写入并发执行：迭代器不会看到只写入了一部分的批次，
+// 也不会读到正在修改的 MemTable。需要配合 -race 运行
+func TestDatabaseIteratorConcurrentWrites(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MemTableSize = 16 * 1024
+	db, err := Open(t.TempDir(), opts)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	const keys = 10
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for round := 0; round < 200; round++ {
+			// 每个批次把所有 key 更新为同一个值
+			batch := NewWriteBatch()
+			for i := 0; i < keys; i++ {
+				batch.Put(fmt.Sprintf("concurrent_%d", i), []byte(fmt.Sprintf("%04d", round)))
+			}
+			assert.NoError(t, db.Write(batch))
+		}
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		it := db.NewIterator("concurrent_", "concurrent_~")
+		gotKeys, values := collect(it)
+		assert.NoError(t, it.Error())
+		it.Close()
+		if len(gotKeys) == 0 {
+			continue
+		}
+		assert.Len(t, gotKeys, keys)
+		for _, value := range values {
+			assert.Equal(t, values[0], value, "iterator sees a partially applied batch")
+		}
+	}
+}
+
+// appendOperator 将操作数依次追加到旧值之后
+type appendOperator struct{}
+
+func (appendOperator) Name() string {
+	return "append"
+}
+
+func (appendOperator) FullMerge(_ kv.Key, existing kv.Value, operands []kv.Value) (kv.Value, error) {
+	result := append(kv.Value{}, existing...)
+	for _, operand := range operands {
+		result = append(result, operand...)
+	}
+	return result, nil
+}
+
+func TestMergeIteratorMergeOperandsAndRangeTombstones(t *testing.T) {
+	newest := newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("+2"), Seq: 6, Kind: kv.KindMerge},
+		kv.KeyValuePair{Key: "c", Value: []byte("+x"), Seq: 7, Kind: kv.KindMerge},
+	)
+	oldest := newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("+1"), Seq: 5, Kind: kv.KindMerge},
+		kv.KeyValuePair{Key: "a", Value: []byte("base"), Seq: 2},
+		kv.KeyValuePair{Key: "a", Value: []byte("older"), Seq: 1},
+		kv.KeyValuePair{Key: "b", Value: []byte("b1"), Seq: 1},
+		kv.KeyValuePair{Key: "c", Value: []byte("c1"), Seq: 1},
+		kv.KeyValuePair{Key: "d", Value: []byte("d1"), Seq: 1},
+	)
+	// 区间删除标记 [b, d)@3 覆盖 b 和 c 的旧版本，但不覆盖 c 上更新的操作数
+	tombstones := []kv.KeyValuePair{{Key: "b", Value: kv.Value("d"), Seq: 3, Kind: kv.KindRangeDelete}}
+
+	it := newMergeIterator([]internalIterator{newest, oldest}, tombstones, appendOperator{}, "", "", kv.MaxSequence)
+	keys, values := collect(it)
+	it.Close()
+	assert.NoError(t, it.Error())
+	assert.Equal(t, []string{"a", "c", "d"}, keys)
+	assert.Equal(t, []string{"base+1+2", "+x", "d1"}, values)
+
+	// 快照 2 看不到操作数和区间删除标记
+	it = newMergeIterator([]internalIterator{
+		newMemSource(kv.KeyValuePair{Key: "a", Value: []byte("+1"), Seq: 5, Kind: kv.KindMerge}),
+		newMemSource(kv.KeyValuePair{Key: "a", Value: []byte("base"), Seq: 2}, kv.KeyValuePair{Key: "b", Value: []byte("b1"), Seq: 1}),
+	}, tombstones, appendOperator{}, "", "", 2)
+	keys, values = collect(it)
+	it.Close()
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Equal(t, []string{"base", "b1"}, values)
+
+	// 没有合并算子时返回错误
+	it = newMergeIterator([]internalIterator{newMemSource(
+		kv.KeyValuePair{Key: "a", Value: []byte("+1"), Seq: 1, Kind: kv.KindMerge},
+	)}, nil, nil, "", "", kv.MaxSequence)
+	defer it.Close()
+	assert.False(t, it.Valid())
+	assert.ErrorIs(t, it.Error(), ErrNoMergeOperator)
+}
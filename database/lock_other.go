@@ -0,0 +1,22 @@
+//go:build !unix
+
+package database
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile 在不支持 flock 的平台上只创建 LOCK 文件，不提供跨进程的互斥
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// unlockFile 关闭 LOCK 文件
+func unlockFile(file *os.File) error {
+	return file.Close()
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile 创建或打开 path 并加上独占的 flock 锁，锁已被其他进程或同一进程中的其他实例持有时返回 ErrLocked。
+// 进程退出时操作系统会自动释放锁，崩溃之后不会留下需要手动清理的锁。
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file %s: %w", path, err)
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("flock %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// unlockFile 释放 lockFile 加的锁并关闭文件，LOCK 文件本身保留在目录中
+func unlockFile(file *os.File) error {
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unlock %s: %w", file.Name(), err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,280 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/go-ini/ini"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+	"github.com/xmh1011/go-lsm/memtable"
+	"github.com/xmh1011/go-lsm/sstable"
+	"github.com/xmh1011/go-lsm/sstable/compress"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+const (
+	walDirectory     = "wal"
+	sstableDirectory = "sstable"
+
+	defaultBlockCacheSize = 8 * 1024 * 1024 // 8MB
+)
+
+// Options 是单个数据库实例的配置，同一进程中的多个实例互不影响。
+// 可以在 DefaultOptions 的基础上修改，也可以通过 LoadOptions 从 ini 文件读取。
+type Options struct {
+	// WALDir 和 SSTableDir 为空时分别使用数据库目录下的 wal 和 sstable 子目录
+	WALDir     string `ini:"wal_path"`
+	SSTableDir string `ini:"sstable_path"`
+
+	// MemTableSize 是单个 MemTable 的容量（字节），写满后封存并在后台刷盘
+	MemTableSize uint64 `ini:"memtable_size"`
+	// SSTableSize 是合并时单个 SSTable 的目标大小（字节）
+	SSTableSize uint64 `ini:"sstable_size"`
+	// BlockSize 是 SSTable 中单个数据块的目标大小（字节）
+	BlockSize int `ini:"block_size"`
+	// NumLevels 是 SSTable 的层级数量（包括 Level0）
+	NumLevels int `ini:"num_levels"`
+	// LevelMultiplier 是相邻层级目标大小的倍数
+	LevelMultiplier int `ini:"level_multiplier"`
+	// Level0CompactionTrigger 是触发 Level0 合并的文件数量
+	Level0CompactionTrigger int `ini:"level0_compaction_trigger"`
+	// MaxBytesForLevelBase 是基准层级（Level0 合并的目标层级）的目标大小（字节）
+	MaxBytesForLevelBase uint64 `ini:"max_bytes_for_level_base"`
+	// DynamicLevelBytes 为 true 时按最后一层的实际大小推算各层级的目标大小，Level0 直接合并到目标大小不超过
+	// MaxBytesForLevelBase 的最深层级；为 false 时 Level0 合并到 Level1
+	DynamicLevelBytes bool `ini:"dynamic_level_bytes"`
+	// CompactionPri 是 Level1 及以上的层级选择参与合并的文件的方式（round_robin 或 min_overlapping_ratio）
+	CompactionPri string `ini:"compaction_pri"`
+	// CompactionStyle 是合并方式：level 为 leveled 合并，universal 为 size-tiered 合并，写放大更小但空间放大更大；
+	// fifo 把所有文件保留在 Level0 且从不合并，只删除最旧的文件，适用于只关心近期数据的时序和缓存场景
+	CompactionStyle string `ini:"compaction_style"`
+	// Universal 是 universal 合并的参数，CompactionStyle 为 universal 时生效
+	Universal UniversalCompactionOptions `ini:"universal_compaction"`
+	// FIFO 是 FIFO 合并删除旧文件的条件，CompactionStyle 为 fifo 时生效
+	FIFO FIFOCompactionOptions `ini:"fifo_compaction"`
+	// BloomBitsPerKey 是 SSTable 的布隆过滤器中每个 key 占用的位数，位图长度按 SSTable 中 key 的数量计算，
+	// 每个 key 10 位时理论误判率约为 1%
+	BloomBitsPerKey uint `ini:"bloom_bits_per_key"`
+	// Compression 是各层级数据块使用的压缩算法名称（none、snappy、zlib 或通过 compress.Register 注册的算法），
+	// 第 i 项用于 Level i，层级多于配置项时使用最后一项
+	Compression []string `ini:"compression"`
+	// VerifyChecksums 为 true 时每次读取 SSTable 的数据块都检查校验和，加载文件和合并时总是检查
+	VerifyChecksums bool `ini:"verify_checksums"`
+	// BlockCacheSize 是所有 SSTable 共享的块缓存容量（字节），为 0 时不使用块缓存
+	BlockCacheSize int64 `ini:"block_cache_size"`
+	// MaxOpenFiles 是同时保持打开的 SSTable 文件数量上限
+	MaxOpenFiles int `ini:"max_open_files"`
+	// PinIndexAndFilter 为 true 时 SSTable 的索引块和布隆过滤器常驻内存；
+	// 为 false 时它们和数据块一样通过块缓存读取，占用的内存受块缓存容量限制
+	PinIndexAndFilter bool `ini:"pin_index_and_filter"`
+
+	// WriteStall 是写入减速和停写的阈值，StopIMemTables 即允许累积的 IMemTable 数量上限
+	WriteStall WriteStallOptions `ini:"write_stall"`
+
+	// ReadOnly 为 true 时以只读模式打开：不加目录锁，不写 WAL、不刷盘也不合并，
+	// 恢复时只读取 WAL 和 SSTable，可以与一个读写实例同时打开同一个目录
+	ReadOnly bool `ini:"read_only"`
+
+	// SyncPolicy 是 WAL 的落盘策略
+	SyncPolicy wal.SyncPolicy `ini:"-"`
+	// MergeOperator 用于合并 Merge 操作数，不使用 Merge 时可以为空
+	MergeOperator kv.MergeOperator `ini:"-"`
+}
+
+// DefaultOptions 返回默认配置
+func DefaultOptions() *Options {
+	tableOpts := sstable.DefaultOptions("")
+	return &Options{
+		MemTableSize:            memtable.DefaultMaxSize,
+		SSTableSize:             tableOpts.TableSize,
+		BlockSize:               tableOpts.BlockSize,
+		NumLevels:               tableOpts.NumLevels,
+		LevelMultiplier:         tableOpts.LevelMultiplier,
+		Level0CompactionTrigger: tableOpts.Level0CompactionTrigger,
+		MaxBytesForLevelBase:    tableOpts.MaxBytesForLevelBase,
+		DynamicLevelBytes:       tableOpts.DynamicLevelBytes,
+		CompactionPri:           tableOpts.CompactionPri.String(),
+		CompactionStyle:         tableOpts.CompactionStyle.String(),
+		Universal:               UniversalCompactionOptions(tableOpts.Universal),
+		FIFO:                    FIFOCompactionOptions(tableOpts.FIFO),
+		BloomBitsPerKey:         tableOpts.BloomBitsPerKey,
+		Compression:             compressionNames(tableOpts.Compression),
+		BlockCacheSize:          defaultBlockCacheSize,
+		MaxOpenFiles:            tableOpts.MaxOpenFiles,
+		PinIndexAndFilter:       true,
+		WriteStall:              DefaultWriteStallOptions(),
+		SyncPolicy:              wal.DefaultSyncPolicy(),
+	}
+}
+
+// UniversalCompactionOptions 是 universal 合并选择有序段的参数，含义见 sstable.UniversalOptions
+type UniversalCompactionOptions struct {
+	SizeRatio                   int `ini:"size_ratio"`                     // 大小相近的有序段的容忍百分比
+	MinMergeWidth               int `ini:"min_merge_width"`                // 按大小比例合并时至少合并的有序段数量
+	MaxSizeAmplificationPercent int `ini:"max_size_amplification_percent"` // 空间放大的上限（百分比）
+}
+
+// Validate 检查参数是否合法
+func (o UniversalCompactionOptions) Validate() error {
+	if o.SizeRatio < 0 {
+		return fmt.Errorf("universal size ratio must not be negative: %d", o.SizeRatio)
+	}
+	if o.MinMergeWidth < 2 {
+		return fmt.Errorf("universal min merge width must be at least 2: %d", o.MinMergeWidth)
+	}
+	if o.MaxSizeAmplificationPercent <= 0 {
+		return fmt.Errorf("universal max size amplification percent must be positive: %d", o.MaxSizeAmplificationPercent)
+	}
+	return nil
+}
+
+// FIFOCompactionOptions 是 FIFO 合并删除旧文件的条件，含义见 sstable.FIFOOptions
+type FIFOCompactionOptions struct {
+	MaxTableFilesSize uint64        `ini:"max_table_files_size"` // Level0 中所有 SSTable 的总大小上限（字节）
+	TTL               time.Duration `ini:"ttl"`                  // 数据的保留时间，为 0 时不按时间删除，读写模式下定期检查
+}
+
+// Validate 检查参数是否合法
+func (o FIFOCompactionOptions) Validate() error {
+	if o.MaxTableFilesSize == 0 {
+		return fmt.Errorf("fifo max table files size must be positive: %d", o.MaxTableFilesSize)
+	}
+	if o.TTL < 0 {
+		return fmt.Errorf("fifo ttl must not be negative: %s", o.TTL)
+	}
+	return nil
+}
+
+// LoadOptions 从 ini 文件读取配置，文件中未出现的配置项保留默认值
+func LoadOptions(path string) (*Options, error) {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		log.Errorf("load options file %s error: %s", path, err.Error())
+		return nil, fmt.Errorf("load options file %s error: %w", path, err)
+	}
+
+	opts := DefaultOptions() // 基于默认配置覆盖
+	if err = cfg.MapTo(opts); err != nil {
+		log.Errorf("parse options file %s error: %s", path, err.Error())
+		return nil, fmt.Errorf("parse options file %s error: %w", path, err)
+	}
+	return opts, nil
+}
+
+// Validate 检查配置是否合法
+func (o *Options) Validate() error {
+	if o.MemTableSize == 0 || o.SSTableSize == 0 {
+		return fmt.Errorf("memtable size and sstable size must be positive: %d, %d", o.MemTableSize, o.SSTableSize)
+	}
+	if o.BlockSize <= 0 {
+		return fmt.Errorf("block size must be positive: %d", o.BlockSize)
+	}
+	if o.NumLevels < 2 {
+		return fmt.Errorf("num levels must be at least 2: %d", o.NumLevels)
+	}
+	if o.LevelMultiplier < 2 {
+		return fmt.Errorf("level multiplier must be at least 2: %d", o.LevelMultiplier)
+	}
+	if o.Level0CompactionTrigger <= 0 {
+		return fmt.Errorf("level0 compaction trigger must be positive: %d", o.Level0CompactionTrigger)
+	}
+	if o.MaxBytesForLevelBase == 0 {
+		return fmt.Errorf("max bytes for level base must be positive: %d", o.MaxBytesForLevelBase)
+	}
+	if _, err := sstable.ParseCompactionPri(o.CompactionPri); err != nil {
+		return err
+	}
+	if _, err := sstable.ParseCompactionStyle(o.CompactionStyle); err != nil {
+		return err
+	}
+	if err := o.Universal.Validate(); err != nil {
+		return err
+	}
+	if err := o.FIFO.Validate(); err != nil {
+		return err
+	}
+	if o.BloomBitsPerKey == 0 {
+		return fmt.Errorf("bloom bits per key must be positive: %d", o.BloomBitsPerKey)
+	}
+	if len(o.Compression) == 0 {
+		return fmt.Errorf("compression must be set for at least one level")
+	}
+	if _, err := o.compressionCodecs(); err != nil {
+		return err
+	}
+	if o.BlockCacheSize < 0 {
+		return fmt.Errorf("block cache size must not be negative: %d", o.BlockCacheSize)
+	}
+	if o.MaxOpenFiles <= 0 {
+		return fmt.Errorf("max open files must be positive: %d", o.MaxOpenFiles)
+	}
+	if err := o.WriteStall.Validate(); err != nil {
+		return err
+	}
+	return o.SyncPolicy.Validate()
+}
+
+// withDirs 返回补全了目录的配置副本，未设置的目录放在数据库目录 path 下
+func (o *Options) withDirs(path string) *Options {
+	opts := *o
+	if opts.WALDir == "" {
+		opts.WALDir = filepath.Join(path, walDirectory)
+	}
+	if opts.SSTableDir == "" {
+		opts.SSTableDir = filepath.Join(path, sstableDirectory)
+	}
+	return &opts
+}
+
+// sstableOptions 返回 SSTable Manager 使用的配置
+func (o *Options) sstableOptions() sstable.Options {
+	// 名称已经在 Validate 中检查过，无法识别时使用默认值
+	codecs, _ := o.compressionCodecs()
+	pri, _ := sstable.ParseCompactionPri(o.CompactionPri)
+	style, _ := sstable.ParseCompactionStyle(o.CompactionStyle)
+	return sstable.Options{
+		Dir:                     o.SSTableDir,
+		TableSize:               o.SSTableSize,
+		BlockSize:               o.BlockSize,
+		NumLevels:               o.NumLevels,
+		LevelMultiplier:         o.LevelMultiplier,
+		Level0CompactionTrigger: o.Level0CompactionTrigger,
+		MaxBytesForLevelBase:    o.MaxBytesForLevelBase,
+		DynamicLevelBytes:       o.DynamicLevelBytes,
+		CompactionPri:           pri,
+		CompactionStyle:         style,
+		Universal:               sstable.UniversalOptions(o.Universal),
+		FIFO:                    sstable.FIFOOptions(o.FIFO),
+		BloomBitsPerKey:         o.BloomBitsPerKey,
+		Compression:             codecs,
+		VerifyChecksums:         o.VerifyChecksums,
+		BlockCacheSize:          o.BlockCacheSize,
+		MaxOpenFiles:            o.MaxOpenFiles,
+		CacheIndexAndFilter:     !o.PinIndexAndFilter,
+	}
+}
+
+// compressionCodecs 将各层级的压缩算法名称转换为算法编号
+func (o *Options) compressionCodecs() ([]compress.Codec, error) {
+	codecs := make([]compress.Codec, 0, len(o.Compression))
+	for level, name := range o.Compression {
+		codec, err := compress.ParseCodec(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compression of level %d: %w", level, err)
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// compressionNames 返回各层级压缩算法的名称
+func compressionNames(codecs []compress.Codec) []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.String())
+	}
+	return names
+}
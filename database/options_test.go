@@ -0,0 +1,131 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/sstable"
+	"github.com/xmh1011/go-lsm/sstable/compress"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, DefaultOptions().Validate())
+
+	for _, modify := range []func(*Options){
+		func(o *Options) { o.MemTableSize = 0 },
+		func(o *Options) { o.SSTableSize = 0 },
+		func(o *Options) { o.BlockSize = 0 },
+		func(o *Options) { o.NumLevels = 1 },
+		func(o *Options) { o.LevelMultiplier = 1 },
+		func(o *Options) { o.Level0CompactionTrigger = 0 },
+		func(o *Options) { o.MaxBytesForLevelBase = 0 },
+		func(o *Options) { o.CompactionPri = "oldest" },
+		func(o *Options) { o.CompactionStyle = "tiered" },
+		func(o *Options) { o.Universal.SizeRatio = -1 },
+		func(o *Options) { o.Universal.MinMergeWidth = 1 },
+		func(o *Options) { o.Universal.MaxSizeAmplificationPercent = 0 },
+		func(o *Options) { o.FIFO.MaxTableFilesSize = 0 },
+		func(o *Options) { o.FIFO.TTL = -time.Second },
+		func(o *Options) { o.BloomBitsPerKey = 0 },
+		func(o *Options) { o.Compression = nil },
+		func(o *Options) { o.Compression = []string{"none", "lz4"} },
+		func(o *Options) { o.BlockCacheSize = -1 },
+		func(o *Options) { o.MaxOpenFiles = 0 },
+		func(o *Options) { o.WriteStall.StopIMemTables = 0 },
+		func(o *Options) { o.SyncPolicy = wal.SyncPolicy{Mode: wal.SyncInterval} },
+	} {
+		opts := DefaultOptions()
+		modify(opts)
+		assert.Error(t, opts.Validate())
+
+		_, err := Open(t.TempDir(), opts)
+		assert.Error(t, err)
+	}
+}
+
+func TestLoadOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "options.ini")
+	content := "memtable_size = 4096\nnum_levels = 4\ncompression = none, snappy\nverify_checksums = true\nblock_cache_size = 0\npin_index_and_filter = false\ndynamic_level_bytes = true\ncompaction_pri = min_overlapping_ratio\ncompaction_style = universal\n\n[write_stall]\nstop_imemtables = 20\n\n[universal_compaction]\nsize_ratio = 10\n\n[fifo_compaction]\nttl = 24h\n"
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	opts, err := LoadOptions(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(4096), opts.MemTableSize)
+	assert.Equal(t, 4, opts.NumLevels)
+	assert.Equal(t, 20, opts.WriteStall.StopIMemTables)
+	assert.Equal(t, []string{"none", "snappy"}, opts.Compression)
+	assert.True(t, opts.sstableOptions().VerifyChecksums)
+	assert.Equal(t, []compress.Codec{compress.NoCompression, compress.SnappyCompression}, opts.sstableOptions().Compression)
+	assert.Zero(t, opts.sstableOptions().BlockCacheSize)
+	assert.True(t, opts.sstableOptions().CacheIndexAndFilter)
+	assert.True(t, opts.sstableOptions().DynamicLevelBytes)
+	assert.Equal(t, sstable.CompactByMinOverlappingRatio, opts.sstableOptions().CompactionPri)
+	assert.Equal(t, sstable.CompactionStyleUniversal, opts.sstableOptions().CompactionStyle)
+	assert.Equal(t, 10, opts.sstableOptions().Universal.SizeRatio)
+	assert.Equal(t, DefaultOptions().Universal.MinMergeWidth, opts.Universal.MinMergeWidth)
+	assert.Equal(t, 24*time.Hour, opts.sstableOptions().FIFO.TTL)
+	assert.Equal(t, DefaultOptions().FIFO.MaxTableFilesSize, opts.FIFO.MaxTableFilesSize)
+
+	// 文件中未出现的配置项保留默认值
+	defaults := DefaultOptions()
+	assert.Equal(t, defaults.SSTableSize, opts.SSTableSize)
+	assert.Equal(t, defaults.WriteStall.SlowdownIMemTables, opts.WriteStall.SlowdownIMemTables)
+	assert.Equal(t, defaults.SyncPolicy, opts.SyncPolicy)
+
+	// 示例配置文件与默认配置一致
+	sample, err := LoadOptions(filepath.Join("..", "config", "config.ini"))
+	assert.NoError(t, err)
+	assert.Equal(t, defaults, sample)
+
+	_, err = LoadOptions(filepath.Join(t.TempDir(), "missing.ini"))
+	assert.Error(t, err)
+}
+
+// TestOpenWithOptions 测试目录和容量等配置只作用于各自的数据库实例
+func TestOpenWithOptions(t *testing.T) {
+	root := t.TempDir()
+	opts := DefaultOptions()
+	opts.WALDir = filepath.Join(root, "logs")
+	opts.MemTableSize = 4096
+	small, err := Open(filepath.Join(root, "small"), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "logs"), small.Options().WALDir)
+	assert.Equal(t, filepath.Join(root, "small", sstableDirectory), small.Options().SSTableDir)
+	// 调用方的配置不会被修改
+	assert.Empty(t, opts.SSTableDir)
+
+	other := openTestDB(t, filepath.Join(root, "other"))
+	assert.Equal(t, filepath.Join(root, "other", walDirectory), other.Options().WALDir)
+
+	value := make([]byte, 1024)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, small.Put(fmt.Sprintf("key%d", i), value))
+		assert.NoError(t, other.Put(fmt.Sprintf("key%d", i), value))
+	}
+	assert.NoError(t, small.waitForFlush())
+
+	// 较小的 MemTable 已多次刷盘，默认配置的实例仍只有一个 MemTable
+	assert.Positive(t, small.SSTables.Level0FileCount())
+	assert.Zero(t, other.SSTables.Level0FileCount())
+	assert.Zero(t, other.MemTables.IMemTableCount())
+
+	assert.NoError(t, small.Close())
+	assert.NoError(t, other.Close())
+
+	// 使用相同的配置重新打开并恢复
+	reopened, err := Open(filepath.Join(root, "small"), opts)
+	assert.NoError(t, err)
+	assert.NoError(t, reopened.Recover())
+	for i := 0; i < 8; i++ {
+		val, err := reopened.Get(fmt.Sprintf("key%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.NoError(t, reopened.Close())
+}
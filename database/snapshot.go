@@ -0,0 +1,78 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// Snapshot 是数据库在某一时刻的只读视图。
+// 通过快照读取时只能看到序列号不大于快照序列号的版本，之后的写入对其不可见。
+// 快照在释放之前会阻止合并丢弃它可见的历史版本，使用完毕后需要调用 Database.ReleaseSnapshot。
+type Snapshot struct {
+	db       *Database
+	seq      uint64
+	released atomic.Bool // 保证并发重复释放时只减少一次引用计数
+}
+
+// Sequence 返回快照的序列号
+func (s *Snapshot) Sequence() uint64 {
+	return s.seq
+}
+
+// Get 读取快照时刻 key 对应的值，不存在或已被删除时返回 nil
+func (s *Snapshot) Get(key string) ([]byte, error) {
+	if err := s.db.acquire(); err != nil {
+		return nil, err
+	}
+	defer s.db.release()
+
+	return s.db.getWithSeq(kv.Key(key), s.seq)
+}
+
+// NewIterator 返回遍历快照时刻 [lower, upper) 区间的有序迭代器，参数含义同 Database.NewIterator
+func (s *Snapshot) NewIterator(lower, upper string) Iterator {
+	return s.db.openIterator(kv.Key(lower), kv.Key(upper), s.seq)
+}
+
+// snapshotList 记录所有存活快照的序列号及其引用计数
+type snapshotList struct {
+	mu   sync.Mutex
+	refs map[uint64]int
+}
+
+func newSnapshotList() *snapshotList {
+	return &snapshotList{
+		refs: make(map[uint64]int),
+	}
+}
+
+func (l *snapshotList) acquire(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refs[seq]++
+}
+
+func (l *snapshotList) release(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refs[seq]--
+	if l.refs[seq] <= 0 {
+		delete(l.refs, seq)
+	}
+}
+
+// sequences 返回所有存活快照的序列号（无序）
+func (l *snapshotList) sequences() []uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seqs := make([]uint64, 0, len(l.refs))
+	for seq := range l.refs {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotGet(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	assert.NoError(t, db.Put("snap_key", []byte("v1")))
+	assert.NoError(t, db.Put("snap_gone", []byte("g1")))
+
+	snapshot, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	defer db.ReleaseSnapshot(snapshot)
+
+	// 快照之后的写入对快照不可见
+	assert.NoError(t, db.Put("snap_key", []byte("v2")))
+	assert.NoError(t, db.Delete("snap_gone"))
+	assert.NoError(t, db.Put("snap_new", []byte("n1")))
+
+	val, err := snapshot.Get("snap_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	val, err = snapshot.Get("snap_gone")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("g1"), val)
+
+	val, err = snapshot.Get("snap_new")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	// 最新视图不受快照影响
+	val, err = db.Get("snap_key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	val, err = db.Get("snap_gone")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+func TestSnapshotIterator(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	assert.NoError(t, db.Put("snapit_a", []byte("a1")))
+	assert.NoError(t, db.Put("snapit_b", []byte("b1")))
+
+	snapshot, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	defer db.ReleaseSnapshot(snapshot)
+
+	assert.NoError(t, db.Put("snapit_a", []byte("a2")))
+	assert.NoError(t, db.Delete("snapit_b"))
+	assert.NoError(t, db.Put("snapit_c", []byte("c2")))
+
+	it := snapshot.NewIterator("snapit_", "snapit_~")
+	keys, values := collect(it)
+	it.Close()
+	assert.Equal(t, []string{"snapit_a", "snapit_b"}, keys)
+	assert.Equal(t, []string{"a1", "b1"}, values)
+
+	it = db.NewIterator("snapit_", "snapit_~")
+	keys, values = collect(it)
+	it.Close()
+	assert.Equal(t, []string{"snapit_a", "snapit_c"}, keys)
+	assert.Equal(t, []string{"a2", "c2"}, values)
+}
+
+func TestReleaseSnapshot(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	first, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	second, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, first.Sequence(), second.Sequence())
+	assert.Equal(t, []uint64{first.Sequence()}, db.snapshots.sequences())
+
+	db.ReleaseSnapshot(first)
+	// 重复释放不影响其他快照的引用计数
+	db.ReleaseSnapshot(first)
+	assert.Equal(t, []uint64{second.Sequence()}, db.snapshots.sequences())
+
+	db.ReleaseSnapshot(second)
+	assert.Empty(t, db.snapshots.sequences())
+}
+
+// TestReleaseSnapshotConcurrently 测试并发释放同一个快照只减少一次引用计数
+func TestReleaseSnapshotConcurrently(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+
+	first, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	second, err := db.GetSnapshot()
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db.ReleaseSnapshot(first)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []uint64{second.Sequence()}, db.snapshots.sequences())
+
+	db.ReleaseSnapshot(second)
+	assert.Empty(t, db.snapshots.sequences())
+}
+
+// TestSnapshotIteratorConcurrentWrites 测试快照迭代器与写入并发执行时只看到快照时刻的数据。需要配合 -race 运行
+func TestSnapshotIteratorConcurrentWrites(t *testing.T) {
+	db := openTestDB(t, t.TempDir())
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, db.Put(fmt.Sprintf("snapcw_%d", i), []byte("old")))
+	}
+	snapshot, err := db.GetSnapshot()
+	assert.NoError(t, err)
+	defer db.ReleaseSnapshot(snapshot)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			assert.NoError(t, db.Put(fmt.Sprintf("snapcw_%d", i%20), []byte("new")))
+		}
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		it := snapshot.NewIterator("snapcw_", "snapcw_~")
+		keys, values := collect(it)
+		it.Close()
+		assert.Len(t, keys, 10)
+		for _, value := range values {
+			assert.Equal(t, "old", value)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 // 定义 kv 和 存储方式
 // 采用小端存储，使用长度前缀编码
 /*
-┌────────────┬──────────┬──────────────┬────────────┐
-│ key length │ key data │ value length │ value data │
-└────────────┴──────────┴──────────────┴────────────┘
+┌────────────┬──────────┬──────────┬──────┬──────────────┬────────────┐
+│ key length │ key data │ sequence │ kind │ value length │ value data │
+└────────────┴──────────┴──────────┴──────┴──────────────┴────────────┘
 */
 
 package kv
@@ -12,6 +12,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/xmh1011/go-lsm/log"
 )
@@ -21,25 +22,88 @@ type (
 	Value []byte
 )
 
+// Kind 表示一条记录的类型，删除等操作通过类型而不是特殊的 value 表示，
+// 因此任何用户数据都不会被误认为删除标记。
+type Kind uint8
+
+const (
+	// KindPut 写入 Value，是 KeyValuePair 的零值类型
+	KindPut Kind = iota
+	// KindDelete 删除 Key 的所有旧版本
+	KindDelete
+	// KindSingleDelete 删除 Key，调用方保证该 Key 自上次删除以来最多只被 Put 过一次，
+	// 合并时与其下方紧邻的 Put 可以一起被丢弃
+	KindSingleDelete
+	// KindMerge 记录一个合并操作数，读取时由 MergeOperator 与更旧的版本合并
+	KindMerge
+	// KindRangeDelete 删除 [Key, Value) 区间内序列号更小的所有版本，Value 为区间的结束 key
+	KindRangeDelete
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindPut:
+		return "Put"
+	case KindDelete:
+		return "Delete"
+	case KindSingleDelete:
+		return "SingleDelete"
+	case KindMerge:
+		return "Merge"
+	case KindRangeDelete:
+		return "RangeDelete"
+	default:
+		return fmt.Sprintf("Kind(%d)", uint8(k))
+	}
+}
+
+// Valid 判断是否为已定义的记录类型
+func (k Kind) Valid() bool {
+	return k <= KindRangeDelete
+}
+
+// KeyValuePair 是一次写入产生的一个版本，Seq 为写入时分配的单调递增序列号。
+// 同一 Key 可以存在多个版本，序列号越大版本越新。
 type KeyValuePair struct {
 	Key   Key
 	Value Value
+	Seq   uint64
+	Kind  Kind
 }
 
-const deletedValueStr = "～DELETED～"
-
-var DeletedValue = Value(deletedValueStr)
+// MaxSequence 表示读取最新版本，所有序列号都不大于它
+const MaxSequence uint64 = math.MaxUint64
 
 func (p *KeyValuePair) Copy() *KeyValuePair {
 	return &KeyValuePair{
 		Key:   p.Key,
 		Value: p.Value,
+		Seq:   p.Seq,
+		Kind:  p.Kind,
 	}
 }
 
+// IsDeleted 判断是否为单个 key 的删除标记
 func (p *KeyValuePair) IsDeleted() bool {
-	// 判断 Value 是否为删除标记
-	return p.Value != nil && string(p.Value) == deletedValueStr
+	return p.Kind == KindDelete || p.Kind == KindSingleDelete
+}
+
+// Covers 判断区间删除标记 p 是否覆盖 key 的 seq 版本：key 位于 [p.Key, p.Value) 且版本更旧
+func (p *KeyValuePair) Covers(key Key, seq uint64) bool {
+	return p.Kind == KindRangeDelete && p.Key <= key && key < Key(p.Value) && seq < p.Seq
+}
+
+// CoveringSequence 返回 tombstones 中覆盖 key 且对 snapshot 可见的区间删除标记的最大序列号，
+// 序列号小于返回值的 key 版本均已被删除。没有覆盖的区间删除标记时返回 0。
+func CoveringSequence(tombstones []KeyValuePair, key Key, snapshot uint64) uint64 {
+	var seq uint64
+	for i := range tombstones {
+		t := &tombstones[i]
+		if t.Seq <= snapshot && t.Seq > seq && t.Key <= key && key < Key(t.Value) {
+			seq = t.Seq
+		}
+	}
+	return seq
 }
 
 // EncodeTo 使用4字节小端编码
@@ -57,6 +121,18 @@ func (p *KeyValuePair) EncodeTo(w io.Writer) error {
 		return fmt.Errorf("encode key: %w", err)
 	}
 
+	// 编码序列号（8字节小端）
+	if err := binary.Write(w, binary.LittleEndian, p.Seq); err != nil {
+		log.Errorf("write sequence failed: %s", err)
+		return fmt.Errorf("encode sequence: %w", err)
+	}
+
+	// 编码记录类型（1字节）
+	if err := binary.Write(w, binary.LittleEndian, p.Kind); err != nil {
+		log.Errorf("write kind failed: %s", err)
+		return fmt.Errorf("encode kind: %w", err)
+	}
+
 	// 编码 value 长度（4字节小端）
 	valLen := uint32(len(p.Value))
 	if err := binary.Write(w, binary.LittleEndian, valLen); err != nil {
@@ -93,6 +169,21 @@ func (p *KeyValuePair) DecodeFrom(r io.Reader) error {
 	}
 	p.Key = Key(key)
 
+	// 解码序列号（8字节小端）
+	if err := binary.Read(r, binary.LittleEndian, &p.Seq); err != nil {
+		log.Errorf("read sequence failed: %s", err)
+		return fmt.Errorf("decode sequence: %w", err)
+	}
+
+	// 解码记录类型（1字节）
+	if err := binary.Read(r, binary.LittleEndian, &p.Kind); err != nil {
+		log.Errorf("read kind failed: %s", err)
+		return fmt.Errorf("decode kind: %w", err)
+	}
+	if !p.Kind.Valid() {
+		return fmt.Errorf("invalid kind: %d", p.Kind)
+	}
+
 	// 解码 value 长度（4字节小端）
 	var valLen uint32
 	if err := binary.Read(r, binary.LittleEndian, &valLen); err != nil {
@@ -110,14 +201,18 @@ func (p *KeyValuePair) DecodeFrom(r io.Reader) error {
 		return fmt.Errorf("decode value: %w", err)
 	}
 	p.Value = val
+	// 删除标记没有 value
+	if p.IsDeleted() {
+		p.Value = nil
+	}
 
 	return nil
 }
 
 // EstimateSize 估算编码后大小
 func (p *KeyValuePair) EstimateSize() uint64 {
-	// 4字节 key 长度 + key 数据长度 + 4字节 value 长度 + value 数据长度 + 8字节 value offset
-	return 4 + uint64(len(p.Key)) + 4 + uint64(len(p.Value)) + 8
+	// 4字节 key 长度 + key 数据长度 + 4字节 value 长度 + value 数据长度 + 8字节 value offset + 8字节序列号 + 1字节类型
+	return 4 + uint64(len(p.Key)) + 4 + uint64(len(p.Value)) + 8 + 8 + 1
 }
 
 // DecodeFrom 从 io.Reader 解码 Key（小端存储 + 4字节长度前缀）
@@ -19,6 +19,7 @@ func TestKeyValuePair_EncodeDecode(t *testing.T) {
 			pair: &KeyValuePair{
 				Key:   "test_key",
 				Value: []byte("test_value"),
+				Seq:   42,
 			},
 			wantErr:  false,
 			checkVal: true,
@@ -44,8 +45,29 @@ func TestKeyValuePair_EncodeDecode(t *testing.T) {
 		{
 			name: "deleted value",
 			pair: &KeyValuePair{
-				Key:   "deleted_key",
-				Value: DeletedValue,
+				Key:  "deleted_key",
+				Seq:  7,
+				Kind: KindDelete,
+			},
+			wantErr:  false,
+			checkVal: false,
+		},
+		{
+			name: "value looks like legacy tombstone",
+			pair: &KeyValuePair{
+				Key:   "magic_key",
+				Value: []byte("～DELETED～"),
+			},
+			wantErr:  false,
+			checkVal: true,
+		},
+		{
+			name: "range delete",
+			pair: &KeyValuePair{
+				Key:   "a",
+				Value: []byte("z"),
+				Seq:   9,
+				Kind:  KindRangeDelete,
 			},
 			wantErr:  false,
 			checkVal: true,
@@ -86,6 +108,8 @@ func TestKeyValuePair_EncodeDecode(t *testing.T) {
 
 			// 验证
 			assert.Equal(t, tt.pair.Key, decoded.Key, "Key should match")
+			assert.Equal(t, tt.pair.Seq, decoded.Seq, "Seq should match")
+			assert.Equal(t, tt.pair.Kind, decoded.Kind, "Kind should match")
 
 			if tt.checkVal {
 				assert.Equal(t, tt.pair.Value, decoded.Value, "Value should match")
@@ -183,8 +207,8 @@ func TestValue_EncodeDecode(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name:    "deleted value",
-			value:   DeletedValue,
+			name:    "legacy tombstone value",
+			value:   []byte("～DELETED～"),
 			wantErr: false,
 		},
 		{
@@ -214,11 +238,37 @@ func TestValue_EncodeDecode(t *testing.T) {
 				assert.Equal(t, len(tt.value), len(decoded), "Decoded value length should match")
 			}
 
-			// 验证IsDeleted方法
-			if tt.name == "deleted value" {
-				pair := &KeyValuePair{Value: decoded}
-				assert.True(t, pair.IsDeleted(), "IsDeleted() should return true for deleted value")
-			}
+			// 删除由记录类型表示，任何 value 都不会被当作删除标记
+			pair := &KeyValuePair{Value: decoded}
+			assert.False(t, pair.IsDeleted(), "IsDeleted() should return false for any put value")
 		})
 	}
 }
+
+func TestKeyValuePair_DecodeInvalidKind(t *testing.T) {
+	buf := &bytes.Buffer{}
+	pair := &KeyValuePair{Key: "k", Value: []byte("v"), Kind: Kind(99)}
+	assert.NoError(t, pair.EncodeTo(buf))
+
+	decoded := &KeyValuePair{}
+	assert.Error(t, decoded.DecodeFrom(buf))
+}
+
+func TestCoveringSequence(t *testing.T) {
+	tombstones := []KeyValuePair{
+		{Key: "b", Value: Value("d"), Seq: 5, Kind: KindRangeDelete},
+		{Key: "c", Value: Value("f"), Seq: 8, Kind: KindRangeDelete},
+	}
+
+	assert.Equal(t, uint64(0), CoveringSequence(tombstones, "a", MaxSequence))
+	assert.Equal(t, uint64(5), CoveringSequence(tombstones, "b", MaxSequence))
+	assert.Equal(t, uint64(8), CoveringSequence(tombstones, "c", MaxSequence))
+	// 结束 key 不在区间内
+	assert.Equal(t, uint64(0), CoveringSequence(tombstones, "f", MaxSequence))
+	// 快照看不到更新的区间删除
+	assert.Equal(t, uint64(5), CoveringSequence(tombstones, "c", 6))
+
+	assert.True(t, tombstones[0].Covers("c", 4))
+	assert.False(t, tombstones[0].Covers("c", 5))
+	assert.False(t, tombstones[0].Covers("d", 1))
+}
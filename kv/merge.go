@@ -0,0 +1,11 @@
+package kv
+
+// MergeOperator 定义 Merge 记录的合并方式。
+// 读取时，同一 Key 上连续的 Merge 操作数会与其下方最近的 Put（或不存在的值）一起交给 FullMerge。
+type MergeOperator interface {
+	// Name 返回合并算子的名称
+	Name() string
+
+	// FullMerge 将按从旧到新排列的 operands 依次合并到 existing 上，existing 为 nil 表示 Key 不存在
+	FullMerge(key Key, existing Value, operands []Value) (Value, error)
+}
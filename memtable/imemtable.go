@@ -11,6 +11,7 @@
 package memtable
 
 import (
+	"errors"
 	"os"
 
 	"github.com/xmh1011/go-lsm/kv"
@@ -22,18 +23,20 @@ import (
 // IMemTable is an immutable memtable, used for flush/compaction.
 // It is read-only and supports only Search and Scan operations.
 type IMemTable struct {
-	id      uint64
-	entries *skiplist.SkipList
-	wal     *wal.WAL
+	id        uint64
+	entries   *skiplist.SkipList
+	rangeDels []kv.KeyValuePair
+	wal       *wal.WAL
 }
 
 // NewIMemTable creates an IMemTable from an existing MemTable.
 // Used when memtable is frozen for flushing.
 func NewIMemTable(mem *MemTable) *IMemTable {
 	return &IMemTable{
-		id:      mem.id,
-		entries: mem.entries,
-		wal:     mem.wal,
+		id:        mem.id,
+		entries:   mem.entries,
+		rangeDels: mem.rangeDels,
+		wal:       mem.wal,
 	}
 }
 
@@ -42,7 +45,28 @@ func (t *IMemTable) Search(key kv.Key) (kv.Value, bool) {
 	return t.entries.Search(key)
 }
 
+// SearchWithSeq 查找序列号不大于 seq 的最新版本，用于快照读。
+func (t *IMemTable) SearchWithSeq(key kv.Key, seq uint64) (kv.Value, bool) {
+	return t.entries.SearchWithSeq(key, seq)
+}
+
+// SearchPair 返回序列号不大于 seq 的最新版本（包括删除标记），不存在时返回 nil
+func (t *IMemTable) SearchPair(key kv.Key, seq uint64) *kv.KeyValuePair {
+	return t.entries.SearchPair(key, seq)
+}
+
+// RangeTombstones 返回 IMemTable 中的所有区间删除标记
+func (t *IMemTable) RangeTombstones() []kv.KeyValuePair {
+	return t.rangeDels
+}
+
+// Empty 返回 IMemTable 中是否既没有记录也没有区间删除标记
+func (t *IMemTable) Empty() bool {
+	return t.entries.First() == nil && len(t.rangeDels) == 0
+}
+
 // RangeScan scans all key-value pairs in order and calls the callback.
+// 区间删除标记不在其中，需要通过 RangeTombstones 获取。
 func (t *IMemTable) RangeScan(callback func(*kv.KeyValuePair)) {
 	iter := skiplist.NewSkipListIterator(t.entries)
 	defer iter.Close()
@@ -52,14 +76,33 @@ func (t *IMemTable) RangeScan(callback func(*kv.KeyValuePair)) {
 	}
 }
 
+// NewIterator 返回遍历当前 IMemTable 的迭代器。
+func (t *IMemTable) NewIterator() *Iterator {
+	return NewMemTableIterator(t.entries)
+}
+
 // ID returns the ID of this IMemTable.
 func (t *IMemTable) ID() uint64 {
 	return t.id
 }
 
 func (t *IMemTable) Clean() {
+	if err := t.closeWAL(); err != nil {
+		log.Errorf("failed to close WAL file %d: %s", t.id, err.Error())
+	}
 	err := t.wal.DeleteFile()
 	if err != nil && !os.IsNotExist(err) {
 		log.Errorf("failed to clean WAL file %d: %s", t.id, err.Error())
 	}
 }
+
+// closeWAL 关闭 WAL 文件句柄，WAL 已经关闭时不返回错误
+func (t *IMemTable) closeWAL() error {
+	if t.wal == nil {
+		return nil
+	}
+	if err := t.wal.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
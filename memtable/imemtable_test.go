@@ -86,3 +86,16 @@ func TestIMemTableSharedData(t *testing.T) {
 	assert.True(t, found)
 	assert.Equal(t, kv.Value("200"), val)
 }
+
+// TestIMemTableEmpty verifies that range tombstones alone make an IMemTable non-empty.
+func TestIMemTableEmpty(t *testing.T) {
+	mem := NewMemTable(44, t.TempDir())
+	assert.True(t, NewIMemTable(mem).Empty())
+
+	assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: "a", Value: kv.Value("b"), Seq: 1, Kind: kv.KindRangeDelete}))
+	assert.False(t, NewIMemTable(mem).Empty())
+
+	mem = NewMemTable(45, t.TempDir())
+	assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: "x", Value: []byte("1")}))
+	assert.False(t, NewIMemTable(mem).Empty())
+}
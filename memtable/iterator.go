@@ -3,7 +3,7 @@
 // 顺序写入所有 key/value
 // Compaction 合并多个 SSTable 或 MemTable
 // 多路归并排序场景需要对多个 MemTable/SSTable 并发迭代
-// TODO: 支持 Range Query（范围查询）
+// Range Query（范围查询）由 database 包中的合并迭代器组合多个 Iterator 实现
 
 package memtable
 
@@ -35,6 +35,11 @@ func (i *Iterator) Seek(key kv.Key) {
 	i.iter.Seek(key)
 }
 
+// SeekLT 将迭代器定位到最后一个 key 严格小于指定值的节点。
+func (i *Iterator) SeekLT(key kv.Key) {
+	i.iter.SeekLT(key)
+}
+
 // SeekToFirst 将迭代器定位到第一个节点。
 func (i *Iterator) SeekToFirst() {
 	i.iter.SeekToFirst()
@@ -60,6 +65,27 @@ func (i *Iterator) Value() kv.Value {
 	return i.iter.Value()
 }
 
+// Seq 返回当前节点的序列号。
+func (i *Iterator) Seq() uint64 {
+	if pair := i.iter.Pair(); pair != nil {
+		return pair.Seq
+	}
+	return 0
+}
+
+// Kind 返回当前节点的记录类型。
+func (i *Iterator) Kind() kv.Kind {
+	if pair := i.iter.Pair(); pair != nil {
+		return pair.Kind
+	}
+	return kv.KindPut
+}
+
+// Pair 返回当前节点的键值对，删除标记同样会被返回。
+func (i *Iterator) Pair() *kv.KeyValuePair {
+	return i.iter.Pair()
+}
+
 // Close 关闭迭代器，释放相关资源
 func (i *Iterator) Close() {
 	i.iter.Close()
@@ -1,108 +1,241 @@
 package memtable
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"sync/atomic"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/util"
+	"github.com/xmh1011/go-lsm/wal"
 )
 
-const (
-	maxIMemTableCount = 10
-)
-
-var idGenerator atomic.Uint64
-
-// ResetIDGenerator 重置 ID 生成器
-func ResetIDGenerator() {
-	idGenerator.Store(0)
-}
-
 type Manager struct {
+	// writeMu 串行化写入以及 MemTable 的替换。写入在 mu 之外写 WAL，只在分配序列号、插入跳表和封存时持有 mu，
+	// 读取不会等待写 WAL 的磁盘 I/O
+	writeMu sync.Mutex
+
 	mu    sync.RWMutex
 	Mem   *MemTable
 	IMems []*IMemTable
+
+	// walDir 是 WAL 文件所在目录，maxSize 是每个 MemTable 的容量上限
+	walDir  string
+	maxSize uint64
+	// nextID 是 MemTable（及其 WAL 文件）的 ID 生成器，每个 Manager 独立计数
+	nextID atomic.Uint64
+	// readOnly 为 true 时只读取 WAL 文件，不创建、截断或删除任何文件
+	readOnly bool
+
+	// lastSeq 是最近一次成功写入的序列号，写入插入跳表之后在持有写锁时推进，
+	// 序列号不大于 lastSeq 的数据都已经完整地插入 MemTable
+	lastSeq uint64
+
+	// syncPolicy 是新建 MemTable 的 WAL 使用的落盘策略
+	syncPolicy wal.SyncPolicy
 }
 
-func NewMemTableManager() *Manager {
-	return &Manager{
-		Mem:   NewMemTable(idGenerator.Add(1), config.Conf.WALPath),
-		IMems: make([]*IMemTable, 0),
+// NewMemTableManager 创建在 walDir 下写 WAL 的 Manager，maxSize 为每个 MemTable 的容量上限，为 0 时使用 DefaultMaxSize
+func NewMemTableManager(walDir string, maxSize uint64) *Manager {
+	m := &Manager{
+		IMems:   make([]*IMemTable, 0),
+		walDir:  walDir,
+		maxSize: maxSize,
 	}
+	m.Mem = m.newMemTable()
+	return m
 }
 
+// NewReadOnlyMemTableManager 创建只读的 Manager，恢复时只重放 walDir 下的 WAL 文件而不修改它们。
+// 只读的 Manager 不写 WAL，调用方需要保证不向其写入数据。
+func NewReadOnlyMemTableManager(walDir string, maxSize uint64) *Manager {
+	m := &Manager{
+		Mem:      NewMemTableWithoutWAL(),
+		IMems:    make([]*IMemTable, 0),
+		walDir:   walDir,
+		maxSize:  maxSize,
+		readOnly: true,
+	}
+	m.Mem.SetMaxSize(maxSize)
+	return m
+}
+
+// newMemTable 以下一个 ID 在 walDir 下创建新的 MemTable
+func (m *Manager) newMemTable() *MemTable {
+	mem := NewMemTable(m.nextID.Add(1), m.walDir)
+	mem.SetMaxSize(m.maxSize)
+	return mem
+}
+
+// Insert 为 pair 分配新的序列号后写入 MemTable
 func (m *Manager) Insert(pair kv.KeyValuePair) (*IMemTable, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.InsertBatch([]kv.KeyValuePair{pair})
+}
 
-	if m.Mem.CanInsert(pair) {
-		if err := m.Mem.Insert(pair); err != nil {
-			log.Errorf("insert memtable error: %s", err.Error())
-			return nil, fmt.Errorf("insert memtable error: %w", err)
-		}
-		return nil, nil
+// InsertBatch 为 pairs 依次分配连续的序列号，并作为一条 WAL 记录原子地写入同一个 MemTable。
+// 当前 MemTable 放不下整批数据时先将其转为 IMemTable，返回值为此次被封存、需要刷盘的 IMemTable。
+// 被封存的 IMemTable 在刷盘完成、调用方通过 RemoveIMemTable 移除之前仍然参与读取。
+func (m *Manager) InsertBatch(pairs []kv.KeyValuePair) (*IMemTable, error) {
+	return m.InsertBatchWithOptions(pairs, WriteOptions{})
+}
+
+// InsertBatchWithOptions 与 InsertBatch 相同，按 opts 决定是否写 WAL 以及是否立即 fsync。
+// 写入失败时不推进序列号，数据也不会插入 MemTable。
+func (m *Manager) InsertBatchWithOptions(pairs []kv.KeyValuePair, opts WriteOptions) (*IMemTable, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	var sealed *IMemTable
+	m.mu.Lock()
+	// 整批数据写入同一个 MemTable，超过容量的批次也不拆分
+	if !m.Mem.CanInsertBatch(pairs) {
+		sealed = m.promoteLocked()
 	}
+	mem := m.Mem
+	seq := m.lastSeq
+	m.mu.Unlock()
 
-	evicted := m.promoteLocked()
-	if err := m.Mem.Insert(pair); err != nil {
-		log.Errorf("insert after promote error: %s", err.Error())
-		return nil, fmt.Errorf("insert after promote error: %w", err)
+	// writeMu 保证写 WAL 期间 MemTable 不会被替换，序列号也不会被其他写入分配
+	for i := range pairs {
+		seq++
+		pairs[i].Seq = seq
 	}
+	if err := mem.AppendWAL(pairs, opts); err != nil {
+		log.Errorf("insert memtable error: %s", err.Error())
+		return sealed, fmt.Errorf("insert memtable error: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return evicted, nil
+	mem.AddPairs(pairs)
+	if opts.DisableWAL {
+		mem.unlogged = true
+	}
+	m.lastSeq = seq
+	return sealed, nil
 }
 
-func (m *Manager) Search(key kv.Key) kv.Value {
+// Search 从新到旧依次查找 MemTable 和 IMemTable。
+// 第二个返回值表示是否命中记录；命中删除标记时返回 (nil, true)，调用方无需再查找 SSTable。
+func (m *Manager) Search(key kv.Key) (kv.Value, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if value, ok := m.Mem.Search(key); ok {
-		return value
+		return value, true
 	}
 	for i := len(m.IMems) - 1; i >= 0; i-- {
 		if value, ok := m.IMems[i].Search(key); ok {
-			return value
+			return value, true
 		}
 	}
-	return nil
+	return nil, false
 }
 
-func (m *Manager) Delete(key kv.Key) (*IMemTable, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SearchWithSeq 与 Search 相同，但只查找序列号不大于 seq 的版本，用于快照读。
+func (m *Manager) SearchWithSeq(key kv.Key, seq uint64) (kv.Value, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	if err := m.Mem.Delete(key); err != nil {
-		log.Errorf("delete memtable error: %s", err.Error())
-		return nil, fmt.Errorf("delete memtable error: %w", err)
+	if value, ok := m.Mem.SearchWithSeq(key, seq); ok {
+		return value, true
+	}
+	for i := len(m.IMems) - 1; i >= 0; i-- {
+		if value, ok := m.IMems[i].SearchWithSeq(key, seq); ok {
+			return value, true
+		}
 	}
+	return nil, false
+}
 
-	// 如果 memtable 中没有这个 key，但是说明有可能存在于内存中的 imemtable
-	// 而 imemtable 是不可变的，所以这时候需要在 memtable 中插入一条 deleted 记录
-	pair := kv.KeyValuePair{
-		Key:   key,
-		Value: kv.DeletedValue,
+// SearchPair 从新到旧查找序列号不大于 seq 的最新版本，返回其副本（包括删除标记和 Merge 操作数）。
+// 区间删除标记不参与点查，调用方需要结合 RangeTombstones 判断返回的版本是否已被删除。
+func (m *Manager) SearchPair(key kv.Key, seq uint64) (kv.KeyValuePair, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if pair := m.Mem.SearchPair(key, seq); pair != nil {
+		return *pair, true
 	}
-	if m.Mem.CanInsert(pair) {
-		if err := m.Mem.Insert(pair); err != nil {
-			log.Errorf("insert memtable error: %s", err.Error())
-			return nil, fmt.Errorf("insert memtable error: %w", err)
+	for i := len(m.IMems) - 1; i >= 0; i-- {
+		if pair := m.IMems[i].SearchPair(key, seq); pair != nil {
+			return *pair, true
 		}
-		return nil, nil
 	}
+	return kv.KeyValuePair{}, false
+}
+
+// RangeTombstones 返回 MemTable 及所有 IMemTable 中的区间删除标记
+func (m *Manager) RangeTombstones() []kv.KeyValuePair {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tombstones := make([]kv.KeyValuePair, 0)
+	tombstones = append(tombstones, m.Mem.RangeTombstones()...)
+	for _, imem := range m.IMems {
+		tombstones = append(tombstones, imem.RangeTombstones()...)
+	}
+	return tombstones
+}
+
+// CoveringSequence 返回 MemTable 及所有 IMemTable 中覆盖 key 且对 snapshot 可见的区间删除标记的最大序列号，
+// 没有时返回 0。与 RangeTombstones 不同，不复制区间删除标记
+func (m *Manager) CoveringSequence(key kv.Key, snapshot uint64) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	evicted := m.promoteLocked()
-	if err := m.Mem.Insert(pair); err != nil {
-		log.Errorf("insert after promote error: %s", err.Error())
-		return nil, fmt.Errorf("insert after promote error: %w", err)
+	seq := kv.CoveringSequence(m.Mem.RangeTombstones(), key, snapshot)
+	for _, imem := range m.IMems {
+		seq = max(seq, kv.CoveringSequence(imem.RangeTombstones(), key, snapshot))
 	}
+	return seq
+}
+
+// SetSyncPolicy 设置当前及之后新建的 MemTable 的 WAL 落盘策略
+func (m *Manager) SetSyncPolicy(policy wal.SyncPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.syncPolicy = policy
+	m.Mem.SetSyncPolicy(policy)
+}
+
+// SyncWAL 将当前 MemTable 的 WAL 中尚未落盘的数据 fsync 到磁盘
+func (m *Manager) SyncWAL() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	return evicted, nil
+	return m.Mem.SyncWAL()
+}
+
+// LastSequence 返回最近一次写入分配的序列号
+func (m *Manager) LastSequence() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastSeq
+}
+
+// SetLastSequence 设置已分配的序列号，恢复时用于接续 SSTable 中已持久化的序列号
+func (m *Manager) SetLastSequence(seq uint64) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastSeq = max(m.lastSeq, seq)
+}
+
+// Delete 在 MemTable 中插入一条带新序列号的删除标记。
+// 旧版本不会被物理删除：它们可能仍对存活的快照可见，也可能存在于不可变的 IMemTable 和 SSTable 中。
+func (m *Manager) Delete(key kv.Key) (*IMemTable, error) {
+	return m.InsertBatch([]kv.KeyValuePair{{Key: key, Kind: kv.KindDelete}})
 }
 
 func (m *Manager) GetAll() []*IMemTable {
@@ -114,19 +247,101 @@ func (m *Manager) GetAll() []*IMemTable {
 	return out
 }
 
-// promoteLocked：仅在已持有写锁的情况下调用！
-func (m *Manager) promoteLocked() *IMemTable {
-	var evicted *IMemTable
-	if len(m.IMems) >= maxIMemTableCount {
-		evicted = m.IMems[0]
-		m.IMems = m.IMems[1:]
+// NewIterators 返回 MemTable 及所有 IMemTable 的迭代器，按数据从新到旧排序。
+func (m *Manager) NewIterators() []*Iterator {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	iters := make([]*Iterator, 0, len(m.IMems)+1)
+	iters = append(iters, m.Mem.NewIterator())
+	for i := len(m.IMems) - 1; i >= 0; i-- {
+		iters = append(iters, m.IMems[i].NewIterator())
+	}
+	return iters
+}
+
+// Seal 将当前 MemTable 封存为 IMemTable 并返回，用于强制刷盘；MemTable 为空时不封存并返回 nil
+func (m *Manager) Seal() *IMemTable {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Mem.Empty() {
+		return nil
+	}
+	return m.promoteLocked()
+}
+
+// SealUnlogged 在当前 MemTable 包含使用 WriteOptions.DisableWAL 写入的数据时将其封存并返回，否则返回 nil。
+// 这些数据不在 WAL 中，关闭之前必须刷盘
+func (m *Manager) SealUnlogged() *IMemTable {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.Mem.unlogged {
+		return nil
 	}
+	return m.promoteLocked()
+}
+
+// Close 将当前 MemTable 的 WAL 落盘并关闭所有 WAL 文件，WAL 文件保留在磁盘上用于恢复
+func (m *Manager) Close() error {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	if err := m.Mem.Close(); err != nil {
+		log.Errorf("close WAL of memtable %d failed: %s", m.Mem.ID(), err.Error())
+		return fmt.Errorf("close WAL of memtable %d failed: %w", m.Mem.ID(), err)
+	}
+	for _, imem := range m.IMems {
+		if err := imem.closeWAL(); err != nil {
+			log.Errorf("close WAL of imemtable %d failed: %s", imem.ID(), err.Error())
+			return fmt.Errorf("close WAL of imemtable %d failed: %w", imem.ID(), err)
+		}
+	}
+	return nil
+}
+
+// IMemTableCount 返回尚未刷盘的 IMemTable 数量
+func (m *Manager) IMemTableCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.IMems)
+}
+
+// RemoveIMemTable 在 imem 刷盘完成后将其移出读取路径
+func (m *Manager) RemoveIMemTable(imem *IMemTable) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.IMems {
+		if t == imem {
+			m.IMems = append(m.IMems[:i:i], m.IMems[i+1:]...)
+			return
+		}
+	}
+}
+
+// promoteLocked 将当前 MemTable 封存为 IMemTable 并返回，仅在已持有写锁的情况下调用！
+func (m *Manager) promoteLocked() *IMemTable {
+	// 切换 WAL 之前将旧 WAL 中按策略尚未落盘的数据落盘，之后不会再有写入触发它的 fsync
+	if m.syncPolicy.Mode != wal.SyncNever {
+		if err := m.Mem.SyncWAL(); err != nil {
+			log.Errorf("sync WAL of memtable %d before promotion failed: %s", m.Mem.ID(), err.Error())
+		}
+	}
 	imem := NewIMemTable(m.Mem)
 	m.IMems = append(m.IMems, imem)
-	m.Mem = NewMemTable(util.IDGen.Next(), config.Conf.WALPath)
+	m.Mem = m.newMemTable()
+	m.Mem.SetSyncPolicy(m.syncPolicy)
 
-	return evicted
+	return imem
 }
 
 func (m *Manager) CanInsert(pair kv.KeyValuePair) bool {
@@ -136,46 +351,110 @@ func (m *Manager) CanInsert(pair kv.KeyValuePair) bool {
 	return m.Mem.CanInsert(pair)
 }
 
-// Recover 从 WALManager 恢复所有 memtable 数据，最多构造 10 个 IMemTable 和 1 个 MemTable
+// Recover 从 WALManager 恢复所有 memtable 数据，最新的 WAL 恢复为 MemTable，其余的恢复为等待刷盘的 IMemTable
 func (m *Manager) Recover() error {
+	_, err := m.RecoverWithMode(wal.TolerateCorruptedTailRecords)
+	return err
+}
+
+// RecoverWithMode 按指定的恢复模式重放所有 WAL 文件，返回丢弃了数据的 WAL 文件的恢复报告。
+// PointInTimeRecovery 模式下，一旦某个 WAL 文件被截断，所有更新的 WAL 文件都会被整体丢弃并删除。
+func (m *Manager) RecoverWithMode(mode wal.RecoveryMode) ([]*wal.RecoveryReport, error) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	ResetIDGenerator()
 	// 收集所有 WAL 恢复数据
-	files, err := os.ReadDir(config.GetWALPath()) // 返回的是文件名，而不是文件完整路径
+	files, err := os.ReadDir(m.walDir) // 返回的是文件名，而不是文件完整路径
 	if err != nil {
-		log.Errorf("failed to read WAL directory %s: %s", config.GetWALPath(), err.Error())
-		return fmt.Errorf("failed to read WAL directory %s: %w", config.GetWALPath(), err)
+		log.Errorf("failed to read WAL directory %s: %s", m.walDir, err.Error())
+		return nil, fmt.Errorf("failed to read WAL directory %s: %w", m.walDir, err)
 	}
 	// 将所有 WAL 按照 ID 排序，最新的加载为 memtable，其余加载为 imemtable
 	sort.Slice(files, func(i, j int) bool { return util.ExtractID(files[i].Name()) < util.ExtractID(files[j].Name()) })
+
 	// 构建 IMemTable 和 MemTable
-	for i, file := range files {
+	reports := make([]*wal.RecoveryReport, 0)
+	mems := make([]*MemTable, 0, len(files))
+	stopped := false
+	for _, file := range files {
+		path := filepath.Join(m.walDir, file.Name())
+		if stopped {
+			// 更早的 WAL 已被截断，更新的数据不再是一致的前缀
+			report, err := m.dropWALFile(path)
+			if err != nil {
+				return reports, err
+			}
+			reports = append(reports, report)
+			continue
+		}
+
 		mem := NewMemTableWithoutWAL()
-		if err = mem.RecoverFromWAL(file.Name()); err != nil {
+		mem.SetMaxSize(m.maxSize)
+		var report *wal.RecoveryReport
+		if m.readOnly {
+			report, err = mem.ReplayWALWithMode(path, mode)
+		} else {
+			report, err = mem.RecoverFromWALWithMode(path, mode)
+		}
+		if err != nil {
 			log.Errorf("recover from WAL %s failed: %s", file.Name(), err.Error())
-			return fmt.Errorf("recover from WAL %s failed: %w", file.Name(), err)
+			return reports, fmt.Errorf("recover from WAL %s failed: %w", file.Name(), err)
+		}
+		if report.Dropped() {
+			reports = append(reports, report)
 		}
-		if i == len(files)-1 {
+		stopped = report.Truncated && mode == wal.PointInTimeRecovery
+		mems = append(mems, mem)
+	}
+
+	for i, mem := range mems {
+		m.lastSeq = max(m.lastSeq, mem.MaxSequence())
+		if i == len(mems)-1 {
+			// 替换之前关闭创建 Manager 时打开的 WAL 文件句柄，文件本身已经作为 WAL 恢复
+			if err = m.Mem.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Errorf("close WAL file %d for memtable failed: %s", m.Mem.ID(), err.Error())
+				return reports, fmt.Errorf("close WAL file %d for memtable failed: %w", m.Mem.ID(), err)
+			}
 			m.Mem = mem
+			m.Mem.SetSyncPolicy(m.syncPolicy)
 			// 并且处理自增 id 的逻辑
-			idGenerator.Add(util.ExtractID(file.Name()))
+			m.nextID.Store(max(m.nextID.Load(), mem.ID()))
 		} else {
-			// 其他的都作为 IMemTable
-			err = mem.wal.Close()
-			if err != nil {
-				log.Errorf("close WAL file %s for imemtable failed: %s", file.Name(), err.Error())
-				return err
+			// 其他的都作为 IMemTable，只读模式下没有打开 WAL
+			imem := NewIMemTable(mem)
+			if err = imem.closeWAL(); err != nil {
+				log.Errorf("close WAL file %d for imemtable failed: %s", mem.ID(), err.Error())
+				return reports, err
 			}
-			m.IMems = append(m.IMems, NewIMemTable(mem))
+			m.IMems = append(m.IMems, imem)
 		}
 	}
 
-	// 保证最多 10 个 IMemTable
-	if len(m.IMems) > maxIMemTableCount {
-		m.IMems = m.IMems[len(m.IMems)-maxIMemTableCount:]
+	return reports, nil
+}
+
+// dropWALFile 整体丢弃一个 WAL 文件并返回对应的恢复报告，只读模式下文件保留在磁盘上
+func (m *Manager) dropWALFile(path string) (*wal.RecoveryReport, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Errorf("stat WAL file %s failed: %s", path, err.Error())
+		return nil, fmt.Errorf("stat WAL file %s failed: %w", path, err)
+	}
+	if m.readOnly {
+		log.Warnf("skip WAL file %s after point-in-time recovery stopped", path)
+		return &wal.RecoveryReport{Path: path, DroppedBytes: info.Size(), Truncated: true}, nil
 	}
+	if err = os.Remove(path); err != nil {
+		log.Errorf("remove WAL file %s failed: %s", path, err.Error())
+		return nil, fmt.Errorf("remove WAL file %s failed: %w", path, err)
+	}
+	log.Warnf("drop WAL file %s after point-in-time recovery stopped", path)
 
-	return nil
+	return &wal.RecoveryReport{
+		Path:         path,
+		DroppedBytes: info.Size(),
+		Truncated:    true,
+	}, nil
 }
@@ -8,37 +8,54 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/wal"
 )
 
-func TestMemTableBuilderInsertAndEviction(t *testing.T) {
-	manager := NewMemTableManager()
+func TestMemTableBuilderInsertAndSeal(t *testing.T) {
+	manager := NewMemTableManager(t.TempDir(), 0)
 
 	// 手动构造 key-value 对，每次都填满 MemTable 触发 Promote
-	var evicted *IMemTable
-	var err error
-	for i := 0; i <= maxIMemTableCount; i++ {
+	const promotions = 12
+	sealed := make([]*IMemTable, 0, promotions)
+	for i := 0; i <= promotions; i++ {
 		// 构造一个大的 kv，使得每次都触发 Promote
 		key := kv.Key(fmt.Sprintf("key-%03d", i))
-		value := kv.Value(make([]byte, maxMemoryTableSize)) // 触发 Flush
-		evicted, err = manager.Insert(kv.KeyValuePair{Key: key, Value: value})
+		value := kv.Value(make([]byte, DefaultMaxSize)) // 触发 Flush
+		imem, err := manager.Insert(kv.KeyValuePair{Key: key, Value: value})
 		assert.NoError(t, err, "should not return error on insert")
+		if i == 0 {
+			// 空的 MemTable 放不下超大的记录，也会先被封存
+			assert.NotNil(t, imem)
+			manager.RemoveIMemTable(imem)
+			continue
+		}
+		// 每次 Promote 都立即返回被封存的 IMemTable，不再等到数量超限才淘汰
+		assert.NotNil(t, imem, "should return sealed imem on every promotion")
+		sealed = append(sealed, imem)
 	}
-
-	// 第一次出现淘汰，应是在第 (maxIMemTableCount+1) 次 Promote 时
-	assert.NotNil(t, evicted, "should return evicted imem")
-
-	// 再插一次，继续淘汰第二个 imem
-	evicted2, _ := manager.Insert(kv.KeyValuePair{
+	assert.Equal(t, promotions, manager.IMemTableCount(), "sealed imems stay readable until flushed")
+
+	// 刷盘完成后移除，数据不再从 IMemTable 中读取
+	val, found := manager.Search("key-000")
+	assert.True(t, found)
+	assert.Len(t, val, DefaultMaxSize)
+	manager.RemoveIMemTable(sealed[0])
+	_, found = manager.Search("key-000")
+	assert.False(t, found)
+	assert.Equal(t, promotions-1, manager.IMemTableCount())
+
+	// 重复移除是安全的
+	manager.RemoveIMemTable(sealed[0])
+	assert.Equal(t, promotions-1, manager.IMemTableCount())
+
+	// 再插一次，继续封存
+	imem, err := manager.Insert(kv.KeyValuePair{
 		Key:   "last",
 		Value: make([]byte, 512*1024),
 	})
-	assert.NotNil(t, evicted2, "second eviction should not be nil")
-
-	// 验证 IMemTable 数量不超过 maxIMemTableCount
-	all := manager.GetAll()
-	assert.LessOrEqual(t, len(all), maxIMemTableCount, "should not exceed max count")
+	assert.NoError(t, err)
+	assert.NotNil(t, imem, "should seal the full memtable")
 
 	// 验证当前 MemTable 仍可写入
 	ok := manager.CanInsert(kv.KeyValuePair{Key: "z", Value: []byte("zzz")})
@@ -47,42 +64,40 @@ func TestMemTableBuilderInsertAndEviction(t *testing.T) {
 
 func TestInsertTriggersPromotion(t *testing.T) {
 	tempDir := t.TempDir()
-	config.Conf.WALPath = tempDir
 
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tempDir, 0)
 
-	var evicted *IMemTable
+	var sealed *IMemTable
 	var err error
-	for i := 0; i <= maxIMemTableCount; i++ {
+	for i := 0; i <= 10; i++ {
 		// 构造一个大的 kv，使得每次都触发 Promote
 		key := kv.Key(fmt.Sprintf("key-%03d", i))
-		value := kv.Value(make([]byte, maxMemoryTableSize)) // 触发 Flush
-		evicted, err = manager.Insert(kv.KeyValuePair{Key: key, Value: value})
+		value := kv.Value(make([]byte, DefaultMaxSize)) // 触发 Flush
+		sealed, err = manager.Insert(kv.KeyValuePair{Key: key, Value: value})
 		assert.NoError(t, err, "should not return error on insert")
+		assert.NotNil(t, sealed, "should return sealed imem")
 	}
-	// 第一次出现淘汰，应是在第 (maxIMemTableCount+1) 次 Promote 时
-	assert.NotNil(t, evicted, "should return evicted imem")
 
 	// 再插入应触发 promote
-	evicted, err = manager.Insert(kv.KeyValuePair{Key: "newKey", Value: []byte("newValue")})
+	sealed, err = manager.Insert(kv.KeyValuePair{Key: "newKey", Value: []byte("newValue")})
 	assert.NoError(t, err)
-	assert.NotNil(t, evicted, "Should evict one IMemTable")
-	assert.Equal(t, 10, len(manager.GetAll()), "Should have ten IMemTable")
+	assert.NotNil(t, sealed, "Should seal one IMemTable")
+	assert.Equal(t, 12, len(manager.GetAll()), "Should keep every unflushed IMemTable")
 
-	// 触发 promote 后再次 delete
-	evicted, err = manager.Delete("someKey")
+	// MemTable 还有空间时 delete 不触发 promote
+	sealed, err = manager.Delete("someKey")
 	assert.NoError(t, err)
-	assert.Nil(t, evicted, "Should evict one IMemTable")
+	assert.Nil(t, sealed, "Should not seal any IMemTable")
 
-	val := manager.Search("someKey")
+	val, found := manager.Search("someKey")
+	assert.True(t, found, "Deleted key should hit the tombstone")
 	assert.Nil(t, val, "Deleted key should return nil")
 }
 
 func TestDeleteTriggersPromotion(t *testing.T) {
 	tempDir := t.TempDir()
-	config.Conf.WALPath = tempDir
 
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tempDir, 0)
 
 	// 填满 MemTable
 	for i := 0; i < 100000; i++ {
@@ -94,19 +109,40 @@ func TestDeleteTriggersPromotion(t *testing.T) {
 
 func TestSearchFromMemTables(t *testing.T) {
 	tempDir := t.TempDir()
-	config.Conf.WALPath = tempDir
 
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tempDir, 0)
 	_, err := manager.Insert(kv.KeyValuePair{Key: "key", Value: []byte("value")})
 	assert.NoError(t, err, "Insert should not return error")
 
 	_, err = manager.Insert(kv.KeyValuePair{Key: "key", Value: []byte("newValue")}) // 更新同一 key
 	assert.NoError(t, err, "Insert should not return error")
 
-	val := manager.Search("key")
+	val, found := manager.Search("key")
+	assert.True(t, found)
 	assert.Equal(t, kv.Value("newValue"), val)
 }
 
+// TestCoveringSequence 测试 MemTable 和 IMemTable 中的区间删除标记覆盖的序列号
+func TestCoveringSequence(t *testing.T) {
+	manager := NewMemTableManager(t.TempDir(), 0)
+	_, err := manager.Insert(kv.KeyValuePair{Key: "b", Value: kv.Value("d"), Kind: kv.KindRangeDelete})
+	assert.NoError(t, err)
+	first := manager.LastSequence()
+
+	assert.Equal(t, first, manager.CoveringSequence("c", first))
+	assert.Equal(t, uint64(0), manager.CoveringSequence("c", first-1))
+	assert.Equal(t, uint64(0), manager.CoveringSequence("d", first))
+
+	// 冻结之后 IMemTable 中的区间删除标记仍然生效，取最大的序列号
+	assert.NotNil(t, manager.Seal())
+	_, err = manager.Insert(kv.KeyValuePair{Key: "c", Value: kv.Value("e"), Kind: kv.KindRangeDelete})
+	assert.NoError(t, err)
+	second := manager.LastSequence()
+	assert.Equal(t, first, manager.CoveringSequence("b", second))
+	assert.Equal(t, second, manager.CoveringSequence("c", second))
+	assert.Equal(t, first, manager.CoveringSequence("c", first))
+}
+
 // mockCreateWalFile 在指定目录下创建一个空的 WAL 文件，文件名必须符合 ExtractID 的格式 "000001.wal"
 func mockCreateWalFile(t *testing.T, dir string, id uint64) string {
 	filename := filepath.Join(dir, fmt.Sprintf("%d.wal", id)) // 比如 "1.wal"
@@ -118,23 +154,26 @@ func mockCreateWalFile(t *testing.T, dir string, id uint64) string {
 
 func TestRecoverSuccess(t *testing.T) {
 	tempDir := t.TempDir()
-	config.Conf.WALPath = tempDir
 
 	// 创建多个 WAL 文件，id 从 1 到 5
 	for i := uint64(1); i <= 5; i++ {
 		mockCreateWalFile(t, tempDir, i)
 	}
 
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tempDir, 0)
+	initial := manager.Mem
 
 	// Recover 应成功返回，且最后一个 WAL 恢复的 MemTable 是 manager.Mem，其余是 IMemTable
 	err := manager.Recover()
 	assert.NoError(t, err)
 	assert.NotNil(t, manager.Mem)
+	// 被替换的 MemTable 的 WAL 文件句柄已经关闭
+	assert.NotSame(t, initial, manager.Mem)
+	assert.ErrorIs(t, initial.wal.Close(), os.ErrClosed)
 	assert.GreaterOrEqual(t, len(manager.IMems), 0)
 
-	// 确认 IMemTable 数量不超过 maxIMemTableCount
-	assert.LessOrEqual(t, len(manager.IMems), maxIMemTableCount)
+	// 除最新的 WAL 之外都恢复为等待刷盘的 IMemTable，不再丢弃超出数量的部分
+	assert.GreaterOrEqual(t, len(manager.IMems), 4)
 
 	// 检查 manager.Mem 的 id 是最后一个文件的 id
 	lastFile := mockCreateWalFile(t, tempDir, 100)
@@ -144,11 +183,10 @@ func TestRecoverSuccess(t *testing.T) {
 // 模拟 WAL 目录读取失败
 func TestRecoverReadDirFail(t *testing.T) {
 	tmp := t.TempDir()
-	config.Conf.WALPath = tmp
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tmp, 0)
 
 	// 传入一个不存在的目录
-	config.Conf.WALPath = "/path/does/not/exist"
+	manager.walDir = "/path/does/not/exist"
 
 	err := manager.Recover()
 	assert.Error(t, err)
@@ -157,7 +195,6 @@ func TestRecoverReadDirFail(t *testing.T) {
 // 模拟 WAL 恢复失败（用空文件名，必定失败）
 func TestRecoverFromWALFail(t *testing.T) {
 	tempDir := t.TempDir()
-	config.Conf.WALPath = tempDir
 
 	// 创建一个非法文件名
 	fname := filepath.Join(tempDir, "invalid.wal")
@@ -165,8 +202,273 @@ func TestRecoverFromWALFail(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, f.Close(), "WAL file should be created")
 
-	manager := NewMemTableManager()
+	manager := NewMemTableManager(tempDir, 0)
 
 	err = manager.Recover()
 	assert.Error(t, err)
 }
+
+func TestSequenceAssignment(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manager := NewMemTableManager(tempDir, 0)
+	_, err := manager.Insert(kv.KeyValuePair{Key: "seq", Value: []byte("v1")})
+	assert.NoError(t, err)
+	snapshot := manager.LastSequence()
+
+	_, err = manager.Insert(kv.KeyValuePair{Key: "seq", Value: []byte("v2")})
+	assert.NoError(t, err)
+	_, err = manager.Delete("seq")
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot+2, manager.LastSequence())
+
+	// 最新版本为删除标记，快照仍能读取旧版本
+	val, found := manager.Search("seq")
+	assert.True(t, found)
+	assert.Nil(t, val)
+
+	val, found = manager.SearchWithSeq("seq", snapshot)
+	assert.True(t, found)
+	assert.Equal(t, kv.Value("v1"), val)
+
+	// 恢复时序列号只会前进
+	manager.SetLastSequence(1)
+	assert.Equal(t, snapshot+2, manager.LastSequence())
+}
+
+func TestInsertWALFailureKeepsSequence(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manager := NewMemTableManager(tempDir, 0)
+	_, err := manager.Insert(kv.KeyValuePair{Key: "a", Value: []byte("1")})
+	assert.NoError(t, err)
+	before := manager.LastSequence()
+
+	// 关闭 WAL 使后续写入失败
+	assert.NoError(t, manager.Mem.wal.Close())
+	_, err = manager.InsertBatch([]kv.KeyValuePair{
+		{Key: "b", Value: []byte("2")},
+		{Key: "c", Value: []byte("3")},
+	})
+	assert.Error(t, err)
+
+	// 失败的写入不推进序列号，也不插入 MemTable
+	assert.Equal(t, before, manager.LastSequence())
+	_, found := manager.Search("b")
+	assert.False(t, found)
+	_, found = manager.Search("c")
+	assert.False(t, found)
+}
+
+func TestSearchDuringConcurrentInsert(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manager := NewMemTableManager(tempDir, 0)
+	defer manager.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			key := kv.Key(fmt.Sprintf("key%03d", i))
+			_, err := manager.InsertBatchWithOptions([]kv.KeyValuePair{{Key: key, Value: []byte("v")}}, WriteOptions{Sync: true})
+			assert.NoError(t, err)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, uint64(200), manager.LastSequence())
+			for i := 0; i < 200; i++ {
+				_, found := manager.Search(kv.Key(fmt.Sprintf("key%03d", i)))
+				assert.True(t, found)
+			}
+			return
+		default:
+			// 序列号不大于 LastSequence 的数据都已插入 MemTable
+			seq := manager.LastSequence()
+			if seq > 0 {
+				_, found := manager.SearchWithSeq(kv.Key(fmt.Sprintf("key%03d", seq-1)), seq)
+				assert.True(t, found)
+			}
+		}
+	}
+}
+
+func TestRecoverPointInTimeDropsNewerWALs(t *testing.T) {
+	tempDir := t.TempDir()
+
+	for id := uint64(1); id <= 3; id++ {
+		mem := NewMemTable(id, tempDir)
+		assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("k%d", id)), Value: []byte("v"), Seq: id}))
+		assert.NoError(t, mem.wal.Close())
+	}
+	// 破坏第 2 个 WAL 的最后一个字节
+	path := filepath.Join(tempDir, "2.wal")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+
+	manager := NewMemTableManager(tempDir, 0)
+	reports, err := manager.RecoverWithMode(wal.PointInTimeRecovery)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 2)
+	assert.Equal(t, path, reports[0].Path)
+	assert.Equal(t, filepath.Join(tempDir, "3.wal"), reports[1].Path)
+
+	// 损坏之前的数据保留，之后的数据全部丢弃
+	_, found := manager.Search("k1")
+	assert.True(t, found)
+	_, found = manager.Search("k2")
+	assert.False(t, found)
+	_, found = manager.Search("k3")
+	assert.False(t, found)
+	assert.Equal(t, uint64(2), manager.Mem.ID())
+	assert.Equal(t, uint64(1), manager.LastSequence())
+
+	_, err = os.Stat(filepath.Join(tempDir, "3.wal"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestManagerSyncPolicyAndWriteOptions 测试落盘策略对新建的 MemTable 同样生效，以及跳过 WAL 的写入
+func TestManagerSyncPolicyAndWriteOptions(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manager := NewMemTableManager(tempDir, 0)
+	manager.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncEveryWrite})
+
+	_, err := manager.Insert(kv.KeyValuePair{Key: "a", Value: []byte("1")})
+	assert.NoError(t, err)
+	assert.Zero(t, manager.Mem.wal.UnsyncedBytes())
+
+	// 触发 Promote 之后新的 MemTable 继承落盘策略
+	_, err = manager.Insert(kv.KeyValuePair{Key: "big", Value: make([]byte, DefaultMaxSize)})
+	assert.NoError(t, err)
+	assert.Len(t, manager.GetAll(), 1)
+	_, err = manager.Insert(kv.KeyValuePair{Key: "b", Value: []byte("2")})
+	assert.NoError(t, err)
+	assert.Zero(t, manager.Mem.wal.UnsyncedBytes())
+
+	// 跳过 WAL 的写入只存在于内存中
+	manager.SetSyncPolicy(wal.DefaultSyncPolicy())
+	size := manager.Mem.wal.UnsyncedBytes()
+	_, err = manager.InsertBatchWithOptions([]kv.KeyValuePair{{Key: "c", Value: []byte("3")}}, WriteOptions{DisableWAL: true})
+	assert.NoError(t, err)
+	assert.Equal(t, size, manager.Mem.wal.UnsyncedBytes())
+	val, found := manager.Search("c")
+	assert.True(t, found)
+	assert.Equal(t, kv.Value("3"), val)
+
+	// 写入 WAL 但不落盘，之后通过 SyncWAL 落盘
+	_, err = manager.Insert(kv.KeyValuePair{Key: "d", Value: []byte("4")})
+	assert.NoError(t, err)
+	assert.NotZero(t, manager.Mem.wal.UnsyncedBytes())
+	assert.NoError(t, manager.SyncWAL())
+	assert.Zero(t, manager.Mem.wal.UnsyncedBytes())
+}
+
+// TestManagerSealAndClose 测试强制封存以及关闭之后 WAL 仍保留在磁盘上
+func TestManagerSealAndClose(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manager := NewMemTableManager(tempDir, 0)
+	// 空的 MemTable 不封存
+	assert.Nil(t, manager.Seal())
+
+	_, err := manager.Insert(kv.KeyValuePair{Key: "a", Value: []byte("1")})
+	assert.NoError(t, err)
+	sealed := manager.Seal()
+	assert.NotNil(t, sealed)
+	assert.Equal(t, 1, manager.IMemTableCount())
+	assert.True(t, manager.Mem.Empty())
+
+	_, err = manager.Insert(kv.KeyValuePair{Key: "b", Value: []byte("2")})
+	assert.NoError(t, err)
+	assert.NoError(t, manager.Close())
+	assert.Zero(t, manager.Mem.wal.UnsyncedBytes())
+	files, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// 刷盘完成后删除已关闭的 WAL 不会报错
+	sealed.Clean()
+	_, err = os.Stat(filepath.Join(tempDir, fmt.Sprintf("%d.wal", sealed.ID())))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestManagerPerInstanceOptions 测试 WAL 目录、MemTable 容量和 ID 生成器均属于各自的 Manager
+func TestManagerPerInstanceOptions(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	small := NewMemTableManager(dir1, 128)
+	other := NewMemTableManager(dir2, 0)
+	assert.Equal(t, uint64(1), small.Mem.ID())
+	assert.Equal(t, uint64(1), other.Mem.ID())
+
+	// 超过 128 字节即封存，默认容量的 Manager 不受影响
+	sealed, err := small.Insert(kv.KeyValuePair{Key: "a", Value: make([]byte, 64)})
+	assert.NoError(t, err)
+	assert.Nil(t, sealed)
+	sealed, err = small.Insert(kv.KeyValuePair{Key: "b", Value: make([]byte, 64)})
+	assert.NoError(t, err)
+	assert.NotNil(t, sealed)
+	assert.Equal(t, uint64(2), small.Mem.ID())
+
+	sealed, err = other.Insert(kv.KeyValuePair{Key: "a", Value: make([]byte, 256)})
+	assert.NoError(t, err)
+	assert.Nil(t, sealed)
+	assert.Equal(t, uint64(1), other.Mem.ID())
+
+	// WAL 文件写入各自的目录
+	_, err = os.Stat(filepath.Join(dir1, "2.wal"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir2, "2.wal"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, small.Close())
+	assert.NoError(t, other.Close())
+
+	// 恢复后继续沿用容量设置和 ID
+	recovered := NewMemTableManager(dir1, 128)
+	assert.NoError(t, recovered.Recover())
+	assert.Equal(t, uint64(2), recovered.Mem.ID())
+	assert.Equal(t, uint64(128), recovered.Mem.capacity())
+}
+
+// TestReadOnlyManagerRecover 测试只读的 Manager 恢复时不创建、截断或删除 WAL 文件
+func TestReadOnlyManagerRecover(t *testing.T) {
+	tempDir := t.TempDir()
+	manager := NewMemTableManager(tempDir, 128)
+	for i := 0; i < 3; i++ {
+		_, err := manager.Insert(kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("k%d", i)), Value: make([]byte, 64)})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, manager.Close())
+	// 破坏第 2 个 WAL 的最后一个字节
+	path := filepath.Join(tempDir, "2.wal")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+	before, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+
+	readOnly := NewReadOnlyMemTableManager(tempDir, 128)
+	reports, err := readOnly.RecoverWithMode(wal.PointInTimeRecovery)
+	assert.NoError(t, err)
+	assert.Len(t, reports, 2)
+	_, found := readOnly.Search("k0")
+	assert.True(t, found)
+	_, found = readOnly.Search("k2")
+	assert.False(t, found)
+	assert.Equal(t, 1, readOnly.IMemTableCount())
+	assert.NoError(t, readOnly.Close())
+
+	// 文件数量和内容均未改变
+	after, err := os.ReadDir(tempDir)
+	assert.NoError(t, err)
+	assert.Equal(t, len(before), len(after))
+	corrupted, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data, corrupted)
+}
@@ -14,7 +14,6 @@ import (
 	"fmt"
 	"path/filepath"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/memtable/skiplist"
@@ -23,7 +22,8 @@ import (
 )
 
 const (
-	maxMemoryTableSize = 2 * 1024 * 1024 // 2MB
+	// DefaultMaxSize 是未指定容量时 MemTable 的默认容量
+	DefaultMaxSize = 2 * 1024 * 1024 // 2MB
 )
 
 // MemTable is an in-memory data structure used to store kv.KeyValuePairs.
@@ -36,6 +36,13 @@ type MemTable struct {
 	entries     *skiplist.SkipList
 	wal         *wal.WAL
 	sizeInBytes uint64
+	maxSeq      uint64 // 已写入记录的最大序列号
+	maxSize     uint64 // 容量上限，为 0 时使用 DefaultMaxSize
+	unlogged    bool   // 是否包含使用 WriteOptions.DisableWAL 写入的数据，这些数据只能通过刷盘持久化
+
+	// rangeDels 保存区间删除标记。它们覆盖的是一段 key 而不是单个 key，因此不放入跳表，
+	// 以免点查和遍历把区间的起始 key 当作普通记录
+	rangeDels []kv.KeyValuePair
 }
 
 // NewMemTable creates a new instance of MemTable with WAL.
@@ -64,37 +71,116 @@ func (t *MemTable) Search(key kv.Key) (kv.Value, bool) {
 	return t.entries.Search(key)
 }
 
+// SearchWithSeq 查找序列号不大于 seq 的最新版本，用于快照读。
+func (t *MemTable) SearchWithSeq(key kv.Key, seq uint64) (kv.Value, bool) {
+	return t.entries.SearchWithSeq(key, seq)
+}
+
+// SearchPair 返回序列号不大于 seq 的最新版本（包括删除标记），不存在时返回 nil
+func (t *MemTable) SearchPair(key kv.Key, seq uint64) *kv.KeyValuePair {
+	return t.entries.SearchPair(key, seq)
+}
+
+// RangeTombstones 返回 MemTable 中的所有区间删除标记
+func (t *MemTable) RangeTombstones() []kv.KeyValuePair {
+	return t.rangeDels
+}
+
+// WriteOptions 控制单次写入如何记录 WAL
+type WriteOptions struct {
+	// Sync 为 true 时写入 WAL 之后立即 fsync，不受落盘策略限制
+	Sync bool
+	// DisableWAL 为 true 时不写 WAL，数据在刷盘之前崩溃会丢失
+	DisableWAL bool
+}
+
 // Insert inserts a key-value pair into the memtable and WAL.
 func (t *MemTable) Insert(pair kv.KeyValuePair) error {
+	return t.InsertBatch([]kv.KeyValuePair{pair})
+}
+
+// InsertBatch 将一组 key-value 作为一条 WAL 记录写入后再插入 MemTable，恢复时整体重放。
+func (t *MemTable) InsertBatch(pairs []kv.KeyValuePair) error {
+	return t.InsertBatchWithOptions(pairs, WriteOptions{})
+}
+
+// InsertBatchWithOptions 与 InsertBatch 相同，按 opts 决定是否写 WAL 以及是否立即 fsync。
+func (t *MemTable) InsertBatchWithOptions(pairs []kv.KeyValuePair, opts WriteOptions) error {
 	// WAL: write to log first, then flush to disk.
-	if t.wal != nil {
-		if err := t.wal.Append(pair); err != nil {
-			log.Errorf("error appending %+v to WAL: %s", pair, err.Error())
-			return fmt.Errorf("error appending %+v to WAL: %w", pair, err)
-		}
+	if err := t.AppendWAL(pairs, opts); err != nil {
+		return err
+	}
+	t.AddPairs(pairs)
+	if opts.DisableWAL {
+		t.unlogged = true
 	}
-	t.AddPair(pair)
 	return nil
 }
 
-// Delete deletes a key-value pair from the memtable and WAL.
-// 无论跳表中是否存在该 key，都应将删除操作记录到 WAL（写入删除标记，即 tombstone），
-// 以便在后续合并(compaction)时正确处理删除操作。
-// 如果 memtable 中没有这个 key，那么则直接返回 nil
+// AppendWAL 按 opts 将 pairs 作为一条记录写入 WAL，不修改 MemTable。没有 WAL 或 opts.DisableWAL 时不做任何事
+func (t *MemTable) AppendWAL(pairs []kv.KeyValuePair, opts WriteOptions) error {
+	if t.wal == nil || opts.DisableWAL {
+		return nil
+	}
+	if err := t.wal.AppendBatchWithSync(pairs, opts.Sync); err != nil {
+		log.Errorf("error appending %d pairs to WAL: %s", len(pairs), err.Error())
+		return fmt.Errorf("error appending %d pairs to WAL: %w", len(pairs), err)
+	}
+	return nil
+}
+
+// Delete 向 MemTable 和 WAL 写入 key 的删除标记（tombstone）。
+// 无论跳表中是否存在该 key 都需要写入，删除标记在读取和合并(compaction)时覆盖更旧数据源中的同名 key。
+// 删除标记使用比 MemTable 中已有记录更大的序列号；经由 Manager 写入时序列号由 Manager 统一分配。
 func (t *MemTable) Delete(key kv.Key) error {
-	// Delete the record in skiplist first
-	if ok := t.entries.Delete(key); ok {
-		// if the record is deleted in skiplist, insert a nil value in the wal.
-		if t.wal != nil {
-			if err := t.wal.Append(kv.KeyValuePair{Key: key, Value: nil}); err != nil {
-				log.Errorf("error delete key %s from WAL: %s", key, err.Error())
-				return fmt.Errorf("error delete key %s from WAL: %w", key, err)
-			}
-		}
+	pair := kv.KeyValuePair{
+		Key:  key,
+		Seq:  t.maxSeq + 1,
+		Kind: kv.KindDelete,
+	}
+	if err := t.Insert(pair); err != nil {
+		log.Errorf("error delete key %s: %s", key, err.Error())
+		return fmt.Errorf("error delete key %s: %w", key, err)
 	}
 	return nil
 }
 
+// SetSyncPolicy 设置 WAL 的落盘策略
+func (t *MemTable) SetSyncPolicy(policy wal.SyncPolicy) {
+	if t.wal != nil {
+		t.wal.SetSyncPolicy(policy)
+	}
+}
+
+// SyncWAL 将 WAL 中尚未落盘的数据 fsync 到磁盘
+func (t *MemTable) SyncWAL() error {
+	if t.wal == nil {
+		return nil
+	}
+	return t.wal.SyncIfDirty()
+}
+
+// Close 将 WAL 中的数据 fsync 到磁盘后关闭 WAL 文件
+func (t *MemTable) Close() error {
+	if t.wal == nil {
+		return nil
+	}
+	if err := t.wal.Sync(); err != nil {
+		return err
+	}
+	return t.wal.Close()
+}
+
+// Empty 返回 MemTable 中是否既没有记录也没有区间删除标记
+func (t *MemTable) Empty() bool {
+	return t.entries.First() == nil && len(t.rangeDels) == 0
+}
+
+// NewIterator 返回遍历当前 MemTable 的迭代器。
+func (t *MemTable) NewIterator() *Iterator {
+	return NewMemTableIterator(t.entries)
+}
+
 func (t *MemTable) ApproximateSize() uint64 {
 	return t.sizeInBytes
 }
@@ -103,6 +189,11 @@ func (t *MemTable) ID() uint64 {
 	return t.id
 }
 
+// MaxSequence 返回 MemTable 中记录的最大序列号
+func (t *MemTable) MaxSequence() uint64 {
+	return t.maxSeq
+}
+
 func (t *MemTable) AddPairs(pairs []kv.KeyValuePair) {
 	for _, pair := range pairs {
 		t.AddPair(pair)
@@ -112,32 +203,89 @@ func (t *MemTable) AddPairs(pairs []kv.KeyValuePair) {
 func (t *MemTable) AddPair(pair kv.KeyValuePair) {
 	// 估算大小
 	t.sizeInBytes += pair.EstimateSize()
+	t.maxSeq = max(t.maxSeq, pair.Seq)
+	if pair.Kind == kv.KindRangeDelete {
+		t.rangeDels = append(t.rangeDels, pair)
+		return
+	}
 	// Writing the key/value pair in the Skiplist.
 	t.entries.Add(pair)
 }
 
 func (t *MemTable) CanInsert(pair kv.KeyValuePair) bool {
-	return t.ApproximateSize()+pair.EstimateSize() <= maxMemoryTableSize
+	return t.CanInsertBatch([]kv.KeyValuePair{pair})
+}
+
+// CanInsertBatch 判断整批数据能否一起写入当前 MemTable
+func (t *MemTable) CanInsertBatch(pairs []kv.KeyValuePair) bool {
+	size := t.ApproximateSize()
+	for i := range pairs {
+		size += pairs[i].EstimateSize()
+	}
+	return size <= t.capacity()
+}
+
+// SetMaxSize 设置 MemTable 的容量上限，为 0 时使用 DefaultMaxSize
+func (t *MemTable) SetMaxSize(size uint64) {
+	t.maxSize = size
+}
+
+func (t *MemTable) capacity() uint64 {
+	if t.maxSize == 0 {
+		return DefaultMaxSize
+	}
+	return t.maxSize
+}
+
+// RecoverFromWAL 从路径为 path 的 WAL 文件恢复 MemTable，MemTable 的 id 取自文件名
+func (t *MemTable) RecoverFromWAL(path string) error {
+	_, err := t.RecoverFromWALWithMode(path, wal.TolerateCorruptedTailRecords)
+	return err
 }
 
-// RecoverFromWAL constructs up to 10 IMemTable and 1 MemTable from WAL files.
-func (t *MemTable) RecoverFromWAL(fileName string) error {
+// RecoverFromWALWithMode 按指定的恢复模式重放 WAL 文件，并返回丢弃数据的情况
+func (t *MemTable) RecoverFromWALWithMode(path string, mode wal.RecoveryMode) (*wal.RecoveryReport, error) {
+	fileName := filepath.Base(path)
 	var err error
 	t.id, err = util.ExtractIDFromFileName(fileName)
 	if err != nil {
 		log.Errorf("invalid WAL file: %s, err: %s", fileName, err.Error())
-		return fmt.Errorf("invalid WAL file %s: %w", fileName, err)
+		return nil, fmt.Errorf("invalid WAL file %s: %w", fileName, err)
 	}
 
 	pairs := make([]kv.KeyValuePair, 0)
-	t.wal, err = wal.Recover(filepath.Join(config.GetWALPath(), fileName), func(pair kv.KeyValuePair) {
+	var report *wal.RecoveryReport
+	t.wal, report, err = wal.RecoverWithMode(path, mode, func(pair kv.KeyValuePair) {
 		pairs = append(pairs, pair)
 	})
 	if err != nil {
 		log.Errorf("recover WAL %s failed: %s", fileName, err.Error())
-		return fmt.Errorf("recover WAL %s failed: %w", fileName, err)
+		return report, fmt.Errorf("recover WAL %s failed: %w", fileName, err)
 	}
 	t.AddPairs(pairs)
 
-	return nil
+	return report, nil
+}
+
+// ReplayWALWithMode 以只读方式重放 WAL 文件恢复 MemTable，不修改文件，恢复出的 MemTable 不关联 WAL
+func (t *MemTable) ReplayWALWithMode(path string, mode wal.RecoveryMode) (*wal.RecoveryReport, error) {
+	fileName := filepath.Base(path)
+	var err error
+	t.id, err = util.ExtractIDFromFileName(fileName)
+	if err != nil {
+		log.Errorf("invalid WAL file: %s, err: %s", fileName, err.Error())
+		return nil, fmt.Errorf("invalid WAL file %s: %w", fileName, err)
+	}
+
+	pairs := make([]kv.KeyValuePair, 0)
+	report, err := wal.Replay(path, mode, func(pair kv.KeyValuePair) {
+		pairs = append(pairs, pair)
+	})
+	if err != nil {
+		log.Errorf("replay WAL %s failed: %s", fileName, err.Error())
+		return report, fmt.Errorf("replay WAL %s failed: %w", fileName, err)
+	}
+	t.AddPairs(pairs)
+
+	return report, nil
 }
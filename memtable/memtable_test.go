@@ -7,7 +7,6 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 )
 
@@ -48,7 +47,7 @@ func TestDelete(t *testing.T) {
 	m := NewMemTable(3, tempDir)
 	pair := kv.KeyValuePair{
 		Key:   "delKey",
-		Value: kv.DeletedValue,
+		Value: []byte("delValue"),
 	}
 
 	// Insert the key/value pair.
@@ -63,9 +62,14 @@ func TestDelete(t *testing.T) {
 	err = m.Delete("nonexistent")
 	assert.NoError(t, err)
 
-	// Seek for the key after deletion.
-	_, found := m.Search("delKey")
-	assert.False(t, found, "Deleted key should not be found")
+	// Seek for the key after deletion: the tombstone hides the older value.
+	val, found := m.Search("delKey")
+	assert.True(t, found, "Deleted key should resolve to its tombstone")
+	assert.Nil(t, val, "Deleted key should not return a value")
+
+	tombstone := m.SearchPair("delKey", kv.MaxSequence)
+	assert.NotNil(t, tombstone)
+	assert.Equal(t, kv.KindDelete, tombstone.Kind)
 }
 
 // TestCanInsertAndApproximateSize 测试容量判断和大小统计
@@ -85,15 +89,14 @@ func TestCanInsertAndApproximateSize(t *testing.T) {
 func TestRecoverFromWAL(t *testing.T) {
 	// 先创建一个 MemTable 并插入数据，然后 Close WAL，准备恢复
 	tmp := t.TempDir()
-	config.Conf.WALPath = tmp
-	m := NewMemTable(100, config.GetWALPath())
+	m := NewMemTable(100, tmp)
 	pair := kv.KeyValuePair{Key: "recoverKey", Value: []byte("recoverValue")}
 	err := m.Insert(pair)
 	assert.NoError(t, err)
 
 	// 创建一个新实例来恢复
-	m2 := NewMemTable(100, config.GetWALPath())
-	err = m2.RecoverFromWAL("100.wal")
+	m2 := NewMemTableWithoutWAL()
+	err = m2.RecoverFromWAL(filepath.Join(tmp, "100.wal"))
 	assert.NoError(t, err)
 
 	val, found := m2.Search("recoverKey")
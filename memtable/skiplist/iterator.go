@@ -6,6 +6,8 @@ import (
 
 // Iterator is a read-only iterator for the SkipList,
 // used for range scans, flush operations, and compaction merges.
+// 迭代器会原样返回删除标记（tombstone），由调用方通过 Pair().IsDeleted() 判断，
+// 这样 flush 和多路归并时删除标记才能覆盖更旧数据源中的同名 key。
 type Iterator struct {
 	head *Node // Reference to the skiplist's head node.
 	curr *Node // Current node the iterator is pointing to.
@@ -19,60 +21,65 @@ type Iterator struct {
 func NewSkipListIterator(s *SkipList) *Iterator {
 	return &Iterator{
 		head: s.Head,
-		curr: s.Head.Forward[0],
+		curr: s.Head.next(0),
 	}
 }
 
-// SeekToFirst moves the iterator to the first valid node in the SkipList (skipping logically deleted nodes).
+// SeekToFirst moves the iterator to the first node in the SkipList.
 func (i *Iterator) SeekToFirst() {
-	i.curr = i.head.Forward[0]
-	// Skip over nodes marked as deleted.
-	for i.curr != nil && i.curr.Pair.IsDeleted() {
-		i.curr = i.curr.Forward[0]
-	}
+	i.curr = i.head.next(0)
 }
 
-// SeekToLast moves the iterator to the last valid node in the SkipList (skipping logically deleted nodes).
+// SeekToLast moves the iterator to the last node in the SkipList.
 func (i *Iterator) SeekToLast() {
-	i.curr = i.head
-	// 从头开始顺序到达最后一个节点
-	for i.curr.Forward[0] != nil {
-		i.curr = i.curr.Forward[0]
+	node := i.head
+	// 从顶层向下逐层查找，每层尽量向右走
+	for level := len(i.head.forward) - 1; level >= 0; level-- {
+		for next := node.next(level); next != nil; next = node.next(level) {
+			node = next
+		}
 	}
-	// 如果最后节点被删除，则无法向后查找，可设为 nil
-	if i.curr != nil && i.curr.Pair.IsDeleted() {
-		i.curr = nil
+	if node == i.head {
+		node = nil
 	}
+	i.curr = node
 }
 
-// Seek 将迭代器定位到第一个 key 大于或等于指定值的有效节点。
+// Seek 将迭代器定位到第一个 key 大于或等于指定值的节点。
 func (i *Iterator) Seek(key kv.Key) {
+	i.curr = i.findLessThan(key).next(0)
+}
+
+// SeekLT 将迭代器定位到最后一个 key 严格小于指定值的节点，不存在时迭代器失效。
+func (i *Iterator) SeekLT(key kv.Key) {
+	node := i.findLessThan(key)
+	if node == i.head {
+		node = nil
+	}
+	i.curr = node
+}
+
+// findLessThan 返回 key 严格小于指定值的最后一个节点，不存在时返回头节点。
+func (i *Iterator) findLessThan(key kv.Key) *Node {
 	node := i.head
 	// 从顶层向下逐层查找
-	for level := maxLevel - 1; level >= 0; level-- {
-		for node.Forward[level] != nil && node.Forward[level].Pair.Key < key {
-			node = node.Forward[level]
+	for level := len(i.head.forward) - 1; level >= 0; level-- {
+		for next := node.next(level); next != nil && next.Pair.Key < key; next = node.next(level) {
+			node = next
 		}
 	}
-	// 目标位置可能就是 node.Forward[0]
-	node = node.Forward[0]
-
-	// 跳过逻辑删除节点
-	for node != nil && node.Pair.IsDeleted() {
-		node = node.Forward[0]
-	}
-	i.curr = node
+	return node
 }
 
-// Valid returns true if the iterator points to a valid (non-deleted) node.
+// Valid returns true if the iterator points to a node.
 func (i *Iterator) Valid() bool {
-	return i.curr != nil && !i.curr.Pair.IsDeleted()
+	return i.curr != nil
 }
 
 // Next moves the iterator to the next node in the SkipList.
 func (i *Iterator) Next() {
 	if i.curr != nil {
-		i.curr = i.curr.Forward[0]
+		i.curr = i.curr.next(0)
 	}
 }
 
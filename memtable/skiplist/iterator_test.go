@@ -1,6 +1,7 @@
 package skiplist
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,3 +69,108 @@ func TestSkipListIteratorSkipDeleted(t *testing.T) {
 
 	assert.Equal(t, resultKeys, expectedKeys)
 }
+
+// TestSkipListIteratorTombstone ensures that tombstones are visible to the iterator
+// instead of terminating the scan.
+func TestSkipListIteratorTombstone(t *testing.T) {
+	sl := NewSkipList()
+	sl.Add(kv.KeyValuePair{Key: "a", Value: []byte("1")})
+	sl.Add(kv.KeyValuePair{Key: "b", Kind: kv.KindDelete})
+	sl.Add(kv.KeyValuePair{Key: "c", Value: []byte("3")})
+
+	iter := NewSkipListIterator(sl)
+	defer iter.Close()
+
+	var resultKeys []kv.Key
+	var deleted []bool
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		resultKeys = append(resultKeys, iter.Key())
+		deleted = append(deleted, iter.Pair().IsDeleted())
+	}
+
+	assert.Equal(t, []kv.Key{"a", "b", "c"}, resultKeys)
+	assert.Equal(t, []bool{false, true, false}, deleted)
+}
+
+// TestSkipListIteratorSeek tests Seek, SeekLT and SeekToLast positioning.
+func TestSkipListIteratorSeek(t *testing.T) {
+	sl := NewSkipList()
+	for _, key := range []kv.Key{"b", "d", "f"} {
+		sl.Add(kv.KeyValuePair{Key: key, Value: []byte(key)})
+	}
+
+	iter := NewSkipListIterator(sl)
+	defer iter.Close()
+
+	iter.Seek("c")
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("d"), iter.Key())
+
+	iter.Seek("d")
+	assert.Equal(t, kv.Key("d"), iter.Key())
+
+	iter.Seek("g")
+	assert.False(t, iter.Valid())
+
+	iter.SeekLT("d")
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("b"), iter.Key())
+
+	iter.SeekLT("z")
+	assert.Equal(t, kv.Key("f"), iter.Key())
+
+	iter.SeekLT("b")
+	assert.False(t, iter.Valid())
+
+	iter.SeekToLast()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("f"), iter.Key())
+
+	empty := NewSkipListIterator(NewSkipList())
+	empty.SeekToLast()
+	assert.False(t, empty.Valid())
+}
+
+// TestSkipListIteratorConcurrentAdd tests that iterators and searches can run concurrently with Add,
+// and always see the nodes in order. Run it with -race.
+func TestSkipListIteratorConcurrentAdd(t *testing.T) {
+	sl := NewSkipList()
+	const n = 2000
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			sl.Add(kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("key%04d", i%500)), Value: []byte("v"), Seq: uint64(i + 1)})
+		}
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		iter := NewSkipListIterator(sl)
+		var prev *kv.KeyValuePair
+		for ; iter.Valid(); iter.Next() {
+			pair := iter.Pair()
+			if prev != nil {
+				assert.True(t, prev.Key < pair.Key || (prev.Key == pair.Key && prev.Seq > pair.Seq),
+					"%s@%d is out of order after %s@%d", pair.Key, pair.Seq, prev.Key, prev.Seq)
+			}
+			prev = pair
+		}
+		iter.Seek("key0250")
+		if iter.Valid() {
+			assert.GreaterOrEqual(t, iter.Key(), kv.Key("key0250"))
+		}
+		_, _ = sl.Search("key0100")
+	}
+
+	count := 0
+	for iter := NewSkipListIterator(sl); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, n, count)
+}
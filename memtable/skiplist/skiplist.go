@@ -12,6 +12,7 @@ package skiplist
 
 import (
 	"math/rand/v2"
+	"sync/atomic"
 
 	"github.com/xmh1011/go-lsm/kv"
 )
@@ -22,28 +23,45 @@ const (
 )
 
 // Node 跳表节点的实现
-// Pair: 存储在kv包中定义的KV键值对
-// 根据kv的key大小来排序存储数据
+// Pair: 存储在kv包中定义的KV键值对，节点链接到跳表之后不再修改
+// 按 key 升序、同一 key 内按序列号降序排序存储数据，即同一 key 的最新版本排在最前面
 type Node struct {
 	Pair    kv.KeyValuePair
-	Forward []*Node
+	forward []atomic.Pointer[Node]
+}
+
+// newNode 创建层数为 level 的节点
+func newNode(pair kv.KeyValuePair, level int) *Node {
+	return &Node{
+		Pair:    pair,
+		forward: make([]atomic.Pointer[Node], level),
+	}
+}
+
+// next 返回节点在第 i 层的后继节点
+func (n *Node) next(i int) *Node {
+	return n.forward[i].Load()
+}
+
+// setNext 设置节点在第 i 层的后继节点
+func (n *Node) setNext(i int, node *Node) {
+	n.forward[i].Store(node)
 }
 
 // SkipList is used in memtable.. It is a lock-free implementation of Skiplist.
 // It is important to have a lock-free implementation,
 // otherwise scan operation will take lock(s) (/read-locks) which will start interfering with write operations.
+// 写入（Add、Delete）需要调用方互斥，读取（查找和迭代）可以与写入并发执行：
+// 节点的前向指针是原子指针，新节点先设置好自己的前向指针，再自底向上逐层链接，
+// 读取方看到一个节点时它的数据和后继都已经完整，与 LevelDB 的跳表相同。
 type SkipList struct {
 	Head  *Node
-	Level int
+	level atomic.Int32
 }
 
 func NewSkipList() *SkipList {
 	return &SkipList{
-		Head: &Node{
-			Pair:    kv.KeyValuePair{},
-			Forward: make([]*Node, maxLevel),
-		},
-		Level: 0,
+		Head: newNode(kv.KeyValuePair{}, maxLevel),
 	}
 }
 
@@ -55,109 +73,136 @@ func (s *SkipList) randomLevel() int {
 	return lv
 }
 
-// Search 在跳表中搜索一个元素
-// 判断key大小，来逐层查找
+// before 判断节点是否排在 (key, seq) 之前：key 更小，或 key 相同但版本更新
+func (n *Node) before(key kv.Key, seq uint64) bool {
+	return n.Pair.Key < key || (n.Pair.Key == key && n.Pair.Seq > seq)
+}
+
+// Search 在跳表中搜索一个元素的最新版本
 func (s *SkipList) Search(key kv.Key) (kv.Value, bool) {
+	return s.SearchWithSeq(key, kv.MaxSequence)
+}
+
+// SearchWithSeq 搜索序列号不大于 seq 的最新版本
+// 命中删除标记时返回 (nil, true)
+func (s *SkipList) SearchWithSeq(key kv.Key, seq uint64) (kv.Value, bool) {
+	pair := s.SearchPair(key, seq)
+	if pair == nil {
+		return nil, false
+	}
+	if pair.IsDeleted() {
+		// 如果是逻辑删除的元素，返回 nil
+		return nil, true
+	}
+
+	return pair.Value, true
+}
+
+// SearchPair 返回序列号不大于 seq 的最新版本，包括删除标记和 Merge 操作数，不存在时返回 nil
+// 判断key大小，来逐层查找
+func (s *SkipList) SearchPair(key kv.Key, seq uint64) *kv.KeyValuePair {
 	curr := s.Head
-	for i := s.Level - 1; i >= 0; i-- {
-		// 找到第 i 层小于且最接近 key 的元素
-		for curr.Forward[i] != nil && curr.Forward[i].Pair.Key < key {
-			curr = curr.Forward[i]
+	for i := int(s.level.Load()) - 1; i >= 0; i-- {
+		// 找到第 i 层排在 (key, seq) 之前且最接近的元素
+		for next := curr.next(i); next != nil && next.before(key, seq); next = curr.next(i) {
+			curr = next
 		}
 	}
-	curr = curr.Forward[0]
+	curr = curr.next(0)
 	// 检测当前元素的值是否等于 key
 	if curr == nil || curr.Pair.Key != key {
-		return nil, false
-	}
-	if curr.Pair.IsDeleted() {
-		// 如果是逻辑删除的元素，返回 nil
-		return nil, true
+		return nil
 	}
 
-	return curr.Pair.Value, true
+	return &curr.Pair
 }
 
 // Add 向跳表中添加一个元素。
-// 如果 key 已存在，则更新其值（并清除删除标记）；否则插入新的节点。
+// 如果相同 key 和序列号的版本已存在，则用新节点替换它；否则插入新的版本节点。
+// 正在读取旧节点的迭代器仍然可以通过它继续向后遍历。
 func (s *SkipList) Add(value kv.KeyValuePair) {
 	update := make([]*Node, maxLevel)
 	curr := s.Head
+	level := int(s.level.Load())
 	// 同 Seek 一样，从最高层查找
-	for i := s.Level - 1; i >= 0; i-- {
-		for curr.Forward[i] != nil && curr.Forward[i].Pair.Key < value.Key {
-			curr = curr.Forward[i]
+	for i := level - 1; i >= 0; i-- {
+		for next := curr.next(i); next != nil && next.before(value.Key, value.Seq); next = curr.next(i) {
+			curr = next
 		}
 		update[i] = curr
 	}
-	// 检查是否已存在该 key
-	next := curr.Forward[0]
-	if next != nil && next.Pair.Key == value.Key {
-		// 更新值，并取消删除标记
-		next.Pair = value
-		return
-	}
-	// 插入新的节点
+
+	// 检查是否已存在该版本，存在时新节点沿用旧节点的层数和后继
 	lv := s.randomLevel()
-	if lv > s.Level {
-		// 对于新增层级，将 Head 作为更新节点
-		for i := s.Level; i < lv; i++ {
+	old := curr.next(0)
+	if old != nil && old.Pair.Key == value.Key && old.Pair.Seq == value.Seq {
+		lv = len(old.forward)
+	} else {
+		old = nil
+	}
+	if lv > level {
+		// 对于新增层级，将 Head 作为更新节点。读取方看到更高的层数时这些层级为空，会直接下降到下一层
+		for i := level; i < lv; i++ {
 			update[i] = s.Head
 		}
-		s.Level = lv
+		s.level.Store(int32(lv))
 	}
-	newNode := &Node{
-		Pair:    value,
-		Forward: make([]*Node, lv),
+
+	node := newNode(value, lv)
+	for i := 0; i < lv; i++ {
+		if old != nil {
+			node.setNext(i, old.next(i))
+		} else {
+			node.setNext(i, update[i].next(i))
+		}
 	}
-	// 更新各层 Forward 指针
+	// 自底向上发布新节点，在某一层可见的节点在更低的层级也一定可见
 	for i := 0; i < lv; i++ {
-		newNode.Forward[i] = update[i].Forward[i]
-		update[i].Forward[i] = newNode
+		update[i].setNext(i, node)
 	}
 }
 
-// Delete 删除跳表中指定 key 对应的节点。
-// 这里采用逻辑删除（设置 Deleted 标记），同时更新 Forward 指针以便后续遍历跳过删除节点。
+// Delete 将跳表中指定 key 最新版本对应的节点从各层链表中移除。
+// 节点本身不被修改，正在读取它的迭代器仍然可以继续向后遍历。
 // 返回 true 表示成功删除，false 表示 key 不存在。
 func (s *SkipList) Delete(key kv.Key) bool {
 	update := make([]*Node, maxLevel)
 	curr := s.Head
+	level := int(s.level.Load())
 	// 查找待删除节点的前驱节点
-	for i := s.Level - 1; i >= 0; i-- {
-		for curr.Forward[i] != nil && curr.Forward[i].Pair.Key < key {
-			curr = curr.Forward[i]
+	for i := level - 1; i >= 0; i-- {
+		for next := curr.next(i); next != nil && next.Pair.Key < key; next = curr.next(i) {
+			curr = next
 		}
 		update[i] = curr
 	}
-	target := curr.Forward[0]
+	target := curr.next(0)
 	if target == nil || target.Pair.Key != key {
 		return false
 	}
-	target.Pair.Value = kv.DeletedValue
-	// 更新各层 Forward 指针，直接跳过已删除节点
-	for i := 0; i < s.Level; i++ {
-		if update[i].Forward[i] != target {
-			break
+	// 更新各层前向指针，直接跳过已删除节点
+	for i := len(target.forward) - 1; i >= 0; i-- {
+		if update[i].next(i) == target {
+			update[i].setNext(i, target.next(i))
 		}
-		update[i].Forward[i] = target.Forward[i]
 	}
 	// 调整跳表的层级，确保最高层至少有一个节点
-	for s.Level > 1 && s.Head.Forward[s.Level-1] == nil {
-		s.Level--
+	for level > 1 && s.Head.next(level-1) == nil {
+		level--
 	}
+	s.level.Store(int32(level))
 	return true
 }
 
 // First 返回跳表中第一个非删除的有效元素（最小 key）
 // 如果跳表为空或只包含逻辑删除的节点，则返回 nil。
 func (s *SkipList) First() *kv.KeyValuePair {
-	curr := s.Head.Forward[0]
+	curr := s.Head.next(0)
 	for curr != nil {
 		if !curr.Pair.IsDeleted() {
 			return &curr.Pair
 		}
-		curr = curr.Forward[0]
+		curr = curr.next(0)
 	}
 	return nil
 }
@@ -61,3 +61,38 @@ func TestSkipListDelete(t *testing.T) {
 		assert.True(t, found, "expected to find key %s", k)
 	}
 }
+
+// TestSkipListVersions tests that multiple versions of a key are kept and ordered by sequence.
+func TestSkipListVersions(t *testing.T) {
+	sl := NewSkipList()
+	sl.Add(kv.KeyValuePair{Key: "k", Value: []byte("v1"), Seq: 1})
+	sl.Add(kv.KeyValuePair{Key: "k", Value: []byte("v3"), Seq: 3})
+	sl.Add(kv.KeyValuePair{Key: "k", Seq: 5, Kind: kv.KindDelete})
+	sl.Add(kv.KeyValuePair{Key: "a", Value: []byte("a2"), Seq: 2})
+
+	// 最新版本是删除标记
+	value, found := sl.Search("k")
+	assert.True(t, found)
+	assert.Nil(t, value)
+
+	// 按快照序列号读取历史版本
+	value, found = sl.SearchWithSeq("k", 4)
+	assert.True(t, found)
+	assert.Equal(t, kv.Value("v3"), value)
+
+	value, found = sl.SearchWithSeq("k", 2)
+	assert.True(t, found)
+	assert.Equal(t, kv.Value("v1"), value)
+
+	_, found = sl.SearchWithSeq("k", 0)
+	assert.False(t, found)
+
+	// 遍历顺序：key 升序，同一 key 内序列号降序
+	iter := NewSkipListIterator(sl)
+	defer iter.Close()
+	var seqs []uint64
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		seqs = append(seqs, iter.Pair().Seq)
+	}
+	assert.Equal(t, []uint64{2, 5, 3, 1}, seqs)
+}
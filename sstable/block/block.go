@@ -0,0 +1,271 @@
+package block
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const (
+	// DefaultBlockSize 是数据块的默认目标大小（字节），数据块的编码大小达到该值后开始新的数据块
+	DefaultBlockSize = 4 * 1024
+	// DefaultRestartInterval 是数据块中相邻两个重启点之间的记录数
+	DefaultRestartInterval = 16
+
+	entryTrailerSize = 9 // seq(8) + kind(1)
+	restartSize      = 4 // 每个重启点的偏移量和重启点数量均为 4 字节
+)
+
+// Block 是 SSTable 中的一个块，数据块和索引块使用相同的格式，按 Key 升序、同一 Key 内按 Seq 降序保存记录。
+// 编码格式：
+//
+//	entry:    shared(uvarint) + unshared(uvarint) + valueLen(uvarint) + key[shared:] + seq(8) + kind(1) + value
+//	trailer:  restart[0] ... restart[n-1]（各 4 字节）+ n（4 字节）
+//
+// 每隔若干条记录设置一个重启点，重启点处的记录保存完整的 key（shared 为 0），
+// 其余记录只保存与前一个 key 不同的后缀。查找时先在重启点上二分查找，再从重启点顺序扫描。
+type Block struct {
+	data           []byte
+	restartsOffset int // 重启点数组的起始位置，也是记录区的结束位置
+	numRestarts    int
+}
+
+// NewBlock 解析编码后的块，data 在块的生命周期内不能被修改
+func NewBlock(data []byte) (*Block, error) {
+	if len(data) < restartSize {
+		log.Errorf("block is too short: %d bytes", len(data))
+		return nil, fmt.Errorf("block is too short: %d bytes", len(data))
+	}
+	numRestarts := int(binary.LittleEndian.Uint32(data[len(data)-restartSize:]))
+	if numRestarts == 0 || numRestarts > (len(data)-restartSize)/restartSize {
+		log.Errorf("invalid number of restarts %d in block of %d bytes", numRestarts, len(data))
+		return nil, fmt.Errorf("invalid number of restarts %d in block of %d bytes", numRestarts, len(data))
+	}
+
+	b := &Block{
+		data:           data,
+		restartsOffset: len(data) - restartSize - numRestarts*restartSize,
+		numRestarts:    numRestarts,
+	}
+	for i := 0; i < numRestarts; i++ {
+		if offset := b.restart(i); offset > b.restartsOffset {
+			log.Errorf("restart %d at offset %d is out of range %d", i, offset, b.restartsOffset)
+			return nil, fmt.Errorf("restart %d at offset %d is out of range %d", i, offset, b.restartsOffset)
+		}
+	}
+	return b, nil
+}
+
+// Size 返回块编码后的大小（字节）
+func (b *Block) Size() int {
+	return len(b.data)
+}
+
+// Data 返回块编码后的内容
+func (b *Block) Data() []byte {
+	return b.data
+}
+
+// restart 返回第 i 个重启点的偏移量
+func (b *Block) restart(i int) int {
+	pos := b.restartsOffset + i*restartSize
+	return int(binary.LittleEndian.Uint32(b.data[pos : pos+restartSize]))
+}
+
+// NewIterator 创建块的迭代器，迭代器创建后处于无效位置，需要先调用 Seek 系列方法定位
+func (b *Block) NewIterator() *Iterator {
+	return &Iterator{block: b}
+}
+
+// Iterator 遍历一个块中的记录，定位时在重启点上二分查找
+type Iterator struct {
+	block *Block
+
+	offset     int // 当前记录的起始位置
+	nextOffset int // 下一条记录的起始位置
+	key        []byte
+	seq        uint64
+	kind       kv.Kind
+	value      []byte
+	valid      bool
+
+	// err 是解析记录时遇到的错误，出错后迭代器失效
+	err error
+}
+
+// Valid 检查迭代器当前位置是否有效
+func (i *Iterator) Valid() bool {
+	return i.valid
+}
+
+// Error 返回解析记录时遇到的错误
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+// Key 返回当前记录的 key
+func (i *Iterator) Key() kv.Key {
+	if !i.valid {
+		return ""
+	}
+	return kv.Key(i.key)
+}
+
+// Seq 返回当前记录的序列号
+func (i *Iterator) Seq() uint64 {
+	if !i.valid {
+		return 0
+	}
+	return i.seq
+}
+
+// Kind 返回当前记录的类型
+func (i *Iterator) Kind() kv.Kind {
+	if !i.valid {
+		return kv.KindPut
+	}
+	return i.kind
+}
+
+// Value 返回当前记录的 value，返回的切片引用块的内容，调用方不能修改
+func (i *Iterator) Value() []byte {
+	if !i.valid {
+		return nil
+	}
+	return i.value
+}
+
+// Next 将迭代器移动到下一条记录
+func (i *Iterator) Next() {
+	if !i.valid {
+		return
+	}
+	i.parseNext()
+}
+
+// SeekToFirst 将迭代器移动到第一条记录
+func (i *Iterator) SeekToFirst() {
+	i.seekToRestart(0)
+	i.parseNext()
+}
+
+// SeekToLast 将迭代器移动到最后一条记录
+func (i *Iterator) SeekToLast() {
+	i.seekToRestart(i.block.numRestarts - 1)
+	for i.parseNext() && i.nextOffset < i.block.restartsOffset {
+	}
+}
+
+// SeekGE 定位到第一个 key 大于或等于 target 的记录，不存在时设置为无效状态
+func (i *Iterator) SeekGE(target kv.Key) {
+	i.seekToRestart(i.searchRestart(target))
+	for i.parseNext() && kv.Key(i.key) < target {
+	}
+}
+
+// SeekLT 定位到最后一个 key 严格小于 target 的记录，不存在时设置为无效状态
+func (i *Iterator) SeekLT(target kv.Key) {
+	// 除第一个重启点外，searchRestart 返回的重启点处的 key 一定小于 target，所求记录在该重启点之后
+	restart := i.searchRestart(target)
+	found := -1
+	i.seekToRestart(restart)
+	for i.parseNext() && kv.Key(i.key) < target {
+		found = i.offset
+	}
+	if i.err != nil {
+		return
+	}
+	if found < 0 {
+		i.valid = false
+		return
+	}
+
+	// 前缀压缩的记录只能从重启点顺序解析，重新扫描到找到的记录
+	i.seekToRestart(restart)
+	for i.parseNext() && i.offset < found {
+	}
+}
+
+// Close 释放迭代器引用的块
+func (i *Iterator) Close() {
+	i.valid = false
+	i.key = nil
+	i.value = nil
+}
+
+// searchRestart 返回最后一个 key 小于 target 的重启点，不存在时返回 0
+func (i *Iterator) searchRestart(target kv.Key) int {
+	left, right := 0, i.block.numRestarts-1
+	for left < right {
+		mid := left + (right-left+1)/2
+		i.seekToRestart(mid)
+		if !i.parseNext() {
+			return 0
+		}
+		if kv.Key(i.key) < target {
+			left = mid
+		} else {
+			right = mid - 1
+		}
+	}
+	return left
+}
+
+// seekToRestart 将下一次解析的位置设置为第 index 个重启点
+func (i *Iterator) seekToRestart(index int) {
+	i.key = i.key[:0]
+	i.valid = false
+	i.nextOffset = i.block.restart(index)
+}
+
+// parseNext 解析 nextOffset 处的记录，到达记录区末尾或解析失败时返回 false
+func (i *Iterator) parseNext() bool {
+	data := i.block.data[:i.block.restartsOffset]
+	pos := i.nextOffset
+	if pos >= len(data) {
+		i.valid = false
+		return false
+	}
+
+	shared, n1 := binary.Uvarint(data[pos:])
+	if n1 <= 0 {
+		return i.corrupt(pos, "invalid shared key length")
+	}
+	unshared, n2 := binary.Uvarint(data[pos+n1:])
+	if n2 <= 0 {
+		return i.corrupt(pos, "invalid unshared key length")
+	}
+	valueLen, n3 := binary.Uvarint(data[pos+n1+n2:])
+	if n3 <= 0 {
+		return i.corrupt(pos, "invalid value length")
+	}
+	start := pos + n1 + n2 + n3
+	remaining := uint64(len(data) - start)
+	if shared > uint64(len(i.key)) || unshared > remaining || valueLen > remaining || unshared+valueLen+entryTrailerSize > remaining {
+		return i.corrupt(pos, "entry exceeds block")
+	}
+
+	keyEnd := start + int(unshared)
+	i.key = append(i.key[:shared], data[start:keyEnd]...)
+	i.seq = binary.LittleEndian.Uint64(data[keyEnd:])
+	i.kind = kv.Kind(data[keyEnd+8])
+	if !i.kind.Valid() {
+		return i.corrupt(pos, fmt.Sprintf("invalid kind %d", i.kind))
+	}
+	valueStart := keyEnd + entryTrailerSize
+	i.value = data[valueStart : valueStart+int(valueLen)]
+	i.offset = pos
+	i.nextOffset = valueStart + int(valueLen)
+	i.valid = true
+	return true
+}
+
+// corrupt 记录解析错误并使迭代器失效
+func (i *Iterator) corrupt(offset int, reason string) bool {
+	log.Errorf("corrupted block entry at offset %d: %s", offset, reason)
+	i.err = fmt.Errorf("corrupted block entry at offset %d: %s", offset, reason)
+	i.valid = false
+	return false
+}
@@ -0,0 +1,167 @@
+package block
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// buildTestBlock 构造包含 n 个 key 的块，每个 key 有两个版本，重启点间隔为 4
+func buildTestBlock(t *testing.T, n int) *Block {
+	builder := NewBlockBuilder(4)
+	for i := 0; i < n; i++ {
+		key := kv.Key(fmt.Sprintf("key%03d", i*2))
+		builder.Add(key, uint64(i*2+2), kv.KindPut, []byte(fmt.Sprintf("new%d", i)))
+		builder.Add(key, uint64(i*2+1), kv.KindDelete, nil)
+	}
+	b, err := NewBlock(builder.Finish())
+	assert.NoError(t, err)
+	return b
+}
+
+func TestBlock_Iterate(t *testing.T) {
+	b := buildTestBlock(t, 50)
+	it := b.NewIterator()
+	assert.False(t, it.Valid())
+
+	count := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		i := count / 2
+		assert.Equal(t, kv.Key(fmt.Sprintf("key%03d", i*2)), it.Key())
+		if count%2 == 0 {
+			assert.Equal(t, uint64(i*2+2), it.Seq())
+			assert.Equal(t, kv.KindPut, it.Kind())
+			assert.Equal(t, []byte(fmt.Sprintf("new%d", i)), it.Value())
+		} else {
+			assert.Equal(t, uint64(i*2+1), it.Seq())
+			assert.Equal(t, kv.KindDelete, it.Kind())
+			assert.Empty(t, it.Value())
+		}
+		count++
+	}
+	assert.Equal(t, 100, count)
+	assert.NoError(t, it.Error())
+
+	it.SeekToLast()
+	assert.True(t, it.Valid())
+	assert.Equal(t, kv.Key("key098"), it.Key())
+	assert.Equal(t, uint64(99), it.Seq())
+}
+
+func TestBlock_SeekGEAndSeekLT(t *testing.T) {
+	b := buildTestBlock(t, 50)
+	it := b.NewIterator()
+
+	tests := []struct {
+		target   kv.Key
+		geKey    kv.Key // 空表示无效
+		geSeq    uint64
+		ltKey    kv.Key
+		ltSeq    uint64
+		ltNoSeek bool
+	}{
+		{target: "", geKey: "key000", geSeq: 2},
+		{target: "key000", geKey: "key000", geSeq: 2},
+		{target: "key001", geKey: "key002", geSeq: 4, ltKey: "key000", ltSeq: 1},
+		{target: "key040", geKey: "key040", geSeq: 42, ltKey: "key038", ltSeq: 39},
+		{target: "key041", geKey: "key042", geSeq: 44, ltKey: "key040", ltSeq: 41},
+		{target: "key098", geKey: "key098", geSeq: 100, ltKey: "key096", ltSeq: 97},
+		{target: "key099", ltKey: "key098", ltSeq: 99},
+		{target: "z", ltKey: "key098", ltSeq: 99},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.target), func(t *testing.T) {
+			it.SeekGE(tt.target)
+			assert.Equal(t, tt.geKey != "", it.Valid())
+			assert.Equal(t, tt.geKey, it.Key())
+			assert.Equal(t, tt.geSeq, it.Seq())
+
+			it.SeekLT(tt.target)
+			assert.Equal(t, tt.ltKey != "", it.Valid())
+			assert.Equal(t, tt.ltKey, it.Key())
+			assert.Equal(t, tt.ltSeq, it.Seq())
+		})
+	}
+
+	// 从定位结果继续向后遍历
+	it.SeekLT("key010")
+	it.Next()
+	assert.Equal(t, kv.Key("key010"), it.Key())
+	assert.Equal(t, uint64(12), it.Seq())
+}
+
+func TestBlock_Empty(t *testing.T) {
+	b, err := NewBlock(NewBlockBuilder(DefaultRestartInterval).Finish())
+	assert.NoError(t, err)
+
+	it := b.NewIterator()
+	it.SeekToFirst()
+	assert.False(t, it.Valid())
+	it.SeekToLast()
+	assert.False(t, it.Valid())
+	it.SeekGE("a")
+	assert.False(t, it.Valid())
+	it.SeekLT("a")
+	assert.False(t, it.Valid())
+	assert.NoError(t, it.Error())
+}
+
+func TestBlock_PrefixCompression(t *testing.T) {
+	builder := NewBlockBuilder(DefaultRestartInterval)
+	raw := 0
+	for i := 0; i < 100; i++ {
+		key := kv.Key(fmt.Sprintf("a/long/common/prefix/%04d", i))
+		builder.Add(key, uint64(i), kv.KindPut, nil)
+		raw += len(key)
+	}
+	// 共享前缀只在重启点保存一次
+	assert.Less(t, builder.EstimatedSize(), raw)
+}
+
+func TestNewBlock_Invalid(t *testing.T) {
+	_, err := NewBlock([]byte{1, 2})
+	assert.Error(t, err)
+
+	// 重启点数量为 0
+	_, err = NewBlock(binary.LittleEndian.AppendUint32(nil, 0))
+	assert.Error(t, err)
+
+	// 重启点数量超出块大小
+	_, err = NewBlock(binary.LittleEndian.AppendUint32(nil, 10))
+	assert.Error(t, err)
+
+	// 重启点偏移量越界
+	data := binary.LittleEndian.AppendUint32(nil, 100)
+	_, err = NewBlock(binary.LittleEndian.AppendUint32(data, 1))
+	assert.Error(t, err)
+}
+
+func TestBlock_CorruptedEntry(t *testing.T) {
+	builder := NewBlockBuilder(DefaultRestartInterval)
+	builder.Add("key", 1, kv.KindPut, []byte("value"))
+	data := builder.Finish()
+
+	// value 长度超出记录区
+	corrupted := append([]byte(nil), data...)
+	corrupted[2] = 0x7f
+	b, err := NewBlock(corrupted)
+	assert.NoError(t, err)
+	it := b.NewIterator()
+	it.SeekToFirst()
+	assert.False(t, it.Valid())
+	assert.Error(t, it.Error())
+
+	// 非法的记录类型
+	corrupted = append([]byte(nil), data...)
+	corrupted[3+len("key")+8] = 0xff
+	b, err = NewBlock(corrupted)
+	assert.NoError(t, err)
+	it = b.NewIterator()
+	it.SeekToFirst()
+	assert.False(t, it.Valid())
+	assert.Error(t, it.Error())
+}
@@ -0,0 +1,89 @@
+package block
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// BlockBuilder 按 Block 的格式编码记录，记录必须按 Key 升序、同一 Key 内按 Seq 降序追加
+type BlockBuilder struct {
+	buf             bytes.Buffer
+	restarts        []uint32
+	restartInterval int
+	counter         int // 距离上一个重启点的记录数
+	entries         int
+	lastKey         []byte
+}
+
+// NewBlockBuilder 创建每隔 restartInterval 条记录设置一个重启点的 BlockBuilder
+func NewBlockBuilder(restartInterval int) *BlockBuilder {
+	return &BlockBuilder{
+		restarts:        []uint32{0},
+		restartInterval: max(1, restartInterval),
+	}
+}
+
+// Add 追加一条记录，key 只保存与前一个 key 不同的后缀
+func (b *BlockBuilder) Add(key kv.Key, seq uint64, kind kv.Kind, value []byte) {
+	shared := 0
+	if b.counter < b.restartInterval {
+		for shared < len(b.lastKey) && shared < len(key) && b.lastKey[shared] == key[shared] {
+			shared++
+		}
+	} else {
+		// 新的重启点保存完整的 key
+		b.restarts = append(b.restarts, uint32(b.buf.Len()))
+		b.counter = 0
+	}
+
+	var scratch [binary.MaxVarintLen64]byte
+	for _, n := range []uint64{uint64(shared), uint64(len(key) - shared), uint64(len(value))} {
+		b.buf.Write(scratch[:binary.PutUvarint(scratch[:], n)])
+	}
+	b.buf.WriteString(string(key[shared:]))
+	var trailer [entryTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:8], seq)
+	trailer[8] = byte(kind)
+	b.buf.Write(trailer[:])
+	b.buf.Write(value)
+
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+	b.entries++
+}
+
+// EstimatedSize 返回当前内容编码后的大小（字节）
+func (b *BlockBuilder) EstimatedSize() int {
+	return b.buf.Len() + len(b.restarts)*restartSize + restartSize
+}
+
+// Len 返回已追加的记录数
+func (b *BlockBuilder) Len() int {
+	return b.entries
+}
+
+// Empty 判断是否还没有追加记录
+func (b *BlockBuilder) Empty() bool {
+	return b.entries == 0
+}
+
+// Finish 追加重启点数组并返回编码后的块，返回的切片不会被之后的 Add 修改
+func (b *BlockBuilder) Finish() []byte {
+	data := make([]byte, 0, b.EstimatedSize())
+	data = append(data, b.buf.Bytes()...)
+	for _, restart := range b.restarts {
+		data = binary.LittleEndian.AppendUint32(data, restart)
+	}
+	return binary.LittleEndian.AppendUint32(data, uint32(len(b.restarts)))
+}
+
+// Reset 清空内容，用于构建下一个块
+func (b *BlockBuilder) Reset() {
+	b.buf.Reset()
+	b.restarts = b.restarts[:1]
+	b.counter = 0
+	b.entries = 0
+	b.lastKey = b.lastKey[:0]
+}
@@ -0,0 +1,41 @@
+package block
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+func TestBlockBuilder_AddAndReset(t *testing.T) {
+	builder := NewBlockBuilder(2)
+	assert.True(t, builder.Empty())
+	emptySize := builder.EstimatedSize()
+
+	builder.Add("a", 3, kv.KindPut, []byte("1"))
+	builder.Add("ab", 2, kv.KindMerge, []byte("2"))
+	builder.Add("abc", 1, kv.KindPut, []byte("3"))
+	assert.Equal(t, 3, builder.Len())
+	assert.False(t, builder.Empty())
+
+	data := builder.Finish()
+	assert.Equal(t, builder.EstimatedSize(), len(data))
+	// 第三条记录是新的重启点
+	assert.Len(t, builder.restarts, 2)
+
+	// Reset 之后的内容不影响已经返回的块
+	builder.Reset()
+	assert.True(t, builder.Empty())
+	assert.Equal(t, emptySize, builder.EstimatedSize())
+	builder.Add("z", 1, kv.KindPut, []byte("z"))
+
+	b, err := NewBlock(data)
+	assert.NoError(t, err)
+	it := b.NewIterator()
+	keys := make([]kv.Key, 0)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, []kv.Key{"a", "ab", "abc"}, keys)
+}
@@ -0,0 +1,74 @@
+package block
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/xmh1011/go-lsm/log"
+	"github.com/xmh1011/go-lsm/sstable/compress"
+)
+
+// TrailerSize 是写入文件的每个块之后的尾部长度。
+// 块在文件中的格式为：content + codec(1) + crc32c(4)，其中 codec 是 content 使用的压缩算法，
+// crc32c 是 content 和 codec 的校验和。Handle 的大小包含尾部。
+const TrailerSize = 1 + 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// minCompressionSavingRatio 表示压缩后至少要节省 1/minCompressionSavingRatio 的空间，否则保存原始内容
+const minCompressionSavingRatio = 8
+
+// EncodeContents 使用 c 压缩块的内容并追加尾部，c 为 nil、压缩失败或压缩效果不明显时保存原始内容
+func EncodeContents(raw []byte, c compress.Compressor) []byte {
+	if c != nil && c.Codec() != compress.NoCompression {
+		compressed, err := c.Compress(raw)
+		if err != nil {
+			log.Errorf("compress block with %s error: %s", c.Name(), err.Error())
+		} else if len(compressed) < len(raw)-len(raw)/minCompressionSavingRatio {
+			return appendTrailer(compressed, c.Codec())
+		}
+	}
+	data := make([]byte, 0, len(raw)+TrailerSize)
+	data = append(data, raw...)
+	return appendTrailer(data, compress.NoCompression)
+}
+
+// appendTrailer 在 content 之后追加压缩算法和校验和
+func appendTrailer(content []byte, codec compress.Codec) []byte {
+	data := append(content, byte(codec))
+	return binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+}
+
+// DecodeContents 根据尾部记录的压缩算法还原块的内容，verifyChecksum 为 true 时先检查校验和
+func DecodeContents(data []byte, verifyChecksum bool) ([]byte, error) {
+	if len(data) < TrailerSize {
+		log.Errorf("block is too short for trailer: %d bytes", len(data))
+		return nil, fmt.Errorf("block is too short for trailer: %d bytes", len(data))
+	}
+	codecEnd := len(data) - TrailerSize + 1
+	if verifyChecksum {
+		expected := binary.LittleEndian.Uint32(data[codecEnd:])
+		if actual := crc32.Checksum(data[:codecEnd], crcTable); actual != expected {
+			log.Errorf("block checksum mismatch: expected %08x, actual %08x", expected, actual)
+			return nil, fmt.Errorf("block checksum mismatch: expected %08x, actual %08x", expected, actual)
+		}
+	}
+	codec := compress.Codec(data[codecEnd-1])
+	content := data[:codecEnd-1]
+	if codec == compress.NoCompression {
+		return content, nil
+	}
+
+	c, err := compress.Lookup(codec)
+	if err != nil {
+		log.Errorf("lookup compressor of block error: %s", err.Error())
+		return nil, fmt.Errorf("lookup compressor failed: %w", err)
+	}
+	raw, err := c.Decompress(content)
+	if err != nil {
+		log.Errorf("decompress block with %s error: %s", c.Name(), err.Error())
+		return nil, fmt.Errorf("decompress block with %s failed: %w", c.Name(), err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,78 @@
+package block
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/sstable/compress"
+)
+
+func TestEncodeDecodeContents(t *testing.T) {
+	builder := NewBlockBuilder(DefaultRestartInterval)
+	for i := 0; i < 100; i++ {
+		builder.Add("key", uint64(100-i), 0, bytes.Repeat([]byte("v"), 64))
+	}
+	raw := builder.Finish()
+
+	for _, codec := range []compress.Codec{compress.NoCompression, compress.SnappyCompression, compress.ZlibCompression} {
+		c, err := compress.Lookup(codec)
+		assert.NoError(t, err)
+		data := EncodeContents(raw, c)
+		assert.Equal(t, byte(codec), data[len(data)-TrailerSize])
+		if codec != compress.NoCompression {
+			assert.Less(t, len(data), len(raw))
+		}
+
+		decoded, err := DecodeContents(data, true)
+		assert.NoError(t, err)
+		assert.Equal(t, raw, decoded)
+	}
+
+	// 不指定压缩算法时保存原始内容
+	data := EncodeContents(raw, nil)
+	assert.Equal(t, raw, data[:len(data)-TrailerSize])
+	assert.Equal(t, byte(compress.NoCompression), data[len(raw)])
+}
+
+// TestEncodeContentsFallback 测试压缩效果不明显时保存原始内容
+func TestEncodeContentsFallback(t *testing.T) {
+	raw := []byte("abcdefghijklmnopqrstuvwxyz0123456789")
+	c, err := compress.Lookup(compress.ZlibCompression)
+	assert.NoError(t, err)
+	data := EncodeContents(raw, c)
+	assert.Equal(t, byte(compress.NoCompression), data[len(data)-TrailerSize])
+	assert.Equal(t, raw, data[:len(data)-TrailerSize])
+}
+
+func TestDecodeContentsErrors(t *testing.T) {
+	_, err := DecodeContents([]byte{1, 2, 3}, false)
+	assert.Error(t, err)
+
+	// 未知的压缩算法
+	_, err = DecodeContents(appendTrailer([]byte{1, 2, 3}, 200), true)
+	assert.Error(t, err)
+
+	// 压缩内容损坏
+	_, err = DecodeContents(appendTrailer([]byte{1, 2, 3}, compress.ZlibCompression), true)
+	assert.Error(t, err)
+}
+
+// TestDecodeContentsChecksum 测试任意一个字节被修改之后校验失败，不检查校验和时仍按尾部解码
+func TestDecodeContentsChecksum(t *testing.T) {
+	raw := []byte("block contents")
+	data := EncodeContents(raw, nil)
+	for i := range data {
+		corrupted := append([]byte{}, data...)
+		corrupted[i] ^= 0x01
+		_, err := DecodeContents(corrupted, true)
+		assert.Error(t, err, "flip byte %d", i)
+	}
+
+	corrupted := append([]byte{}, data...)
+	corrupted[0] ^= 0x01
+	decoded, err := DecodeContents(corrupted, false)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('b')^0x01, decoded[0])
+}
@@ -1,17 +1,25 @@
 package block
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 
 	"github.com/xmh1011/go-lsm/log"
 )
 
-// Footer 表示 SSTable 的文件尾，固定长度（16 字节）。
+// Footer 表示 SSTable 的文件尾，固定长度（96 字节），记录文件中各部分的位置、文件格式版本、校验和和魔数。
+// 魔数位于文件的最后 8 字节，用于识别被截断或不是 SSTable 的文件；校验和是各个 Handle 和版本号的 CRC32C，
+// 避免按损坏的 Handle 读取文件中的其他部分。
 type Footer struct {
-	DataHandle  Handle // 数据块的 Handle
-	IndexHandle Handle // 索引块的 Handle
+	DataHandle     Handle // 所有数据块所在的区域
+	IndexHandle    Handle // 索引块的 Handle
+	RangeDelHandle Handle // 区间删除块的 Handle
+	FilterHandle   Handle // 布隆过滤器的 Handle
+	HeaderHandle   Handle // Header 的 Handle
+	Version        uint32 // 文件格式版本
 }
 
 type Handle struct {
@@ -20,15 +28,26 @@ type Handle struct {
 }
 
 const (
-	FooterSize = 32 // 16 (Data handle) + 16 (Index handle) 字节
-	HandleSize = 16 // 每个 handle 的大小（8 字节偏移 + 8 字节大小）
+	FooterSize = 5*HandleSize + 4 + 4 + 8 // 5 个 handle + 4 字节版本号 + 4 字节校验和 + 8 字节魔数
+	HandleSize = 16                       // 每个 handle 的大小（8 字节偏移 + 8 字节大小）
+
+	footerChecksumOffset = 5*HandleSize + 4 // 校验和覆盖其之前的各个 Handle 和版本号
+
+	// FooterMagic 是 SSTable 文件末尾的魔数（"golsmsst"）
+	FooterMagic uint64 = 0x676f6c736d737374
+	// FormatVersion 是当前写入的文件格式版本：块式数据格式，每个块带有压缩算法和 CRC32C 校验和
+	FormatVersion uint32 = 1
 )
 
 // NewFooter 创建一个新的 Footer 实例
 func NewFooter() *Footer {
 	return &Footer{
-		DataHandle:  NewHandle(0, 0),
-		IndexHandle: NewHandle(0, 0),
+		DataHandle:     NewHandle(0, 0),
+		IndexHandle:    NewHandle(0, 0),
+		RangeDelHandle: NewHandle(0, 0),
+		FilterHandle:   NewHandle(0, 0),
+		HeaderHandle:   NewHandle(0, 0),
+		Version:        FormatVersion,
 	}
 }
 
@@ -41,6 +60,25 @@ func NewHandle(offset, size int64) Handle {
 
 // EncodeTo 将 Footer 编码到 io.Writer 中
 func (f *Footer) EncodeTo(w io.Writer) error {
+	buf := bytes.NewBuffer(make([]byte, 0, FooterSize))
+	if err := f.encodeFields(buf); err != nil {
+		return err
+	}
+
+	tail := make([]byte, 0, 12)
+	tail = binary.LittleEndian.AppendUint32(tail, crc32.Checksum(buf.Bytes(), crcTable))
+	tail = binary.LittleEndian.AppendUint64(tail, FooterMagic)
+	buf.Write(tail)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		log.Errorf("write footer failed: %s", err.Error())
+		return fmt.Errorf("write footer failed: %w", err)
+	}
+
+	return nil
+}
+
+// encodeFields 依次编码各个 Handle 和版本号，即校验和覆盖的部分
+func (f *Footer) encodeFields(w io.Writer) error {
 	if err := f.DataHandle.EncodeTo(w); err != nil {
 		log.Errorf("encode data handle failed: %s", err.Error())
 		return fmt.Errorf("encode data handle failed: %w", err)
@@ -51,11 +89,53 @@ func (f *Footer) EncodeTo(w io.Writer) error {
 		return fmt.Errorf("encode index handle failed: %w", err)
 	}
 
+	if err := f.RangeDelHandle.EncodeTo(w); err != nil {
+		log.Errorf("encode range deletion handle failed: %s", err.Error())
+		return fmt.Errorf("encode range deletion handle failed: %w", err)
+	}
+
+	if err := f.FilterHandle.EncodeTo(w); err != nil {
+		log.Errorf("encode filter handle failed: %s", err.Error())
+		return fmt.Errorf("encode filter handle failed: %w", err)
+	}
+
+	if err := f.HeaderHandle.EncodeTo(w); err != nil {
+		log.Errorf("encode header handle failed: %s", err.Error())
+		return fmt.Errorf("encode header handle failed: %w", err)
+	}
+
+	// 总是按当前版本的格式写入
+	if _, err := w.Write(binary.LittleEndian.AppendUint32(nil, FormatVersion)); err != nil {
+		log.Errorf("encode format version failed: %s", err.Error())
+		return fmt.Errorf("encode format version failed: %w", err)
+	}
+
 	return nil
 }
 
-// DecodeFrom 从 io.Reader 中解码 Footer
-func (f *Footer) DecodeFrom(r io.Reader) error {
+// DecodeFrom 从 io.Reader 中解码 Footer，魔数或校验和不匹配、版本不受支持时返回错误
+func (f *Footer) DecodeFrom(reader io.Reader) error {
+	buf := make([]byte, FooterSize)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		log.Errorf("read footer failed: %s", err.Error())
+		return fmt.Errorf("read footer failed: %w", err)
+	}
+	if magic := binary.LittleEndian.Uint64(buf[FooterSize-8:]); magic != FooterMagic {
+		log.Errorf("bad footer magic number: %016x", magic)
+		return fmt.Errorf("bad footer magic number: %016x", magic)
+	}
+	expected := binary.LittleEndian.Uint32(buf[footerChecksumOffset:])
+	if actual := crc32.Checksum(buf[:footerChecksumOffset], crcTable); actual != expected {
+		log.Errorf("footer checksum mismatch: expected %08x, actual %08x", expected, actual)
+		return fmt.Errorf("footer checksum mismatch: expected %08x, actual %08x", expected, actual)
+	}
+	f.Version = binary.LittleEndian.Uint32(buf[footerChecksumOffset-4:])
+	if f.Version != FormatVersion {
+		log.Errorf("unsupported format version: %d", f.Version)
+		return fmt.Errorf("unsupported format version: %d", f.Version)
+	}
+
+	r := bytes.NewReader(buf)
 	if err := f.DataHandle.DecodeFrom(r); err != nil {
 		log.Errorf("decode data handle failed: %s", err.Error())
 		return fmt.Errorf("decode data handle failed: %w", err)
@@ -66,6 +146,21 @@ func (f *Footer) DecodeFrom(r io.Reader) error {
 		return fmt.Errorf("decode index handle failed: %w", err)
 	}
 
+	if err := f.RangeDelHandle.DecodeFrom(r); err != nil {
+		log.Errorf("decode range deletion handle failed: %s", err.Error())
+		return fmt.Errorf("decode range deletion handle failed: %w", err)
+	}
+
+	if err := f.FilterHandle.DecodeFrom(r); err != nil {
+		log.Errorf("decode filter handle failed: %s", err.Error())
+		return fmt.Errorf("decode filter handle failed: %w", err)
+	}
+
+	if err := f.HeaderHandle.DecodeFrom(r); err != nil {
+		log.Errorf("decode header handle failed: %s", err.Error())
+		return fmt.Errorf("decode header handle failed: %w", err)
+	}
+
 	return nil
 }
 
@@ -100,3 +195,21 @@ func (h *Handle) EncodeTo(w io.Writer) error {
 
 	return nil
 }
+
+// Bytes 返回 Handle 的编码，用作索引块中的 value
+func (h *Handle) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	_ = h.EncodeTo(buf) // 写入 bytes.Buffer 不会失败
+	return buf.Bytes()
+}
+
+// ParseHandle 解码 Bytes 返回的 Handle
+func ParseHandle(data []byte) (Handle, error) {
+	var h Handle
+	if len(data) != HandleSize {
+		log.Errorf("invalid handle length: %d", len(data))
+		return h, fmt.Errorf("invalid handle length: %d", len(data))
+	}
+	err := h.DecodeFrom(bytes.NewReader(data))
+	return h, err
+}
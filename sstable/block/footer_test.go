@@ -2,6 +2,8 @@ package block
 
 import (
 	"bytes"
+	"encoding/binary"
+	"hash/crc32"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -83,16 +85,22 @@ func TestFooter_EncodeDecode(t *testing.T) {
 		{
 			name: "non-zero footer",
 			footer: &Footer{
-				DataHandle:  NewHandle(100, 200),
-				IndexHandle: NewHandle(300, 400),
+				DataHandle:     NewHandle(100, 200),
+				IndexHandle:    NewHandle(300, 400),
+				RangeDelHandle: NewHandle(700, 50),
+				FilterHandle:   NewHandle(750, 60),
+				HeaderHandle:   NewHandle(810, 30),
 			},
 			wantErr: false,
 		},
 		{
 			name: "max offset and size",
 			footer: &Footer{
-				DataHandle:  NewHandle(^int64(0), ^int64(0)),
-				IndexHandle: NewHandle(^int64(0), ^int64(0)),
+				DataHandle:     NewHandle(^int64(0), ^int64(0)),
+				IndexHandle:    NewHandle(^int64(0), ^int64(0)),
+				RangeDelHandle: NewHandle(^int64(0), ^int64(0)),
+				FilterHandle:   NewHandle(^int64(0), ^int64(0)),
+				HeaderHandle:   NewHandle(^int64(0), ^int64(0)),
 			},
 			wantErr: false,
 		},
@@ -122,6 +130,10 @@ func TestFooter_EncodeDecode(t *testing.T) {
 			assert.Equal(t, tt.footer.DataHandle.Size, decodedFooter.DataHandle.Size, "DataHandle Size mismatch")
 			assert.Equal(t, tt.footer.IndexHandle.Offset, decodedFooter.IndexHandle.Offset, "IndexHandle Offset mismatch")
 			assert.Equal(t, tt.footer.IndexHandle.Size, decodedFooter.IndexHandle.Size, "IndexHandle Size mismatch")
+			assert.Equal(t, tt.footer.RangeDelHandle, decodedFooter.RangeDelHandle, "RangeDelHandle mismatch")
+			assert.Equal(t, tt.footer.FilterHandle, decodedFooter.FilterHandle, "FilterHandle mismatch")
+			assert.Equal(t, tt.footer.HeaderHandle, decodedFooter.HeaderHandle, "HeaderHandle mismatch")
+			assert.Equal(t, FormatVersion, decodedFooter.Version, "Version mismatch")
 		})
 	}
 }
@@ -156,6 +168,41 @@ func TestFooter_DecodeErrors(t *testing.T) {
 	})
 }
 
+// TestFooter_MagicAndVersion 测试魔数不匹配或版本不受支持时解码失败
+func TestFooter_MagicAndVersion(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.NoError(t, NewFooter().EncodeTo(buf))
+	encoded := buf.Bytes()
+
+	badMagic := append([]byte{}, encoded...)
+	badMagic[FooterSize-1] ^= 0xff
+	err := NewFooter().DecodeFrom(bytes.NewReader(badMagic))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "magic")
+
+	// 版本号被校验和覆盖，需要重新计算校验和
+	badVersion := append([]byte{}, encoded...)
+	badVersion[footerChecksumOffset-4] = byte(FormatVersion + 1)
+	binary.LittleEndian.PutUint32(badVersion[footerChecksumOffset:], crc32.Checksum(badVersion[:footerChecksumOffset], crcTable))
+	err = NewFooter().DecodeFrom(bytes.NewReader(badVersion))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "version")
+}
+
+// TestFooter_Checksum 测试 Handle 损坏时校验和不匹配
+func TestFooter_Checksum(t *testing.T) {
+	footer := NewFooter()
+	footer.IndexHandle = NewHandle(300, 400)
+	buf := &bytes.Buffer{}
+	assert.NoError(t, footer.EncodeTo(buf))
+
+	corrupted := buf.Bytes()
+	corrupted[HandleSize] ^= 0x01 // IndexHandle 的偏移量
+	err := NewFooter().DecodeFrom(bytes.NewReader(corrupted))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+}
+
 func TestFooter_NewHandle(t *testing.T) {
 	offset, size := int64(123), int64(456)
 	handle := NewHandle(offset, size)
@@ -172,3 +219,13 @@ func TestFooter_NewFooter(t *testing.T) {
 	assert.Equal(t, int64(0), footer.IndexHandle.Offset, "IndexHandle Offset should be 0")
 	assert.Equal(t, int64(0), footer.IndexHandle.Size, "IndexHandle Size should be 0")
 }
+
+func TestHandle_BytesAndParse(t *testing.T) {
+	handle := NewHandle(4096, 123)
+	parsed, err := ParseHandle(handle.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, handle, parsed)
+
+	_, err = ParseHandle(handle.Bytes()[:HandleSize-1])
+	assert.Error(t, err)
+}
@@ -1,17 +1,21 @@
 package block
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 )
 
+// Header 记录 SSTable 的元数据：key 范围和序列号范围。
+// 编码格式：MinKey + MaxKey（各为 4 字节长度前缀 + key）+ MinSeq(8) + MaxSeq(8)
 type Header struct {
 	MinKey kv.Key
 	MaxKey kv.Key
+	MinSeq uint64
+	MaxSeq uint64
 }
 
 func NewHeader(minKey, maxKey kv.Key) *Header {
@@ -33,20 +37,32 @@ func (h *Header) EncodeTo(w io.Writer) error {
 		return fmt.Errorf("encode max key: %w", err)
 	}
 
+	if err := binary.Write(w, binary.LittleEndian, [2]uint64{h.MinSeq, h.MaxSeq}); err != nil {
+		log.Errorf("encode sequence range failed: %s", err)
+		return fmt.Errorf("encode sequence range: %w", err)
+	}
+
 	return nil
 }
 
-// DecodeFrom 从文件解码Header（小端存储）
-func (h *Header) DecodeFrom(file *os.File) error {
-	if _, err := h.MinKey.DecodeFrom(file); err != nil {
+// DecodeFrom 从 io.Reader 解码Header（小端存储）
+func (h *Header) DecodeFrom(r io.Reader) error {
+	if _, err := h.MinKey.DecodeFrom(r); err != nil {
 		log.Errorf("decode min key failed: %s", err)
 		return fmt.Errorf("decode min key: %w", err)
 	}
 
-	if _, err := h.MaxKey.DecodeFrom(file); err != nil {
+	if _, err := h.MaxKey.DecodeFrom(r); err != nil {
 		log.Errorf("decode max key failed: %s", err)
 		return err
 	}
 
+	var seqs [2]uint64
+	if err := binary.Read(r, binary.LittleEndian, &seqs); err != nil {
+		log.Errorf("decode sequence range failed: %s", err)
+		return fmt.Errorf("decode sequence range: %w", err)
+	}
+	h.MinSeq, h.MaxSeq = seqs[0], seqs[1]
+
 	return nil
 }
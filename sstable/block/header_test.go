@@ -42,6 +42,7 @@ func TestHeader_EncodeDecode(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// 创建 Header 并编码
 			header := NewHeader(tt.minKey, tt.maxKey)
+			header.MinSeq, header.MaxSeq = 3, 9
 			buf := &bytes.Buffer{}
 			err := header.EncodeTo(buf)
 
@@ -78,6 +79,8 @@ func TestHeader_EncodeDecode(t *testing.T) {
 			// 验证解码后的数据
 			assert.Equal(t, tt.minKey, decodedHeader.MinKey, "MinKey mismatch")
 			assert.Equal(t, tt.maxKey, decodedHeader.MaxKey, "MaxKey mismatch")
+			assert.Equal(t, uint64(3), decodedHeader.MinSeq, "MinSeq mismatch")
+			assert.Equal(t, uint64(9), decodedHeader.MaxSeq, "MaxSeq mismatch")
 		})
 	}
 }
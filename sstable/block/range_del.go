@@ -0,0 +1,74 @@
+package block
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+// RangeDelBlock 保存 SSTable 中的区间删除标记。
+// 区间删除标记覆盖的是一段 key，不适合放入按单个 key 排序的索引块，因此单独存放，
+// 并在加载 SSTable 时一次性读入内存。每一项按 kv.KeyValuePair 的格式编码，Key 为起始 key，Value 为结束 key。
+type RangeDelBlock struct {
+	Tombstones []kv.KeyValuePair
+}
+
+func NewRangeDelBlock() *RangeDelBlock {
+	return &RangeDelBlock{
+		Tombstones: make([]kv.KeyValuePair, 0),
+	}
+}
+
+// Add 追加一个区间删除标记
+func (b *RangeDelBlock) Add(pair kv.KeyValuePair) {
+	b.Tombstones = append(b.Tombstones, pair)
+}
+
+func (b *RangeDelBlock) Len() int {
+	return len(b.Tombstones)
+}
+
+// EncodeTo 将所有区间删除标记编码到 writer，返回写入的字节数
+func (b *RangeDelBlock) EncodeTo(w io.Writer) (int64, error) {
+	buf := &bytes.Buffer{}
+	for i := range b.Tombstones {
+		if err := b.Tombstones[i].EncodeTo(buf); err != nil {
+			log.Errorf("encode range tombstone failed: %s", err.Error())
+			return 0, fmt.Errorf("encode range tombstone failed: %w", err)
+		}
+	}
+
+	n, err := w.Write(buf.Bytes())
+	if err != nil {
+		log.Errorf("write range deletion block failed: %s", err.Error())
+		return 0, fmt.Errorf("write range deletion block failed: %w", err)
+	}
+	return int64(n), nil
+}
+
+// DecodeFrom 从 reader 中读取 size 字节并解码区间删除标记
+func (b *RangeDelBlock) DecodeFrom(r io.Reader, size int64) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		log.Errorf("read range deletion block failed: %s", err.Error())
+		return fmt.Errorf("read range deletion block failed: %w", err)
+	}
+
+	b.Tombstones = make([]kv.KeyValuePair, 0)
+	buf := bytes.NewReader(data)
+	for buf.Len() > 0 {
+		var pair kv.KeyValuePair
+		if err := pair.DecodeFrom(buf); err != nil {
+			log.Errorf("decode range tombstone failed: %s", err.Error())
+			return fmt.Errorf("decode range tombstone failed: %w", err)
+		}
+		if pair.Kind != kv.KindRangeDelete {
+			return fmt.Errorf("unexpected kind %s in range deletion block", pair.Kind)
+		}
+		b.Add(pair)
+	}
+	return nil
+}
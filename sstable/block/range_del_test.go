@@ -0,0 +1,45 @@
+package block
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+func TestRangeDelBlock_EncodeDecode(t *testing.T) {
+	block := NewRangeDelBlock()
+	block.Add(kv.KeyValuePair{Key: "a", Value: kv.Value("c"), Seq: 3, Kind: kv.KindRangeDelete})
+	block.Add(kv.KeyValuePair{Key: "m", Value: kv.Value("z"), Seq: 7, Kind: kv.KindRangeDelete})
+
+	buf := new(bytes.Buffer)
+	size, err := block.EncodeTo(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), size)
+
+	decoded := NewRangeDelBlock()
+	assert.NoError(t, decoded.DecodeFrom(bytes.NewReader(buf.Bytes()), size))
+	assert.Equal(t, block.Tombstones, decoded.Tombstones)
+
+	// 空块编码为 0 字节
+	empty := NewRangeDelBlock()
+	size, err = empty.EncodeTo(new(bytes.Buffer))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	assert.NoError(t, decoded.DecodeFrom(bytes.NewReader(nil), 0))
+	assert.Equal(t, 0, decoded.Len())
+}
+
+func TestRangeDelBlock_DecodeRejectsPointRecords(t *testing.T) {
+	pair := kv.KeyValuePair{Key: "a", Value: kv.Value("1"), Seq: 1, Kind: kv.KindPut}
+	buf := new(bytes.Buffer)
+	assert.NoError(t, pair.EncodeTo(buf))
+
+	block := NewRangeDelBlock()
+	assert.Error(t, block.DecodeFrom(bytes.NewReader(buf.Bytes()), int64(buf.Len())))
+
+	// 数据不完整
+	assert.Error(t, block.DecodeFrom(bytes.NewReader(buf.Bytes()[:3]), int64(buf.Len())))
+}
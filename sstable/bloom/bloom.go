@@ -69,6 +69,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 
 	"github.com/bits-and-blooms/bitset"
 
@@ -77,8 +78,12 @@ import (
 )
 
 const (
-	defaultBloomFilterM = 1600000
-	defaultBloomFilterK = 16
+	// DefaultBitSize 和 DefaultHashNum 是默认的位图长度和哈希函数个数
+	DefaultBitSize = 1600000
+	DefaultHashNum = 16
+
+	// DefaultBitsPerKey 是按元素数量构建布隆过滤器时每个元素默认占用的位数，理论误判率约为 1%
+	DefaultBitsPerKey = 10
 )
 
 // A Filter is a representation of a set of _n_ items, where the main
@@ -101,7 +106,7 @@ func NewBloomFilter(m uint, k uint) *Filter {
 }
 
 func DefaultBloomFilter() *Filter {
-	return NewBloomFilter(defaultBloomFilterM, defaultBloomFilterK)
+	return NewBloomFilter(DefaultBitSize, DefaultHashNum)
 }
 
 // From creates a new Bloom filter with len(_data_) * 64 bits and _k_ hashing
@@ -156,6 +161,17 @@ func NewWithEstimates(n uint, fp float64) *Filter {
 	return NewBloomFilter(m, k)
 }
 
+// FalsePositiveRate 返回每个元素占用 bitsPerKey 位、哈希函数个数最优时的理论误判率
+func FalsePositiveRate(bitsPerKey uint) float64 {
+	return math.Exp(-float64(bitsPerKey) * math.Ln2 * math.Ln2)
+}
+
+// NewWithBitsPerKey 为 n 个元素创建每个元素约占 bitsPerKey 位的布隆过滤器，
+// 位图长度和哈希函数个数由 EstimateParameters 按对应的理论误判率估计。n 为 0 时按 1 个元素创建
+func NewWithBitsPerKey(n uint, bitsPerKey uint) *Filter {
+	return NewWithEstimates(max(n, 1), FalsePositiveRate(max(bitsPerKey, 1)))
+}
+
 // Cap returns the capacity, _m_, of a Bloom filter
 func (f *Filter) Cap() uint {
 	return f.arraySize
@@ -444,9 +460,35 @@ func (f *Filter) TestOrAddString(data string) bool {
 	return f.TestOrAdd([]byte(data))
 }
 
-// MayContain for SSTable key
+// KeyHash 返回 SSTable 中 key 的 64 位哈希值。构建 SSTable 时只保存每个 key 的哈希值，
+// 构建完成时通过 AddHash 加入过滤器，内存占用只与 key 的数量有关，与 key 的长度无关
+func KeyHash(key kv.Key) uint64 {
+	return baseHashes([]byte(key))[0]
+}
+
+// hashLocation 通过双重哈希由 64 位哈希值 h 得到第 i 个位置
+func (f *Filter) hashLocation(h uint64, i uint) uint {
+	delta := bits.RotateLeft64(h, 32)
+	return uint((h + uint64(i)*delta) % uint64(f.arraySize))
+}
+
+// AddHash 将哈希值为 h（由 KeyHash 计算）的 key 加入过滤器，这样加入的 key 只能通过 MayContain 查询
+func (f *Filter) AddHash(h uint64) *Filter {
+	for i := uint(0); i < f.hashNum; i++ {
+		f.bitVector.Set(f.hashLocation(h, i))
+	}
+	return f
+}
+
+// MayContain 判断 SSTable 中是否可能存在 key，过滤器中的 key 需要通过 AddHash 加入
 func (f *Filter) MayContain(key kv.Key) bool {
-	return f.TestString(string(key))
+	h := KeyHash(key)
+	for i := uint(0); i < f.hashNum; i++ {
+		if !f.bitVector.Test(f.hashLocation(h, i)) {
+			return false
+		}
+	}
+	return true
 }
 
 // DecodeFrom 从 io.Reader 解码 Filter（小端存储 + uint64 长度前缀）
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"fmt"
 	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
 )
 
 func TestBasic(t *testing.T) {
@@ -408,6 +411,52 @@ func TestFPP(t *testing.T) {
 	assert.LessOrEqual(t, actualFPP, 0.001, "excessive false positive probability")
 }
 
+// TestNewWithBitsPerKey 测试按元素数量和每个元素的位数创建布隆过滤器
+func TestNewWithBitsPerKey(t *testing.T) {
+	f := NewWithBitsPerKey(1000, 10)
+	assert.InDelta(t, 10000, f.Cap(), 1)
+	assert.Equal(t, uint(7), f.hashNum)
+	assert.InDelta(t, 0.0082, FalsePositiveRate(10), 0.0001)
+
+	for i := uint32(0); i < 1000; i++ {
+		n := make([]byte, 4)
+		binary.BigEndian.PutUint32(n, i)
+		f.Add(n)
+	}
+	count := 0
+	for i := uint32(0); i < 10000; i++ {
+		n := make([]byte, 4)
+		binary.BigEndian.PutUint32(n, i+1000)
+		if f.Test(n) {
+			count++
+		}
+	}
+	assert.Less(t, float64(count)/10000, 0.02, "excessive false positive probability")
+
+	// 元素数量和位数为 0 时仍然可以使用
+	empty := NewWithBitsPerKey(0, 0)
+	assert.Positive(t, empty.Cap())
+	assert.False(t, empty.TestString("missing"))
+}
+
+// TestAddHash 测试按 key 的哈希值构建的过滤器没有假阴性，误判率接近理论值
+func TestAddHash(t *testing.T) {
+	f := NewWithBitsPerKey(1000, 10)
+	for i := 0; i < 1000; i++ {
+		f.AddHash(KeyHash(kv.Key(fmt.Sprintf("key%d", i))))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.MayContain(kv.Key(fmt.Sprintf("key%d", i))))
+	}
+	count := 0
+	for i := 0; i < 10000; i++ {
+		if f.MayContain(kv.Key(fmt.Sprintf("missing%d", i))) {
+			count++
+		}
+	}
+	assert.Less(t, float64(count)/10000, 0.02, "excessive false positive probability")
+}
+
 func TestEncodeDecodeBinary(t *testing.T) {
 	f := NewBloomFilter(1000, 4)
 	f.Add([]byte("one"))
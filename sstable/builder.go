@@ -3,34 +3,72 @@ package sstable
 import (
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/memtable"
-	"github.com/xmh1011/go-lsm/sstable/block"
 )
 
 type Builder struct {
-	table *SSTable
-	size  uint64
+	table   *SSTable
+	size    uint64
+	maxSize uint64
 }
 
-func NewSSTableBuilder(level int) *Builder {
+// NewSSTableBuilder 创建向 table 写入数据的 Builder，写入的数据达到 maxSize 时 ShouldFlush 返回 true
+func NewSSTableBuilder(table *SSTable, maxSize uint64) *Builder {
 	return &Builder{
-		table: NewSSTableWithLevel(level),
-		size:  0,
+		table:   table,
+		size:    0,
+		maxSize: maxSize,
 	}
 }
 
-// BuildSSTableFromIMemTable 构建一个完整的 SSTable（包含 DataBlock、IndexBlock、FilterBlock）
-func BuildSSTableFromIMemTable(imem *memtable.IMemTable) *SSTable {
-	builder := NewSSTableBuilder(minSSTableLevel)
+// newBuilder 创建向指定层级的新 SSTable 写入数据的 Builder
+func (m *Manager) newBuilder(level int) *Builder {
+	return NewSSTableBuilder(m.newTable(level), m.opts.TableSize)
+}
+
+// BuildSSTableFromIMemTable 构建一个完整的 Level0 SSTable（包含数据块、IndexBlock、FilterBlock）
+func (m *Manager) BuildSSTableFromIMemTable(imem *memtable.IMemTable) *SSTable {
+	builder := m.newBuilder(minSSTableLevel)
 
 	// 遍历所有 key-value 对
 	imem.RangeScan(func(pair *kv.KeyValuePair) {
 		builder.Add(pair)
 	})
+	// 区间删除标记不在跳表中，需要单独写入
+	for _, tombstone := range imem.RangeTombstones() {
+		builder.Add(&tombstone)
+	}
 
 	return builder.Build()
 }
 
-// Add 向当前 DataBlock（[]kv.Value）添加记录；若当前 Block 满了，则创建新 Block。
+// writeIMemTable 将 imem 中的数据流式写入一个新的 Level0 SSTable 文件
+func (m *Manager) writeIMemTable(imem *memtable.IMemTable) (*SSTable, error) {
+	w, err := m.newTableWriter(minSSTableLevel)
+	if err != nil {
+		return nil, err
+	}
+	imem.RangeScan(func(pair *kv.KeyValuePair) {
+		if err == nil {
+			err = w.Add(pair)
+		}
+	})
+	// 区间删除标记不在跳表中，需要单独写入
+	for _, tombstone := range imem.RangeTombstones() {
+		if err == nil {
+			err = w.Add(&tombstone)
+		}
+	}
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if _, err = w.Finish(); err != nil {
+		return nil, err
+	}
+	return w.Table(), nil
+}
+
+// Add 向当前数据块添加记录；若当前数据块满了，则创建新的数据块。
 func (b *Builder) Add(pair *kv.KeyValuePair) {
 	b.table.Add(pair)
 	b.size += pair.EstimateSize()
@@ -38,22 +76,37 @@ func (b *Builder) Add(pair *kv.KeyValuePair) {
 
 // ShouldFlush 判断是否应该写入磁盘
 func (b *Builder) ShouldFlush() bool {
-	return b.size >= maxSSTableSize
+	return b.size >= b.maxSize
 }
 
-// Finalize 填充 IndexBlock 和 Header
+// Finalize 填充 Header，Header 的 key 范围同时覆盖点记录和区间删除标记
 func (b *Builder) Finalize() {
-	// 初始化 Header
-	if b.table.DataBlock.Len() > 0 {
-		b.table.Header = &block.Header{
-			MaxKey: b.table.IndexBlock.Indexes[b.table.DataBlock.Len()-1].Key,
-			MinKey: b.table.IndexBlock.Indexes[0].Key,
+	found := false
+	var minKey, maxKey kv.Key
+	if b.table.entries > 0 {
+		minKey = b.table.firstKey
+		maxKey = b.table.lastPair.Key
+		found = true
+	}
+	for _, tombstone := range b.table.RangeDelBlock.Tombstones {
+		end := kv.Key(tombstone.Value)
+		if !found || tombstone.Key < minKey {
+			minKey = tombstone.Key
 		}
+		if !found || end > maxKey {
+			maxKey = end
+		}
+		found = true
+	}
+	if found {
+		b.table.Header.MinKey = minKey
+		b.table.Header.MaxKey = maxKey
 	}
 }
 
-// Build 返回最终构建好的 SSTable
+// Build 结束最后一个数据块并返回最终构建好的 SSTable
 func (b *Builder) Build() *SSTable {
 	b.Finalize()
+	b.table.finish()
 	return b.table
 }
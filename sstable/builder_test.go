@@ -9,15 +9,16 @@ import (
 )
 
 func TestNewSSTableBuilder(t *testing.T) {
-	builder := NewSSTableBuilder(1)
+	builder := newTestManager(t).newBuilder(1)
 	assert.NotNil(t, builder)
 	assert.NotNil(t, builder.table)
 	assert.Equal(t, uint64(0), builder.size)
 	assert.Equal(t, 1, builder.table.level)
+	assert.Equal(t, uint64(defaultTableSize), builder.maxSize)
 }
 
 func TestBuilder_Add(t *testing.T) {
-	builder := NewSSTableBuilder(0)
+	builder := newTestManager(t).newBuilder(0)
 
 	// 添加测试数据
 	pair1 := &kv.KeyValuePair{
@@ -32,13 +33,9 @@ func TestBuilder_Add(t *testing.T) {
 	builder.Add(pair1)
 	builder.Add(pair2)
 
-	// 验证 DataBlock
-	assert.Equal(t, 2, builder.table.DataBlock.Len())
-
-	// 验证 IndexBlock
-	assert.Equal(t, 2, len(builder.table.IndexBlock.Indexes))
-	assert.Equal(t, kv.Key("key1"), builder.table.IndexBlock.Indexes[0].Key)
-	assert.Equal(t, kv.Key("key2"), builder.table.IndexBlock.Indexes[1].Key)
+	// 验证记录写入当前数据块
+	assert.Equal(t, 2, builder.table.entries)
+	assert.Equal(t, 2, builder.table.dataBlock.Len())
 
 	// 验证 size 计算
 	expectedSize := pair1.EstimateSize() + pair2.EstimateSize()
@@ -53,24 +50,24 @@ func TestBuilder_ShouldFlush(t *testing.T) {
 	}{
 		{
 			name:     "not flush",
-			size:     maxSSTableSize - 1,
+			size:     defaultTableSize - 1,
 			expected: false,
 		},
 		{
 			name:     "exact flush",
-			size:     maxSSTableSize,
+			size:     defaultTableSize,
 			expected: true,
 		},
 		{
 			name:     "exceed flush",
-			size:     maxSSTableSize + 1,
+			size:     defaultTableSize + 1,
 			expected: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			builder := NewSSTableBuilder(0)
+			builder := newTestManager(t).newBuilder(0)
 			builder.size = tt.size
 			assert.Equal(t, tt.expected, builder.ShouldFlush())
 		})
@@ -78,13 +75,11 @@ func TestBuilder_ShouldFlush(t *testing.T) {
 }
 
 func TestBuilder_Finalize(t *testing.T) {
-	builder := NewSSTableBuilder(0)
+	builder := newTestManager(t).newBuilder(0)
 
 	// 添加一些数据
-	builder.table.DataBlock.Add(kv.Value("value1"))
-	builder.table.DataBlock.Add(kv.Value("value2"))
-	builder.table.IndexBlock.Add("key1", 0)
-	builder.table.IndexBlock.Add("key2", 100)
+	builder.table.Add(&kv.KeyValuePair{Key: "key1", Value: kv.Value("value1")})
+	builder.table.Add(&kv.KeyValuePair{Key: "key2", Value: kv.Value("value2")})
 
 	builder.Finalize()
 
@@ -95,7 +90,7 @@ func TestBuilder_Finalize(t *testing.T) {
 }
 
 func TestBuilder_Build(t *testing.T) {
-	builder := NewSSTableBuilder(0)
+	builder := newTestManager(t).newBuilder(0)
 
 	// 添加一些数据
 	pair := &kv.KeyValuePair{
@@ -114,4 +109,10 @@ func TestBuilder_Build(t *testing.T) {
 	assert.NotNil(t, sstable.Header)
 	assert.Equal(t, kv.Key("testKey"), sstable.Header.MinKey)
 	assert.Equal(t, kv.Key("testKey"), sstable.Header.MaxKey)
+
+	// 验证最后一个数据块已经写入索引
+	assert.NotNil(t, sstable.IndexBlock)
+	pairs, err := sstable.GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Equal(t, []kv.KeyValuePair{*pair}, pairs)
 }
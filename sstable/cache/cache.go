@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultShards 是缓存默认的分片数量
+const DefaultShards = 16
+
+// Key 是缓存项的 key，由 SSTable 文件的 id 和块在文件中的偏移量组成
+type Key struct {
+	FileID uint64
+	Offset int64
+}
+
+// Stats 是缓存的统计信息
+type Stats struct {
+	Hits      uint64 // 命中次数
+	Misses    uint64 // 未命中次数
+	Inserts   uint64 // 插入次数
+	Evictions uint64 // 因容量不足被淘汰的缓存项数量
+	Usage     int64  // 当前缓存项占用的总大小（字节）
+	Capacity  int64  // 缓存容量（字节）
+}
+
+// Cache 是按容量限制的分片 LRU 缓存，被所有 SSTable 共享，用于缓存解析之后的块。
+// 每个分片独立加锁并各自维护 LRU 链表，分片容量为总容量的均分，缓存项按 charge 计入占用。
+// 缓存的值被多个读者共享，调用方不能修改。
+type Cache struct {
+	shards   []*shard
+	capacity int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	inserts   atomic.Uint64
+	evictions atomic.Uint64
+}
+
+// shard 是缓存的一个分片
+type shard struct {
+	mu       sync.Mutex
+	capacity int64
+	usage    int64
+	lru      *list.List // 表头是最近使用的缓存项
+	items    map[Key]*list.Element
+}
+
+// entry 是 LRU 链表中的一个缓存项
+type entry struct {
+	key    Key
+	value  any
+	charge int64
+}
+
+// NewCache 创建总容量为 capacity 字节、分为 shards 个分片的缓存，shards 不大于 0 时使用 DefaultShards
+func NewCache(capacity int64, shards int) *Cache {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	c := &Cache{
+		shards:   make([]*shard, shards),
+		capacity: capacity,
+	}
+	perShard := (capacity + int64(shards) - 1) / int64(shards)
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			capacity: perShard,
+			lru:      list.New(),
+			items:    make(map[Key]*list.Element),
+		}
+	}
+	return c
+}
+
+// Get 查找 key 对应的值，命中时将其移动到 LRU 链表的表头
+func (c *Cache) Get(key Key) (any, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	s.lru.MoveToFront(elem)
+	return elem.Value.(*entry).value, true
+}
+
+// Insert 插入或替换 key 对应的值，charge 是值占用的大小。
+// 分片容量不足时从 LRU 链表的表尾开始淘汰，大于分片容量的值不会被缓存
+func (c *Cache) Insert(key Key, value any, charge int) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	if int64(charge) > s.capacity {
+		return
+	}
+	c.inserts.Add(1)
+	s.items[key] = s.lru.PushFront(&entry{key: key, value: value, charge: int64(charge)})
+	s.usage += int64(charge)
+	for s.usage > s.capacity {
+		s.remove(s.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Erase 删除 key 对应的值
+func (c *Cache) Erase(key Key) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+}
+
+// Stats 返回缓存的统计信息
+func (c *Cache) Stats() Stats {
+	stats := Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Inserts:   c.inserts.Load(),
+		Evictions: c.evictions.Load(),
+		Capacity:  c.capacity,
+	}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		stats.Usage += s.usage
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+// shard 返回 key 所在的分片
+func (c *Cache) shard(key Key) *shard {
+	h := key.FileID*0x9e3779b97f4a7c15 ^ uint64(key.Offset)*0xc2b2ae3d27d4eb4f
+	return c.shards[(h>>32)%uint64(len(c.shards))]
+}
+
+// remove 从分片中删除缓存项，调用方需要持有 mu
+func (s *shard) remove(elem *list.Element) {
+	e := s.lru.Remove(elem).(*entry)
+	delete(s.items, e.key)
+	s.usage -= e.charge
+}
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheGetInsert(t *testing.T) {
+	c := NewCache(1024, 1)
+	_, ok := c.Get(Key{FileID: 1, Offset: 0})
+	assert.False(t, ok)
+
+	c.Insert(Key{FileID: 1, Offset: 0}, "a", 100)
+	value, ok := c.Get(Key{FileID: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+
+	// 同一文件不同偏移量、不同文件相同偏移量都是不同的缓存项
+	_, ok = c.Get(Key{FileID: 1, Offset: 100})
+	assert.False(t, ok)
+	_, ok = c.Get(Key{FileID: 2, Offset: 0})
+	assert.False(t, ok)
+
+	// 替换已有的值
+	c.Insert(Key{FileID: 1, Offset: 0}, "b", 200)
+	value, _ = c.Get(Key{FileID: 1, Offset: 0})
+	assert.Equal(t, "b", value)
+
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Inserts)
+	assert.Equal(t, int64(200), stats.Usage)
+	assert.Equal(t, int64(1024), stats.Capacity)
+
+	c.Erase(Key{FileID: 1, Offset: 0})
+	_, ok = c.Get(Key{FileID: 1, Offset: 0})
+	assert.False(t, ok)
+	assert.Zero(t, c.Stats().Usage)
+}
+
+// TestCacheEvictsLeastRecentlyUsed 测试容量不足时淘汰最久未使用的缓存项
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(300, 1)
+	for i := int64(0); i < 3; i++ {
+		c.Insert(Key{FileID: 1, Offset: i}, i, 100)
+	}
+	// 访问第一个缓存项，第二个成为最久未使用的
+	_, ok := c.Get(Key{FileID: 1, Offset: 0})
+	assert.True(t, ok)
+
+	c.Insert(Key{FileID: 1, Offset: 3}, int64(3), 100)
+	_, ok = c.Get(Key{FileID: 1, Offset: 1})
+	assert.False(t, ok)
+	for _, offset := range []int64{0, 2, 3} {
+		_, ok = c.Get(Key{FileID: 1, Offset: offset})
+		assert.True(t, ok, "offset %d", offset)
+	}
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+	assert.Equal(t, int64(300), c.Stats().Usage)
+
+	// 一个大的缓存项淘汰多个小的缓存项
+	c.Insert(Key{FileID: 2}, "large", 250)
+	assert.Equal(t, int64(250), c.Stats().Usage)
+	assert.Equal(t, uint64(4), c.Stats().Evictions)
+
+	// 大于容量的值不缓存
+	c.Insert(Key{FileID: 3}, "huge", 301)
+	_, ok = c.Get(Key{FileID: 3})
+	assert.False(t, ok)
+	_, ok = c.Get(Key{FileID: 2})
+	assert.True(t, ok)
+}
+
+// TestCacheShards 测试各分片的占用之和不超过总容量
+func TestCacheShards(t *testing.T) {
+	c := NewCache(16*1024, 0)
+	assert.Len(t, c.shards, DefaultShards)
+	for i := 0; i < 1000; i++ {
+		c.Insert(Key{FileID: uint64(i % 7), Offset: int64(i * 4096)}, i, 100)
+	}
+	stats := c.Stats()
+	assert.LessOrEqual(t, stats.Usage, stats.Capacity)
+	assert.Equal(t, uint64(1000), stats.Inserts)
+	assert.Positive(t, stats.Evictions)
+
+	used := 0
+	for _, s := range c.shards {
+		if s.usage > 0 {
+			used++
+		}
+	}
+	assert.Greater(t, used, DefaultShards/2, "keys should be spread across shards")
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	c := NewCache(64*1024, 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := Key{FileID: uint64(i % 10), Offset: int64(i % 100)}
+				if value, ok := c.Get(key); ok {
+					assert.Equal(t, fmt.Sprintf("%d-%d", key.FileID, key.Offset), value)
+				} else {
+					c.Insert(key, fmt.Sprintf("%d-%d", key.FileID, key.Offset), 64)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	stats := c.Stats()
+	assert.Equal(t, uint64(8000), stats.Hits+stats.Misses)
+}
@@ -3,236 +3,336 @@ package sstable
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 )
 
-// Compaction 执行 Level0 的同步合并，并触发 Level1 及以上的异步合并。
+// Compaction 在 Level0 需要合并时同步合并 Level0，并在有层级需要合并时启动异步合并。
 // 合并流程：
-// 1. 收集 Level0 文件，解码其 DataBlock，并统计全局 key 区间。
-// 2. 从 Level1 中找出与该区间交集的文件，将其 DataBlock 一并取出。
-// 3. 使用归并排序将所有块合并分块，产出新 SSTable（写入 Level1）。
-// 4. 删除旧 Level0 和 Level1 文件，并加入新文件记录。
-// 5. 如果 Level1 超限，异步触发后续合并。
-// Compaction 执行 Level0 的同步合并，并触发后续异步合并
+// 1. 计算各层级的合并分数：Level0 为文件数量与触发数量之比，其余层级为实际大小与目标大小之比。
+// 2. Level0 的所有文件合并到基准层级；其余层级按 CompactionPri 选出一个文件合并到下一层级。
+// 3. 为输入文件和下一层级中与之重叠的文件创建迭代器，通过迭代器堆多路归并，输出的数据块写满就写入磁盘。
+// 4. 通过一条 MANIFEST 记录原子地加入新文件、移除旧文件，旧文件在不再被任何版本引用之后删除。
+// 5. 异步合并每次选择分数最高的层级，直到所有层级的分数都小于 1。
+// universal 合并时第 1、2 步替换为按有序段的数量、大小比例和空间放大选出连续的有序段，见 pickUniversalCompaction。
+// FIFO 合并不归并数据，只通过 MANIFEST 记录删除过期或超出总大小上限的最旧的 Level0 文件，见 pickFIFOCompaction。
 func (m *Manager) Compaction() error {
-	// 等待同一层级的压缩完成
-	if err := m.waitCompaction(minSSTableLevel); err != nil {
-		log.Errorf("wait compaction for level %d error: %s", minSSTableLevel, err.Error())
-		return fmt.Errorf("wait compaction error: %w", err)
+	if err := m.maybeCompactLevel(minSSTableLevel); err != nil {
+		log.Errorf("compact level %d error: %s", minSSTableLevel, err.Error())
+		return fmt.Errorf("compact level %d error: %w", minSSTableLevel, err)
 	}
 
-	// 检查 Level0 是否需要压缩
-	if !m.isLevelNeedToBeMerged(minSSTableLevel) {
-		log.Debug("level 0 not need to be merged")
-		return nil
+	// Level0 合并之后下面的层级可能超出目标大小
+	if _, ok := m.pickCompactionLevel(); ok {
+		m.scheduleAsyncCompaction()
 	}
+	return nil
+}
 
-	// 开始 Level0 压缩
-	if err := m.compactLevel(minSSTableLevel); err != nil {
-		log.Errorf("compact level %d error: %s", minSSTableLevel, err.Error())
-		return fmt.Errorf("compact level %d error: %w", minSSTableLevel, err)
+// Close 停止启动新的异步合并和定期的过期检查，并等待正在运行的异步合并完成。
+// 正在进行的合并会完成当前层级后退出，不再继续合并其他层级。
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if !m.closed && m.ttlStop != nil {
+		close(m.ttlStop)
 	}
+	m.closed = true
+	m.mu.Unlock()
 
-	// 触发下一层级异步压缩（如果需要）
-	if m.isLevelNeedToBeMerged(minSSTableLevel + 1) {
-		go m.asyncCompactLevel(minSSTableLevel + 1)
-	}
+	m.bgCompactions.Wait()
+	m.closeManifest()
+	m.tableCache.close()
+}
 
-	return nil
+// scheduleAsyncCompaction 启动异步合并，已经有异步合并在运行或 Manager 关闭之后不再启动
+func (m *Manager) scheduleAsyncCompaction() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed || m.bgScheduled {
+		return
+	}
+	m.bgScheduled = true
+	m.bgCompactions.Add(1)
+	go func() {
+		defer m.bgCompactions.Done()
+		m.backgroundCompaction()
+	}()
 }
 
-// asyncCompactLevel 异步合并指定层级（Level1 及以上）
-func (m *Manager) asyncCompactLevel(level int) {
-	for {
-		// 等待同一层级的压缩完成
-		if err := m.waitCompaction(level); err != nil {
-			log.Errorf("wait compaction error: %v", err)
-			return
-		}
+// isClosed 返回 Manager 是否已经关闭
+func (m *Manager) isClosed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.closed
+}
 
-		// 检查是否需要压缩
-		if !m.isLevelNeedToBeMerged(level) {
+// backgroundCompaction 每次合并分数最高的层级，直到所有层级的分数都小于 1、合并出错、Manager 关闭
+// 或者开始独占的手动合并
+func (m *Manager) backgroundCompaction() {
+	defer func() {
+		m.mu.Lock()
+		m.bgScheduled = false
+		m.mu.Unlock()
+	}()
+
+	for !m.isClosed() && !m.IsExclusiveManual() {
+		level, ok := m.pickCompactionLevel()
+		if !ok {
 			return
 		}
-
-		// 执行压缩
-		if err := m.compactLevel(level); err != nil {
+		if err := m.maybeCompactLevel(level); err != nil {
 			log.Errorf("async compaction at level %d error: %v", level, err)
 			return
 		}
-
-		// 如果下一层级仍需压缩，继续循环（仅对中间层级）
-		if level < maxSSTableLevel && m.isLevelNeedToBeMerged(level+1) {
-			continue
-		}
-		return
 	}
 }
 
-// compactLevel 同步合并指定层级
+// compactLevel 合并指定层级，不检查层级是否需要合并
 func (m *Manager) compactLevel(level int) error {
-	// 标记当前层级开始压缩
-	m.startCompaction(level)
-	defer m.endCompaction(level)
-
-	// 1. 读取当前层级的所有键值对
-	files := m.getFilesByLevel(level)
-	// 对于 level 1 及以上的层级
-	// 按照时间顺序，只合并超出数量的旧文件
-	if level > minSSTableLevel {
-		files = files[:maxFileNumsInLevel(level)]
-	}
-	allPairs, err := m.loadLevelData(files)
-	if err != nil {
-		log.Errorf("load level %d data error: %s", level, err.Error())
-		return fmt.Errorf("load level %d data error: %w", level, err)
-	}
+	return m.runCompaction(level, false)
+}
 
-	// 2. 加载重叠文件
-	var nextLevelPairs []kv.KeyValuePair
-	var oldNextFiles []string
-	if level < maxSSTableLevel {
-		minK, maxK := getGlobalKeyRangeFromPairs(allPairs)
-		nextLevelPairs, oldNextFiles, err = m.mergeNextLevelFiles(level+1, minK, maxK)
-		if err != nil {
-			log.Errorf("merge next level files error: %s", err.Error())
-			return fmt.Errorf("merge next level files error: %w", err)
-		}
-		allPairs = append(allPairs, nextLevelPairs...)
+// maybeCompactLevel 在层级的合并分数达到 1 时合并该层级
+func (m *Manager) maybeCompactLevel(level int) error {
+	return m.runCompaction(level, true)
+}
+
+// runCompaction 等待相关层级上正在进行的合并完成之后合并 level 层，
+// onlyIfNeeded 为 true 时是自动合并，在等待之后重新检查层级是否仍需要合并，独占的手动合并进行期间直接返回
+func (m *Manager) runCompaction(level int, onlyIfNeeded bool) error {
+	levels := m.reserveCompaction(func(v *Version) []int {
+		return m.compactionLevels(v, level)
+	}, !onlyIfNeeded)
+	if levels == nil {
+		return nil
 	}
+	defer m.endCompaction(levels...)
 
-	// 3. 合并并生成新 SSTable
-	newTables := CompactAndMergeKVs(allPairs, level+1) // 目标层级为当前+1
+	// 合并期间持有当前版本，旧文件在新版本提交且所有读者释放之后才会被删除
+	v := m.currentVersion()
+	defer v.Unref()
 
-	// 4. 清理旧文件
-	if err := m.removeOldSSTables(files, level); err != nil {
-		log.Errorf("remove old SSTables error: %s", err.Error())
-		return fmt.Errorf("remove old SSTables error: %w", err)
+	if onlyIfNeeded && m.compactionScores(v)[level] < 1 {
+		return nil
 	}
-	if len(oldNextFiles) > 0 {
-		if err := m.removeOldSSTables(oldNextFiles, level+1); err != nil {
-			log.Errorf("remove old next level SSTables error: %s", err.Error())
-			return fmt.Errorf("remove old next level SSTables error: %w", err)
-		}
+	var c *compaction
+	switch m.opts.CompactionStyle {
+	case CompactionStyleUniversal:
+		c = m.pickUniversalCompaction(v, !onlyIfNeeded)
+	case CompactionStyleFIFO:
+		c = m.pickFIFOCompaction(v, time.Now())
+	default:
+		c = m.pickCompaction(v, level, levels[1])
+	}
+	if c == nil {
+		return nil
+	}
+	if err := m.doCompaction(c); err != nil {
+		return err
 	}
 
-	// 5. 添加新文件
-	if err := m.addNewSSTables(newTables); err != nil {
-		log.Errorf("add new SSTables error: %s", err.Error())
-		return fmt.Errorf("add new SSTables error: %w", err)
+	if len(c.runs) == 0 && !c.deleteOnly && level > minSSTableLevel {
+		_, maxKey := getGlobalKeyRange(c.inputs)
+		m.setCompactPointer(level, maxKey)
 	}
+	return nil
+}
 
-	// 6. 如果目标层级仍需压缩，递归处理（仅对中间层级）
-	if level < maxSSTableLevel && m.isLevelNeedToBeMerged(level+1) {
-		return m.compactLevel(level + 1)
+// doCompaction 执行合并 c：归并输入文件写入输出层级，在同一条 MANIFEST 记录中新增新文件、删除旧文件。
+// deleteOnly 的合并直接删除输入文件
+func (m *Manager) doCompaction(c *compaction) error {
+	if c.deleteOnly {
+		return m.deleteTables(c.inputs)
+	}
+
+	// 通过每个文件的迭代器流式合并，新 SSTable 写满一个就写入磁盘
+	tables := c.tables()
+	newTables, err := m.mergeTables(tables, c.outputLevel)
+	if err != nil {
+		log.Errorf("merge level %d files error: %s", c.level, err.Error())
+		return fmt.Errorf("merge level %d files error: %w", c.level, err)
 	}
 
+	// 记录落盘之前崩溃时恢复结果仍是合并之前的版本，新文件被忽略
+	edit := &VersionEdit{}
+	for _, table := range tables {
+		edit.DeleteFile(table.level, table.id)
+	}
+	for _, table := range newTables {
+		edit.AddFile(table.Meta())
+	}
+	if err := m.logAndApply(edit, newTables); err != nil {
+		log.Errorf("log and apply compaction of level %d error: %s", c.level, err.Error())
+		return fmt.Errorf("log and apply compaction of level %d error: %w", c.level, err)
+	}
+	m.compactionStats.recordCompaction(tables, newTables)
 	return nil
 }
 
-// waitCompaction 等待指定层级的压缩完成
-func (m *Manager) waitCompaction(level int) error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	for m.isLevelCompacting(level) {
-		log.Debugf("level %d is compacting, waiting...", level)
-		m.compactionCond.Wait()
+// deleteTables 通过一条 MANIFEST 记录从当前版本中移除 tables，文件在不再被任何版本引用之后删除
+func (m *Manager) deleteTables(tables []*SSTable) error {
+	edit := &VersionEdit{}
+	for _, table := range tables {
+		edit.DeleteFile(table.level, table.id)
+	}
+	if err := m.logAndApply(edit, nil); err != nil {
+		log.Errorf("log and apply deletion of %d files error: %s", len(tables), err.Error())
+		return fmt.Errorf("log and apply deletion of %d files error: %w", len(tables), err)
 	}
+	log.Debugf("deleted %d files without compaction", len(tables))
 	return nil
 }
 
-// startCompaction 标记层级开始压缩
-func (m *Manager) startCompaction(level int) {
+// reserveCompaction 等待 levels 返回的层级上正在进行的合并完成，将这些层级标记为正在合并并返回。
+// 同一个层级同一时间只参与一个合并，避免两个合并删除同一个文件。
+// manual 为 false 的自动合并在独占的手动合并进行期间放弃合并，返回 nil
+func (m *Manager) reserveCompaction(levels func(v *Version) []int, manual bool) []int {
+	// compactionCond 绑定的是写锁，Wait 之前必须持有写锁
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.compactingLevels[level] = true
+	for {
+		if m.exclusiveManual && !manual {
+			return nil
+		}
+		// 涉及的层级由当前版本决定，每次被唤醒之后重新计算
+		reserved := levels(m.current)
+		busy := false
+		for _, l := range reserved {
+			busy = busy || m.compactingLevels[l]
+		}
+		if !busy {
+			for _, l := range reserved {
+				m.compactingLevels[l] = true
+			}
+			return reserved
+		}
+		log.Debugf("levels %v are compacting, waiting...", reserved)
+		m.compactionCond.Wait()
+	}
 }
 
-// endCompaction 标记层级压缩完成并广播通知
-func (m *Manager) endCompaction(level int) {
+// compactionLevels 返回合并 level 层时需要独占的层级。leveled 合并为 level 层和输出层级；
+// universal 合并的输入可能跨越所有层级，同一时间只运行一个；FIFO 合并只删除 Level0 的文件
+func (m *Manager) compactionLevels(v *Version, level int) []int {
+	switch m.opts.CompactionStyle {
+	case CompactionStyleUniversal:
+		levels := make([]int, len(v.levels))
+		for l := range levels {
+			levels[l] = l
+		}
+		return levels
+	case CompactionStyleFIFO:
+		return []int{minSSTableLevel}
+	}
+	return []int{level, m.outputLevel(v, level)}
+}
+
+// endCompaction 取消层级的合并标记并广播通知
+func (m *Manager) endCompaction(levels ...int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.compactingLevels, level)
+	for _, level := range levels {
+		delete(m.compactingLevels, level)
+	}
 	m.compactionCond.Broadcast()
 }
 
-// isLevelCompacting 检查层级是否正在压缩
-func (m *Manager) isLevelCompacting(level int) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	return m.compactingLevels[level]
-}
-
-// loadLevelData 加载指定层级的所有键值对
-func (m *Manager) loadLevelData(files []string) ([]kv.KeyValuePair, error) {
-	allPairs := make([]kv.KeyValuePair, 0)
-
-	for _, path := range files {
-		sst, ok := m.getSSTableByPath(path)
-		if !ok {
-			log.Errorf("sstable not found for path: %s", path)
-			continue
+// mergeTables 为每个输入文件创建迭代器，经迭代器堆归并后写入 level 层的新 SSTable，tables 按从新到旧排列。
+// 内存中只保留每个输入的当前位置和正在构建的数据块，输出的数据块写满之后立即写入磁盘。
+// 合并失败时删除已经写入的新文件。
+func (m *Manager) mergeTables(tables []*SSTable, level int) ([]*SSTable, error) {
+	iters := make([]pairIterator, 0, len(tables))
+	defer func() {
+		for _, it := range iters {
+			it.Close()
 		}
+	}()
 
-		pairs, err := sst.GetDataBlockFromFile(path)
+	tombstones := make([]kv.KeyValuePair, 0)
+	for _, sst := range tables {
+		it, err := openTableIterator(sst)
 		if err != nil {
-			log.Errorf("decode sstable from file %s error: %s", path, err.Error())
-			return nil, fmt.Errorf("decode sstable from file %s error: %w", path, err)
+			return nil, err
 		}
-
-		allPairs = append(allPairs, pairs...)
+		iters = append(iters, it)
+		tombstones = append(tombstones, sst.RangeTombstones()...)
 	}
 
-	return allPairs, nil
-}
-
-// mergeNextLevelFiles 合并下一层级的重叠文件
-func (m *Manager) mergeNextLevelFiles(level int, minK, maxK kv.Key) ([]kv.KeyValuePair, []string, error) {
-	nextLevelFiles := m.getFilesByLevel(level)
-	oldFiles := make([]string, 0)
-	allPairs := make([]kv.KeyValuePair, 0)
-
-	for _, path := range nextLevelFiles {
-		sst, ok := m.getSSTableByPath(path)
-		if !ok {
-			log.Errorf("sstable not found for path: %s", path)
-			continue
+	newTables := make([]*SSTable, 0)
+	err := m.mergeIterators(iters, tombstones, level, m.liveSnapshots(), m.newFileOutput, func(table *SSTable) error {
+		newTables = append(newTables, table)
+		return nil
+	})
+	if err != nil {
+		for _, table := range newTables {
+			if removeErr := table.Remove(); removeErr != nil {
+				log.Errorf("remove sstable %s error: %s", table.FilePath(), removeErr.Error())
+			}
 		}
+		return nil, err
+	}
+	return newTables, nil
+}
 
-		if overlapRange(minK, maxK, sst) {
-			pairs, err := sst.GetDataBlockFromFile(path)
-			if err != nil {
-				log.Errorf("load data blocks error: %v", err)
-				return nil, nil, err
-			}
-			allPairs = append(allPairs, pairs...)
-			oldFiles = append(oldFiles, path)
+// newestFirst 将当前层级和下一层级的文件按从新到旧排列：当前层级的文件比下一层级的新，
+// 同一层级内 id 越大越新
+func newestFirst(files, nextFiles []*SSTable) []*SSTable {
+	tables := make([]*SSTable, 0, len(files)+len(nextFiles))
+	for _, group := range [][]*SSTable{files, nextFiles} {
+		for i := len(group) - 1; i >= 0; i-- {
+			tables = append(tables, group[i])
 		}
 	}
+	return tables
+}
 
-	return allPairs, oldFiles, nil
+// openTableIterator 创建 sst 的迭代器并立即打开文件，文件缺失时在合并开始之前返回错误。
+// 合并总是检查数据块的校验和，避免把损坏的数据写入新文件之后再删除原文件
+func openTableIterator(sst *SSTable) (*Iterator, error) {
+	file, err := sst.files.acquire(sst)
+	if err != nil {
+		return nil, fmt.Errorf("open file %s error: %w", sst.FilePath(), err)
+	}
+	it := newTableIterator(sst)
+	it.file, it.reader = file, file
+	it.verifyChecksums = true
+	it.fillCache = false
+	it.SeekToFirst()
+	return it, it.Error()
 }
 
-// getGlobalKeyRangeFromPairs 从键值对中计算全局 Key 范围
-func getGlobalKeyRangeFromPairs(pairs []kv.KeyValuePair) (kv.Key, kv.Key) {
-	if len(pairs) == 0 {
-		return "", ""
+// overlappingFiles 返回版本 v 中 level 层与 [minK, maxK] 有交集的文件
+func overlappingFiles(v *Version, level int, minK, maxK kv.Key) []*SSTable {
+	tables := make([]*SSTable, 0)
+	for _, sst := range v.files(level) {
+		if overlapRange(minK, maxK, sst) {
+			tables = append(tables, sst)
+		}
 	}
+	return tables
+}
 
-	minKey, maxKey := pairs[0].Key, pairs[0].Key
-	for _, pair := range pairs {
-		if pair.Key < minKey {
-			minKey = pair.Key
+// getGlobalKeyRange 根据 SSTable 的元数据计算全局 Key 范围，元数据中已经包含区间删除标记的结束 key
+func getGlobalKeyRange(tables []*SSTable) (kv.Key, kv.Key) {
+	var minKey, maxKey kv.Key
+	found := false
+	for _, sst := range tables {
+		if sst.Header.MinKey == "" && sst.Header.MaxKey == "" {
+			continue // 空文件
+		}
+		if !found || sst.Header.MinKey < minKey {
+			minKey = sst.Header.MinKey
 		}
-		if pair.Key > maxKey {
-			maxKey = pair.Key
+		if !found || sst.Header.MaxKey > maxKey {
+			maxKey = sst.Header.MaxKey
 		}
+		found = true
 	}
 	return minKey, maxKey
 }
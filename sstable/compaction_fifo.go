@@ -0,0 +1,100 @@
+package sstable
+
+import (
+	"time"
+
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const (
+	defaultFIFOMaxTableFilesSize = 1024 * 1024 * 1024 // 1GB
+	// minFIFOTTLCheckInterval 是定期检查过期文件的最短间隔
+	minFIFOTTLCheckInterval = 100 * time.Millisecond
+)
+
+// FIFOOptions 是 FIFO 合并删除旧文件的条件
+type FIFOOptions struct {
+	// MaxTableFilesSize 是 Level0 中所有 SSTable 的总大小上限（字节），超出时从最旧的文件开始删除
+	MaxTableFilesSize uint64
+	// TTL 是数据的保留时间，文件写入完成的时间早于 TTL 之前时删除该文件，为 0 时不按时间删除
+	TTL time.Duration
+}
+
+// DefaultFIFOOptions 返回 FIFO 合并的默认参数
+func DefaultFIFOOptions() FIFOOptions {
+	return FIFOOptions{MaxTableFilesSize: defaultFIFOMaxTableFilesSize}
+}
+
+// pickFIFOCompaction 返回 FIFO 合并在 now 时刻需要删除的 Level0 文件，没有需要删除的文件时返回 nil。
+// 从最旧的文件开始，依次删除已经过期的文件，以及使总大小超出 MaxTableFilesSize 的文件。
+// 文件只会被整个删除，不会被合并或重写。过期检查在刷盘之后、调用 Compaction 时以及 StartTTLCheck
+// 启动的定期检查中进行，两次检查之间已经过期的数据仍然可以读到
+func (m *Manager) pickFIFOCompaction(v *Version, now time.Time) *compaction {
+	files := v.files(minSSTableLevel) // 按 id 升序，即从旧到新
+	var total uint64
+	for _, table := range files {
+		total += table.Size()
+	}
+
+	opts := m.opts.FIFO
+	n := 0
+	for ; n < len(files); n++ {
+		expired := opts.TTL > 0 && now.Sub(files[n].modTime) > opts.TTL
+		if !expired && total <= opts.MaxTableFilesSize {
+			break
+		}
+		total -= files[n].Size()
+	}
+	if n == 0 {
+		return nil
+	}
+	return &compaction{level: minSSTableLevel, outputLevel: minSSTableLevel, inputs: files[:n], deleteOnly: true}
+}
+
+// fifoScore 返回 FIFO 合并的分数：有需要删除的文件时为 1，否则为 0
+func (m *Manager) fifoScore(v *Version) float64 {
+	if m.pickFIFOCompaction(v, time.Now()) == nil {
+		return 0
+	}
+	return 1
+}
+
+// StartTTLCheck 在 FIFO 合并设置了 TTL 时启动后台协程，每隔 TTL 的十分之一（不少于 minFIFOTTLCheckInterval）
+// 检查并删除过期的文件，没有新的写入时过期的数据也会被删除。其他合并方式、未设置 TTL 或已经启动时不做任何事，
+// Close 时停止
+func (m *Manager) StartTTLCheck() {
+	if m.opts.CompactionStyle != CompactionStyleFIFO || m.opts.FIFO.TTL <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed || m.ttlStop != nil {
+		return
+	}
+	m.ttlStop = make(chan struct{})
+	interval := max(m.opts.FIFO.TTL/10, minFIFOTTLCheckInterval)
+	m.bgCompactions.Add(1)
+	go func(stop chan struct{}) {
+		defer m.bgCompactions.Done()
+		m.ttlCheckLoop(interval, stop)
+	}(m.ttlStop)
+}
+
+// ttlCheckLoop 每隔 interval 检查一次 Level0 是否有需要删除的文件，直到 stop 被关闭
+func (m *Manager) ttlCheckLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := m.Compaction(); err != nil {
+				log.Errorf("periodic fifo ttl compaction error: %s", err.Error())
+			}
+		}
+	}
+}
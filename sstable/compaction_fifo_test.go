@@ -0,0 +1,171 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// newFIFOTestManager 创建使用 FIFO 合并的 Manager
+func newFIFOTestManager(t *testing.T, fifo FIFOOptions) *Manager {
+	opts := DefaultOptions(t.TempDir())
+	opts.CompactionStyle = CompactionStyleFIFO
+	opts.FIFO = fifo
+	return NewSSTableManager(opts)
+}
+
+// TestPickFIFOCompaction 测试按总大小上限和 TTL 从最旧的文件开始选出需要删除的文件
+func TestPickFIFOCompaction(t *testing.T) {
+	now := time.Now()
+	type file struct {
+		size uint64
+		age  time.Duration
+	}
+	tests := []struct {
+		name string
+		fifo FIFOOptions
+		// 按从旧到新添加
+		files []file
+		want  []uint64
+	}{
+		{
+			name:  "within limits",
+			fifo:  FIFOOptions{MaxTableFilesSize: 300, TTL: time.Hour},
+			files: []file{{100, 30 * time.Minute}, {100, 0}},
+		},
+		{
+			name:  "exceeds max size",
+			fifo:  FIFOOptions{MaxTableFilesSize: 250},
+			files: []file{{100, 0}, {100, 0}, {100, 0}},
+			want:  []uint64{100},
+		},
+		{
+			name:  "expired",
+			fifo:  FIFOOptions{MaxTableFilesSize: 1000, TTL: time.Hour},
+			files: []file{{10, 3 * time.Hour}, {20, 2 * time.Hour}, {30, time.Minute}},
+			want:  []uint64{10, 20},
+		},
+		{
+			name:  "expired and exceeds max size",
+			fifo:  FIFOOptions{MaxTableFilesSize: 40, TTL: time.Hour},
+			files: []file{{10, 2 * time.Hour}, {20, time.Minute}, {30, 0}},
+			want:  []uint64{10, 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := newFIFOTestManager(t, tt.fifo)
+			for _, f := range tt.files {
+				table := addMockTable(mgr, minSSTableLevel, "a", "z", f.size)
+				table.modTime = now.Add(-f.age)
+			}
+			c := mgr.pickFIFOCompaction(mgr.current, now)
+			if tt.want == nil {
+				assert.Nil(t, c)
+				return
+			}
+			if assert.NotNil(t, c) {
+				assert.True(t, c.deleteOnly)
+				sizes := make([]uint64, 0)
+				for _, table := range c.inputs {
+					sizes = append(sizes, table.Size())
+				}
+				assert.Equal(t, tt.want, sizes)
+			}
+		})
+	}
+}
+
+// TestFIFOCompaction 测试 FIFO 合并时所有文件都保留在 Level0，总大小超出上限时删除最旧的文件而不合并
+func TestFIFOCompaction(t *testing.T) {
+	mgr := newFIFOTestManager(t, DefaultFIFOOptions())
+	var files []string
+	for i := 0; i < 10; i++ {
+		if i == 1 {
+			// 每个文件大小相同，最多保留三个文件
+			mgr.opts.FIFO.MaxTableFilesSize = mgr.getLevelTables(minSSTableLevel)[0].Size() * 3
+		}
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i)})
+		level0 := mgr.getFilesByLevel(minSSTableLevel)
+		files = append(files, level0[len(level0)-1])
+	}
+	mgr.bgCompactions.Wait()
+
+	assert.Equal(t, files[7:], mgr.getFilesByLevel(minSSTableLevel))
+	for level := minSSTableLevel + 1; level <= mgr.maxLevel(); level++ {
+		assert.Empty(t, mgr.getFilesByLevel(level))
+	}
+	for _, f := range files[:7] {
+		assert.NoFileExists(t, f)
+	}
+	for i := 0; i < 10; i++ {
+		val, err := mgr.Search(kv.Key(fmt.Sprintf("key%d", i)))
+		assert.NoError(t, err)
+		if i < 7 {
+			assert.Nil(t, val, "key%d should have been dropped", i)
+		} else {
+			assert.Equal(t, []byte(fmt.Sprintf("key%d", i)), val)
+		}
+	}
+
+	stats := mgr.CompactionStats()
+	assert.Zero(t, stats.Compactions)
+	assert.Zero(t, stats.CompactionWriteBytes)
+}
+
+// TestFIFOCompactionTTL 测试恢复之后按文件的修改时间删除过期的文件
+func TestFIFOCompactionTTL(t *testing.T) {
+	fifo := FIFOOptions{MaxTableFilesSize: 1 << 20, TTL: time.Hour}
+	mgr := newFIFOTestManager(t, fifo)
+	flushTestPairs(t, mgr, []string{"old"}, []string{"new"})
+	files := mgr.getFilesByLevel(minSSTableLevel)
+	assert.Len(t, files, 2)
+	mgr.Close()
+
+	// 将较旧的文件的修改时间设置为两小时之前
+	old := files[0]
+	past := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(old, past, past))
+
+	recovered := NewSSTableManager(mgr.opts)
+	assert.NoError(t, recovered.Recover())
+	assert.True(t, recovered.isLevelNeedToBeMerged(minSSTableLevel))
+	assert.NoError(t, recovered.Compaction())
+	assert.Equal(t, files[1:], recovered.getFilesByLevel(minSSTableLevel))
+	assert.NoFileExists(t, old)
+
+	val, err := recovered.Search("old")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+	val, err = recovered.Search("new")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+// TestFIFOTTLCheck 测试没有新的写入时定期检查删除过期的文件，Close 之后检查停止
+func TestFIFOTTLCheck(t *testing.T) {
+	mgr := newFIFOTestManager(t, FIFOOptions{MaxTableFilesSize: 1 << 20, TTL: 200 * time.Millisecond})
+	flushTestPairs(t, mgr, []string{"key"})
+	files := mgr.getFilesByLevel(minSSTableLevel)
+	assert.Len(t, files, 1)
+
+	mgr.StartTTLCheck()
+	mgr.StartTTLCheck() // 重复调用不会启动新的协程
+	assert.Eventually(t, func() bool {
+		return len(mgr.getFilesByLevel(minSSTableLevel)) == 0
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.NoFileExists(t, files[0])
+
+	val, err := mgr.Search("key")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	mgr.Close()
+	mgr.Close()
+}
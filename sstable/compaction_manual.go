@@ -0,0 +1,148 @@
+package sstable
+
+import (
+	"fmt"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+// CompactRangeOptions 是手动合并的参数
+type CompactRangeOptions struct {
+	// TargetLevel 是合并的目标层级，为 0 时合并到最后一层。只对 leveled 合并生效
+	TargetLevel int
+	// Exclusive 为 true 时等待正在进行的自动合并完成之后开始，手动合并期间不运行自动合并
+	Exclusive bool
+}
+
+// CompactRange 手动合并 [begin, end] 范围内的 SSTable，begin 或 end 为 nil 时该方向不设边界。
+// leveled 合并从 Level0 开始，将每一层与范围重叠的文件逐层合并到下一层，直到目标层级，
+// 合并到最后一层时删除标记和被覆盖的旧版本随之丢弃；
+// universal 合并忽略范围和目标层级，将所有有序段合并到最后一层；FIFO 合并只删除过期或超出总大小上限的文件。
+// 合并完成之后如果有层级超出目标大小，启动异步合并
+func (m *Manager) CompactRange(begin, end *kv.Key, opts CompactRangeOptions) error {
+	target := opts.TargetLevel
+	if target == 0 {
+		target = m.maxLevel()
+	}
+	if target < minSSTableLevel+1 || target > m.maxLevel() {
+		log.Errorf("invalid compact range target level %d, available: 1-%d", opts.TargetLevel, m.maxLevel())
+		return fmt.Errorf("invalid target level %d, available: 1-%d", opts.TargetLevel, m.maxLevel())
+	}
+	if begin != nil && end != nil && *begin > *end {
+		return fmt.Errorf("invalid compact range: begin %q is greater than end %q", *begin, *end)
+	}
+
+	if err := m.compactRange(begin, end, target, opts.Exclusive); err != nil {
+		log.Errorf("compact range error: %s", err.Error())
+		return fmt.Errorf("compact range error: %w", err)
+	}
+
+	// 手动合并之后下面的层级可能超出目标大小
+	if _, ok := m.pickCompactionLevel(); ok {
+		m.scheduleAsyncCompaction()
+	}
+	return nil
+}
+
+// compactRange 按合并方式执行手动合并，exclusive 为 true 时合并期间暂停自动合并
+func (m *Manager) compactRange(begin, end *kv.Key, target int, exclusive bool) error {
+	if exclusive {
+		m.beginExclusiveManual()
+		defer m.endExclusiveManual()
+	}
+
+	if m.opts.CompactionStyle != CompactionStyleLevel {
+		return m.compactLevel(minSSTableLevel)
+	}
+	for level := minSSTableLevel; level < target; level++ {
+		if err := m.compactRangeLevel(level, level+1, begin, end); err != nil {
+			return fmt.Errorf("compact level %d error: %w", level, err)
+		}
+	}
+	return nil
+}
+
+// compactRangeLevel 将 level 层与 [begin, end] 重叠的文件合并到 outputLevel 层
+func (m *Manager) compactRangeLevel(level, outputLevel int, begin, end *kv.Key) error {
+	levels := m.reserveCompaction(func(*Version) []int {
+		return []int{level, outputLevel}
+	}, true)
+	defer m.endCompaction(levels...)
+
+	v := m.currentVersion()
+	defer v.Unref()
+
+	c := pickRangeCompaction(v, level, outputLevel, begin, end)
+	if c == nil {
+		return nil
+	}
+	return m.doCompaction(c)
+}
+
+// pickRangeCompaction 选出版本 v 中 level 层与 [begin, end] 重叠的文件，以及 outputLevel 层中与之重叠的文件。
+// Level0 的文件之间可能重叠，不断扩大范围直到没有其他 Level0 文件与选出的文件重叠，
+// 避免较新的文件合并到下一层之后，与之重叠的较旧的文件留在它之上
+func pickRangeCompaction(v *Version, level, outputLevel int, begin, end *kv.Key) *compaction {
+	inputs := make([]*SSTable, 0)
+	for _, sst := range v.files(level) {
+		if overlapBounds(sst, begin, end) {
+			inputs = append(inputs, sst)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	if level == minSSTableLevel {
+		for {
+			minKey, maxKey := getGlobalKeyRange(inputs)
+			expanded := overlappingFiles(v, level, minKey, maxKey)
+			if len(expanded) <= len(inputs) {
+				break
+			}
+			inputs = expanded
+		}
+	}
+
+	minKey, maxKey := getGlobalKeyRange(inputs)
+	return &compaction{
+		level:       level,
+		outputLevel: outputLevel,
+		inputs:      inputs,
+		nextInputs:  overlappingFiles(v, outputLevel, minKey, maxKey),
+	}
+}
+
+// overlapBounds 判断 sst 的 key 范围是否与 [begin, end] 有交集，begin 或 end 为 nil 时该方向不设边界
+func overlapBounds(sst *SSTable, begin, end *kv.Key) bool {
+	return (begin == nil || sst.Header.MaxKey >= *begin) && (end == nil || sst.Header.MinKey <= *end)
+}
+
+// beginExclusiveManual 等待正在进行的合并全部完成，然后标记独占的手动合并开始
+func (m *Manager) beginExclusiveManual() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.exclusiveManual || len(m.compactingLevels) > 0 {
+		m.compactionCond.Wait()
+	}
+	m.exclusiveManual = true
+}
+
+// endExclusiveManual 标记独占的手动合并结束并广播通知
+func (m *Manager) endExclusiveManual() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.exclusiveManual = false
+	m.compactionCond.Broadcast()
+}
+
+// IsExclusiveManual 返回是否有独占的手动合并正在进行，期间自动合并不会执行
+func (m *Manager) IsExclusiveManual() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.exclusiveManual
+}
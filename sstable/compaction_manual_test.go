@@ -0,0 +1,113 @@
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// TestPickRangeCompaction 测试选出与范围重叠的文件，Level0 中与选出的文件重叠的文件一并参与合并
+func TestPickRangeCompaction(t *testing.T) {
+	mgr := newTestManager(t)
+	a := addMockTable(mgr, minSSTableLevel, "a", "c", 10)
+	b := addMockTable(mgr, minSSTableLevel, "c", "f", 10)
+	addMockTable(mgr, minSSTableLevel, "x", "z", 10)
+	d := addMockTable(mgr, 1, "a", "b", 100)
+	addMockTable(mgr, 1, "m", "n", 100)
+	g := addMockTable(mgr, 2, "d", "k", 1000)
+
+	begin, end := kv.Key("a"), kv.Key("b")
+	c := pickRangeCompaction(mgr.current, minSSTableLevel, 1, &begin, &end)
+	if assert.NotNil(t, c) {
+		assert.Equal(t, []*SSTable{a, b}, c.inputs)
+		assert.Equal(t, []*SSTable{d}, c.nextInputs)
+	}
+
+	begin = "e"
+	c = pickRangeCompaction(mgr.current, 1, 2, &begin, nil)
+	if assert.NotNil(t, c) {
+		assert.Len(t, c.inputs, 1)
+		assert.Equal(t, kv.Key("m"), c.inputs[0].Header.MinKey)
+		assert.Empty(t, c.nextInputs)
+	}
+	c = pickRangeCompaction(mgr.current, 1, 2, nil, nil)
+	if assert.NotNil(t, c) {
+		assert.Len(t, c.inputs, 2)
+		assert.Equal(t, []*SSTable{g}, c.nextInputs)
+	}
+
+	begin, end = "o", "w"
+	assert.Nil(t, pickRangeCompaction(mgr.current, 1, 2, &begin, &end))
+}
+
+// TestCompactRange 测试手动合并把范围内的文件逐层合并到目标层级，且数据完整
+func TestCompactRange(t *testing.T) {
+	mgr := newTestManager(t)
+	for i := 0; i < mgr.opts.Level0CompactionTrigger-1; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("a%d", i), fmt.Sprintf("z%d", i)})
+	}
+	assert.Len(t, mgr.getFilesByLevel(minSSTableLevel), mgr.opts.Level0CompactionTrigger-1)
+
+	// 合并到指定层级
+	assert.NoError(t, mgr.CompactRange(nil, nil, CompactRangeOptions{TargetLevel: 2}))
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+	assert.Empty(t, mgr.getFilesByLevel(1))
+	assert.NotEmpty(t, mgr.getFilesByLevel(2))
+
+	// 默认合并到最后一层，范围之外的文件不参与合并
+	flushTestPairs(t, mgr, []string{"m0"})
+	begin, end := kv.Key("a"), kv.Key("b")
+	assert.NoError(t, mgr.CompactRange(&begin, &end, CompactRangeOptions{Exclusive: true}))
+	assert.Len(t, mgr.getFilesByLevel(minSSTableLevel), 1)
+	assert.Empty(t, mgr.getFilesByLevel(2))
+	assert.NotEmpty(t, mgr.getFilesByLevel(mgr.maxLevel()))
+	mgr.bgCompactions.Wait()
+
+	for _, key := range []string{"a0", "a1", "a2", "z0", "z1", "z2", "m0"} {
+		val, err := mgr.Search(kv.Key(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+
+	assert.Error(t, mgr.CompactRange(nil, nil, CompactRangeOptions{TargetLevel: mgr.opts.NumLevels}))
+	assert.Error(t, mgr.CompactRange(&end, &begin, CompactRangeOptions{}))
+}
+
+// TestExclusiveManualCompaction 测试独占的手动合并期间不运行自动合并，结束之后自动合并恢复
+func TestExclusiveManualCompaction(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.beginExclusiveManual()
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i)})
+	}
+	assert.Len(t, mgr.getFilesByLevel(minSSTableLevel), mgr.opts.Level0CompactionTrigger)
+	assert.True(t, mgr.isLevelNeedToBeMerged(minSSTableLevel))
+
+	// 手动合并不受独占标记影响
+	assert.NoError(t, mgr.compactLevel(minSSTableLevel))
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i)})
+	}
+	mgr.endExclusiveManual()
+	assert.NoError(t, mgr.Compaction())
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+}
+
+// TestCompactRangeUniversal 测试 universal 合并的手动合并把所有有序段合并到最后一层
+func TestCompactRangeUniversal(t *testing.T) {
+	mgr := newUniversalTestManager(t, 4)
+	flushTestPairs(t, mgr, []string{"a", "b"}, []string{"b", "c"})
+	assert.Len(t, mgr.current.sortedRuns(), 2)
+
+	begin := kv.Key("x")
+	assert.NoError(t, mgr.CompactRange(&begin, nil, CompactRangeOptions{}))
+	runs := mgr.current.sortedRuns()
+	if assert.Len(t, runs, 1) {
+		assert.Equal(t, mgr.maxLevel(), runs[0].level)
+	}
+}
@@ -0,0 +1,228 @@
+package sstable
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// CompactionPri 是 Level1 及以上的层级选择参与合并的文件的方式
+type CompactionPri uint8
+
+const (
+	// CompactByRoundRobin 按 key 的顺序轮流选择文件，每次从上一次合并的文件之后开始
+	CompactByRoundRobin CompactionPri = 0
+	// CompactByMinOverlappingRatio 选择与下一层级重叠的数据量相对自身大小最小的文件，写放大最小
+	CompactByMinOverlappingRatio CompactionPri = 1
+)
+
+var compactionPriNames = map[CompactionPri]string{
+	CompactByRoundRobin:          "round_robin",
+	CompactByMinOverlappingRatio: "min_overlapping_ratio",
+}
+
+// ParseCompactionPri 根据名称返回文件选择方式，名称不区分大小写
+func ParseCompactionPri(name string) (CompactionPri, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for pri, priName := range compactionPriNames {
+		if priName == name {
+			return pri, nil
+		}
+	}
+	return CompactByRoundRobin, fmt.Errorf("unknown compaction pri %q, available: %s, %s",
+		name, CompactByRoundRobin, CompactByMinOverlappingRatio)
+}
+
+// String 返回文件选择方式的名称
+func (p CompactionPri) String() string {
+	if name, ok := compactionPriNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("compaction_pri(%d)", uint8(p))
+}
+
+// compaction 是一次合并的输入：leveled 合并为 level 层中选出的文件以及 outputLevel 层中与之重叠的文件，
+// universal 合并为连续的若干个有序段，FIFO 合并为需要删除的 Level0 文件
+type compaction struct {
+	level       int
+	outputLevel int
+	inputs      []*SSTable  // level 层参与合并的文件，按 id 升序
+	nextInputs  []*SSTable  // outputLevel 层中与 inputs 重叠的文件
+	runs        []sortedRun // universal 合并的有序段，按从新到旧排列
+	deleteOnly  bool        // 为 true 时直接删除 inputs，不生成新文件
+}
+
+// tables 返回参与合并的所有文件，按数据从新到旧排列
+func (c *compaction) tables() []*SSTable {
+	if len(c.runs) == 0 {
+		return newestFirst(c.inputs, c.nextInputs)
+	}
+	tables := make([]*SSTable, 0)
+	for _, run := range c.runs {
+		tables = append(tables, run.tables...)
+	}
+	return tables
+}
+
+// levelTargets 返回版本 v 中 Level1 及以上各层级的目标大小（字节）和基准层级，基准层级之上的层级目标大小为 0
+func (m *Manager) levelTargets(v *Version) ([]uint64, int) {
+	multiplier := uint64(m.opts.LevelMultiplier)
+	targets := make([]uint64, len(v.levels))
+	if !m.opts.DynamicLevelBytes {
+		targets[minSSTableLevel+1] = m.opts.MaxBytesForLevelBase
+		for level := minSSTableLevel + 2; level <= m.maxLevel(); level++ {
+			targets[level] = targets[level-1] * multiplier
+		}
+		return targets, minSSTableLevel + 1
+	}
+
+	// 最后一层的目标大小是其实际大小，向上逐层除以倍数，直到目标大小不超过 MaxBytesForLevelBase
+	var maxSize uint64
+	for level := minSSTableLevel + 1; level <= m.maxLevel(); level++ {
+		maxSize = max(maxSize, v.levelSize(level))
+	}
+	baseLevel := m.maxLevel()
+	targets[baseLevel] = max(maxSize, m.opts.MaxBytesForLevelBase)
+	for baseLevel > minSSTableLevel+1 && targets[baseLevel] > m.opts.MaxBytesForLevelBase {
+		targets[baseLevel-1] = targets[baseLevel] / multiplier
+		baseLevel--
+	}
+
+	// 基准层级不能比已有数据的最浅层级更深，否则 Level0 合并的较新数据会被放到较旧的数据之下
+	for level := minSSTableLevel + 1; level < baseLevel; level++ {
+		if len(v.levels[level]) == 0 {
+			continue
+		}
+		for l := baseLevel - 1; l >= level; l-- {
+			targets[l] = max(targets[l+1]/multiplier, 1)
+		}
+		baseLevel = level
+		break
+	}
+	return targets, baseLevel
+}
+
+// compactionScores 返回版本 v 中各层级的合并分数，分数不小于 1 的层级需要合并。
+// Level0 的分数是文件数量与 Level0CompactionTrigger 之比，其余层级是实际大小与目标大小之比，最后一层总是 0。
+// universal 合并只有 Level0 的分数，为有序段数量与 Level0CompactionTrigger 之比；
+// FIFO 合并也只有 Level0 的分数，有需要删除的文件时为 1
+func (m *Manager) compactionScores(v *Version) []float64 {
+	scores := make([]float64, len(v.levels))
+	switch m.opts.CompactionStyle {
+	case CompactionStyleUniversal:
+		scores[minSSTableLevel] = m.universalScore(v)
+		return scores
+	case CompactionStyleFIFO:
+		scores[minSSTableLevel] = m.fifoScore(v)
+		return scores
+	}
+
+	targets, _ := m.levelTargets(v)
+	scores[minSSTableLevel] = float64(len(v.levels[minSSTableLevel])) / float64(m.opts.Level0CompactionTrigger)
+	for level := minSSTableLevel + 1; level < m.maxLevel(); level++ {
+		// 基准层级之上的层级总是为空，有数据的层级目标大小都大于 0
+		if size := v.levelSize(level); size > 0 {
+			scores[level] = float64(size) / float64(targets[level])
+		}
+	}
+	return scores
+}
+
+// isLevelNeedToBeMerged 检查层级的合并分数是否达到 1
+func (m *Manager) isLevelNeedToBeMerged(level int) bool {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	return m.compactionScores(v)[level] >= 1
+}
+
+// pickCompactionLevel 返回当前版本中合并分数最高且不小于 1 的层级
+func (m *Manager) pickCompactionLevel() (int, bool) {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	best, bestScore := -1, 1.0
+	for level, score := range m.compactionScores(v) {
+		if score >= bestScore {
+			best, bestScore = level, score
+		}
+	}
+	return best, best >= 0
+}
+
+// outputLevel 返回 level 层合并输出的层级：Level0 合并到基准层级，最后一层在层内合并，其余层级合并到下一层
+func (m *Manager) outputLevel(v *Version, level int) int {
+	if level == minSSTableLevel {
+		_, baseLevel := m.levelTargets(v)
+		return baseLevel
+	}
+	return min(level+1, m.maxLevel())
+}
+
+// pickCompaction 在版本 v 中选出 level 层合并到 outputLevel 层的输入文件，level 层为空时返回 nil。
+// Level0 的文件之间可能重叠，全部参与合并；其余层级按 CompactionPri 选出一个文件
+func (m *Manager) pickCompaction(v *Version, level, outputLevel int) *compaction {
+	var inputs []*SSTable
+	if level == minSSTableLevel {
+		inputs = v.files(level)
+	} else if file := m.pickFile(v, level, outputLevel); file != nil {
+		inputs = []*SSTable{file}
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	c := &compaction{level: level, outputLevel: outputLevel, inputs: inputs}
+	if outputLevel != level {
+		minK, maxK := getGlobalKeyRange(inputs)
+		c.nextInputs = overlappingFiles(v, outputLevel, minK, maxK)
+	}
+	return c
+}
+
+// pickFile 按 CompactionPri 选出 level 层中参与合并的一个文件
+func (m *Manager) pickFile(v *Version, level, outputLevel int) *SSTable {
+	files := v.sparseIndexes[level-1] // 按 MinKey 升序
+	if len(files) == 0 {
+		return nil
+	}
+
+	if m.opts.CompactionPri == CompactByMinOverlappingRatio && outputLevel != level {
+		var best *SSTable
+		bestRatio := math.MaxFloat64
+		for _, file := range files {
+			var overlap uint64
+			for _, next := range overlappingFiles(v, outputLevel, file.Header.MinKey, file.Header.MaxKey) {
+				overlap += next.Size()
+			}
+			ratio := float64(overlap) / float64(max(file.Size(), 1))
+			if ratio < bestRatio {
+				best, bestRatio = file, ratio
+			}
+		}
+		return best
+	}
+
+	// 从上一次合并的文件之后开始，到达末尾之后回到第一个文件
+	m.mu.RLock()
+	pointer, ok := m.compactPointers[level]
+	m.mu.RUnlock()
+	if ok {
+		for _, file := range files {
+			if file.Header.MinKey > pointer {
+				return file
+			}
+		}
+	}
+	return files[0]
+}
+
+// setCompactPointer 记录 level 层上一次合并的文件的最大 key
+func (m *Manager) setCompactPointer(level int, key kv.Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compactPointers[level] = key
+}
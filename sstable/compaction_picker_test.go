@@ -0,0 +1,143 @@
+package sstable
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/sstable/block"
+)
+
+// addMockTable 向 mgr 的当前版本中添加一个不对应实际文件的 SSTable
+func addMockTable(mgr *Manager, level int, minKey, maxKey kv.Key, size uint64) *SSTable {
+	sst := mgr.newTable(level)
+	sst.filePath = filepath.Join("mock", fmt.Sprintf("%d.sst", sst.id))
+	sst.Header = block.NewHeader(minKey, maxKey)
+	sst.size = size
+	mgr.addTable(sst)
+	return sst
+}
+
+// TestLevelTargetsDynamic 测试按最后一层的实际大小推算各层级的目标大小和基准层级
+func TestLevelTargetsDynamic(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.NumLevels = 5
+	opts.LevelMultiplier = 10
+	opts.MaxBytesForLevelBase = 1000
+	opts.DynamicLevelBytes = true
+	mgr := NewSSTableManager(opts)
+
+	// 没有数据时 Level0 直接合并到最后一层
+	targets, baseLevel := mgr.levelTargets(mgr.current)
+	assert.Equal(t, []uint64{0, 0, 0, 0, 1000}, targets)
+	assert.Equal(t, 4, baseLevel)
+
+	addMockTable(mgr, 4, "a", "z", 50000)
+	targets, baseLevel = mgr.levelTargets(mgr.current)
+	assert.Equal(t, []uint64{0, 0, 500, 5000, 50000}, targets)
+	assert.Equal(t, 2, baseLevel)
+
+	// 基准层级不能比已有数据的层级更深
+	addMockTable(mgr, 1, "a", "b", 10)
+	targets, baseLevel = mgr.levelTargets(mgr.current)
+	assert.Equal(t, []uint64{0, 50, 500, 5000, 50000}, targets)
+	assert.Equal(t, 1, baseLevel)
+	assert.Equal(t, 1, mgr.outputLevel(mgr.current, minSSTableLevel))
+}
+
+// TestCompactionScores 测试各层级的合并分数以及分数最高的层级
+func TestCompactionScores(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.NumLevels = 4
+	opts.MaxBytesForLevelBase = 1000
+	mgr := NewSSTableManager(opts)
+
+	_, ok := mgr.pickCompactionLevel()
+	assert.False(t, ok)
+
+	for i := 0; i < 2; i++ {
+		addMockTable(mgr, minSSTableLevel, "a", "z", 1)
+	}
+	addMockTable(mgr, 1, "a", "m", 1500)
+	addMockTable(mgr, 2, "a", "m", 30000)
+	addMockTable(mgr, 3, "a", "m", 1<<40)
+	scores := mgr.compactionScores(mgr.current)
+	assert.Equal(t, []float64{0.5, 1.5, 3, 0}, scores)
+
+	level, ok := mgr.pickCompactionLevel()
+	assert.True(t, ok)
+	assert.Equal(t, 2, level)
+}
+
+// TestPickFileRoundRobin 测试按轮转方式依次选择层级中的文件
+func TestPickFileRoundRobin(t *testing.T) {
+	mgr := newTestManager(t)
+	a := addMockTable(mgr, 1, "a", "c", 100)
+	c := addMockTable(mgr, 1, "m", "p", 100)
+	b := addMockTable(mgr, 1, "d", "f", 100)
+	next := addMockTable(mgr, 2, "b", "e", 100)
+
+	for _, want := range []*SSTable{a, b, c, a} {
+		compaction := mgr.pickCompaction(mgr.current, 1, 2)
+		assert.Equal(t, []*SSTable{want}, compaction.inputs)
+		mgr.setCompactPointer(1, want.Header.MaxKey)
+	}
+
+	// 下一层级中与选中文件重叠的文件一并参与合并
+	compaction := mgr.pickCompaction(mgr.current, 1, 2)
+	assert.Equal(t, []*SSTable{b}, compaction.inputs)
+	assert.Equal(t, []*SSTable{next}, compaction.nextInputs)
+}
+
+// TestPickFileMinOverlappingRatio 测试选择与下一层级重叠的数据量相对自身大小最小的文件
+func TestPickFileMinOverlappingRatio(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.CompactionPri = CompactByMinOverlappingRatio
+	mgr := NewSSTableManager(opts)
+	addMockTable(mgr, 1, "a", "c", 100)
+	small := addMockTable(mgr, 1, "m", "p", 100)
+	addMockTable(mgr, 2, "a", "b", 1000)
+	addMockTable(mgr, 2, "n", "o", 50)
+
+	compaction := mgr.pickCompaction(mgr.current, 1, 2)
+	assert.Equal(t, []*SSTable{small}, compaction.inputs)
+	assert.Len(t, compaction.nextInputs, 1)
+}
+
+// TestDynamicLevelBytesCompaction 测试开启动态层级大小时 Level0 合并到基准层级
+func TestDynamicLevelBytesCompaction(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.DynamicLevelBytes = true
+	mgr := NewSSTableManager(opts)
+
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i)})
+	}
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+	assert.Empty(t, mgr.getFilesByLevel(1))
+	assert.NotEmpty(t, mgr.getFilesByLevel(mgr.maxLevel()))
+
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		val, err := mgr.Search(kv.Key(fmt.Sprintf("key%d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("key%d", i)), val)
+	}
+}
+
+func TestParseCompactionPri(t *testing.T) {
+	for _, pri := range []CompactionPri{CompactByRoundRobin, CompactByMinOverlappingRatio} {
+		parsed, err := ParseCompactionPri(" " + pri.String() + " ")
+		assert.NoError(t, err)
+		assert.Equal(t, pri, parsed)
+	}
+	parsed, err := ParseCompactionPri("MIN_OVERLAPPING_RATIO")
+	assert.NoError(t, err)
+	assert.Equal(t, CompactByMinOverlappingRatio, parsed)
+
+	_, err = ParseCompactionPri("oldest")
+	assert.Error(t, err)
+	assert.Equal(t, "compaction_pri(9)", CompactionPri(9).String())
+}
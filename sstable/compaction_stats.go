@@ -0,0 +1,75 @@
+package sstable
+
+import "sync/atomic"
+
+// CompactionStats 是刷盘和合并写入的数据量，以及当前版本中 SSTable 占用的空间，用于比较不同合并方式的写放大和空间放大
+type CompactionStats struct {
+	FlushBytes           uint64 // 刷盘写入 Level0 的字节数
+	CompactionReadBytes  uint64 // 合并读取的输入文件字节数
+	CompactionWriteBytes uint64 // 合并写入的输出文件字节数
+	Compactions          uint64 // 完成的合并次数
+	LiveBytes            uint64 // 当前版本中所有 SSTable 的总大小
+	LastRunBytes         uint64 // 当前版本中最旧的有序段的大小
+}
+
+// WriteAmplification 返回写放大，即刷盘和合并写入的总字节数与刷盘写入的字节数之比，没有刷盘时返回 0
+func (s CompactionStats) WriteAmplification() float64 {
+	if s.FlushBytes == 0 {
+		return 0
+	}
+	return float64(s.FlushBytes+s.CompactionWriteBytes) / float64(s.FlushBytes)
+}
+
+// SpaceAmplification 返回空间放大，即所有 SSTable 的总大小与最旧的有序段大小之比，没有数据时返回 0。
+// 最旧的有序段中没有被覆盖的旧版本，可以近似看作数据的实际大小
+func (s CompactionStats) SpaceAmplification() float64 {
+	if s.LastRunBytes == 0 {
+		return 0
+	}
+	return float64(s.LiveBytes) / float64(s.LastRunBytes)
+}
+
+// compactionStats 是 Manager 的刷盘和合并写入统计计数器
+type compactionStats struct {
+	flushBytes  atomic.Uint64
+	readBytes   atomic.Uint64
+	writeBytes  atomic.Uint64
+	compactions atomic.Uint64
+}
+
+// recordFlush 记录一次刷盘生成的 SSTable
+func (s *compactionStats) recordFlush(table *SSTable) {
+	s.flushBytes.Add(table.Size())
+}
+
+// recordCompaction 记录一次合并的输入和输出文件
+func (s *compactionStats) recordCompaction(inputs, outputs []*SSTable) {
+	for _, table := range inputs {
+		s.readBytes.Add(table.Size())
+	}
+	for _, table := range outputs {
+		s.writeBytes.Add(table.Size())
+	}
+	s.compactions.Add(1)
+}
+
+// CompactionStats 返回刷盘和合并写入的数据量以及当前版本占用的空间，可以据此计算写放大和空间放大
+func (m *Manager) CompactionStats() CompactionStats {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	stats := CompactionStats{
+		FlushBytes:           m.compactionStats.flushBytes.Load(),
+		CompactionReadBytes:  m.compactionStats.readBytes.Load(),
+		CompactionWriteBytes: m.compactionStats.writeBytes.Load(),
+		Compactions:          m.compactionStats.compactions.Load(),
+	}
+	runs := v.sortedRuns()
+	for _, run := range runs {
+		stats.LiveBytes += run.size
+	}
+	if len(runs) > 0 {
+		stats.LastRunBytes = runs[len(runs)-1].size
+	}
+	return stats
+}
@@ -2,10 +2,9 @@ package sstable
 
 import (
 	"fmt"
+	"os"
 	"strconv"
-	"sync"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -15,7 +14,7 @@ import (
 )
 
 func TestSSTableManagerCompaction(t *testing.T) {
-	mgr := NewSSTableManager()
+	mgr := newTestManager(t)
 	tmp := t.TempDir()
 
 	// 1. 创建 Level0 文件
@@ -28,7 +27,7 @@ func TestSSTableManagerCompaction(t *testing.T) {
 		assert.NoError(t, err)
 
 		imem := memtable.NewIMemTable(mem)
-		sst := BuildSSTableFromIMemTable(imem)
+		sst := mgr.BuildSSTableFromIMemTable(imem)
 		sst.level = minSSTableLevel
 
 		oldFiles = append(oldFiles, sst.FilePath())
@@ -49,10 +48,10 @@ func TestSSTableManagerCompaction(t *testing.T) {
 	}
 
 	// 4. 验证 Level0 清空
-	assert.Empty(t, mgr.totalMap[0], "Level0 totalMap not empty")
+	assert.Empty(t, mgr.getFilesByLevel(0), "Level0 not empty")
 
 	// 5. 验证 Level1 有新文件
-	level1Files := mgr.totalMap[1]
+	level1Files := mgr.getFilesByLevel(1)
 	assert.True(t, len(level1Files) > 0, "no Level1 files generated")
 
 	// 6. 验证 Level1 文件内容
@@ -60,121 +59,199 @@ func TestSSTableManagerCompaction(t *testing.T) {
 		sst := NewSSTable()
 		err := sst.DecodeFrom(f)
 		assert.NoError(t, err, "decode Level1 SSTable failed: %s", f)
-		assert.NotEmpty(t, sst.DataBlock, "Level1 SSTable has empty DataBlocks: %s", f)
+		pairs, err := sst.GetKeyValuePairs()
+		assert.NoError(t, err, "read Level1 SSTable failed: %s", f)
+		assert.NotEmpty(t, pairs, "Level1 SSTable has empty DataBlocks: %s", f)
 	}
 }
 
+// TestAsyncCompaction 测试异步合并每次选择分数最高的层级，直到所有层级都不超出目标大小
 func TestAsyncCompaction(t *testing.T) {
-	mgr := NewSSTableManager()
+	opts := DefaultOptions(t.TempDir())
+	opts.MaxBytesForLevelBase = 1024
+	mgr := NewSSTableManager(opts)
 
-	// 1. 创建 Level1 文件（超过阈值）
+	// 1. 创建超出目标大小的 Level1 文件
 	var level1Files []string
 	var tables []*SSTable
-	for i := 0; i < maxFileNumsInLevel(1)+1; i++ {
-		sst := NewSSTableWithLevel(1)
+	for i := 0; i < 8; i++ {
+		sst := mgr.newTable(1)
 		// 添加一些测试数据
 		for j := 0; j < 10; j++ {
-			key := "key" + strconv.Itoa(i*10+j)
-			value := "value" + strconv.Itoa(i*10+j)
+			key := fmt.Sprintf("key%02d", i*10+j)
 			sst.Add(&kv.KeyValuePair{
 				Key:   kv.Key(key),
-				Value: []byte(value),
+				Value: []byte("value" + key),
 			})
 		}
-		sst.Header = block.NewHeader("key0", kv.Key("key"+strconv.Itoa(i*10+9)))
+		sst.Header = block.NewHeader(kv.Key(fmt.Sprintf("key%02d", i*10)), kv.Key(fmt.Sprintf("key%02d", i*10+9)))
 		tables = append(tables, sst)
 		level1Files = append(level1Files, sst.FilePath())
 	}
 	err := mgr.addNewSSTables(tables)
 	assert.NoError(t, err, "add new SSTable failed")
+	assert.True(t, mgr.isLevelNeedToBeMerged(1))
 
-	// 2. 触发异步合并
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		mgr.asyncCompactLevel(1)
-	}()
-	wg.Wait()
-
-	// 3. 等待合并完成或超时
-	timeout := time.After(30 * time.Second)
-	select {
-	case <-timeout:
-		t.Fatal("async compaction timeout")
-	default:
-		// 检查合并是否完成：Level1 文件数减少或 Level2 出现新文件
-		if len(mgr.getFilesByLevel(1)) < len(level1Files) || len(mgr.getFilesByLevel(2)) > 0 {
-			break
+	// 2. 触发异步合并并等待合并完成
+	mgr.scheduleAsyncCompaction()
+	mgr.bgCompactions.Wait()
+
+	// 3. 所有层级都不再超出目标大小，被合并的旧文件已删除
+	for level := minSSTableLevel; level < mgr.maxLevel(); level++ {
+		assert.False(t, mgr.isLevelNeedToBeMerged(level), "level %d still needs compaction", level)
+	}
+	assert.Less(t, len(mgr.getFilesByLevel(1)), len(level1Files))
+	assert.NotEmpty(t, mgr.getFilesByLevel(2))
+	removed := 0
+	for _, f := range level1Files {
+		if _, err := os.Stat(f); os.IsNotExist(err) {
+			removed++
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
+	assert.Equal(t, len(level1Files)-len(mgr.getFilesByLevel(1)), removed)
+
+	// 4. 数据完整
+	for i := 0; i < 80; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		val, err := mgr.Search(kv.Key(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"+key), val)
+	}
+}
 
-	// 4. 验证旧文件被删除
-	for _, f := range level1Files[:maxFileNumsInLevel(1)] {
-		assert.NoFileExists(t, f, "old Level1 file still exists: %s", f)
+// TestManagerCloseStopsAsyncCompaction 测试关闭之后不再启动异步合并
+func TestManagerCloseStopsAsyncCompaction(t *testing.T) {
+	mgr := newTestManager(t)
+	mgr.Close()
+
+	for i := 0; i < 2; i++ {
+		sst := mgr.newTable(1)
+		sst.filePath = fmt.Sprintf("%d.sst", i)
+		sst.size = mgr.opts.MaxBytesForLevelBase
+		mgr.addTable(sst)
 	}
+	assert.True(t, mgr.isLevelNeedToBeMerged(1))
+	level1Files := mgr.getFilesByLevel(1)
+	mgr.scheduleAsyncCompaction()
+	mgr.bgCompactions.Wait()
+	assert.Equal(t, level1Files, mgr.getFilesByLevel(1))
 
-	// 5. 验证新文件生成
-	assert.True(t, len(mgr.getFilesByLevel(1)) > 0 || len(mgr.getFilesByLevel(2)) > 0)
+	// 重复关闭是安全的
+	mgr.Close()
 }
 
 func TestCompactionOnEmptyLevel0(t *testing.T) {
-	mgr := NewSSTableManager()
+	mgr := newTestManager(t)
 
 	// 不添加任何 Level0 文件，直接压缩
 	err := mgr.Compaction()
 	assert.NoError(t, err, "compaction on empty level0 should not fail")
 
 	// 确认 Level0 和 Level1 都是空的
-	assert.Empty(t, mgr.totalMap[0])
-	assert.Empty(t, mgr.totalMap[1])
+	assert.Empty(t, mgr.getFilesByLevel(0))
+	assert.Empty(t, mgr.getFilesByLevel(1))
 }
 
 func TestCompactLevelWithNoFiles(t *testing.T) {
-	mgr := NewSSTableManager()
+	mgr := newTestManager(t)
 
 	err := mgr.compactLevel(minSSTableLevel) // Level0
 	assert.NoError(t, err, "compacting empty level should not error")
 }
 
 func TestCompactionWithInvalidSSTable(t *testing.T) {
-	mgr := NewSSTableManager()
+	mgr := newTestManager(t)
 
-	// 模拟一个无效的文件路径
-	mgr.totalMap[minSSTableLevel] = []string{"1.sst", "2.sst", "3.sst"}
+	// 模拟无效的文件路径
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		sst := mgr.newTable(minSSTableLevel)
+		sst.filePath = fmt.Sprintf("%d.sst", i)
+		mgr.addTable(sst)
+	}
 
 	err := mgr.Compaction()
 	assert.Error(t, err, "compaction should fail on invalid SSTable file")
 }
 
+// TestRecursiveCompactionAcrossLevels 测试 Level0 合并之后 Level1 超出目标大小时继续合并到 Level2
 func TestRecursiveCompactionAcrossLevels(t *testing.T) {
-	mgr := NewSSTableManager()
+	opts := DefaultOptions(t.TempDir())
+	opts.MaxBytesForLevelBase = 256
+	mgr := NewSSTableManager(opts)
 
 	// 构造 Level0 -> Level1 -> Level2 的合并路径
-	for i := 0; i < 4; i++ {
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
 		mem := memtable.NewMemTable(uint64(i+1), t.TempDir())
 		_ = mem.Insert(kv.KeyValuePair{
 			Key:   kv.Key(fmt.Sprintf("key-%d", i)),
 			Value: []byte("val"),
 		})
 		imem := memtable.NewIMemTable(mem)
-		sst := BuildSSTableFromIMemTable(imem)
+		sst := mgr.BuildSSTableFromIMemTable(imem)
 		sst.level = minSSTableLevel
 		_ = mgr.addNewSSTables([]*SSTable{sst})
 	}
 
-	// 伪造更多 Level1 文件以触发下一层压缩
-	for i := 0; i < maxFileNumsInLevel(1); i++ {
-		sst := NewSSTableWithLevel(1)
-		sst.Header = block.NewHeader("keyA", "keyZ")
-		sst.Add(&kv.KeyValuePair{Key: "keyA", Value: []byte("valueA")})
-		sst.Add(&kv.KeyValuePair{Key: "keyZ", Value: []byte("valueZ")})
-		_ = mgr.addNewSSTables([]*SSTable{sst})
-	}
-
-	// 压缩，应触发 Level 0 -> Level1 -> Level2
+	// Level0 合并之后 Level1 超出目标大小，异步合并到 Level2
 	err := mgr.Compaction()
 	assert.NoError(t, err)
+	mgr.bgCompactions.Wait()
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+	assert.False(t, mgr.isLevelNeedToBeMerged(1))
 	assert.True(t, len(mgr.getFilesByLevel(2)) > 0, "should generate Level2 SSTables")
 }
+
+// TestCompactionStreamsToMultipleTables 测试合并输出超过单个 SSTable 大小时拆分为多个文件，且合并之后数据完整
+func TestCompactionStreamsToMultipleTables(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.TableSize = 256
+	mgr := NewSSTableManager(opts)
+
+	// 写满 Level0 并触发合并
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		keys := make([]string, 0)
+		for j := 0; j < 20; j++ {
+			keys = append(keys, fmt.Sprintf("key-%02d-%02d", j, i))
+		}
+		flushTestPairs(t, mgr, keys)
+	}
+	mgr.Close() // 等待后续层级的异步合并完成
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+
+	tables := make([]*SSTable, 0)
+	for level := minSSTableLevel + 1; level <= mgr.maxLevel(); level++ {
+		tables = append(tables, mgr.getLevelTables(level)...)
+	}
+	assert.Greater(t, len(tables), 1, "output should be split into multiple tables")
+	for _, table := range tables {
+		assert.FileExists(t, table.FilePath())
+		assert.Nil(t, table.data, "written tables should not keep data in memory")
+	}
+
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		for j := 0; j < 20; j++ {
+			key := kv.Key(fmt.Sprintf("key-%02d-%02d", j, i))
+			val, err := mgr.Search(key)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(key), val)
+		}
+	}
+}
+
+// TestCompactionKeepsNewestFileForSameSequence 测试没有序列号的旧数据合并时保留最新文件中的版本
+func TestCompactionKeepsNewestFileForSameSequence(t *testing.T) {
+	mgr := newTestManager(t)
+	last := mgr.opts.Level0CompactionTrigger - 1
+	for i := 0; i <= last; i++ {
+		sst := mgr.newTable(minSSTableLevel)
+		sst.Header = block.NewHeader("key", "key")
+		sst.Add(&kv.KeyValuePair{Key: "key", Value: []byte(strconv.Itoa(i))})
+		assert.NoError(t, mgr.addNewSSTables([]*SSTable{sst}))
+	}
+	assert.NoError(t, mgr.Compaction())
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+
+	val, err := mgr.Search("key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(strconv.Itoa(last)), val)
+}
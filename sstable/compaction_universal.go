@@ -0,0 +1,147 @@
+package sstable
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompactionStyle 是 SSTable 的合并方式
+type CompactionStyle uint8
+
+const (
+	// CompactionStyleLevel 是 leveled 合并：每个层级有目标大小，超出时选出文件合并到下一层级，读放大和空间放大小
+	CompactionStyleLevel CompactionStyle = 0
+	// CompactionStyleUniversal 是 universal（size-tiered）合并：数据组织为若干有序段，按大小比例和空间放大合并相邻的有序段，写放大小
+	CompactionStyleUniversal CompactionStyle = 1
+	// CompactionStyleFIFO 是 FIFO 合并：所有文件都保留在 Level0，从不合并，总大小超出上限或数据过期时删除最旧的文件，
+	// 适用于只关心近期数据的时序和缓存场景
+	CompactionStyleFIFO CompactionStyle = 2
+)
+
+var compactionStyleNames = map[CompactionStyle]string{
+	CompactionStyleLevel:     "level",
+	CompactionStyleUniversal: "universal",
+	CompactionStyleFIFO:      "fifo",
+}
+
+// ParseCompactionStyle 根据名称返回合并方式，名称不区分大小写
+func ParseCompactionStyle(name string) (CompactionStyle, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for style, styleName := range compactionStyleNames {
+		if styleName == name {
+			return style, nil
+		}
+	}
+	return CompactionStyleLevel, fmt.Errorf("unknown compaction style %q, available: %s, %s, %s",
+		name, CompactionStyleLevel, CompactionStyleUniversal, CompactionStyleFIFO)
+}
+
+// String 返回合并方式的名称
+func (s CompactionStyle) String() string {
+	if name, ok := compactionStyleNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("compaction_style(%d)", uint8(s))
+}
+
+const (
+	defaultUniversalSizeRatio                   = 1
+	defaultUniversalMinMergeWidth               = 2
+	defaultUniversalMaxSizeAmplificationPercent = 200
+)
+
+// UniversalOptions 是 universal 合并选择有序段的参数
+type UniversalOptions struct {
+	// SizeRatio 是按大小比例合并时的容忍百分比：从最新的有序段开始，
+	// 下一个有序段不超过已选有序段总大小的 (100+SizeRatio)% 时一并合并
+	SizeRatio int
+	// MinMergeWidth 是按大小比例合并时一次至少合并的有序段数量
+	MinMergeWidth int
+	// MaxSizeAmplificationPercent 是空间放大的上限：除最旧的有序段之外的数据量超过最旧有序段大小的该百分比时合并所有有序段
+	MaxSizeAmplificationPercent int
+}
+
+// DefaultUniversalOptions 返回 universal 合并的默认参数
+func DefaultUniversalOptions() UniversalOptions {
+	return UniversalOptions{
+		SizeRatio:                   defaultUniversalSizeRatio,
+		MinMergeWidth:               defaultUniversalMinMergeWidth,
+		MaxSizeAmplificationPercent: defaultUniversalMaxSizeAmplificationPercent,
+	}
+}
+
+// sortedRun 是一个有序段：Level0 中的一个文件，或者 Level1 及以上一个非空层级中的所有文件。
+// universal 合并把层级当作存放有序段的位置，层级越深有序段越旧
+type sortedRun struct {
+	level  int
+	tables []*SSTable
+	size   uint64
+}
+
+// sortedRuns 返回版本 v 中按从新到旧排列的有序段
+func (v *Version) sortedRuns() []sortedRun {
+	runs := make([]sortedRun, 0)
+	for _, table := range v.levels[minSSTableLevel] {
+		runs = append(runs, sortedRun{level: minSSTableLevel, tables: []*SSTable{table}, size: table.Size()})
+	}
+	for level := minSSTableLevel + 1; level < len(v.levels); level++ {
+		if len(v.levels[level]) > 0 {
+			runs = append(runs, sortedRun{level: level, tables: v.levels[level], size: v.levelSize(level)})
+		}
+	}
+	return runs
+}
+
+// universalScore 返回 universal 合并的分数：有序段数量与 Level0CompactionTrigger 之比，少于两个有序段时无法合并，返回 0
+func (m *Manager) universalScore(v *Version) float64 {
+	runs := len(v.sortedRuns())
+	if runs < 2 {
+		return 0
+	}
+	return float64(runs) / float64(m.opts.Level0CompactionTrigger)
+}
+
+// pickUniversalCompaction 从最新的有序段开始选出连续的若干个有序段，force 为 true 时合并所有有序段。
+// 有序段数量达到 Level0CompactionTrigger 时依次尝试：
+// 1. 空间放大超过 MaxSizeAmplificationPercent 时合并所有有序段；
+// 2. 按 SizeRatio 选出大小相近的有序段，至少 MinMergeWidth 个；
+// 3. 合并最新的若干个有序段，使有序段数量回到 Level0CompactionTrigger 以下。
+// 输出放在下一个未参与合并的有序段之上的层级，其间没有空闲层级时继续向后合并有序段，包含最旧的有序段时输出到最后一层
+func (m *Manager) pickUniversalCompaction(v *Version, force bool) *compaction {
+	runs := v.sortedRuns()
+	n := len(runs)
+	if n == 0 || (!force && (n < 2 || n < m.opts.Level0CompactionTrigger)) {
+		return nil
+	}
+
+	opts := m.opts.Universal
+	width := n
+	if !force {
+		var newer uint64
+		for _, run := range runs[:n-1] {
+			newer += run.size
+		}
+		if newer*100 <= runs[n-1].size*uint64(opts.MaxSizeAmplificationPercent) {
+			// 按大小比例选出有序段
+			candidate := runs[0].size
+			width = 1
+			for width < n && runs[width].size*100 <= candidate*uint64(100+opts.SizeRatio) {
+				candidate += runs[width].size
+				width++
+			}
+			if width < opts.MinMergeWidth {
+				width = max(n-m.opts.Level0CompactionTrigger+1, 2)
+			}
+		}
+	}
+
+	// 输出层级必须比下一个有序段所在的层级更浅，且不能是 Level0
+	for width < n && runs[width].level-1 <= minSSTableLevel {
+		width++
+	}
+	c := &compaction{level: minSSTableLevel, outputLevel: m.maxLevel(), runs: runs[:width]}
+	if width < n {
+		c.outputLevel = runs[width].level - 1
+	}
+	return c
+}
@@ -0,0 +1,185 @@
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// newUniversalTestManager 创建使用 universal 合并的 Manager
+func newUniversalTestManager(t *testing.T, trigger int) *Manager {
+	opts := DefaultOptions(t.TempDir())
+	opts.CompactionStyle = CompactionStyleUniversal
+	opts.Level0CompactionTrigger = trigger
+	return NewSSTableManager(opts)
+}
+
+// runSizes 返回有序段的大小，按从新到旧排列
+func runSizes(runs []sortedRun) []uint64 {
+	sizes := make([]uint64, 0, len(runs))
+	for _, run := range runs {
+		sizes = append(sizes, run.size)
+	}
+	return sizes
+}
+
+func TestSortedRuns(t *testing.T) {
+	mgr := newUniversalTestManager(t, 4)
+	addMockTable(mgr, 4, "a", "f", 300)
+	addMockTable(mgr, 4, "g", "z", 400)
+	addMockTable(mgr, 2, "a", "z", 200)
+	older := addMockTable(mgr, minSSTableLevel, "a", "z", 20)
+	newer := addMockTable(mgr, minSSTableLevel, "a", "z", 10)
+
+	runs := mgr.current.sortedRuns()
+	assert.Equal(t, []uint64{10, 20, 200, 700}, runSizes(runs))
+	assert.Equal(t, []*SSTable{newer}, runs[0].tables)
+	assert.Equal(t, []*SSTable{older}, runs[1].tables)
+	assert.Equal(t, 2, runs[2].level)
+	assert.Equal(t, 4, runs[3].level)
+	assert.Equal(t, 1.0, mgr.universalScore(mgr.current))
+}
+
+// TestPickUniversalCompaction 测试按空间放大、大小比例和有序段数量选出有序段以及输出层级
+func TestPickUniversalCompaction(t *testing.T) {
+	type run struct {
+		level int
+		size  uint64
+	}
+	tests := []struct {
+		name        string
+		trigger     int
+		runs        []run // 按从旧到新添加
+		force       bool
+		want        []uint64
+		outputLevel int
+	}{
+		{
+			name:    "below trigger",
+			trigger: 4,
+			runs:    []run{{6, 1000}, {0, 10}, {0, 10}},
+		},
+		{
+			name:        "space amplification",
+			trigger:     4,
+			runs:        []run{{6, 100}, {0, 100}, {0, 100}, {0, 100}},
+			want:        []uint64{100, 100, 100, 100},
+			outputLevel: 6,
+		},
+		{
+			name:        "size ratio",
+			trigger:     4,
+			runs:        []run{{6, 10000}, {0, 10}, {0, 10}, {0, 10}, {0, 10}},
+			want:        []uint64{10, 10, 10, 10},
+			outputLevel: 5,
+		},
+		{
+			name:        "reduce sorted runs",
+			trigger:     3,
+			runs:        []run{{6, 1000000}, {5, 100000}, {0, 1000}, {0, 10}},
+			want:        []uint64{10, 1000},
+			outputLevel: 4,
+		},
+		{
+			name:        "no free level above next run",
+			trigger:     2,
+			runs:        []run{{6, 1000000}, {1, 10000}, {0, 10}, {0, 10}},
+			want:        []uint64{10, 10, 10000},
+			outputLevel: 5,
+		},
+		{
+			name:        "force",
+			trigger:     4,
+			runs:        []run{{6, 1000}, {0, 10}},
+			force:       true,
+			want:        []uint64{10, 1000},
+			outputLevel: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := newUniversalTestManager(t, tt.trigger)
+			for _, r := range tt.runs {
+				addMockTable(mgr, r.level, "a", "z", r.size)
+			}
+			c := mgr.pickUniversalCompaction(mgr.current, tt.force)
+			if tt.want == nil {
+				assert.Nil(t, c)
+				return
+			}
+			if assert.NotNil(t, c) {
+				assert.Equal(t, tt.want, runSizes(c.runs))
+				assert.Equal(t, tt.outputLevel, c.outputLevel)
+			}
+		})
+	}
+}
+
+// TestUniversalCompaction 测试 universal 合并之后有序段数量回落到触发数量以下且数据完整，
+// 新数据所在的有序段总是位于更浅的层级
+func TestUniversalCompaction(t *testing.T) {
+	mgr := newUniversalTestManager(t, 3)
+	for round := 0; round < 12; round++ {
+		keys := make([]string, 0)
+		for i := 0; i < 10; i++ {
+			keys = append(keys, fmt.Sprintf("key%02d", (round*7+i)%40))
+		}
+		flushTestPairs(t, mgr, keys)
+	}
+	mgr.bgCompactions.Wait()
+
+	runs := mgr.current.sortedRuns()
+	assert.Less(t, len(runs), mgr.opts.Level0CompactionTrigger)
+	for i := 1; i < len(runs); i++ {
+		assert.True(t, runs[i].level > runs[i-1].level || runs[i].level == minSSTableLevel)
+	}
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		val, err := mgr.Search(kv.Key(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+
+	stats := mgr.CompactionStats()
+	assert.Positive(t, stats.FlushBytes)
+	assert.Positive(t, stats.Compactions)
+	assert.GreaterOrEqual(t, stats.WriteAmplification(), 1.0)
+	assert.GreaterOrEqual(t, stats.SpaceAmplification(), 1.0)
+	assert.Equal(t, runs[len(runs)-1].size, stats.LastRunBytes)
+}
+
+func TestCompactionStats(t *testing.T) {
+	stats := CompactionStats{}
+	assert.Zero(t, stats.WriteAmplification())
+	assert.Zero(t, stats.SpaceAmplification())
+
+	stats = CompactionStats{FlushBytes: 100, CompactionWriteBytes: 250, LiveBytes: 300, LastRunBytes: 200}
+	assert.Equal(t, 3.5, stats.WriteAmplification())
+	assert.Equal(t, 1.5, stats.SpaceAmplification())
+
+	// leveled 合并同样统计刷盘和合并写入的数据量
+	mgr := newTestManager(t)
+	for i := 0; i < mgr.opts.Level0CompactionTrigger; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i)})
+	}
+	stats = mgr.CompactionStats()
+	assert.Equal(t, uint64(1), stats.Compactions)
+	assert.Positive(t, stats.CompactionReadBytes)
+	assert.Positive(t, stats.CompactionWriteBytes)
+	assert.Equal(t, stats.LiveBytes, stats.LastRunBytes)
+	assert.Equal(t, 1.0, stats.SpaceAmplification())
+}
+
+func TestParseCompactionStyle(t *testing.T) {
+	for _, style := range []CompactionStyle{CompactionStyleLevel, CompactionStyleUniversal, CompactionStyleFIFO} {
+		parsed, err := ParseCompactionStyle(style.String())
+		assert.NoError(t, err)
+		assert.Equal(t, style, parsed)
+	}
+	_, err := ParseCompactionStyle("tiered")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package compress
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xmh1011/go-lsm/log"
+)
+
+// Codec 是压缩算法的编号，写入每个块的尾部，读取时据此选择解压算法
+type Codec uint8
+
+const (
+	// NoCompression 不压缩
+	NoCompression Codec = 0
+	// SnappyCompression 使用与 Snappy 块格式兼容的压缩算法，速度快、压缩率一般
+	SnappyCompression Codec = 1
+	// ZlibCompression 使用 zlib（DEFLATE）压缩，压缩率更高但更耗费 CPU
+	ZlibCompression Codec = 2
+)
+
+// Compressor 是一种压缩算法，实现之后通过 Register 注册即可在配置中使用
+type Compressor interface {
+	// Codec 返回写入块尾部的算法编号，不同的算法编号不能相同
+	Codec() Codec
+	// Name 返回配置中使用的算法名称
+	Name() string
+	// Compress 返回压缩后的内容，不修改 src
+	Compress(src []byte) ([]byte, error)
+	// Decompress 返回解压后的内容，不修改 src
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	mu          sync.RWMutex
+	compressors = make(map[Codec]Compressor)
+)
+
+func init() {
+	for _, c := range []Compressor{noneCompressor{}, snappyCompressor{}, zlibCompressor{}} {
+		if err := Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register 注册压缩算法，算法编号或名称已被占用时返回错误
+func Register(c Compressor) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, registered := range compressors {
+		if registered.Codec() == c.Codec() || registered.Name() == c.Name() {
+			log.Errorf("compressor %s (%d) conflicts with registered compressor %s (%d)", c.Name(), c.Codec(), registered.Name(), registered.Codec())
+			return fmt.Errorf("compressor %s (%d) conflicts with registered compressor %s (%d)", c.Name(), c.Codec(), registered.Name(), registered.Codec())
+		}
+	}
+	compressors[c.Codec()] = c
+	return nil
+}
+
+// Lookup 返回编号为 codec 的压缩算法
+func Lookup(codec Codec) (Compressor, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := compressors[codec]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec %d", codec)
+	}
+	return c, nil
+}
+
+// ParseCodec 根据名称返回压缩算法的编号，名称不区分大小写
+func ParseCodec(name string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, c := range compressors {
+		if c.Name() == name {
+			return c.Codec(), nil
+		}
+	}
+	return NoCompression, fmt.Errorf("unknown compression %q, available: %s", name, strings.Join(names(), ", "))
+}
+
+// String 返回压缩算法的名称
+func (c Codec) String() string {
+	compressor, err := Lookup(c)
+	if err != nil {
+		return fmt.Sprintf("codec(%d)", uint8(c))
+	}
+	return compressor.Name()
+}
+
+// names 返回已注册的算法名称，调用方需要持有 mu
+func names() []string {
+	result := make([]string, 0, len(compressors))
+	for _, c := range compressors {
+		result = append(result, c.Name())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// noneCompressor 原样保存内容
+type noneCompressor struct{}
+
+func (noneCompressor) Codec() Codec {
+	return NoCompression
+}
+
+func (noneCompressor) Name() string {
+	return "none"
+}
+
+func (noneCompressor) Compress(src []byte) ([]byte, error) {
+	return append([]byte(nil), src...), nil
+}
+
+func (noneCompressor) Decompress(src []byte) ([]byte, error) {
+	return append([]byte(nil), src...), nil
+}
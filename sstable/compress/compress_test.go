@@ -0,0 +1,91 @@
+package compress
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		bytes.Repeat([]byte("key-value "), 1000),
+		[]byte("the quick brown fox jumps over the lazy dog"),
+	}
+	for _, codec := range []Codec{NoCompression, SnappyCompression, ZlibCompression} {
+		c, err := Lookup(codec)
+		assert.NoError(t, err)
+		assert.Equal(t, codec, c.Codec())
+		for _, input := range inputs {
+			compressed, err := c.Compress(input)
+			assert.NoError(t, err)
+			decompressed, err := c.Decompress(compressed)
+			assert.NoError(t, err, c.Name())
+			assert.Equal(t, len(input), len(decompressed), c.Name())
+			assert.True(t, bytes.Equal(input, decompressed), c.Name())
+		}
+	}
+
+	// 重复的内容压缩之后明显变小
+	input := bytes.Repeat([]byte("key-value "), 1000)
+	for _, codec := range []Codec{SnappyCompression, ZlibCompression} {
+		c, _ := Lookup(codec)
+		compressed, err := c.Compress(input)
+		assert.NoError(t, err)
+		assert.Less(t, len(compressed), len(input)/10, c.Name())
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for name, expected := range map[string]Codec{
+		"none":    NoCompression,
+		"Snappy":  SnappyCompression,
+		" zlib ":  ZlibCompression,
+		"unknown": NoCompression,
+	} {
+		codec, err := ParseCodec(name)
+		assert.Equal(t, expected, codec)
+		if name == "unknown" {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+	assert.Equal(t, "snappy", SnappyCompression.String())
+	assert.Equal(t, "codec(200)", Codec(200).String())
+
+	_, err := Lookup(Codec(200))
+	assert.Error(t, err)
+}
+
+// reverseCompressor 是测试用的压缩算法，把内容倒序保存
+type reverseCompressor struct{}
+
+func (reverseCompressor) Codec() Codec { return 100 }
+
+func (reverseCompressor) Name() string { return "reverse" }
+
+func (reverseCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src))
+	for i, b := range src {
+		dst[len(src)-1-i] = b
+	}
+	return dst, nil
+}
+
+func (r reverseCompressor) Decompress(src []byte) ([]byte, error) {
+	return r.Compress(src)
+}
+
+func TestRegister(t *testing.T) {
+	assert.NoError(t, Register(reverseCompressor{}))
+	codec, err := ParseCodec("reverse")
+	assert.NoError(t, err)
+	assert.Equal(t, Codec(100), codec)
+
+	// 编号或名称冲突
+	assert.Error(t, Register(reverseCompressor{}))
+	assert.Error(t, Register(noneCompressor{}))
+}
@@ -0,0 +1,187 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Snappy 块格式（https://github.com/google/snappy/blob/main/format_description.txt）：
+//
+//	uvarint(解压后长度) + element...
+//
+// 每个 element 的第一个字节是 tag，低 2 位表示类型：
+//
+//	00 literal：高 6 位为长度减 1，取值 60~63 时长度减 1 保存在之后的 1~4 个字节中
+//	01 copy：长度 4~11、偏移量小于 2048，占 2 字节
+//	10 copy：长度 1~64，偏移量保存在之后的 2 个字节中
+//	11 copy：长度 1~64，偏移量保存在之后的 4 个字节中
+//
+// 编码时使用哈希表查找 4 字节的重复串，解码支持全部四种 element，可以解码其他 Snappy 实现的输出。
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+
+	// snappyFragmentSize 是分段编码的大小，保证 copy 的偏移量不超过 2 字节
+	snappyFragmentSize = 1 << 16
+	snappyMinMatch     = 4
+	snappyHashBits     = 14
+	// snappyMaxExpansion 是解压后长度与压缩后长度之比的上限，用于在分配内存之前识别损坏的数据
+	snappyMaxExpansion = 32
+)
+
+var errCorruptSnappy = errors.New("snappy: corrupt input")
+
+// snappyCompressor 使用 Snappy 块格式压缩
+type snappyCompressor struct{}
+
+func (snappyCompressor) Codec() Codec {
+	return SnappyCompression
+}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), snappyFragmentSize)
+		dst = encodeSnappyFragment(dst, src[:n])
+		src = src[n:]
+	}
+	return dst, nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	decodedLen, n := binary.Uvarint(src)
+	if n <= 0 || decodedLen > uint64(len(src))*snappyMaxExpansion {
+		return nil, errCorruptSnappy
+	}
+	src = src[n:]
+	dst := make([]byte, 0, decodedLen)
+	for len(src) > 0 {
+		var length, offset int
+		switch src[0] & 0x03 {
+		case tagLiteral:
+			length = int(src[0] >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length > len(src) || uint64(len(dst)+length) > decodedLen {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case tagCopy1:
+			if len(src) < 2 {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(src[0]>>2&0x07)
+			offset = int(src[0]>>5)<<8 | int(src[1])
+			src = src[2:]
+		case tagCopy2:
+			if len(src) < 3 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+		case tagCopy4:
+			if len(src) < 5 {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > decodedLen {
+			return nil, errCorruptSnappy
+		}
+		// 源区间可能与目标区间重叠，需要逐字节复制
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != decodedLen {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
+
+// encodeSnappyFragment 编码长度不超过 snappyFragmentSize 的一段数据并追加到 dst
+func encodeSnappyFragment(dst, src []byte) []byte {
+	// table 记录每个哈希值最近出现的位置加 1，0 表示没有出现过
+	var table [1 << snappyHashBits]int32
+	literal := 0 // 尚未输出的字面量的起始位置
+	for i := 0; i+snappyMinMatch <= len(src); {
+		current := binary.LittleEndian.Uint32(src[i:])
+		h := (current * 0x1e35a7bd) >> (32 - snappyHashBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != current {
+			i++
+			continue
+		}
+
+		length := snappyMinMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = emitLiteral(dst, src[literal:i])
+		dst = emitCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+	return emitLiteral(dst, src[literal:])
+}
+
+// emitLiteral 输出一个字面量 element
+func emitLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// emitCopy 输出复制前 offset 字节处 length 字节的 element，length 至少为 4
+func emitCopy(dst []byte, offset, length int) []byte {
+	// 单个 copy 最长 64 字节，拆分时保证剩余长度不小于 4
+	for length >= 68 {
+		dst = append(dst, 63<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|tagCopy1, byte(offset))
+}
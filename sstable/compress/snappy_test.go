@@ -0,0 +1,103 @@
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSnappyDecodeReferenceFormat 测试解码按 Snappy 格式手工构造的数据，覆盖全部 element 类型
+func TestSnappyDecodeReferenceFormat(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 70)
+	tests := []struct {
+		name     string
+		encoded  []byte
+		expected []byte
+	}{
+		{
+			name:     "literal",
+			encoded:  []byte{0x05, 0x10, 'H', 'e', 'l', 'l', 'o'},
+			expected: []byte("Hello"),
+		},
+		{
+			name:     "long literal",
+			encoded:  append([]byte{70, 60<<2 | tagLiteral, 69}, long...),
+			expected: long,
+		},
+		{
+			name:     "copy with 1-byte offset",
+			encoded:  []byte{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04},
+			expected: []byte("abcdabcdabcd"),
+		},
+		{
+			name:     "copy with 2-byte offset",
+			encoded:  []byte{0x06, 0x04, 'a', 'b', 3<<2 | tagCopy2, 0x02, 0x00},
+			expected: []byte("ababab"),
+		},
+		{
+			name:     "copy with 4-byte offset",
+			encoded:  []byte{0x04, 0x00, 'z', 2<<2 | tagCopy4, 0x01, 0x00, 0x00, 0x00},
+			expected: []byte("zzzz"),
+		},
+	}
+
+	c := snappyCompressor{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := c.Decompress(tt.encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, decoded)
+		})
+	}
+}
+
+func TestSnappyEncodeMatchesReference(t *testing.T) {
+	c := snappyCompressor{}
+	encoded, err := c.Compress([]byte("abcdabcdabcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04}, encoded)
+}
+
+func TestSnappyRandomRoundTrip(t *testing.T) {
+	c := snappyCompressor{}
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{3, 100, 4096, snappyFragmentSize + 1234, 3 * snappyFragmentSize} {
+		// 混合随机字节和重复片段，同时覆盖字面量和长短不同的 copy
+		input := make([]byte, 0, size)
+		for len(input) < size {
+			if len(input) > 100 && rng.Intn(2) == 0 {
+				start := rng.Intn(len(input) - 1)
+				end := min(len(input), start+1+rng.Intn(200))
+				input = append(input, input[start:end]...)
+			} else {
+				for n := rng.Intn(50); n > 0; n-- {
+					input = append(input, byte(rng.Intn(256)))
+				}
+			}
+		}
+		input = input[:size]
+
+		compressed, err := c.Compress(input)
+		assert.NoError(t, err)
+		decompressed, err := c.Decompress(compressed)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(input, decompressed), "size %d", size)
+	}
+}
+
+func TestSnappyDecodeCorrupted(t *testing.T) {
+	c := snappyCompressor{}
+	for _, encoded := range [][]byte{
+		{},
+		{0x05, 0x10, 'H', 'e'}, // 字面量被截断
+		{0x04, 0x11, 0x04},     // copy 的偏移量超出已解码的内容
+		{0x05, 0x10, 'H', 'e', 'l', 'l', 'o', 'x'}, // 解码长度超过声明的长度
+		{0x06, 0x10, 'H', 'e', 'l', 'l', 'o'},      // 解码长度小于声明的长度
+		{0xff, 0xff, 0xff, 0xff, 0x0f},             // 声明的长度远超压缩数据能够表示的长度
+	} {
+		_, err := c.Decompress(encoded)
+		assert.Error(t, err, "%v", encoded)
+	}
+}
@@ -0,0 +1,44 @@
+package compress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// zlibCompressor 使用标准库的 zlib 压缩
+type zlibCompressor struct{}
+
+func (zlibCompressor) Codec() Codec {
+	return ZlibCompression
+}
+
+func (zlibCompressor) Name() string {
+	return "zlib"
+}
+
+func (zlibCompressor) Compress(src []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := zlib.NewWriter(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, fmt.Errorf("zlib compress failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("zlib compress failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (zlibCompressor) Decompress(src []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, fmt.Errorf("zlib decompress failed: %w", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("zlib decompress failed: %w", err)
+	}
+	return data, nil
+}
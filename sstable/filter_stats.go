@@ -0,0 +1,53 @@
+package sstable
+
+import "sync/atomic"
+
+// FilterStats 是点查询时布隆过滤器的判断结果统计，只统计 key 落在 SSTable key 范围之内的查询
+type FilterStats struct {
+	Negatives      uint64 // 布隆过滤器判断不存在，跳过了读取数据块
+	TruePositives  uint64 // 布隆过滤器判断可能存在，SSTable 中确实有该 key
+	FalsePositives uint64 // 布隆过滤器判断可能存在，SSTable 中实际没有该 key
+}
+
+// FalsePositiveRate 返回实测的误判率，即不存在的 key 中被布隆过滤器误判为可能存在的比例，没有统计数据时返回 0
+func (s FilterStats) FalsePositiveRate() float64 {
+	absent := s.Negatives + s.FalsePositives
+	if absent == 0 {
+		return 0
+	}
+	return float64(s.FalsePositives) / float64(absent)
+}
+
+// filterStats 是 Manager 中所有 SSTable 共享的布隆过滤器统计计数器，nil 的 filterStats 不做统计
+type filterStats struct {
+	negatives      atomic.Uint64
+	truePositives  atomic.Uint64
+	falsePositives atomic.Uint64
+}
+
+// record 记录一次查询的结果：mayContain 是布隆过滤器的判断，found 是 SSTable 中是否确实有该 key
+func (s *filterStats) record(mayContain, found bool) {
+	if s == nil {
+		return
+	}
+	switch {
+	case !mayContain:
+		s.negatives.Add(1)
+	case found:
+		s.truePositives.Add(1)
+	default:
+		s.falsePositives.Add(1)
+	}
+}
+
+// snapshot 返回当前的统计数据
+func (s *filterStats) snapshot() FilterStats {
+	if s == nil {
+		return FilterStats{}
+	}
+	return FilterStats{
+		Negatives:      s.negatives.Load(),
+		TruePositives:  s.truePositives.Load(),
+		FalsePositives: s.falsePositives.Load(),
+	}
+}
@@ -1,73 +1,252 @@
 package sstable
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+
 	"github.com/xmh1011/go-lsm/kv"
-	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/sstable/block"
 )
 
+// Iterator 是 SSTable 的两级迭代器：先在索引块中定位数据块，再在数据块中定位记录
 type Iterator struct {
-	SSTable       *SSTable
-	IndexIterator *block.Iterator // 索引迭代器，用于查找数据块
+	SSTable *SSTable
+	index   *block.Iterator // 索引块迭代器，每一项对应一个数据块
+	data    *block.Iterator // 当前数据块的迭代器
+	// dataOffset 是当前数据块在文件中的偏移量
+	dataOffset int64
+	// verifyChecksums 为 true 时加载数据块之前检查校验和
+	verifyChecksums bool
+	// fillCache 为 true 时通过块缓存加载数据块，合并等只读一次的场景关闭以免冲掉缓存中的热点数据块
+	fillCache bool
+
+	// version 是迭代器持有的版本，通过 Manager.NewIterators 创建时设置，关闭时释放
+	version *Version
+
+	// reader 是读取数据块的来源：还保留着内存数据的 SSTable 直接从内存中读取，
+	// 否则在第一次加载数据块时从 tableCache 获取打开的文件，并在关闭迭代器时释放
+	reader io.ReaderAt
+	file   *tableFile
+
+	// err 是加载数据块时遇到的错误，出错后迭代器失效
+	err error
 }
 
-// NewSSTableIterator 创建一个新的 SSTable 迭代器
+// NewSSTableIterator 创建一个新的 SSTable 迭代器并定位到第一条记录
 func NewSSTableIterator(sst *SSTable) *Iterator {
+	it := newTableIterator(sst)
+	it.SeekToFirst()
+	return it
+}
+
+// newTableIterator 创建尚未定位的 SSTable 迭代器
+func newTableIterator(sst *SSTable) *Iterator {
+	index, err := sst.index()
+	if index == nil {
+		// 尚未构建完成或索引块读取失败的 SSTable 视为空，读取失败时迭代器记录错误
+		index, _ = block.NewBlock(block.NewBlockBuilder(1).Finish())
+	}
 	it := &Iterator{
-		SSTable:       sst,
-		IndexIterator: block.NewIterator(sst.IndexBlock), // 使用 SSTable 的索引块创建迭代器
+		SSTable:         sst,
+		index:           index.NewIterator(),
+		verifyChecksums: sst.verifyChecksums,
+		fillCache:       true,
+		err:             err,
+	}
+	if sst.data != nil {
+		it.reader = bytes.NewReader(sst.data)
 	}
-	it.SeekToFirst()
 	return it
 }
 
 // Valid 检查迭代器当前位置是否有效
 func (i *Iterator) Valid() bool {
-	return i.IndexIterator.Valid()
+	return i.err == nil && i.data != nil && i.data.Valid()
 }
 
-// Key 返回当前索引条目的key
+// Error 返回迭代过程中遇到的第一个错误
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+// Key 返回当前记录的key
 func (i *Iterator) Key() kv.Key {
-	return i.IndexIterator.Key()
+	if !i.Valid() {
+		return ""
+	}
+	return i.data.Key()
 }
 
-// Value 返回
-func (i *Iterator) Value() (kv.Value, error) {
+// Seq 返回当前记录的序列号
+func (i *Iterator) Seq() uint64 {
 	if !i.Valid() {
-		return nil, nil // 如果迭代器无效，返回nil
+		return 0
 	}
+	return i.data.Seq()
+}
 
-	value, err := i.SSTable.GetValueByOffset(i.IndexIterator.ValueOffset())
-	if err != nil {
-		log.Errorf("failed to get value by offset error: %s", err.Error())
-		return nil, err // 如果获取值失败，返回错误
+// Kind 返回当前记录的记录类型
+func (i *Iterator) Kind() kv.Kind {
+	if !i.Valid() {
+		return kv.KindPut
 	}
+	return i.data.Kind()
+}
 
-	return value, nil
+// Value 返回当前记录的 value，删除标记的 value 为 nil
+func (i *Iterator) Value() (kv.Value, error) {
+	if !i.Valid() {
+		return nil, i.err // 如果迭代器无效，返回nil
+	}
+	if i.data.Kind() == kv.KindDelete || i.data.Kind() == kv.KindSingleDelete {
+		return nil, nil
+	}
+	// 数据块可能被其他读者共享，返回副本
+	return append(kv.Value{}, i.data.Value()...), nil
 }
 
-// Next 将迭代器移动到下一个索引条目
+// Next 将迭代器移动到下一条记录
 func (i *Iterator) Next() {
-	i.IndexIterator.Next()
+	if !i.Valid() {
+		return
+	}
+	i.data.Next()
+	i.skipForward()
 }
 
-// Seek 查找大于或等于目标key的第一个索引条目（使用二分查找）
+// Seek 查找与目标key完全匹配的第一条记录，不存在时设置为无效状态
 func (i *Iterator) Seek(target kv.Key) {
-	i.IndexIterator.Seek(target)
+	i.SeekGE(target)
+	if i.Valid() && i.Key() != target {
+		i.data = nil
+	}
 }
 
-// SeekToFirst 将迭代器移动到第一个索引条目
+// SeekGE 查找大于或等于目标key的第一条记录，用于范围查询
+func (i *Iterator) SeekGE(target kv.Key) {
+	// 索引项的 key 是数据块中最后一条记录的 key，第一个不小于 target 的索引项对应的数据块包含所求记录
+	i.index.SeekGE(target)
+	if i.loadBlock() {
+		i.data.SeekGE(target)
+		i.skipForward()
+	}
+}
+
+// SeekLT 查找严格小于目标key的最后一条记录，用于反向定位
+func (i *Iterator) SeekLT(target kv.Key) {
+	i.index.SeekGE(target)
+	if !i.index.Valid() {
+		// 所有记录都小于 target
+		i.SeekToLast()
+		return
+	}
+	if !i.loadBlock() {
+		return
+	}
+	i.data.SeekLT(target)
+	if i.data.Valid() || i.checkBlockError() {
+		return
+	}
+
+	// 当前数据块中没有小于 target 的记录，所求记录是前一个数据块的最后一条记录
+	i.index.SeekLT(target)
+	if i.loadBlock() {
+		i.data.SeekToLast()
+		i.checkBlockError()
+	}
+}
+
+// SeekToFirst 将迭代器移动到第一条记录
 func (i *Iterator) SeekToFirst() {
-	i.IndexIterator.SeekToFirst()
+	i.index.SeekToFirst()
+	if i.loadBlock() {
+		i.data.SeekToFirst()
+		i.skipForward()
+	}
 }
 
-// SeekToLast 将迭代器移动到最后一个索引条目
+// SeekToLast 将迭代器移动到最后一条记录
 func (i *Iterator) SeekToLast() {
-	i.IndexIterator.SeekToLast()
+	i.index.SeekToLast()
+	if i.loadBlock() {
+		i.data.SeekToLast()
+		i.checkBlockError()
+	}
 }
 
 // Close 关闭迭代器，释放相关资源
 func (i *Iterator) Close() {
-	i.SSTable = nil         // 清理 SSTable 引用
-	i.IndexIterator.Close() // 关闭索引迭代器
+	if i.file != nil {
+		i.SSTable.files.release(i.file)
+		i.file = nil
+	}
+	i.SSTable = nil // 清理 SSTable 引用
+	i.index.Close() // 关闭索引迭代器
+	i.data = nil
+	i.reader = nil
+	if i.version != nil {
+		i.version.Unref()
+		i.version = nil
+	}
+}
+
+// loadBlock 加载索引迭代器当前指向的数据块，索引迭代器无效或加载失败时返回 false
+func (i *Iterator) loadBlock() bool {
+	i.data = nil
+	if i.err != nil {
+		return false
+	}
+	if !i.index.Valid() {
+		if err := i.index.Error(); err != nil {
+			i.err = i.SSTable.corruption(i.SSTable.Footer.IndexHandle.Offset, fmt.Errorf("read index block failed: %w", err))
+		}
+		return false
+	}
+
+	handle, err := block.ParseHandle(i.index.Value())
+	if err != nil {
+		i.err = i.SSTable.corruption(i.SSTable.Footer.IndexHandle.Offset, fmt.Errorf("parse block handle failed: %w", err))
+		return false
+	}
+	if i.reader == nil {
+		file, err := i.SSTable.files.acquire(i.SSTable)
+		if err != nil {
+			i.err = err
+			return false
+		}
+		i.file, i.reader = file, file
+	}
+	data, err := i.SSTable.readDataBlock(i.reader, handle, i.verifyChecksums, i.fillCache)
+	if err != nil {
+		i.err = err
+		return false
+	}
+	i.data = data.NewIterator()
+	i.dataOffset = handle.Offset
+	return true
+}
+
+// skipForward 当前数据块遍历完之后移动到下一个数据块的第一条记录
+func (i *Iterator) skipForward() {
+	for i.data != nil && !i.data.Valid() {
+		if i.checkBlockError() {
+			return
+		}
+		i.index.Next()
+		if !i.loadBlock() {
+			return
+		}
+		i.data.SeekToFirst()
+	}
+}
+
+// checkBlockError 检查当前数据块是否解析出错，出错时返回 true
+func (i *Iterator) checkBlockError() bool {
+	if i.data == nil || i.data.Error() == nil {
+		return false
+	}
+	i.err = i.SSTable.corruption(i.dataOffset, fmt.Errorf("read data block failed: %w", i.data.Error()))
+	i.data = nil
+	return true
 }
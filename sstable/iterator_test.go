@@ -1,49 +1,25 @@
 package sstable
 
 import (
+	"fmt"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/xmh1011/go-lsm/kv"
-	"github.com/xmh1011/go-lsm/sstable/block"
-	"github.com/xmh1011/go-lsm/sstable/bloom"
 )
 
-// createTestSSTable 构造一个 SSTable 实例用于测试
+// createTestSSTable 构造一个 SSTable 实例用于测试，每条记录单独占用一个数据块
 func createTestSSTable(t *testing.T) *SSTable {
 	sst := NewSSTable()
+	sst.blockSize = 1
 
-	// 构造 DataBlock 1：包含键 "a", "b"
-	db1 := &block.DataBlock{
-		Entries: []kv.Value{
-			[]byte("A"),
-			[]byte("B"),
-			[]byte("C"),
-			[]byte("D"),
-			[]byte("E"),
-		},
-	}
-
-	sst.DataBlock = db1
-
-	// 构造索引块（记录每个数据块的起始键和偏移量）
-	sst.IndexBlock = &block.IndexBlock{
-		Indexes: []*block.IndexEntry{
-			{Key: "a", Offset: 0},
-			{Key: "b", Offset: 0},
-			{Key: "c", Offset: 0},
-			{Key: "d", Offset: 0},
-			{Key: "e", Offset: 0},
-		},
-	}
-
-	// 构造布隆过滤器
-	sst.FilterBlock = bloom.NewBloomFilter(1024, 5)
 	for _, key := range []string{"a", "b", "c", "d", "e"} {
-		sst.FilterBlock.AddString(key)
+		sst.Add(&kv.KeyValuePair{Key: kv.Key(key), Value: kv.Value(strings.ToUpper(key))})
 	}
+	sst.Header.MinKey, sst.Header.MaxKey = "a", "e"
 
 	return sst
 }
@@ -189,3 +165,76 @@ func TestFilterBlockIntegration(t *testing.T) {
 	// 测试不存在的键
 	assert.False(t, loaded.FilterBlock.MayContain("x"))
 }
+
+func TestSSTableIteratorSeekGEAndSeekLT(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "1.sst")
+
+	original := createTestSSTable(t)
+	err := original.EncodeTo(filePath)
+	assert.NoError(t, err)
+
+	loaded := NewSSTable()
+	err = loaded.DecodeFrom(filePath)
+	assert.NoError(t, err)
+
+	iter := NewSSTableIterator(loaded)
+	defer iter.Close()
+
+	// 不存在的 key 定位到下一个 key
+	iter.SeekGE("bb")
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("c"), iter.Key())
+	val, err := iter.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "C", string(val))
+
+	iter.SeekLT("c")
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("b"), iter.Key())
+
+	iter.SeekLT("a")
+	assert.False(t, iter.Valid())
+
+	iter.SeekLT("z")
+	assert.True(t, iter.Valid())
+	assert.Equal(t, kv.Key("e"), iter.Key())
+}
+
+// TestSSTableIteratorAcrossBlocks 测试同一 key 的多个版本跨越数据块时的定位和遍历
+func TestSSTableIteratorAcrossBlocks(t *testing.T) {
+	table := createMultiBlockSSTable(t, 10, 4)
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	table.releaseData()
+
+	iter := NewSSTableIterator(table)
+	defer iter.Close()
+
+	for i := 0; i < 10; i++ {
+		key := kv.Key(fmt.Sprintf("key%03d", i))
+		iter.SeekGE(key)
+		for seq := uint64(4); seq > 0; seq-- {
+			assert.True(t, iter.Valid())
+			assert.Equal(t, key, iter.Key())
+			assert.Equal(t, seq, iter.Seq())
+			iter.Next()
+		}
+
+		iter.SeekLT(key)
+		if i == 0 {
+			assert.False(t, iter.Valid())
+			continue
+		}
+		// 前一个 key 的最旧版本
+		assert.True(t, iter.Valid())
+		assert.Equal(t, kv.Key(fmt.Sprintf("key%03d", i-1)), iter.Key())
+		assert.Equal(t, uint64(1), iter.Seq())
+	}
+
+	iter.SeekToLast()
+	assert.Equal(t, kv.Key("key009"), iter.Key())
+	assert.Equal(t, uint64(1), iter.Seq())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	assert.NoError(t, iter.Error())
+}
@@ -1,235 +1,506 @@
 package sstable
 
 import (
-	"bytes"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/memtable"
 	"github.com/xmh1011/go-lsm/sstable/block"
+	"github.com/xmh1011/go-lsm/sstable/bloom"
+	"github.com/xmh1011/go-lsm/sstable/cache"
+	"github.com/xmh1011/go-lsm/sstable/compress"
 	"github.com/xmh1011/go-lsm/util"
 )
 
 const (
 	minSSTableLevel = 0
-	maxSSTableLevel = 6
-	levelSizeBase   = 2
+
+	defaultNumLevels               = 7
+	defaultLevelMultiplier         = 10
+	defaultLevel0CompactionTrigger = 4
+	defaultMaxBytesForLevelBase    = 10 * 1024 * 1024 // 10MB
+	defaultTableSize               = 2 * 1024 * 1024  // 2MB
 )
 
-func init() {
-	// 创建 SSTable 目录
-	err := os.MkdirAll(config.GetSSTablePath(), os.ModePerm)
-	if err != nil {
-		log.Errorf("failed to create sstable directory: %s", err.Error())
-	}
-	// 为各层创建对应目录
-	for i := minSSTableLevel; i <= maxSSTableLevel; i++ {
-		if err = os.MkdirAll(sstableLevelPath(i, config.GetSSTablePath()), os.ModePerm); err != nil {
-			log.Errorf("failed to create sstable level %d directory: %s", i, err.Error())
+// Options 是单个 Manager 的配置，各个 Manager 之间互不影响
+type Options struct {
+	// Dir 是 SSTable 的根目录，各层级的文件位于其下的子目录中
+	Dir string
+	// TableSize 是合并时单个 SSTable 的目标大小（字节）
+	TableSize uint64
+	// BlockSize 是 SSTable 中单个数据块的目标大小（字节）
+	BlockSize int
+	// NumLevels 是层级数量（包括 Level0）
+	NumLevels int
+	// LevelMultiplier 是相邻层级目标大小的倍数，Level i+1 的目标大小是 Level i 的 LevelMultiplier 倍
+	LevelMultiplier int
+	// Level0CompactionTrigger 是触发 Level0 合并的文件数量
+	Level0CompactionTrigger int
+	// MaxBytesForLevelBase 是基准层级（Level0 合并的目标层级）的目标大小（字节）
+	MaxBytesForLevelBase uint64
+	// DynamicLevelBytes 为 true 时按最后一层的实际大小从下往上推算各层级的目标大小，
+	// 目标大小不超过 MaxBytesForLevelBase 的最深层级作为基准层级，其上的层级保持为空；
+	// 为 false 时基准层级为 Level1，目标大小为 MaxBytesForLevelBase，向下逐层乘以 LevelMultiplier
+	DynamicLevelBytes bool
+	// CompactionPri 是 Level1 及以上的层级选择参与合并的文件的方式
+	CompactionPri CompactionPri
+	// CompactionStyle 是合并方式，默认为 leveled 合并
+	CompactionStyle CompactionStyle
+	// Universal 是 universal 合并的参数，CompactionStyle 为 CompactionStyleUniversal 时生效
+	Universal UniversalOptions
+	// FIFO 是 FIFO 合并删除旧文件的条件，CompactionStyle 为 CompactionStyleFIFO 时生效
+	FIFO FIFOOptions
+	// BloomBitsPerKey 是新建 SSTable 的布隆过滤器中每个 key 占用的位数，位图长度按 SSTable 中 key 的数量计算
+	BloomBitsPerKey uint
+	// Compression 是各层级数据块使用的压缩算法，第 i 项用于 Level i，层级多于配置项时使用最后一项
+	Compression []compress.Codec
+	// VerifyChecksums 为 true 时每次读取数据块都检查校验和；为 false 时只在加载文件和合并时检查
+	VerifyChecksums bool
+	// BlockCacheSize 是所有 SSTable 共享的块缓存容量（字节），为 0 时不使用块缓存
+	BlockCacheSize int64
+	// MaxOpenFiles 是同时保持打开的 SSTable 文件数量上限，超过时关闭最久未使用的文件
+	MaxOpenFiles int
+	// CacheIndexAndFilter 为 true 时索引块和布隆过滤器不常驻内存，而是和数据块一样通过块缓存读取，可能被淘汰；
+	// 为 false 时它们在 SSTable 的生命周期内常驻内存
+	CacheIndexAndFilter bool
+}
+
+// DefaultCompression 返回 numLevels 个层级的默认压缩算法：
+// Level0 和 Level1 的文件很快会被合并，不压缩；最后一层保存大部分数据，使用压缩率更高的 zlib；其余层级使用 snappy
+func DefaultCompression(numLevels int) []compress.Codec {
+	codecs := make([]compress.Codec, numLevels)
+	for level := range codecs {
+		switch {
+		case level <= 1:
+			codecs[level] = compress.NoCompression
+		case level == numLevels-1:
+			codecs[level] = compress.ZlibCompression
+		default:
+			codecs[level] = compress.SnappyCompression
 		}
 	}
+	return codecs
+}
+
+// DefaultOptions 返回以 dir 为根目录的默认配置
+func DefaultOptions(dir string) Options {
+	return Options{
+		Dir:                     dir,
+		TableSize:               defaultTableSize,
+		BlockSize:               block.DefaultBlockSize,
+		NumLevels:               defaultNumLevels,
+		LevelMultiplier:         defaultLevelMultiplier,
+		Level0CompactionTrigger: defaultLevel0CompactionTrigger,
+		MaxBytesForLevelBase:    defaultMaxBytesForLevelBase,
+		Universal:               DefaultUniversalOptions(),
+		FIFO:                    DefaultFIFOOptions(),
+		BloomBitsPerKey:         bloom.DefaultBitsPerKey,
+		Compression:             DefaultCompression(defaultNumLevels),
+		MaxOpenFiles:            defaultMaxOpenFiles,
+	}
+}
+
+// withDefaults 将未设置的配置项替换为默认值
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions(o.Dir)
+	if o.TableSize == 0 {
+		o.TableSize = defaults.TableSize
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = defaults.BlockSize
+	}
+	if o.NumLevels < 2 {
+		o.NumLevels = defaults.NumLevels
+	}
+	if o.LevelMultiplier < 2 {
+		o.LevelMultiplier = defaults.LevelMultiplier
+	}
+	if o.Level0CompactionTrigger <= 0 {
+		o.Level0CompactionTrigger = defaults.Level0CompactionTrigger
+	}
+	if o.MaxBytesForLevelBase == 0 {
+		o.MaxBytesForLevelBase = defaults.MaxBytesForLevelBase
+	}
+	if o.Universal == (UniversalOptions{}) {
+		o.Universal = defaults.Universal
+	}
+	if o.Universal.MinMergeWidth < 2 {
+		o.Universal.MinMergeWidth = defaults.Universal.MinMergeWidth
+	}
+	if o.Universal.MaxSizeAmplificationPercent <= 0 {
+		o.Universal.MaxSizeAmplificationPercent = defaults.Universal.MaxSizeAmplificationPercent
+	}
+	if o.FIFO.MaxTableFilesSize == 0 {
+		o.FIFO.MaxTableFilesSize = defaults.FIFO.MaxTableFilesSize
+	}
+	if o.BloomBitsPerKey == 0 {
+		o.BloomBitsPerKey = defaults.BloomBitsPerKey
+	}
+	if len(o.Compression) == 0 {
+		o.Compression = DefaultCompression(o.NumLevels)
+	}
+	if o.MaxOpenFiles <= 0 {
+		o.MaxOpenFiles = defaults.MaxOpenFiles
+	}
+	return o
 }
 
 // Manager 管理内存中的 SSTable 元信息（Footer/Filter/Index）+ 磁盘中的文件记录。
 type Manager struct {
 	mu sync.RWMutex
 
-	// levels 保存各层级的 SSTable 元信息，按层级分组，每层内按 id 降序排序
-	levels [][]*SSTable
+	opts Options
+
+	// blockCache 是所有 SSTable 共享的块缓存，未配置时为 nil
+	blockCache *cache.Cache
+
+	// tableCache 缓存打开的 SSTable 文件，所有读取共享
+	tableCache *tableCache
+
+	// filterStats 统计所有 SSTable 的布隆过滤器判断结果
+	filterStats *filterStats
 
-	// fileIndex 用于快速查找 SSTable 的索引 (文件路径 -> *SSTable)
-	fileIndex map[string]*SSTable
+	// compactionStats 统计刷盘和合并写入的数据量
+	compactionStats *compactionStats
 
-	// totalMap 记录所有层级的文件路径
-	totalMap map[int][]string
+	// nextID 是 SSTable 文件的 ID 生成器，每个 Manager 独立计数
+	nextID atomic.Uint64
 
-	// 稀疏索引，按照 key 排序 level 1 及以上的 SSTable，用于查找
-	sparseIndexes [][]*SSTable
+	// current 是当前版本，由 mu 保护，每次变更都会生成新的版本替换它
+	current *Version
 
 	// 异步合并控制
 	compactionCond   *sync.Cond
-	compactingLevels map[int]bool // 记录各层级的压缩状态
+	compactingLevels map[int]bool   // 记录正在合并的输入和输出层级
+	bgCompactions    sync.WaitGroup // 正在运行的异步合并协程
+	bgScheduled      bool           // 已经有异步合并协程在运行，同一时间最多一个
+	closed           bool           // 关闭之后不再启动新的异步合并
+	exclusiveManual  bool           // 独占的手动合并正在进行，期间不运行自动合并
+	ttlStop          chan struct{}  // 关闭之后停止定期检查 FIFO 合并的过期文件，未启动时为 nil
+
+	// compactPointers 记录 Level1 及以上各层级上一次合并的文件的最大 key，按轮转方式选择文件时从其后开始
+	compactPointers map[int]kv.Key
+
+	// snapshots 返回当前存活快照的序列号，合并时需要保留对这些快照可见的历史版本
+	snapshots func() []uint64
+
+	// manifestMu 串行化 SSTable 集合的变更，manifest 为 nil 时下一次变更会创建新的 MANIFEST
+	manifestMu sync.Mutex
+	manifest   *manifest
 }
 
-func NewSSTableManager() *Manager {
+// NewSSTableManager 按 opts 创建 Manager，opts 中未设置的配置项使用默认值
+func NewSSTableManager(opts Options) *Manager {
+	opts = opts.withDefaults()
 	mgr := &Manager{
-		levels:           make([][]*SSTable, maxSSTableLevel+1),
-		fileIndex:        make(map[string]*SSTable),
-		totalMap:         make(map[int][]string),
+		opts:             opts,
+		current:          newVersion(opts.NumLevels),
 		compactingLevels: make(map[int]bool),
-		sparseIndexes:    make([][]*SSTable, maxSSTableLevel),
+		compactPointers:  make(map[int]kv.Key),
+		tableCache:       newTableCache(opts.MaxOpenFiles),
+		filterStats:      &filterStats{},
+		compactionStats:  &compactionStats{},
+	}
+	if opts.BlockCacheSize > 0 {
+		mgr.blockCache = cache.NewCache(opts.BlockCacheSize, cache.DefaultShards)
 	}
 	mgr.compactionCond = sync.NewCond(&mgr.mu)
 	return mgr
 }
 
-// CreateNewSSTable 将 imem 数据构建为 SSTable，写入到磁盘，然后将其元数据添加到内存中。
+// CreateNewSSTable 将 imem 刷盘为 Level0 SSTable（见 FlushIMemTable），然后执行合并逻辑
 func (m *Manager) CreateNewSSTable(imem *memtable.IMemTable) error {
-	sst := BuildSSTableFromIMemTable(imem)
-	defer imem.Clean() // 删除 WAL 文件
-
-	// 写入 Level0 文件
-	filePath := sstableFilePath(sst.id, sst.level, config.GetSSTablePath())
-	if err := sst.EncodeTo(filePath); err != nil {
-		log.Errorf("encode sstable to file %s error: %s", sst.FilePath(), err.Error())
-		return fmt.Errorf("encode sstable failed: %w", err)
+	if err := m.FlushIMemTable(imem); err != nil {
+		return err
 	}
 
-	// 添加到内存中
-	m.addTable(sst)
-
 	// 执行合并逻辑
 	if err := m.Compaction(); err != nil {
 		log.Errorf("compaction error: %s", err.Error())
 		return fmt.Errorf("compaction failed: %w", err)
 	}
+	return nil
+}
+
+// FlushIMemTable 将 imem 数据构建为 SSTable，写入到磁盘，然后将其元数据记录到 MANIFEST 并添加到内存中。
+// 只有 SSTable 通过 MANIFEST 提交之后才删除 imem 的 WAL 文件；写入或提交失败时 WAL 文件保留在磁盘上，
+// 重启之后从 WAL 恢复。空的 imem 不生成 SSTable，只删除其 WAL 文件。
+func (m *Manager) FlushIMemTable(imem *memtable.IMemTable) error {
+	if imem.Empty() {
+		imem.Clean()
+		return nil
+	}
+
+	// 流式写入 Level0 文件
+	sst, err := m.writeIMemTable(imem)
+	if err != nil {
+		log.Errorf("write imemtable to sstable error: %s", err.Error())
+		return fmt.Errorf("write sstable failed: %w", err)
+	}
+
+	// 记录到 MANIFEST 并添加到内存中
+	edit := &VersionEdit{}
+	edit.AddFile(sst.Meta())
+	if err := m.logAndApply(edit, []*SSTable{sst}); err != nil {
+		// 没有提交的 SSTable 不会被恢复，数据仍在 WAL 中，删除文件
+		_ = sst.Remove()
+		log.Errorf("log and apply version edit error: %s", err.Error())
+		return fmt.Errorf("log and apply version edit failed: %w", err)
+	}
+	m.compactionStats.recordFlush(sst)
 
+	// 数据已经持久化在 SSTable 中，删除 WAL 文件
+	imem.Clean()
 	return nil
 }
 
-// Search 从低层级向高层级查找 key，同层级按 id 降序查找
-// 返回找到的值或错误，如果未找到返回 (nil, nil)
-func (m *Manager) Search(key kv.Key) ([]byte, error) {
-	// 1. 从高层级向低层级查找
-	for level := minSSTableLevel; level <= maxSSTableLevel; level++ {
-		// 2. 等待该层级的潜在合并完成（仅对需要等待的层级）
-		if err := m.waitForCompactionIfNeeded(level); err != nil {
-			log.Errorf("wait for compaction at level %d failed: %s", level, err.Error())
-			return nil, fmt.Errorf("wait for compaction failed: %w", err)
-		}
+// Options 返回 Manager 使用的配置
+func (m *Manager) Options() Options {
+	return m.opts
+}
 
-		// 3. 先从level 0开始查找
-		if level == minSSTableLevel {
-			val, err := m.searchFromLevel0(key)
-			if err != nil {
-				log.Errorf("search from level 0 failed: %s", err.Error())
-				return nil, fmt.Errorf("search from level 0 failed: %w", err)
-			}
-			if val != nil {
-				return val, nil
-			}
-			continue
-		}
+// newTable 在指定层级创建一个新的空 SSTable，分配新的 ID 并按配置设置块大小、压缩算法和布隆过滤器参数
+func (m *Manager) newTable(level int) *SSTable {
+	table := NewSSTable()
+	table.id = m.nextID.Add(1)
+	table.level = level
+	table.filePath = sstableFilePath(table.id, level, m.opts.Dir)
+	table.bitsPerKey = m.opts.BloomBitsPerKey
+	table.blockSize = m.opts.BlockSize
+	table.compressor = m.compressor(level)
+	m.setReadOptions(table)
+	return table
+}
 
-		val, err := m.searchFromLevelWithSparseIndex(key, level)
-		if err != nil {
-			log.Errorf("search from level %d failed: %s", level, err.Error())
-			return nil, fmt.Errorf("search from level %d failed: %w", level, err)
-		}
-		if val != nil {
-			return val, nil
-		}
+// setReadOptions 按配置设置 SSTable 读取数据块的方式，新建和恢复的 SSTable 都需要在写入或加载文件之前设置
+func (m *Manager) setReadOptions(table *SSTable) {
+	table.verifyChecksums = m.opts.VerifyChecksums
+	table.cache = m.blockCache
+	table.files = m.tableCache
+	table.filterStats = m.filterStats
+	table.pinIndexAndFilter = !m.opts.CacheIndexAndFilter
+}
+
+// FilterStats 返回点查询时布隆过滤器的判断结果统计，可以据此计算实测的误判率
+func (m *Manager) FilterStats() FilterStats {
+	return m.filterStats.snapshot()
+}
+
+// BlockCacheStats 返回块缓存的统计信息，未配置块缓存时返回零值
+func (m *Manager) BlockCacheStats() cache.Stats {
+	if m.blockCache == nil {
+		return cache.Stats{}
+	}
+	return m.blockCache.Stats()
+}
+
+// compressor 返回 level 层新建 SSTable 使用的压缩算法，算法未注册时不压缩
+func (m *Manager) compressor(level int) compress.Compressor {
+	codec := m.opts.Compression[min(level, len(m.opts.Compression)-1)]
+	c, err := compress.Lookup(codec)
+	if err != nil {
+		log.Errorf("lookup compressor for level %d error: %s", level, err.Error())
+		return nil
 	}
+	return c
+}
 
-	// 5. 所有层级都未找到
-	return nil, nil
+// maxLevel 返回最高层级的编号
+func (m *Manager) maxLevel() int {
+	return m.opts.NumLevels - 1
 }
 
-// waitForCompactionIfNeeded 等待指定层级完成合并（如果正在合并）
-// 如果层级正在合并，则阻塞直到合并完成；否则立即返回
-// 返回可能因等待被中断而产生的错误
-func (m *Manager) waitForCompactionIfNeeded(level int) error {
+// SetSnapshots 设置获取存活快照序列号的回调
+func (m *Manager) SetSnapshots(snapshots func() []uint64) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// 快速检查：如果该层级没有在合并，直接返回
-	if !m.isCompacting(level) {
-		return nil
-	}
+	m.snapshots = snapshots
+}
 
-	// 等待合并完成
-	for m.isCompacting(level) {
-		m.compactionCond.Wait()
-	}
+// liveSnapshots 返回升序排列的存活快照序列号
+func (m *Manager) liveSnapshots() []uint64 {
+	m.mu.RLock()
+	snapshots := m.snapshots
+	m.mu.RUnlock()
 
-	return nil
+	if snapshots == nil {
+		return nil
+	}
+	seqs := snapshots()
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs
 }
 
-// isCompacting 辅助方法：检查指定层级是否正在合并
-func (m *Manager) isCompacting(level int) bool {
-	return m.compactingLevels[level]
+// currentVersion 返回当前版本并增加其引用计数，使用完毕后需要调用 Unref
+func (m *Manager) currentVersion() *Version {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	m.current.Ref()
+	return m.current
 }
 
-func (m *Manager) searchFromLevel0(key kv.Key) (kv.Value, error) {
-	tables := m.getLevelTables(minSSTableLevel)
+// MaxSequence 返回所有 SSTable 中记录的最大序列号
+func (m *Manager) MaxSequence() uint64 {
+	v := m.currentVersion()
+	defer v.Unref()
 
-	// 在当前层级中按表ID降序查找
-	for _, table := range tables {
-		val, err := m.searchFromTable(table, key)
-		if err != nil {
-			log.Errorf("search from table %s failed: %s", table.FilePath(), err.Error())
-			return nil, fmt.Errorf("search from table %s failed: %w", table.FilePath(), err)
-		}
-		if val != nil {
-			return val, nil
+	var maxSeq uint64
+	for _, tables := range v.levels {
+		for _, table := range tables {
+			maxSeq = max(maxSeq, table.MaxSequence())
 		}
 	}
-
-	return nil, nil
+	return maxSeq
 }
 
-// searchFromLevelWithSparseIndex 使用稀疏索引在指定层级查找key
-func (m *Manager) searchFromLevelWithSparseIndex(key kv.Key, level int) (kv.Value, error) {
+// Level0FileCount 返回 Level0 中的 SSTable 数量
+func (m *Manager) Level0FileCount() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	// 1. 使用稀疏索引找到可能包含该key的SSTable范围
-	// 稀疏索引是按MinKey排序的，我们可以找到最后一个MinKey小于等于key的SSTable
-	sparseIndexes := m.sparseIndexes[level-1]
-	index := sort.Search(len(sparseIndexes), func(i int) bool {
-		return bytes.Compare([]byte(sparseIndexes[i].Header.MinKey), []byte(key)) > 0
-	})
-	if index > 0 {
-		index-- // 调整到最后一个 <= key 的位置
+	return len(m.current.levels[minSSTableLevel])
+}
+
+// Search 从低层级向高层级查找 key 的最新版本，同层级按 id 降序查找
+// 返回找到的值或错误，如果未找到或命中删除标记返回 (nil, nil)
+func (m *Manager) Search(key kv.Key) ([]byte, error) {
+	return m.SearchWithSeq(key, kv.MaxSequence)
+}
+
+// SearchWithSeq 与 Search 相同，但只查找序列号不大于 seq 的版本，用于快照读。
+func (m *Manager) SearchWithSeq(key kv.Key, seq uint64) ([]byte, error) {
+	pair, err := m.SearchPair(key, seq)
+	if err != nil {
+		return nil, err
+	}
+	// 命中删除标记意味着更旧的层级中的数据已失效
+	if pair == nil || pair.IsDeleted() {
+		return nil, nil
 	}
+	return pair.Value, nil
+}
 
-	// 2. 在SSTable中查找key
-	if index < len(sparseIndexes) {
-		sst := sparseIndexes[index]
-		val, err := m.searchFromTable(sst, key)
-		if err != nil {
-			log.Errorf("search from table %s failed: %s", sst.FilePath(), err.Error())
-			return nil, fmt.Errorf("search from table %s failed: %w", sst.FilePath(), err)
-		}
-		if val != nil {
-			return val, nil
+// SearchPair 从低层级向高层级查找 key 的序列号不大于 seq 的最新版本，返回完整的记录（包括删除标记和合并操作数），
+// 未找到时返回 (nil, nil)。返回结果不考虑区间删除标记，调用方需要结合 RangeTombstones 判断。
+// 查找期间持有当前版本的引用，不会等待正在进行的合并。
+func (m *Manager) SearchPair(key kv.Key, seq uint64) (*kv.KeyValuePair, error) {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	return v.searchPair(key, seq)
+}
+
+// RangeTombstones 返回所有 SSTable 中的区间删除标记。返回的切片由当前版本缓存，调用方不能修改
+func (m *Manager) RangeTombstones() []kv.KeyValuePair {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	return v.tombstones[:len(v.tombstones):len(v.tombstones)]
+}
+
+// CoveringSequence 返回 SSTable 中覆盖 key 且对 snapshot 可见的区间删除标记的最大序列号，没有时返回 0。
+// 只检查 key 范围包含 key 的 SSTable，开销与区间删除标记的总数无关
+func (m *Manager) CoveringSequence(key kv.Key, snapshot uint64) uint64 {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	var seq uint64
+	for _, table := range v.rangeDelTables {
+		if table.Header.MinKey <= key && key <= table.Header.MaxKey {
+			seq = max(seq, kv.CoveringSequence(table.RangeTombstones(), key, snapshot))
 		}
 	}
+	return seq
+}
 
-	return nil, nil
+// NewIterators 返回与 [lower, upper) 存在交集的所有 SSTable 的迭代器，按数据从新到旧排序：
+// Level0 按 id 降序，随后依次为 Level1 及以上各层。upper 为空表示没有上界。
+// 每个迭代器都持有当前版本的引用，关闭之前其中的文件不会被合并删除。
+func (m *Manager) NewIterators(lower, upper kv.Key) []*Iterator {
+	v := m.currentVersion()
+	defer v.Unref()
+
+	iters := make([]*Iterator, 0)
+	for level := minSSTableLevel; level <= m.maxLevel(); level++ {
+		for _, table := range v.levels[level] {
+			if table.Header.MaxKey < lower || (upper != "" && table.Header.MinKey >= upper) {
+				continue
+			}
+			it := NewSSTableIterator(table)
+			v.Ref()
+			it.version = v
+			iters = append(iters, it)
+		}
+	}
+	return iters
 }
 
-func (m *Manager) searchFromTable(sst *SSTable, key kv.Key) (kv.Value, error) {
-	if !sst.MayContain(key) {
-		return nil, nil
+// Recover 重放 MANIFEST，加载最后一个已提交版本中所有 SSTable 的元数据信息到内存中。
+// 不在该版本中的文件（崩溃时残留的未提交文件或尚未删除的旧文件）会被忽略。
+// 没有 CURRENT 文件时按层级目录中的文件恢复，下一次变更时会为其创建 MANIFEST。
+func (m *Manager) Recover() error {
+	state, err := readManifest(m.opts.Dir)
+	if err != nil {
+		log.Errorf("read manifest in %s error: %s", m.opts.Dir, err.Error())
+		return fmt.Errorf("read manifest failed: %w", err)
+	}
+	if state == nil {
+		return m.recoverFromLevelDirs()
 	}
 
-	// 使用迭代器查找
-	it := NewSSTableIterator(sst)
-	defer it.Close()
+	// 按 id 升序加载
+	files := make([]FileMeta, 0, len(state.files))
+	for _, file := range state.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ID < files[j].ID
+	})
 
-	it.Seek(key)
-	if it.Valid() && it.Key() == key {
-		return it.Value()
+	maxID := state.lastFileID
+	tables := make([]*SSTable, 0, len(files))
+	for _, file := range files {
+		if file.Level < minSSTableLevel || file.Level > m.maxLevel() {
+			log.Errorf("recover: file %d in manifest %s has invalid level %d", file.ID, state.path, file.Level)
+			return fmt.Errorf("file %d has invalid level %d", file.ID, file.Level)
+		}
+		filePath := sstableFilePath(file.ID, file.Level, m.opts.Dir)
+		table := NewRecoverSSTable(file.Level)
+		table.id = file.ID
+		m.setReadOptions(table)
+		if err := table.DecodeFrom(filePath); err != nil {
+			log.Errorf("recover: load meta for file %s error: %s", filePath, err.Error())
+			return fmt.Errorf("load meta for file %s failed: %w", filePath, err)
+		}
+		tables = append(tables, table)
+		maxID = max(maxID, file.ID)
 	}
-	return nil, nil
+	m.apply(nil, tables)
+
+	m.nextID.Store(max(m.nextID.Load(), maxID))
+	return nil
 }
 
-// Recover 加载所有层中 SSTable 的元数据信息到内存中
-func (m *Manager) Recover() error {
+// recoverFromLevelDirs 加载所有层级目录中 SSTable 的元数据信息到内存中
+func (m *Manager) recoverFromLevelDirs() error {
 	var maxID uint64
-	ResetIDGenerator()
+	tables := make([]*SSTable, 0)
 
-	for level := minSSTableLevel; level <= maxSSTableLevel; level++ {
-		dir := sstableLevelPath(level, config.GetSSTablePath())
+	for level := minSSTableLevel; level <= m.maxLevel(); level++ {
+		dir := sstableLevelPath(level, m.opts.Dir)
 		files, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			// 层级目录在第一次写入该层级时才创建
+			continue
+		}
 		if err != nil {
 			log.Errorf("failed to read directory %s: %s", dir, err.Error())
 			return fmt.Errorf("read directory %s failed: %w", dir, err)
@@ -253,151 +524,179 @@ func (m *Manager) Recover() error {
 
 		// 加载每个文件的元数据
 		for _, file := range files {
-			if file.IsDir() {
+			// 跳过未完成写入的临时文件，只读模式下不能删除
+			if file.IsDir() || filepath.Ext(file.Name()) != "."+sstFileSuffix {
 				continue
 			}
 
 			filePath := filepath.Join(dir, file.Name())
 			table := NewRecoverSSTable(level)
 			table.id = util.ExtractID(file.Name())
+			m.setReadOptions(table)
 
 			if err := table.DecodeFrom(filePath); err != nil {
 				log.Errorf("recover: load meta for file %s error: %s", filePath, err.Error())
 				return fmt.Errorf("load meta for file %s failed: %w", filePath, err)
 			}
 
-			m.addTable(table)
+			tables = append(tables, table)
 		}
 	}
+	m.apply(nil, tables)
 
-	idGenerator.Add(maxID)
+	m.nextID.Store(max(m.nextID.Load(), maxID))
 	return nil
 }
 
-// addTable 将新的 SSTable 添加到内存中，保持层级和排序（新文件在最前面）
+// addTable 将新的 SSTable 添加到当前版本中，不记录 MANIFEST
 func (m *Manager) addTable(table *SSTable) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	table.DataBlock = block.NewDataBlock()
-	level := table.level
-	tables := m.levels[level]
+	m.apply(nil, []*SSTable{table})
+}
 
-	// 直接插入到列表开头（保持降序）
-	m.levels[level] = append([]*SSTable{table}, tables...)
+// apply 基于当前版本生成应用了 edit 和 added 的新版本并替换当前版本，旧版本在所有读者释放之后回收。
+// 新增的 SSTable 已经写入磁盘，不再在内存中保留数据块。
+func (m *Manager) apply(edit *VersionEdit, added []*SSTable) {
+	for _, table := range added {
+		table.releaseData()
+	}
 
-	// 添加到文件索引
-	m.fileIndex[table.FilePath()] = table
+	m.mu.Lock()
+	old := m.current
+	m.current = old.apply(edit, added)
+	m.mu.Unlock()
 
-	m.totalMap[level] = append(m.totalMap[level], table.FilePath())
+	old.Unref()
+}
 
-	// 更新稀疏索引（仅对 Level 1 及以上层级）
-	if level > minSSTableLevel {
-		// 根据最小 Key 更新稀疏索引，进行插入
-		sparseIndexes := m.sparseIndexes[level-1]
-		index := sort.Search(len(sparseIndexes), func(i int) bool {
-			return bytes.Compare([]byte(sparseIndexes[i].Header.MinKey), []byte(table.Header.MinKey)) > 0
-		})
-		sparseIndexes = append(sparseIndexes[:index], append([]*SSTable{table}, sparseIndexes[index:]...)...)
-		m.sparseIndexes[level-1] = sparseIndexes
+// writeTables 将新的 SSTable 写入磁盘，此时它们还没有记录到 MANIFEST 中
+func (m *Manager) writeTables(tables []*SSTable) error {
+	for _, table := range tables {
+		if err := table.EncodeTo(table.FilePath()); err != nil {
+			log.Errorf("encode sstable to file %s error: %s", table.FilePath(), err.Error())
+			return fmt.Errorf("encode sstable failed: %w", err)
+		}
 	}
+	return nil
 }
 
-// removeOldSSTables 删除旧的 SSTable 文件
-func (m *Manager) removeOldSSTables(oldFiles []string, level int) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// addNewSSTables 将新的 SSTable 写入磁盘，记录到 MANIFEST 并添加到内存中
+func (m *Manager) addNewSSTables(newTables []*SSTable) error {
+	if err := m.writeTables(newTables); err != nil {
+		return err
+	}
 
-	for _, oldPath := range oldFiles {
-		if sst, exists := m.fileIndex[oldPath]; exists {
-			// 从层级中移除
-			tables := m.levels[level]
-			for i, t := range tables {
-				if t.id == sst.id {
-					m.levels[level] = append(tables[:i], tables[i+1:]...)
-					break
-				}
-			}
-			// 从稀疏索引中移除
-			if level > minSSTableLevel {
-				sparseIndexes := m.sparseIndexes[level-1]
-				for i, t := range sparseIndexes {
-					if t.id == sst.id {
-						m.sparseIndexes[level-1] = append(sparseIndexes[:i], sparseIndexes[i+1:]...)
-						break
-					}
-				}
-			}
-		}
-		// 从文件索引中移除
-		delete(m.fileIndex, oldPath)
+	edit := &VersionEdit{}
+	for _, table := range newTables {
+		edit.AddFile(table.Meta())
+	}
+	return m.logAndApply(edit, newTables)
+}
 
-		// 从 totalMap 中移除
-		m.totalMap[level] = util.RemoveString(m.totalMap[level], oldPath)
+// logAndApply 将 edit 追加到 MANIFEST 并落盘，成功之后再把变更应用到内存中，added 为 edit 中新增文件对应的 SSTable。
+// 记录落盘之前崩溃时，恢复结果仍然是变更之前的版本。
+func (m *Manager) logAndApply(edit *VersionEdit, added []*SSTable) error {
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
+
+	// 新文件的目录项需要在记录 MANIFEST 之前落盘
+	dirs := make(map[string]bool)
+	for _, table := range added {
+		dir := filepath.Dir(table.FilePath())
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
 
-		// 物理删除文件
-		if err := os.Remove(oldPath); err != nil {
-			log.Errorf("remove file %s error: %s", oldPath, err.Error())
-			return fmt.Errorf("remove file %s failed: %w", oldPath, err)
+	edit.LastFileID = m.nextID.Load()
+	if m.manifest == nil || m.manifest.size >= maxManifestFileSize {
+		if err := m.newManifest(edit); err != nil {
+			return err
+		}
+	} else if err := m.manifest.append(edit); err != nil {
+		// MANIFEST 末尾可能留下不完整的记录，不再向其追加，下一次变更创建新的 MANIFEST
+		if closeErr := m.manifest.close(); closeErr != nil {
+			log.Errorf("close manifest %s error: %s", m.manifest.path, closeErr.Error())
 		}
+		m.manifest = nil
+		return err
 	}
 
+	m.apply(edit, added)
 	return nil
 }
 
-// addNewSSTables 添加新的 SSTable 到指定层级
-func (m *Manager) addNewSSTables(newTables []*SSTable) error {
-	for _, nt := range newTables {
-		// 写入磁盘
-		if err := nt.EncodeTo(nt.FilePath()); err != nil {
-			log.Errorf("encode sstable to file %s error: %s", nt.FilePath(), err.Error())
-			return fmt.Errorf("encode sstable failed: %w", err)
+// newManifest 创建新的 MANIFEST，依次写入当前版本的快照和 edit，然后将 CURRENT 指向新文件并删除旧的 MANIFEST。
+// 调用方需要持有 manifestMu。
+func (m *Manager) newManifest(edit *VersionEdit) error {
+	id := m.nextID.Add(1)
+	edit.LastFileID = id
+	snapshot := &VersionEdit{LastFileID: id}
+	v := m.currentVersion()
+	for _, tables := range v.levels {
+		for _, table := range tables {
+			snapshot.AddFile(table.Meta())
 		}
+	}
+	v.Unref()
 
-		// 添加到内存
-		m.addTable(nt)
+	mf, err := createManifest(m.opts.Dir, id, snapshot)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(mf.path)
+	if err = mf.append(edit); err == nil {
+		err = setCurrent(m.opts.Dir, name)
+	}
+	if err != nil {
+		_ = mf.close()
+		return err
 	}
 
+	if m.manifest != nil {
+		if err = m.manifest.close(); err != nil {
+			log.Errorf("close manifest %s error: %s", m.manifest.path, err.Error())
+		}
+	}
+	m.manifest = mf
+	removeObsoleteManifests(m.opts.Dir, name)
 	return nil
 }
 
-// getLevelTables 获取指定层级的所有 SSTable（已排序）
-func (m *Manager) getLevelTables(level int) []*SSTable {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// closeManifest 关闭正在追加的 MANIFEST
+func (m *Manager) closeManifest() {
+	m.manifestMu.Lock()
+	defer m.manifestMu.Unlock()
 
-	tables := m.levels[level]
-	if tables == nil {
-		return nil
+	if m.manifest == nil {
+		return
 	}
-
-	// 返回副本以避免外部修改
-	result := make([]*SSTable, len(tables))
-	copy(result, tables)
-	return result
+	if err := m.manifest.close(); err != nil {
+		log.Errorf("close manifest %s error: %s", m.manifest.path, err.Error())
+	}
+	m.manifest = nil
 }
 
-// getFilesByLevel 获取指定层级的所有文件路径
-func (m *Manager) getFilesByLevel(level int) []string {
+// getLevelTables 获取当前版本中指定层级的所有 SSTable（按 id 降序）
+func (m *Manager) getLevelTables(level int) []*SSTable {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return append([]string{}, m.totalMap[level]...)
-}
-
-// isLevelNeedToBeMerged 检查层级是否需要合并
-func (m *Manager) isLevelNeedToBeMerged(level int) bool {
-	return len(m.getFilesByLevel(level)) > maxFileNumsInLevel(level)
-}
-
-func maxFileNumsInLevel(level int) int {
-	return int(math.Pow(float64(levelSizeBase), float64(level+1)))
+	// 版本不可变，直接返回即可
+	return m.current.levels[level]
 }
 
-func (m *Manager) getSSTableByPath(path string) (*SSTable, bool) {
+// getFilesByLevel 获取当前版本中指定层级的所有文件路径（按 id 升序，即从旧到新）
+func (m *Manager) getFilesByLevel(level int) []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	sst, ok := m.fileIndex[path]
-	return sst, ok
+	files := make([]string, 0, len(m.current.levels[level]))
+	for _, table := range m.current.files(level) {
+		files = append(files, table.FilePath())
+	}
+	return files
 }
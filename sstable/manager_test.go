@@ -2,20 +2,26 @@ package sstable
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
-	"time"
 
 	"github.com/stretchr/testify/assert"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/memtable"
 	"github.com/xmh1011/go-lsm/sstable/block"
 	"github.com/xmh1011/go-lsm/sstable/bloom"
+	"github.com/xmh1011/go-lsm/sstable/compress"
 )
 
+// newTestManager 创建以临时目录为根目录、使用默认配置的 Manager
+func newTestManager(t *testing.T) *Manager {
+	return NewSSTableManager(DefaultOptions(t.TempDir()))
+}
+
 func TestSSTableManagerCreateNewSSTable(t *testing.T) {
 	tmp := t.TempDir()
 
@@ -27,8 +33,7 @@ func TestSSTableManagerCreateNewSSTable(t *testing.T) {
 	// 冻结为 IMemTable
 	imem := memtable.NewIMemTable(mem)
 	// 构建 Manager
-	ResetIDGenerator()
-	manager := NewSSTableManager()
+	manager := newTestManager(t)
 
 	// 调用 CreateNewSSTable
 	err := manager.CreateNewSSTable(imem)
@@ -43,12 +48,26 @@ func TestSSTableManagerCreateNewSSTable(t *testing.T) {
 	tables := manager.getLevelTables(0)
 	assert.Len(t, tables, 1)
 	assert.Equal(t, uint64(1), tables[0].id)
+	assert.Equal(t, 1, manager.Level0FileCount())
 
 	// 检查 WAL 文件是否被 Clean 删除
 	_, err = os.Stat(filepath.Join(tmp, fmt.Sprintf("%d.wal", mem.ID())))
 	assert.True(t, os.IsNotExist(err), "WAL 文件应被 Clean 删除")
 }
 
+// TestSSTableManagerCreateNewSSTableEmpty 测试空的 IMemTable 不生成 SSTable，但 WAL 仍被删除
+func TestSSTableManagerCreateNewSSTableEmpty(t *testing.T) {
+	tmp := t.TempDir()
+	mem := memtable.NewMemTable(2, tmp)
+	manager := newTestManager(t)
+
+	assert.NoError(t, manager.CreateNewSSTable(memtable.NewIMemTable(mem)))
+	assert.Equal(t, 0, manager.Level0FileCount())
+
+	_, err := os.Stat(filepath.Join(tmp, fmt.Sprintf("%d.wal", mem.ID())))
+	assert.True(t, os.IsNotExist(err), "WAL 文件应被 Clean 删除")
+}
+
 func TestSSTableManagerSearch(t *testing.T) {
 	// 1. 创建临时目录和测试数据
 	dir := t.TempDir()
@@ -66,8 +85,7 @@ func TestSSTableManagerSearch(t *testing.T) {
 		{Key: "key3", Value: []byte("value3")},
 	}
 
-	// 创建数据块和过滤器
-	sst.FilterBlock = bloom.NewBloomFilter(1024, 3)
+	// 创建数据块
 	for _, record := range testRecords {
 		sst.Add(record)
 	}
@@ -79,7 +97,7 @@ func TestSSTableManagerSearch(t *testing.T) {
 	assert.FileExists(t, sst.filePath)
 
 	// 4. 初始化SSTableManager
-	manager := NewSSTableManager()
+	manager := newTestManager(t)
 	err = manager.addNewSSTables([]*SSTable{sst})
 	assert.NoError(t, err)
 
@@ -137,8 +155,7 @@ func TestSSTableManagerSearch(t *testing.T) {
 
 func TestSSTableManagerRecover(t *testing.T) {
 	tmp := t.TempDir()
-	config.Conf.SSTablePath = tmp
-	for level := 0; level <= maxSSTableLevel; level++ {
+	for level := 0; level < defaultNumLevels; level++ {
 		dir := sstableLevelPath(level, tmp)
 		err := os.MkdirAll(dir, 0755)
 		assert.NoError(t, err, "Failed to create directory for level %d", level)
@@ -153,7 +170,6 @@ func TestSSTableManagerRecover(t *testing.T) {
 		Key:   "key1",
 		Value: []byte("value1"),
 	}
-	sst.FilterBlock = bloom.NewBloomFilter(1024, 3)
 	sst.Add(&record)
 	sst.Header = block.NewHeader(record.Key, record.Key)
 
@@ -163,7 +179,7 @@ func TestSSTableManagerRecover(t *testing.T) {
 	assert.FileExists(t, sst.filePath)
 
 	// 初始化 Manager 并恢复
-	manager := NewSSTableManager()
+	manager := NewSSTableManager(DefaultOptions(tmp))
 	err = manager.Recover() // 从磁盘加载元数据
 	assert.NoError(t, err)
 
@@ -171,34 +187,12 @@ func TestSSTableManagerRecover(t *testing.T) {
 	tables := manager.getLevelTables(0)
 	assert.Len(t, tables, 1)
 	assert.Equal(t, uint64(1), tables[0].id)
-}
-
-func TestSSTableManagerRemoveOldSSTables(t *testing.T) {
-	dir := t.TempDir()
-
-	// 1. 创建旧 SSTable 文件
-	sst1 := NewSSTable()
-	sst1.id = 1
-	sst1.level = 0
-	sst1.filePath = filepath.Join(dir, "1.sst")
-	err := os.WriteFile(sst1.filePath, []byte("dummy"), 0644)
-	assert.NoError(t, err)
-
-	// 2. 初始化 Manager 并删除旧文件
-	manager := NewSSTableManager()
-	manager.fileIndex[sst1.filePath] = sst1 // 手动注册到索引
-	manager.totalMap[0] = []string{sst1.filePath}
-
-	err = manager.removeOldSSTables([]string{sst1.filePath}, 0)
-	assert.NoError(t, err)
-
-	// 3. 验证文件已被删除
-	_, err = os.Stat(sst1.filePath)
-	assert.True(t, os.IsNotExist(err))
+	// 新的 SSTable 接续已恢复的最大 ID
+	assert.Equal(t, uint64(2), manager.newTable(0).id)
 }
 
 func TestSSTableManagerAddTableOrderingAndIndex(t *testing.T) {
-	manager := NewSSTableManager()
+	manager := newTestManager(t)
 
 	sst1 := NewSSTable()
 	sst1.id = 2
@@ -216,69 +210,47 @@ func TestSSTableManagerAddTableOrderingAndIndex(t *testing.T) {
 	manager.addTable(sst2)
 
 	tables := manager.getLevelTables(1)
-	assert.Equal(t, []uint64{1, 2}, []uint64{tables[0].id, tables[1].id}, "SSTable 应该按 id 降序插入")
+	assert.Equal(t, []uint64{2, 1}, []uint64{tables[0].id, tables[1].id}, "SSTable 应该按 id 降序排列")
 
-	sparse := manager.sparseIndexes[0]
+	sparse := manager.current.sparseIndexes[0]
 	assert.Equal(t, 2, len(sparse))
 	assert.Equal(t, sst1.id, sparse[0].id)
 }
 
-func TestWaitForCompactionIfNeeded(t *testing.T) {
-	manager := NewSSTableManager()
-	level := 2
-
-	// 模拟合并正在进行
-	manager.compactingLevels[level] = true
-
-	done := make(chan struct{})
-	go func() {
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			manager.mu.Lock()
-			manager.compactingLevels[level] = false
-			manager.compactionCond.Broadcast()
-			manager.mu.Unlock()
-		}()
-		err := manager.waitForCompactionIfNeeded(level)
-		assert.NoError(t, err)
-		close(done)
-	}()
-
-	select {
-	case <-done:
-	case <-time.After(1 * time.Second):
-		t.Fatal("waitForCompactionIfNeeded 超时")
-	}
-}
-
+// TestIsLevelNeedToBeMerged 测试 Level0 按文件数量、其余层级按数据大小判断是否需要合并
 func TestIsLevelNeedToBeMerged(t *testing.T) {
-	manager := NewSSTableManager()
+	manager := newTestManager(t)
+
+	// 模拟 Level0 达到触发数量
+	for i := 0; i < manager.opts.Level0CompactionTrigger; i++ {
+		assert.False(t, manager.isLevelNeedToBeMerged(minSSTableLevel))
+		sst := manager.newTable(minSSTableLevel)
+		sst.filePath = filepath.Join("mock", fmt.Sprintf("%d.sst", sst.id))
+		manager.addTable(sst)
+	}
+	assert.True(t, manager.isLevelNeedToBeMerged(minSSTableLevel))
 
-	// 模拟超过限制的文件
+	// 模拟超过目标大小的文件，level 2 的目标大小是基准层级的 LevelMultiplier 倍
 	level := 2
-	numsInLevel := maxFileNumsInLevel(level)
-	paths := make([]string, numsInLevel+1)
-	for i := 0; i < numsInLevel+1; i++ {
-		paths[i] = filepath.Join("mock", fmt.Sprintf("%d.sst", i))
-	}
-	manager.totalMap[level] = paths
+	target := manager.opts.MaxBytesForLevelBase * uint64(manager.opts.LevelMultiplier)
+	sst := manager.newTable(level)
+	sst.filePath = filepath.Join("mock", fmt.Sprintf("%d.sst", sst.id))
+	sst.size = target - 1
+	manager.addTable(sst)
+	assert.False(t, manager.isLevelNeedToBeMerged(level))
 
+	sst = manager.newTable(level)
+	sst.filePath = filepath.Join("mock", fmt.Sprintf("%d.sst", sst.id))
+	sst.size = 1
+	manager.addTable(sst)
 	assert.True(t, manager.isLevelNeedToBeMerged(level))
-}
-
-func TestGetSSTableByPath(t *testing.T) {
-	manager := NewSSTableManager()
-
-	sst := NewSSTable()
-	sst.id = 42
-	sst.level = 0
-	sst.filePath = "mock/path/42.sst"
 
+	// 最后一层不需要合并
+	sst = manager.newTable(manager.maxLevel())
+	sst.filePath = filepath.Join("mock", fmt.Sprintf("%d.sst", sst.id))
+	sst.size = math.MaxUint32
 	manager.addTable(sst)
-
-	got, ok := manager.getSSTableByPath("mock/path/42.sst")
-	assert.True(t, ok)
-	assert.Equal(t, uint64(42), got.id)
+	assert.False(t, manager.isLevelNeedToBeMerged(manager.maxLevel()))
 }
 
 func TestAddNewSSTablesFailToWrite(t *testing.T) {
@@ -287,15 +259,14 @@ func TestAddNewSSTablesFailToWrite(t *testing.T) {
 	sst.level = 0
 	sst.filePath = "/invalid/path/1.sst" // 故意非法路径
 
-	manager := NewSSTableManager()
+	manager := newTestManager(t)
 	err := manager.addNewSSTables([]*SSTable{sst})
 	assert.Error(t, err)
 }
 
 func TestRecoverMultipleLevels(t *testing.T) {
 	tmp := t.TempDir()
-	config.Conf.SSTablePath = tmp
-	for level := 0; level <= maxSSTableLevel; level++ {
+	for level := 0; level < defaultNumLevels; level++ {
 		dir := sstableLevelPath(level, tmp)
 		err := os.MkdirAll(dir, 0755)
 		assert.NoError(t, err, "Failed to create directory for level %d", level)
@@ -305,19 +276,216 @@ func TestRecoverMultipleLevels(t *testing.T) {
 		sst.level = level
 		sst.filePath = filepath.Join(dir, fmt.Sprintf("%d.sst", sst.id))
 		sst.Header = block.NewHeader("a", "z")
-		sst.FilterBlock = bloom.NewBloomFilter(1024, 3)
 		sst.Add(&kv.KeyValuePair{Key: "a", Value: []byte("x")})
 		err = sst.EncodeTo(sst.filePath)
 		assert.NoError(t, err)
 	}
 
-	manager := NewSSTableManager()
+	manager := NewSSTableManager(DefaultOptions(tmp))
 	err := manager.Recover()
 	assert.NoError(t, err)
 
-	for level := 0; level <= maxSSTableLevel; level++ {
+	for level := 0; level <= manager.maxLevel(); level++ {
 		tables := manager.getLevelTables(level)
 		assert.Len(t, tables, 1)
 		assert.Equal(t, uint64(level+1), tables[0].id)
 	}
 }
+
+// TestSSTableManagerOptions 测试层级数量、层级目标大小和布隆过滤器参数按 Manager 配置生效
+func TestSSTableManagerOptions(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.NumLevels = 3
+	opts.LevelMultiplier = 4
+	opts.MaxBytesForLevelBase = 1000
+	opts.BloomBitsPerKey = 16
+	manager := NewSSTableManager(opts)
+
+	assert.Equal(t, 2, manager.maxLevel())
+	assert.Len(t, manager.current.levels, 3)
+	assert.Len(t, manager.current.sparseIndexes, 2)
+	targets, baseLevel := manager.levelTargets(manager.current)
+	assert.Equal(t, []uint64{0, 1000, 4000}, targets)
+	assert.Equal(t, 1, baseLevel)
+
+	table := manager.newTable(0)
+	for i := 0; i < 100; i++ {
+		table.Add(&kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("key%03d", i)), Value: []byte("v")})
+	}
+	table.finish()
+	assert.InDelta(t, 1600, table.FilterBlock.Cap(), 1)
+	assert.Equal(t, opts, manager.Options())
+
+	// 未设置的配置项使用默认值
+	manager = NewSSTableManager(Options{Dir: t.TempDir()})
+	assert.Equal(t, DefaultOptions(manager.opts.Dir), manager.Options())
+
+	// 层级目录不存在时恢复为空
+	assert.NoError(t, manager.Recover())
+	assert.Zero(t, manager.Level0FileCount())
+}
+
+// TestSSTableManagerCompression 测试新建 SSTable 按所在层级选择压缩算法
+func TestSSTableManagerCompression(t *testing.T) {
+	manager := newTestManager(t)
+	codecs := make([]compress.Codec, 0)
+	for level := 0; level <= manager.maxLevel(); level++ {
+		codecs = append(codecs, manager.newTable(level).compressor.Codec())
+	}
+	assert.Equal(t, DefaultCompression(defaultNumLevels), codecs)
+	assert.Equal(t, []compress.Codec{
+		compress.NoCompression, compress.NoCompression,
+		compress.SnappyCompression, compress.SnappyCompression, compress.SnappyCompression, compress.SnappyCompression,
+		compress.ZlibCompression,
+	}, codecs)
+
+	// 层级多于配置项时使用最后一项
+	opts := DefaultOptions(t.TempDir())
+	opts.Compression = []compress.Codec{compress.NoCompression, compress.SnappyCompression}
+	manager = NewSSTableManager(opts)
+	assert.Equal(t, compress.NoCompression, manager.newTable(0).compressor.Codec())
+	assert.Equal(t, compress.SnappyCompression, manager.newTable(manager.maxLevel()).compressor.Codec())
+
+	// 未注册的算法不压缩
+	opts.Compression = []compress.Codec{200}
+	manager = NewSSTableManager(opts)
+	assert.Nil(t, manager.newTable(1).compressor)
+
+	// 压缩后的文件可以正常读取
+	manager = newTestManager(t)
+	table := manager.newTable(manager.maxLevel())
+	table.Add(&kv.KeyValuePair{Key: "k", Value: []byte(strings.Repeat("v", 1024))})
+	table.Header.MinKey, table.Header.MaxKey = "k", "k"
+	assert.NoError(t, table.EncodeTo(table.FilePath()))
+	assert.Less(t, table.Footer.DataHandle.Size, int64(1024))
+	table.releaseData()
+	val, err := searchFromTable(table, "k", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, kv.Value(strings.Repeat("v", 1024)), val.Value)
+}
+
+func TestSSTableManagerBlockCache(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.BlockCacheSize = 1024 * 1024
+	manager := NewSSTableManager(opts)
+	flushTestPairs(t, manager, []string{"a", "b", "c"})
+	assert.Zero(t, manager.BlockCacheStats().Inserts)
+
+	// 第一次读取数据块时未命中并放入缓存，之后读取同一个数据块都命中
+	val, err := manager.Search("b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), val)
+	stats := manager.BlockCacheStats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(1), stats.Inserts)
+	assert.Positive(t, stats.Usage)
+
+	for _, key := range []string{"a", "b", "c"} {
+		val, err = manager.Search(kv.Key(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+	stats = manager.BlockCacheStats()
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, opts.BlockCacheSize, stats.Capacity)
+
+	// 合并读取的数据块不放入缓存
+	table := manager.getLevelTables(0)[0]
+	it, err := openTableIterator(table)
+	assert.NoError(t, err)
+	for ; it.Valid(); it.Next() {
+	}
+	it.Close()
+	assert.Equal(t, stats, manager.BlockCacheStats())
+
+	// 未配置块缓存时统计信息为零值
+	assert.Zero(t, newTestManager(t).BlockCacheStats())
+}
+
+// TestSSTableManagerCacheIndexAndFilter 测试索引块和布隆过滤器不常驻内存时通过块缓存读取
+func TestSSTableManagerCacheIndexAndFilter(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.BlockCacheSize = 1024 * 1024
+	opts.CacheIndexAndFilter = true
+	manager := NewSSTableManager(opts)
+	flushTestPairs(t, manager, []string{"a", "b", "c"})
+
+	table := manager.getLevelTables(0)[0]
+	assert.Nil(t, table.IndexBlock)
+	assert.Nil(t, table.FilterBlock)
+
+	val, err := manager.Search("b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), val)
+	// 布隆过滤器、索引块和数据块各一次
+	assert.Equal(t, uint64(3), manager.BlockCacheStats().Inserts)
+	assert.True(t, table.MayContain("c"))
+
+	val, err = manager.Search("c")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("c"), val)
+	assert.Equal(t, uint64(3), manager.BlockCacheStats().Inserts)
+
+	// 恢复的 SSTable 同样不保留索引块和布隆过滤器
+	recovered := NewSSTableManager(opts)
+	assert.NoError(t, recovered.Recover())
+	table = recovered.getLevelTables(0)[0]
+	assert.Nil(t, table.IndexBlock)
+	assert.Nil(t, table.FilterBlock)
+	pairs, err := table.GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 3)
+
+	// 没有块缓存时每次从文件中读取
+	opts.BlockCacheSize = 0
+	uncached := NewSSTableManager(opts)
+	assert.NoError(t, uncached.Recover())
+	val, err = uncached.Search("a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+	assert.True(t, uncached.getLevelTables(0)[0].MayContain("a"))
+}
+
+// TestSSTableManagerFilterStats 测试布隆过滤器按 key 的数量创建，并统计实测的误判率
+func TestSSTableManagerFilterStats(t *testing.T) {
+	manager := newTestManager(t)
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("key%04d", i*2))
+	}
+	flushTestPairs(t, manager, keys)
+
+	table := manager.getLevelTables(0)[0]
+	assert.InDelta(t, 1000*bloom.DefaultBitsPerKey, table.FilterBlock.Cap(), 1)
+
+	for _, key := range keys {
+		val, err := manager.Search(kv.Key(key))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+	// key 范围之外的查询不经过布隆过滤器
+	_, err := manager.Search("zzz")
+	assert.NoError(t, err)
+	stats := manager.FilterStats()
+	assert.Equal(t, uint64(len(keys)), stats.TruePositives)
+	assert.Zero(t, stats.Negatives+stats.FalsePositives)
+	assert.Zero(t, stats.FalsePositiveRate())
+
+	// key 范围之内不存在的 key
+	for i := 0; i < 999; i++ {
+		val, err := manager.Search(kv.Key(fmt.Sprintf("key%04d", i*2+1)))
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+	}
+	stats = manager.FilterStats()
+	assert.Equal(t, uint64(999), stats.Negatives+stats.FalsePositives)
+	assert.Less(t, stats.FalsePositiveRate(), 0.05)
+
+	// 布隆过滤器的参数记录在文件中，与加载时的配置无关
+	opts := DefaultOptions(manager.opts.Dir)
+	opts.BloomBitsPerKey = 2
+	recovered := NewSSTableManager(opts)
+	assert.NoError(t, recovered.Recover())
+	assert.True(t, table.FilterBlock.Equal(recovered.getLevelTables(0)[0].FilterBlock))
+}
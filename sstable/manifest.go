@@ -0,0 +1,379 @@
+// MANIFEST 以追加日志的形式记录 SSTable 集合（Version）的每一次变更（VersionEdit），
+// CURRENT 文件保存当前生效的 MANIFEST 文件名。flush 和合并先写好新的 SSTable 文件，
+// 再把新增和删除的文件作为一条记录原子地追加到 MANIFEST，之后才删除旧文件。
+// 恢复时只重放 MANIFEST，因此崩溃时残留的未提交文件或尚未删除的旧文件都不会影响恢复结果。
+/*
+┌──────────────┬─────────────┬──────────────────────────────────────────────┐
+│ length (4B)  │ CRC32C (4B) │ payload: tag (1B) | fields | tag | fields ... │
+└──────────────┴─────────────┴──────────────────────────────────────────────┘
+length 为 payload 的长度，CRC32C 覆盖 payload，整数均为小端编码
+*/
+
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const (
+	manifestFilePrefix       = "MANIFEST-"
+	currentFileName          = "CURRENT"
+	manifestRecordHeaderSize = 4 + 4
+	maxManifestRecordSize    = 1 << 30
+
+	// maxManifestFileSize 是 MANIFEST 的大小上限，超过之后下一次变更会写入新的 MANIFEST，新文件以当前版本的快照开头
+	maxManifestFileSize = 4 * 1024 * 1024 // 4MB
+)
+
+// VersionEdit 的字段标签
+const (
+	tagLastFileID  byte = 1
+	tagAddedFile   byte = 2
+	tagDeletedFile byte = 3
+)
+
+var manifestCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errIncompleteManifestRecord 表示记录超出了文件末尾，通常是追加记录时崩溃造成的，该记录没有生效
+var errIncompleteManifestRecord = errors.New("incomplete manifest record")
+
+// FileMeta 是 MANIFEST 中记录的 SSTable 元信息
+type FileMeta struct {
+	Level  int
+	ID     uint64
+	Size   uint64
+	MinKey kv.Key
+	MaxKey kv.Key
+	MinSeq uint64
+	MaxSeq uint64
+}
+
+// DeletedFile 标识 VersionEdit 中被删除的 SSTable
+type DeletedFile struct {
+	Level int
+	ID    uint64
+}
+
+// VersionEdit 是对 SSTable 集合的一次原子变更
+type VersionEdit struct {
+	// LastFileID 是已分配的最大文件 ID，为 0 时表示未记录
+	LastFileID uint64
+	Added      []FileMeta
+	Deleted    []DeletedFile
+}
+
+// AddFile 记录新增的 SSTable
+func (e *VersionEdit) AddFile(meta FileMeta) {
+	e.Added = append(e.Added, meta)
+}
+
+// DeleteFile 记录被删除的 SSTable
+func (e *VersionEdit) DeleteFile(level int, id uint64) {
+	e.Deleted = append(e.Deleted, DeletedFile{Level: level, ID: id})
+}
+
+// EncodeTo 将 VersionEdit 编码到 w 中
+func (e *VersionEdit) EncodeTo(w io.Writer) error {
+	if e.LastFileID != 0 {
+		if err := writeFields(w, tagLastFileID, e.LastFileID); err != nil {
+			return fmt.Errorf("encode last file id: %w", err)
+		}
+	}
+	for _, file := range e.Added {
+		if err := writeFields(w, tagAddedFile, uint32(file.Level), file.ID, file.Size, file.MinSeq, file.MaxSeq); err != nil {
+			return fmt.Errorf("encode added file %d: %w", file.ID, err)
+		}
+		if _, err := file.MinKey.EncodeTo(w); err != nil {
+			return fmt.Errorf("encode min key of file %d: %w", file.ID, err)
+		}
+		if _, err := file.MaxKey.EncodeTo(w); err != nil {
+			return fmt.Errorf("encode max key of file %d: %w", file.ID, err)
+		}
+	}
+	for _, file := range e.Deleted {
+		if err := writeFields(w, tagDeletedFile, uint32(file.Level), file.ID); err != nil {
+			return fmt.Errorf("encode deleted file %d: %w", file.ID, err)
+		}
+	}
+	return nil
+}
+
+// DecodeFrom 从 r 中解码 VersionEdit，直到 r 读完
+func (e *VersionEdit) DecodeFrom(r *bytes.Reader) error {
+	for r.Len() > 0 {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("decode tag: %w", err)
+		}
+		switch tag {
+		case tagLastFileID:
+			if err = binary.Read(r, binary.LittleEndian, &e.LastFileID); err != nil {
+				return fmt.Errorf("decode last file id: %w", err)
+			}
+		case tagAddedFile:
+			var level uint32
+			var file FileMeta
+			if err = readFields(r, &level, &file.ID, &file.Size, &file.MinSeq, &file.MaxSeq); err != nil {
+				return fmt.Errorf("decode added file: %w", err)
+			}
+			file.Level = int(level)
+			if _, err = file.MinKey.DecodeFrom(r); err != nil {
+				return fmt.Errorf("decode min key of file %d: %w", file.ID, err)
+			}
+			if _, err = file.MaxKey.DecodeFrom(r); err != nil {
+				return fmt.Errorf("decode max key of file %d: %w", file.ID, err)
+			}
+			e.Added = append(e.Added, file)
+		case tagDeletedFile:
+			var level uint32
+			var id uint64
+			if err = readFields(r, &level, &id); err != nil {
+				return fmt.Errorf("decode deleted file: %w", err)
+			}
+			e.DeleteFile(int(level), id)
+		default:
+			return fmt.Errorf("unknown version edit tag: %d", tag)
+		}
+	}
+	return nil
+}
+
+func writeFields(w io.Writer, tag byte, fields ...any) error {
+	if _, err := w.Write([]byte{tag}); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFields(r io.Reader, fields ...any) error {
+	for _, field := range fields {
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifest 是当前正在追加的 MANIFEST 文件
+type manifest struct {
+	file *os.File
+	path string
+	size int64
+}
+
+// createManifest 在 dir 下创建编号为 id 的 MANIFEST，并写入 snapshot 作为第一条记录。
+// 新文件在 setCurrent 之后才会生效。
+func createManifest(dir string, id uint64, snapshot *VersionEdit) (*manifest, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Errorf("create directory %s error: %s", dir, err.Error())
+		return nil, fmt.Errorf("create directory %s error: %w", dir, err)
+	}
+	path := filepath.Join(dir, manifestFileName(id))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		log.Errorf("create manifest %s error: %s", path, err.Error())
+		return nil, fmt.Errorf("create manifest %s error: %w", path, err)
+	}
+	mf := &manifest{file: file, path: path}
+	if err = mf.append(snapshot); err != nil {
+		_ = mf.close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return mf, nil
+}
+
+// append 将 edit 编码为一条记录追加到 MANIFEST 并落盘
+func (mf *manifest) append(edit *VersionEdit) error {
+	payload := new(bytes.Buffer)
+	if err := edit.EncodeTo(payload); err != nil {
+		log.Errorf("encode version edit error: %s", err.Error())
+		return fmt.Errorf("encode version edit error: %w", err)
+	}
+
+	record := make([]byte, manifestRecordHeaderSize, manifestRecordHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload.Bytes(), manifestCRCTable))
+	record = append(record, payload.Bytes()...)
+	if _, err := mf.file.Write(record); err != nil {
+		log.Errorf("write manifest %s error: %s", mf.path, err.Error())
+		return fmt.Errorf("write manifest %s error: %w", mf.path, err)
+	}
+	if err := mf.file.Sync(); err != nil {
+		log.Errorf("sync manifest %s error: %s", mf.path, err.Error())
+		return fmt.Errorf("sync manifest %s error: %w", mf.path, err)
+	}
+	mf.size += int64(len(record))
+	return nil
+}
+
+func (mf *manifest) close() error {
+	return mf.file.Close()
+}
+
+// manifestFileName 返回编号为 id 的 MANIFEST 文件名
+func manifestFileName(id uint64) string {
+	return fmt.Sprintf("%s%06d", manifestFilePrefix, id)
+}
+
+// setCurrent 原子地将 CURRENT 指向 dir 下的 name：先写临时文件，落盘之后重命名
+func setCurrent(dir, name string) error {
+	tmpPath := filepath.Join(dir, currentFileName+".tmp")
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Errorf("create file %s error: %s", tmpPath, err.Error())
+		return fmt.Errorf("create file %s error: %w", tmpPath, err)
+	}
+	if _, err = file.WriteString(name + "\n"); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Errorf("write file %s error: %s", tmpPath, err.Error())
+		return fmt.Errorf("write file %s error: %w", tmpPath, err)
+	}
+
+	if err = os.Rename(tmpPath, filepath.Join(dir, currentFileName)); err != nil {
+		log.Errorf("rename %s to %s error: %s", tmpPath, currentFileName, err.Error())
+		return fmt.Errorf("rename %s to %s error: %w", tmpPath, currentFileName, err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir 将目录项的变更（新建、重命名的文件）落盘
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		log.Errorf("open directory %s error: %s", dir, err.Error())
+		return fmt.Errorf("open directory %s error: %w", dir, err)
+	}
+	defer file.Close()
+
+	if err = file.Sync(); err != nil {
+		log.Errorf("sync directory %s error: %s", dir, err.Error())
+		return fmt.Errorf("sync directory %s error: %w", dir, err)
+	}
+	return nil
+}
+
+// manifestState 是重放 MANIFEST 得到的最后一个已提交的版本
+type manifestState struct {
+	path       string
+	lastFileID uint64
+	files      map[uint64]FileMeta
+}
+
+func (s *manifestState) apply(edit *VersionEdit) {
+	s.lastFileID = max(s.lastFileID, edit.LastFileID)
+	for _, file := range edit.Deleted {
+		if meta, ok := s.files[file.ID]; ok && meta.Level == file.Level {
+			delete(s.files, file.ID)
+		}
+	}
+	for _, file := range edit.Added {
+		s.files[file.ID] = file
+	}
+}
+
+// readManifest 读取 CURRENT 指向的 MANIFEST 并重放所有记录，CURRENT 不存在时返回 (nil, nil)。
+// 末尾不完整的记录是追加时崩溃留下的，对应的变更没有提交，直接忽略；其他损坏返回错误。
+func readManifest(dir string) (*manifestState, error) {
+	current, err := os.ReadFile(filepath.Join(dir, currentFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Errorf("read %s in %s error: %s", currentFileName, dir, err.Error())
+		return nil, fmt.Errorf("read %s error: %w", currentFileName, err)
+	}
+	name := strings.TrimSpace(string(current))
+	if !strings.HasPrefix(name, manifestFilePrefix) {
+		return nil, fmt.Errorf("invalid %s content: %q", currentFileName, name)
+	}
+
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Errorf("read manifest %s error: %s", path, err.Error())
+		return nil, fmt.Errorf("read manifest %s error: %w", path, err)
+	}
+
+	state := &manifestState{path: path, files: make(map[uint64]FileMeta)}
+	for offset := 0; offset < len(data); {
+		edit, size, err := decodeManifestRecord(data[offset:])
+		if errors.Is(err, errIncompleteManifestRecord) {
+			log.Warnf("manifest %s has incomplete record at offset %d, dropped %d bytes", path, offset, len(data)-offset)
+			break
+		}
+		if err != nil {
+			log.Errorf("decode manifest %s at offset %d error: %s", path, offset, err.Error())
+			return nil, fmt.Errorf("decode manifest %s at offset %d error: %w", path, offset, err)
+		}
+		state.apply(edit)
+		offset += size
+	}
+	return state, nil
+}
+
+// decodeManifestRecord 从 data 的起始位置解码一条记录，返回记录占用的字节数
+func decodeManifestRecord(data []byte) (*VersionEdit, int, error) {
+	if len(data) < manifestRecordHeaderSize {
+		return nil, 0, errIncompleteManifestRecord
+	}
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if length > maxManifestRecordSize {
+		return nil, 0, fmt.Errorf("invalid record length: %d", length)
+	}
+	if uint64(length) > uint64(len(data)-manifestRecordHeaderSize) {
+		return nil, 0, errIncompleteManifestRecord
+	}
+	size := manifestRecordHeaderSize + int(length)
+
+	payload := data[manifestRecordHeaderSize:size]
+	checksum := binary.LittleEndian.Uint32(data[4:8])
+	if actual := crc32.Checksum(payload, manifestCRCTable); actual != checksum {
+		return nil, size, fmt.Errorf("checksum mismatch: expected %08x, actual %08x", checksum, actual)
+	}
+
+	edit := &VersionEdit{}
+	if err := edit.DecodeFrom(bytes.NewReader(payload)); err != nil {
+		return nil, size, err
+	}
+	return edit, size, nil
+}
+
+// removeObsoleteManifests 删除 dir 下除 keep 之外的 MANIFEST 文件
+func removeObsoleteManifests(dir, keep string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Errorf("read directory %s error: %s", dir, err.Error())
+		return
+	}
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, manifestFilePrefix) || name == keep {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Errorf("remove obsolete manifest %s error: %s", name, err.Error())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/memtable"
+)
+
+// flushTestPairs 将每一组 key 分别写入一个 Level0 SSTable，value 与 key 相同
+func flushTestPairs(t *testing.T, mgr *Manager, batches ...[]string) {
+	for _, keys := range batches {
+		mem := memtable.NewMemTable(0, t.TempDir())
+		for _, key := range keys {
+			assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: kv.Key(key), Value: []byte(key)}))
+		}
+		assert.NoError(t, mgr.CreateNewSSTable(memtable.NewIMemTable(mem)))
+	}
+}
+
+// tableIDs 返回各层级中 SSTable 的 id
+func tableIDs(mgr *Manager) [][]uint64 {
+	ids := make([][]uint64, mgr.opts.NumLevels)
+	for level := range ids {
+		for _, table := range mgr.getLevelTables(level) {
+			ids[level] = append(ids[level], table.id)
+		}
+	}
+	return ids
+}
+
+func TestVersionEditEncodeDecode(t *testing.T) {
+	edit := &VersionEdit{LastFileID: 42}
+	edit.AddFile(FileMeta{Level: 1, ID: 7, Size: 1024, MinKey: "a", MaxKey: "z", MinSeq: 3, MaxSeq: 9})
+	edit.AddFile(FileMeta{Level: 0, ID: 8})
+	edit.DeleteFile(0, 5)
+	edit.DeleteFile(1, 6)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, edit.EncodeTo(buf))
+
+	decoded := &VersionEdit{}
+	assert.NoError(t, decoded.DecodeFrom(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, edit, decoded)
+
+	// 未知的标签和截断的字段返回错误
+	assert.Error(t, (&VersionEdit{}).DecodeFrom(bytes.NewReader([]byte{0xff})))
+	assert.Error(t, (&VersionEdit{}).DecodeFrom(bytes.NewReader(buf.Bytes()[:buf.Len()-1])))
+}
+
+// TestManagerRecoverFromManifest 测试恢复结果与 MANIFEST 记录的版本一致，残留的未提交文件被忽略
+func TestManagerRecoverFromManifest(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewSSTableManager(DefaultOptions(dir))
+	// 超过 Level0 上限后触发合并
+	for i := 0; i < 5; i++ {
+		flushTestPairs(t, mgr, []string{fmt.Sprintf("key%d", i), fmt.Sprintf("key%d", i+10)})
+	}
+	mgr.Close()
+	assert.FileExists(t, filepath.Join(dir, currentFileName))
+	assert.Positive(t, len(mgr.getLevelTables(1)))
+
+	// 模拟合并写入新文件之后、记录 MANIFEST 之前崩溃留下的文件
+	stray := mgr.newTable(minSSTableLevel)
+	stray.Add(&kv.KeyValuePair{Key: "stray", Value: []byte("stray")})
+	stray.Header.MinKey, stray.Header.MaxKey = "stray", "stray"
+	assert.NoError(t, stray.EncodeTo(stray.FilePath()))
+
+	recovered := NewSSTableManager(DefaultOptions(dir))
+	assert.NoError(t, recovered.Recover())
+	assert.Equal(t, tableIDs(mgr), tableIDs(recovered))
+	for i := 0; i < 5; i++ {
+		key := kv.Key(fmt.Sprintf("key%d", i))
+		val, err := recovered.Search(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+	val, err := recovered.Search("stray")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+
+	// 新文件的 ID 不会与 MANIFEST 中的文件冲突
+	newID := recovered.newTable(minSSTableLevel).id
+	for _, ids := range tableIDs(recovered) {
+		for _, id := range ids {
+			assert.Less(t, id, newID)
+		}
+	}
+
+	// 之后的变更写入新的 MANIFEST，旧的 MANIFEST 被删除
+	flushTestPairs(t, recovered, []string{"after"})
+	recovered.Close()
+	manifests, err := filepath.Glob(filepath.Join(dir, manifestFilePrefix+"*"))
+	assert.NoError(t, err)
+	assert.Len(t, manifests, 1)
+
+	again := NewSSTableManager(DefaultOptions(dir))
+	assert.NoError(t, again.Recover())
+	assert.Equal(t, tableIDs(recovered), tableIDs(again))
+}
+
+// TestManagerRecoverIgnoresIncompleteManifestRecord 测试追加 MANIFEST 记录时崩溃，未写完的变更不生效
+func TestManagerRecoverIgnoresIncompleteManifestRecord(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewSSTableManager(DefaultOptions(dir))
+	flushTestPairs(t, mgr, []string{"a"}, []string{"b"})
+	expected := tableIDs(mgr)
+
+	// 构造一条只写了一半的记录
+	edit := &VersionEdit{}
+	edit.DeleteFile(minSSTableLevel, mgr.getLevelTables(minSSTableLevel)[0].id)
+	payload := new(bytes.Buffer)
+	assert.NoError(t, edit.EncodeTo(payload))
+	record := []byte{byte(payload.Len()), 0, 0, 0, 0, 0, 0, 0}
+	record = append(record, payload.Bytes()[:payload.Len()/2]...)
+	file, err := os.OpenFile(mgr.manifest.path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, err = file.Write(record)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	mgr.Close()
+
+	recovered := NewSSTableManager(DefaultOptions(dir))
+	assert.NoError(t, recovered.Recover())
+	assert.Equal(t, expected, tableIDs(recovered))
+}
+
+func TestManagerRecoverManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewSSTableManager(DefaultOptions(dir))
+	flushTestPairs(t, mgr, []string{"a"})
+	mgr.Close()
+
+	// MANIFEST 中记录的文件缺失
+	table := mgr.getLevelTables(minSSTableLevel)[0]
+	assert.NoError(t, os.Rename(table.FilePath(), table.FilePath()+".bak"))
+	assert.Error(t, NewSSTableManager(DefaultOptions(dir)).Recover())
+	assert.NoError(t, os.Rename(table.FilePath()+".bak", table.FilePath()))
+
+	// MANIFEST 中间的记录校验失败
+	manifestPath := currentManifestPath(t, dir)
+	data, err := os.ReadFile(manifestPath)
+	assert.NoError(t, err)
+	data[manifestRecordHeaderSize] ^= 0xff
+	assert.NoError(t, os.WriteFile(manifestPath, data, 0644))
+	assert.Error(t, NewSSTableManager(DefaultOptions(dir)).Recover())
+
+	// CURRENT 内容非法
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, currentFileName), []byte("garbage\n"), 0644))
+	assert.Error(t, NewSSTableManager(DefaultOptions(dir)).Recover())
+}
+
+// currentManifestPath 返回 dir 下 CURRENT 指向的 MANIFEST 路径
+func currentManifestPath(t *testing.T, dir string) string {
+	current, err := os.ReadFile(filepath.Join(dir, currentFileName))
+	assert.NoError(t, err)
+	return filepath.Join(dir, strings.TrimSpace(string(current)))
+}
+
+// TestManagerManifestRollover 测试 MANIFEST 超过大小上限之后写入新文件，新文件包含完整的版本快照
+func TestManagerManifestRollover(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewSSTableManager(DefaultOptions(dir))
+	flushTestPairs(t, mgr, []string{"a"})
+	first := mgr.manifest.path
+
+	mgr.manifest.size = maxManifestFileSize
+	flushTestPairs(t, mgr, []string{"b"})
+	assert.NotEqual(t, first, mgr.manifest.path)
+	assert.NoFileExists(t, first)
+	assert.Equal(t, mgr.manifest.path, currentManifestPath(t, dir))
+	mgr.Close()
+
+	recovered := NewSSTableManager(DefaultOptions(dir))
+	assert.NoError(t, recovered.Recover())
+	assert.Equal(t, tableIDs(mgr), tableIDs(recovered))
+}
+
+// TestFlushManifestFailureKeepsWAL 测试 MANIFEST 写入失败时 SSTable 不被提交，WAL 保留在磁盘上，
+// 重启之后数据从 WAL 恢复，之后的变更写入新的 MANIFEST
+func TestFlushManifestFailureKeepsWAL(t *testing.T) {
+	mgr := newTestManager(t)
+	flushTestPairs(t, mgr, []string{"a"})
+
+	walDir := t.TempDir()
+	mems := memtable.NewMemTableManager(walDir, 0)
+	_, err := mems.Insert(kv.KeyValuePair{Key: "b", Value: []byte("b")})
+	assert.NoError(t, err)
+	imem := mems.Seal()
+
+	// 关闭 MANIFEST 的文件句柄，使追加记录失败
+	assert.NoError(t, mgr.manifest.file.Close())
+	assert.Error(t, mgr.FlushIMemTable(imem))
+	assert.Nil(t, mgr.manifest)
+	assert.Equal(t, [][]uint64{{1}}, tableIDs(mgr)[:1])
+	sstFiles, err := filepath.Glob(filepath.Join(sstableLevelPath(minSSTableLevel, mgr.opts.Dir), "*.sst"))
+	assert.NoError(t, err)
+	assert.Len(t, sstFiles, 1)
+
+	// 之后的变更写入新的 MANIFEST
+	flushTestPairs(t, mgr, []string{"c"})
+	assert.NoError(t, mems.Close())
+	mgr.Close()
+
+	// 重启之后 b 从 WAL 恢复，a 和 c 从 SSTable 恢复
+	mems = memtable.NewMemTableManager(walDir, 0)
+	assert.NoError(t, mems.Recover())
+	val, found := mems.Search("b")
+	assert.True(t, found)
+	assert.Equal(t, kv.Value("b"), val)
+
+	recovered := NewSSTableManager(mgr.opts)
+	assert.NoError(t, recovered.Recover())
+	for _, key := range []kv.Key{"a", "c"} {
+		val, err := recovered.Search(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(key), val)
+	}
+	val, err = recovered.Search("b")
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
@@ -2,35 +2,61 @@ package sstable
 
 import (
 	"container/heap"
+	"fmt"
+	"sort"
 
 	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
 )
 
-// KVEntry 包装 KV 对，用于堆排序
-type KVEntry struct {
-	pair kv.KeyValuePair
+// pairIterator 是多路归并的输入，按 Key 升序、同一 Key 内按 Seq 降序输出点记录，区间删除标记单独传入
+type pairIterator interface {
+	Valid() bool
+	Key() kv.Key
+	Seq() uint64
+	Kind() kv.Kind
+	Value() (kv.Value, error)
+	Next()
+	// Error 返回读取输入时遇到的错误，出错的输入变为无效
+	Error() error
+	Close()
 }
 
-// minHeap 按 Key 排序的最小堆
-type minHeap []*KVEntry
+// mergeInput 是参与归并的一个输入，source 是输入的新旧顺序，越小越新
+type mergeInput struct {
+	pairIterator
+	source int
+}
+
+// iteratorHeap 按当前 Key 升序、同一 Key 内按 Seq 降序排序的迭代器最小堆。
+// Key 和 Seq 都相同时（例如没有序列号的旧数据）按 source 排序，较新输入中的记录优先，
+// container/heap 不是稳定的，不能依赖输入加入堆的顺序。
+type iteratorHeap []mergeInput
 
-func (h *minHeap) Len() int {
+func (h *iteratorHeap) Len() int {
 	return len(*h)
 }
 
-func (h *minHeap) Less(i, j int) bool {
-	return (*h)[i].pair.Key < (*h)[j].pair.Key
+func (h *iteratorHeap) Less(i, j int) bool {
+	a, b := (*h)[i], (*h)[j]
+	if a.Key() != b.Key() {
+		return a.Key() < b.Key()
+	}
+	if a.Seq() != b.Seq() {
+		return a.Seq() > b.Seq()
+	}
+	return a.source < b.source
 }
 
-func (h *minHeap) Swap(i, j int) {
+func (h *iteratorHeap) Swap(i, j int) {
 	(*h)[i], (*h)[j] = (*h)[j], (*h)[i]
 }
 
-func (h *minHeap) Push(x any) {
-	*h = append(*h, x.(*KVEntry))
+func (h *iteratorHeap) Push(x any) {
+	*h = append(*h, x.(mergeInput))
 }
 
-func (h *minHeap) Pop() any {
+func (h *iteratorHeap) Pop() any {
 	old := *h
 	n := len(old)
 	item := old[n-1]
@@ -38,57 +64,307 @@ func (h *minHeap) Pop() any {
 	return item
 }
 
-// CompactAndMergeKVs 归并排序并去重（相同 Key 只保留最新的，假设输入中相同 Key 的最新 KV 对在前）
-func CompactAndMergeKVs(kvs []kv.KeyValuePair, level int) []*SSTable {
-	h := &minHeap{}
-	heap.Init(h)
+// advance 将堆顶的迭代器移动到下一条记录，迭代器耗尽时从堆中移除
+func (h *iteratorHeap) advance() {
+	top := (*h)[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(h, 0)
+		return
+	}
+	heap.Pop(h)
+}
+
+// sliceIterator 遍历内存中已排序的记录
+type sliceIterator struct {
+	pairs []kv.KeyValuePair
+	pos   int
+}
+
+func (i *sliceIterator) Valid() bool {
+	return i.pos < len(i.pairs)
+}
+
+func (i *sliceIterator) Key() kv.Key {
+	return i.pairs[i.pos].Key
+}
+
+func (i *sliceIterator) Seq() uint64 {
+	return i.pairs[i.pos].Seq
+}
+
+func (i *sliceIterator) Kind() kv.Kind {
+	return i.pairs[i.pos].Kind
+}
+
+func (i *sliceIterator) Value() (kv.Value, error) {
+	return i.pairs[i.pos].Value, nil
+}
+
+func (i *sliceIterator) Next() {
+	i.pos++
+}
+
+func (i *sliceIterator) Error() error {
+	return nil
+}
+
+func (i *sliceIterator) Close() {}
+
+// tableOutput 是合并结果的写入目标：TableWriter 流式写入文件，memoryOutput 只在内存中构建
+type tableOutput interface {
+	Add(pair *kv.KeyValuePair) error
+	ShouldFlush() bool
+	Empty() bool
+	Finish() (FileMeta, error)
+	Abort()
+	Table() *SSTable
+}
+
+// memoryOutput 在内存中构建 SSTable，不写入文件
+type memoryOutput struct {
+	*Builder
+}
+
+func (o memoryOutput) Add(pair *kv.KeyValuePair) error {
+	o.Builder.Add(pair)
+	return nil
+}
+
+func (o memoryOutput) Empty() bool {
+	return o.size == 0
+}
+
+func (o memoryOutput) Finish() (FileMeta, error) {
+	return o.Build().Meta(), nil
+}
+
+func (o memoryOutput) Abort() {}
+
+func (o memoryOutput) Table() *SSTable {
+	return o.table
+}
+
+// newMemoryOutput 返回在内存中构建 level 层 SSTable 的 tableOutput
+func (m *Manager) newMemoryOutput(level int) (tableOutput, error) {
+	return memoryOutput{Builder: m.newBuilder(level)}, nil
+}
 
-	// 1. 收集所有 KV 对并初始化堆
+// newFileOutput 返回流式写入 level 层 SSTable 文件的 tableOutput
+func (m *Manager) newFileOutput(level int) (tableOutput, error) {
+	w, err := m.newTableWriter(level)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// CompactAndMergeKVs 归并排序并去重，snapshots 为升序排列的存活快照序列号。
+// 同一 Key 的多个版本按序列号从新到旧处理，快照区间由相邻快照划分，落在同一区间内的版本对任何读者都不可区分：
+// 每个快照区间内保留连续的 Merge 操作数以及其后第一个 Put 或删除标记，更旧的版本直接丢弃；
+// 被同一区间内的区间删除标记覆盖的版本也会被丢弃。SingleDelete 与紧随其后同一区间内的 Put 相互抵消。
+// 最后一层合并时，最旧区间内的删除标记之下没有需要保留的旧版本，删除标记本身也会被丢弃。
+// 序列号相同的版本以在 kvs 中靠前的为新。返回的 SSTable 只存在于内存中，合并磁盘上的文件使用 mergeTables。
+func (m *Manager) CompactAndMergeKVs(kvs []kv.KeyValuePair, level int, snapshots []uint64) []*SSTable {
+	// 1. 区间删除标记单独传入，点记录排序后作为唯一的输入
+	points := make([]kv.KeyValuePair, 0, len(kvs))
+	tombstones := make([]kv.KeyValuePair, 0)
 	for _, pair := range kvs {
-		heap.Push(h, &KVEntry{pair: pair})
+		if pair.Kind == kv.KindRangeDelete {
+			tombstones = append(tombstones, pair)
+			continue
+		}
+		points = append(points, pair)
 	}
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Key != points[j].Key {
+			return points[i].Key < points[j].Key
+		}
+		return points[i].Seq > points[j].Seq
+	})
 
+	// 2. 归并去重，内存中的输入不会出错
 	results := make([]*SSTable, 0)
-	builder := NewSSTableBuilder(level)
+	_ = m.mergeIterators([]pairIterator{&sliceIterator{pairs: points}}, tombstones, level, snapshots, m.newMemoryOutput, func(table *SSTable) error {
+		results = append(results, table)
+		return nil
+	})
+	return results
+}
+
+// mergeIterators 对多个有序输入做多路归并并去重，规则同 CompactAndMergeKVs。
+// iters 按从新到旧排列，Key 和 Seq 都相同的记录保留排在前面的输入中的版本。
+// 输出通过 newOutput 创建，每写满一个 SSTable 就交给 finish 处理，出错时放弃正在写入的输出。
+func (m *Manager) mergeIterators(iters []pairIterator, tombstones []kv.KeyValuePair, level int, snapshots []uint64,
+	newOutput func(level int) (tableOutput, error), finish func(*SSTable) error) (err error) {
+	h := &iteratorHeap{}
+	for source, it := range iters {
+		if it.Valid() {
+			*h = append(*h, mergeInput{pairIterator: it, source: source})
+		}
+	}
+	heap.Init(h)
+
+	// 区间删除标记按起始 key 排序
+	tombstones = append([]kv.KeyValuePair(nil), tombstones...)
+	sort.SliceStable(tombstones, func(i, j int) bool {
+		if tombstones[i].Key != tombstones[j].Key {
+			return tombstones[i].Key < tombstones[j].Key
+		}
+		return tombstones[i].Seq > tombstones[j].Seq
+	})
+	bottom := level >= m.maxLevel()
+
+	out, err := newOutput(level)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && out != nil {
+			out.Abort()
+		}
+	}()
+	nextTombstone := 0
+	// addTombstones 将起始 key 不大于 key 的区间删除标记写入当前 SSTable
+	addTombstones := func(key kv.Key, all bool) error {
+		for ; nextTombstone < len(tombstones); nextTombstone++ {
+			tombstone := tombstones[nextTombstone]
+			if !all && tombstone.Key > key {
+				return nil
+			}
+			// 最后一层中，最旧区间内的区间删除标记已经没有可以覆盖的版本
+			if bottom && snapshotStripe(tombstone.Seq, snapshots) == 0 {
+				continue
+			}
+			if err := out.Add(&tombstone); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// finishOutput 结束当前输出并交给 finish 处理
+	finishOutput := func() error {
+		if _, err := out.Finish(); err != nil {
+			return err
+		}
+		return finish(out.Table())
+	}
 
-	var lastWrittenKey kv.Key // 记录上一个写入的 Key
+	var lastKey kv.Key  // 记录上一个处理的 Key
+	var lastStripe int  // 上一个版本所在的快照区间
+	stripeDone := false // 当前快照区间是否已经保留了 Put 或删除标记
+	hasLastKey := false // 是否已处理过至少一个 Key
 
-	// 2. 归并排序并去重
 	for h.Len() > 0 {
-		// 获取当前最小的 Key（堆顶）
-		top := (*h)[0]
-		currentKey := top.pair.Key
+		it := (*h)[0]
+		currentPair := kv.KeyValuePair{Key: it.Key(), Seq: it.Seq(), Kind: it.Kind()}
+		stripe := snapshotStripe(currentPair.Seq, snapshots)
+
+		if hasLastKey && currentPair.Key == lastKey {
+			// 与上一个版本处于同一快照区间，被更新的版本覆盖，直接丢弃
+			if stripe == lastStripe && stripeDone {
+				h.advance()
+				continue
+			}
+		} else {
+			// 切换到新的 Key 时才检查是否需要 Flush，避免同一 Key 的版本被拆分到不同 SSTable
+			if out.ShouldFlush() {
+				if err = finishOutput(); err != nil {
+					return err
+				}
+				if out, err = newOutput(level); err != nil {
+					return err
+				}
+			}
+			if err = addTombstones(currentPair.Key, false); err != nil {
+				return err
+			}
+			lastKey = currentPair.Key
+			hasLastKey = true
+			stripeDone = false
+		}
+		if stripe != lastStripe {
+			stripeDone = false
+		}
+		lastStripe = stripe
 
-		// 如果当前 Key 和上一个写入的 Key 相同，跳过（去重）
-		if len(lastWrittenKey) > 0 && currentKey == lastWrittenKey {
-			heap.Pop(h) // 直接弹出，不处理
+		// 被同一快照区间内的区间删除标记覆盖，任何读者都看不到该版本
+		if kv.CoveringSequence(tombstones, currentPair.Key, stripeUpperBound(stripe, snapshots)) > currentPair.Seq {
+			stripeDone = true
+			h.advance()
 			continue
 		}
 
-		// 弹出堆顶元素（此时一定是当前最小的、未处理的 Key）
-		entry := heap.Pop(h).(*KVEntry)
-		currentPair := entry.pair
+		// 只读取需要保留的版本的 value
+		if !currentPair.IsDeleted() {
+			value, valueErr := it.Value()
+			if err = valueErr; err != nil {
+				log.Errorf("read value of key %s error: %s", currentPair.Key, err.Error())
+				return fmt.Errorf("read value of key %s error: %w", currentPair.Key, err)
+			}
+			currentPair.Value = value
+		}
+		h.advance()
 
-		// 如果不是删除标记，则写入
-		// TODO: 最后一层合并时，将删除标记的 Key 写入 tombstone 文件
-		if !currentPair.IsDeleted() || level < maxSSTableLevel {
-			builder.Add(&currentPair)
-			lastWrittenKey = currentKey // 更新最后写入的 Key
+		switch currentPair.Kind {
+		case kv.KindMerge:
+			// 操作数需要与更旧的版本一起合并，继续保留同一区间内更旧的版本
+			if err = out.Add(&currentPair); err != nil {
+				return err
+			}
+			continue
+		case kv.KindSingleDelete:
+			// SingleDelete 与紧随其后同一区间内的 Put 相互抵消
+			if h.Len() > 0 {
+				next := (*h)[0]
+				if next.Key() == currentPair.Key && next.Kind() == kv.KindPut && snapshotStripe(next.Seq(), snapshots) == stripe {
+					h.advance()
+					stripeDone = true
+					continue
+				}
+			}
 		}
+		stripeDone = true
 
-		// 检查是否需要 Flush
-		if builder.ShouldFlush() {
-			results = append(results, builder.Build())
-			builder = NewSSTableBuilder(level)
-			lastWrittenKey = "" // 重置 lastWrittenKey
+		// 最后一层中，最旧区间内的删除标记之下不会再有需要保留的版本，可以直接丢弃
+		if currentPair.IsDeleted() && bottom && stripe == 0 {
+			continue
+		}
+		if err = out.Add(&currentPair); err != nil {
+			return err
 		}
 	}
 
-	// 3. 处理剩余数据
-	if builder.size > 0 {
-		builder.table.level = level
-		results = append(results, builder.Build())
+	// 出错的输入提前结束，合并结果不完整
+	for _, it := range iters {
+		if err = it.Error(); err != nil {
+			log.Errorf("read merge input error: %s", err.Error())
+			return fmt.Errorf("read merge input error: %w", err)
+		}
 	}
 
-	return results
+	// 处理剩余数据
+	if err = addTombstones("", true); err != nil {
+		return err
+	}
+	if !out.Empty() {
+		return finishOutput()
+	}
+	// 没有输出任何记录，放弃空的输出
+	out.Abort()
+	return nil
+}
+
+// snapshotStripe 返回序列号所在的快照区间，即第一个不小于 seq 的快照下标。
+// 没有快照不小于 seq 时返回 len(snapshots)，表示只对最新的读者可见。
+func snapshotStripe(seq uint64, snapshots []uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool { return snapshots[i] >= seq })
+}
+
+// stripeUpperBound 返回快照区间内的最大序列号
+func stripeUpperBound(stripe int, snapshots []uint64) uint64 {
+	if stripe >= len(snapshots) {
+		return kv.MaxSequence
+	}
+	return snapshots[stripe]
 }
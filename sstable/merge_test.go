@@ -1,6 +1,7 @@
 package sstable
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,8 +9,25 @@ import (
 	"github.com/xmh1011/go-lsm/kv"
 )
 
+// pointPairs 依次返回 tables 中的点记录，不包含区间删除标记
+func pointPairs(t *testing.T, tables ...*SSTable) []kv.KeyValuePair {
+	pairs := make([]kv.KeyValuePair, 0)
+	for _, table := range tables {
+		it := NewSSTableIterator(table)
+		for ; it.Valid(); it.Next() {
+			value, err := it.Value()
+			assert.NoError(t, err)
+			pairs = append(pairs, kv.KeyValuePair{Key: it.Key(), Value: value, Seq: it.Seq(), Kind: it.Kind()})
+		}
+		assert.NoError(t, it.Error())
+		it.Close()
+	}
+	return pairs
+}
+
 // TestCompactAndMergeBlocks_Basic 测试基本的块合并功能
 func TestCompactAndMergeBlocks_Basic(t *testing.T) {
+	mgr := newTestManager(t)
 	// 构造测试数据：两个数据块，包含重叠键和删除标记
 	block1 := []kv.KeyValuePair{
 		{
@@ -35,22 +53,23 @@ func TestCompactAndMergeBlocks_Basic(t *testing.T) {
 	}
 
 	// 执行合并
-	sst := CompactAndMergeKVs(block1, 1)
+	sst := mgr.CompactAndMergeKVs(block1, 1, nil)
 	assert.NotNil(t, sst[0])
 	assert.Equal(t, 1, sst[0].level)
 
 	// 验证数据块：应该已经合并排序并去重
-	assert.Len(t, sst[0].DataBlock.Entries, 4, "Should have 4 entries (alpha, beta, carrot, delta)")
+	pairs := pointPairs(t, sst[0])
+	assert.Len(t, pairs, 4, "Should have 4 entries (alpha, beta, carrot, delta)")
 
 	// 验证键顺序和去重
-	keys := make([]string, len(sst[0].IndexBlock.Indexes))
-	for i, entry := range sst[0].IndexBlock.Indexes {
-		keys[i] = string(entry.Key)
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = string(pair.Key)
 	}
 	assert.Equal(t, []string{"alpha", "beta", "carrot", "delta"}, keys)
 
 	// 验证重复键的处理（后面的块优先）
-	assert.Equal(t, kv.Value("B"), sst[0].DataBlock.Entries[1], "Should use value from later block for duplicate key")
+	assert.Equal(t, kv.Value("B"), pairs[1].Value, "Should use value from later block for duplicate key")
 
 	// 验证布隆过滤器
 	assert.True(t, sst[0].MayContain("alpha"))
@@ -58,3 +77,206 @@ func TestCompactAndMergeBlocks_Basic(t *testing.T) {
 	assert.False(t, sst[0].MayContain("nonexistent"))
 	assert.False(t, sst[0].MayContain("deletedKey"), "Deleted key should not be in filter")
 }
+
+// TestCompactAndMergeKVs_Snapshots 测试合并时保留对存活快照可见的历史版本
+func TestCompactAndMergeKVs_Snapshots(t *testing.T) {
+	mgr := newTestManager(t)
+	pairs := []kv.KeyValuePair{
+		{Key: "k", Value: []byte("v1"), Seq: 1},
+		{Key: "k", Value: []byte("v2"), Seq: 2},
+		{Key: "k", Value: []byte("v4"), Seq: 4},
+		{Key: "k", Value: []byte("v5"), Seq: 5},
+		{Key: "other", Value: []byte("o3"), Seq: 3},
+	}
+
+	versions := func(tables []*SSTable) []uint64 {
+		var seqs []uint64
+		for _, pair := range pointPairs(t, tables...) {
+			seqs = append(seqs, pair.Seq)
+		}
+		return seqs
+	}
+
+	// 没有快照时每个 key 只保留最新版本
+	assert.Equal(t, []uint64{5, 3}, versions(mgr.CompactAndMergeKVs(pairs, 1, nil)))
+
+	// 快照 2 需要看到 v2，快照 4 需要看到 v4
+	assert.Equal(t, []uint64{5, 4, 2, 3}, versions(mgr.CompactAndMergeKVs(pairs, 1, []uint64{2, 4})))
+}
+
+// TestCompactAndMergeKVs_BottomLevelTombstone 测试最后一层删除标记的处理
+func TestCompactAndMergeKVs_BottomLevelTombstone(t *testing.T) {
+	mgr := newTestManager(t)
+	pairs := []kv.KeyValuePair{
+		{Key: "k", Value: []byte("v1"), Seq: 1},
+		{Key: "k", Seq: 3, Kind: kv.KindDelete},
+	}
+
+	// 没有快照时删除标记和旧版本都被丢弃
+	assert.Empty(t, mgr.CompactAndMergeKVs(pairs, mgr.maxLevel(), nil))
+
+	// 快照 2 仍能看到 v1，删除标记必须保留以对更新的读者隐藏 v1
+	tables := mgr.CompactAndMergeKVs(pairs, mgr.maxLevel(), []uint64{2})
+	assert.Len(t, tables, 1)
+	got := pointPairs(t, tables[0])
+	assert.Len(t, got, 2)
+	assert.Equal(t, uint64(3), got[0].Seq)
+	assert.Equal(t, uint64(1), got[1].Seq)
+
+	// 非最后一层总是保留删除标记
+	tables = mgr.CompactAndMergeKVs(pairs, 1, nil)
+	assert.Len(t, tables, 1)
+	assert.Len(t, pointPairs(t, tables[0]), 1)
+}
+
+// TestCompactAndMergeKVs_MergeOperands 测试合并操作数与其下的基础值一起保留
+func TestCompactAndMergeKVs_MergeOperands(t *testing.T) {
+	mgr := newTestManager(t)
+	pairs := []kv.KeyValuePair{
+		{Key: "k", Value: []byte("base"), Seq: 1},
+		{Key: "k", Value: []byte("old"), Seq: 2},
+		{Key: "k", Value: []byte("+a"), Seq: 3, Kind: kv.KindMerge},
+		{Key: "k", Value: []byte("+b"), Seq: 4, Kind: kv.KindMerge},
+	}
+
+	tables := mgr.CompactAndMergeKVs(pairs, 1, nil)
+	assert.Len(t, tables, 1)
+	got, err := tables[0].GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Equal(t, []kv.KeyValuePair{
+		{Key: "k", Value: []byte("+b"), Seq: 4, Kind: kv.KindMerge},
+		{Key: "k", Value: []byte("+a"), Seq: 3, Kind: kv.KindMerge},
+		{Key: "k", Value: []byte("old"), Seq: 2},
+	}, got)
+}
+
+// TestCompactAndMergeKVs_SingleDelete 测试 SingleDelete 与其下的 Put 相互抵消
+func TestCompactAndMergeKVs_SingleDelete(t *testing.T) {
+	mgr := newTestManager(t)
+	pairs := []kv.KeyValuePair{
+		{Key: "a", Value: []byte("v"), Seq: 1},
+		{Key: "a", Seq: 2, Kind: kv.KindSingleDelete},
+		{Key: "b", Value: []byte("v"), Seq: 3},
+	}
+	tables := mgr.CompactAndMergeKVs(pairs, 1, nil)
+	assert.Len(t, tables, 1)
+	got := pointPairs(t, tables[0])
+	assert.Len(t, got, 1)
+	assert.Equal(t, kv.Key("b"), got[0].Key)
+
+	// 快照隔开两者时都需要保留
+	tables = mgr.CompactAndMergeKVs(pairs, 1, []uint64{1})
+	assert.Len(t, tables, 1)
+	got = pointPairs(t, tables[0])
+	assert.Len(t, got, 3)
+	assert.Equal(t, kv.KindSingleDelete, got[0].Kind)
+}
+
+// TestCompactAndMergeKVs_RangeDelete 测试区间删除标记覆盖的版本被丢弃，标记本身保留到最后一层
+func TestCompactAndMergeKVs_RangeDelete(t *testing.T) {
+	mgr := newTestManager(t)
+	pairs := []kv.KeyValuePair{
+		{Key: "a", Value: []byte("a1"), Seq: 1},
+		{Key: "b", Value: []byte("b1"), Seq: 2},
+		{Key: "b", Value: []byte("b5"), Seq: 5},
+		{Key: "c", Value: []byte("c3"), Seq: 3},
+		{Key: "d", Value: []byte("d1"), Seq: 1},
+		{Key: "b", Value: kv.Value("d"), Seq: 4, Kind: kv.KindRangeDelete},
+	}
+
+	keys := func(tables []*SSTable) []string {
+		var result []string
+		for _, pair := range pointPairs(t, tables...) {
+			result = append(result, fmt.Sprintf("%s@%d", pair.Key, pair.Seq))
+		}
+		return result
+	}
+
+	tables := mgr.CompactAndMergeKVs(pairs, 1, nil)
+	assert.Equal(t, []string{"a@1", "b@5", "d@1"}, keys(tables))
+	assert.Len(t, tables[0].RangeTombstones(), 1)
+	assert.Equal(t, kv.Key("a"), tables[0].Header.MinKey)
+	assert.Equal(t, kv.Key("d"), tables[0].Header.MaxKey)
+
+	// 快照 3 仍能看到被覆盖的版本
+	tables = mgr.CompactAndMergeKVs(pairs, 1, []uint64{3})
+	assert.Equal(t, []string{"a@1", "b@5", "b@2", "c@3", "d@1"}, keys(tables))
+
+	// 最后一层丢弃区间删除标记
+	tables = mgr.CompactAndMergeKVs(pairs, mgr.maxLevel(), nil)
+	assert.Equal(t, []string{"a@1", "b@5", "d@1"}, keys(tables))
+	assert.Empty(t, tables[0].RangeTombstones())
+}
+
+// TestMergeIterators_MultipleInputs 测试多个有序输入经迭代器堆归并后按 Key 升序输出，同一 Key 保留最新版本
+func TestMergeIterators_MultipleInputs(t *testing.T) {
+	mgr := newTestManager(t)
+	inputs := []pairIterator{
+		&sliceIterator{pairs: []kv.KeyValuePair{
+			{Key: "a", Value: []byte("a1"), Seq: 1},
+			{Key: "c", Value: []byte("c3"), Seq: 3},
+		}},
+		&sliceIterator{pairs: []kv.KeyValuePair{
+			{Key: "b", Value: []byte("b2"), Seq: 2},
+			{Key: "c", Value: []byte("c1"), Seq: 1},
+		}},
+		&sliceIterator{pairs: []kv.KeyValuePair{
+			{Key: "a", Value: []byte("a4"), Seq: 4},
+			{Key: "d", Kind: kv.KindDelete, Seq: 5},
+		}},
+		&sliceIterator{},
+	}
+
+	tables := make([]*SSTable, 0)
+	err := mgr.mergeIterators(inputs, nil, 1, nil, mgr.newMemoryOutput, func(table *SSTable) error {
+		tables = append(tables, table)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, tables, 1)
+
+	keys := make([]string, 0)
+	values := make([]kv.Value, 0)
+	for _, pair := range pointPairs(t, tables[0]) {
+		keys = append(keys, string(pair.Key))
+		values = append(values, pair.Value)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	assert.Equal(t, []kv.Value{[]byte("a4"), []byte("b2"), []byte("c3"), nil}, values)
+}
+
+// TestMergeIterators_FinishError 测试 finish 返回的错误会中止合并
+func TestMergeIterators_FinishError(t *testing.T) {
+	mgr := newTestManager(t)
+	input := &sliceIterator{pairs: []kv.KeyValuePair{{Key: "a", Value: []byte("a")}}}
+	err := mgr.mergeIterators([]pairIterator{input}, nil, 1, nil, mgr.newMemoryOutput, func(*SSTable) error {
+		return fmt.Errorf("disk full")
+	})
+	assert.Error(t, err)
+}
+
+// TestMergeIterators_SameSequenceNewestInputWins 测试 Key 和 Seq 都相同时保留较新输入中的版本，与堆中的位置无关
+func TestMergeIterators_SameSequenceNewestInputWins(t *testing.T) {
+	mgr := newTestManager(t)
+	for n := 2; n <= 8; n++ {
+		inputs := make([]pairIterator, 0, n)
+		for i := 0; i < n; i++ {
+			inputs = append(inputs, &sliceIterator{pairs: []kv.KeyValuePair{
+				{Key: "a", Value: []byte(fmt.Sprintf("a%d", i))},
+				{Key: kv.Key(fmt.Sprintf("b%d", i)), Value: []byte("b")},
+				{Key: "c", Value: []byte(fmt.Sprintf("c%d", i))},
+			}})
+		}
+
+		tables := make([]*SSTable, 0)
+		err := mgr.mergeIterators(inputs, nil, 1, nil, mgr.newMemoryOutput, func(table *SSTable) error {
+			tables = append(tables, table)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, tables, 1)
+		pairs := pointPairs(t, tables[0])
+		assert.Equal(t, kv.Value("a0"), pairs[0].Value)
+		assert.Equal(t, kv.Value("c0"), pairs[len(pairs)-1].Value)
+	}
+}
@@ -1,35 +1,59 @@
 package sstable
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync/atomic"
+	"time"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 	"github.com/xmh1011/go-lsm/sstable/block"
 	"github.com/xmh1011/go-lsm/sstable/bloom"
+	"github.com/xmh1011/go-lsm/sstable/cache"
+	"github.com/xmh1011/go-lsm/sstable/compress"
 )
 
 const (
-	sstFileSuffix  = "sst"
-	levelSuffix    = "level"
-	maxSSTableSize = 2 * 1024 * 1024 // 2MB
+	sstFileSuffix = "sst"
+	levelSuffix   = "level"
 )
 
-var idGenerator atomic.Uint64
+// ErrCorruption 表示 SSTable 文件损坏：文件被截断、魔数或校验和不匹配，或者内容无法解析
+var ErrCorruption = errors.New("sstable: corrupted file")
 
-// ResetIDGenerator 重置 SSTable ID 生成器
-func ResetIDGenerator() {
-	idGenerator.Store(0)
+// CorruptionError 记录损坏的文件和位置，errors.Is(err, ErrCorruption) 对它成立
+type CorruptionError struct {
+	File   string
+	Offset int64 // 损坏的部分在文件中的起始偏移量
+	Err    error // 具体原因
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d: %s", ErrCorruption, e.File, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() []error {
+	return []error{ErrCorruption, e.Err}
 }
 
 // SSTable is an in-memory representation of the file on disk. An SSTable contains the data sorted by key.
 // SSTables can be created by flushing an immutable MemTable or by merging SSTables (/compaction).
+//
+// 文件格式：
+//
+//	data block 0 ... data block n | FilterBlock | RangeDelBlock | IndexBlock | Header | Footer
+//
+// 数据块按 Key 有序保存记录，每个数据块的编码大小约为 blockSize，数据块内部使用前缀压缩和重启点。
+// 数据块和索引块写入文件时按所在层级配置的算法压缩。除 Footer 外的每个部分都带有块尾部，记录压缩算法和 CRC32C 校验和，
+// 加载文件时总是检查元数据部分的校验和，数据块的校验和只在 verifyChecksums 为 true 时检查。
+// 索引块为每个数据块记录一项，内存中只保留索引块、布隆过滤器、区间删除标记和元数据，数据块在读取时才从文件中加载。
+// 设置了块缓存时，从文件中加载的数据块按 (id, 偏移量) 缓存，被所有 SSTable 共享。索引块和布隆过滤器默认常驻内存，
+// pinIndexAndFilter 为 false 时它们也改为通过块缓存按需加载，可能被淘汰。
 type SSTable struct {
 	id uint64
 
@@ -37,158 +61,212 @@ type SSTable struct {
 
 	filePath string
 
-	// Header 记录 SSTable 的元数据信息，包括最小 Key、最大 Key 等
+	// size 是文件大小（字节），写入或加载文件时设置
+	size uint64
+
+	// modTime 是文件写入完成的时间，加载文件时取文件的修改时间。文件中的所有记录都在此之前写入
+	modTime time.Time
+
+	// refs 是引用该 SSTable 的版本数量，归零时删除文件
+	refs atomic.Int32
+
+	// Header 记录 SSTable 的元数据信息，包括 key 范围和序列号范围
 	Header *block.Header
 
 	// FilterBlock 合并了结构图中的 Filter 和 MetaIndexBlock
-	// 记录 Filter 的相关信息
+	// 记录 Filter 的相关信息。构建完成时按 key 的数量和 bitsPerKey 创建，位图长度和哈希函数个数随位图一起写入文件
 	FilterBlock *bloom.Filter
 
-	// IndexBlock 是数据块的索引部分，记录每个 Data Blcok 的起始位置、大小和最大 Key 值
-	IndexBlock *block.IndexBlock // 按 key 顺序排列的索引项
+	// IndexBlock 为每个数据块记录一项：key 为数据块中最后一条记录的 key，value 为数据块的 Handle。
+	// 构建完成或加载文件之后才会设置
+	IndexBlock *block.Block
 
-	// DataBlock 是 SSTable 的数据块部分，包含实际的value数据。
-	DataBlock *block.DataBlock
+	// RangeDelBlock 记录区间删除标记，加载 SSTable 时一并读入内存
+	RangeDelBlock *block.RangeDelBlock
 
-	// Footer 是 SSTable 的尾部信息，包含了 IndexBlock 的位置等元数据
+	// Footer 是 SSTable 的尾部信息，记录文件中各部分的位置
 	Footer *block.Footer
+
+	// blockSize 是数据块的目标大小（字节），按压缩之前的大小计算
+	blockSize int
+
+	// bitsPerKey 是构建布隆过滤器时每个 key 占用的位数
+	bitsPerKey uint
+
+	// compressor 是写入数据块和索引块时使用的压缩算法，为 nil 时不压缩
+	compressor compress.Compressor
+
+	// verifyChecksums 为 true 时每次读取数据块都检查校验和
+	verifyChecksums bool
+
+	// cache 是所有 SSTable 共享的块缓存，为 nil 时每次都从文件中读取
+	cache *cache.Cache
+
+	// files 是所有 SSTable 共享的打开文件缓存，为 nil 时每次读取都打开新的文件
+	files *tableCache
+
+	// filterStats 统计布隆过滤器的判断结果，为 nil 时不统计
+	filterStats *filterStats
+
+	// pinIndexAndFilter 为 true 时索引块和布隆过滤器常驻内存，否则写入或加载文件之后释放，需要时通过块缓存读取
+	pinIndexAndFilter bool
+
+	// 构建时的状态：data 保存已经编码、尚未写入文件的数据块，dataSize 是已经编码的数据块的总大小，
+	// dataBlock 和 indexBuilder 是正在构建的数据块和索引块。
+	// 通过 TableWriter 写入时 data 随写随清空；只在内存中构建的 SSTable 保留全部数据块，可以直接读取
+	data         []byte
+	dataSize     int64
+	dataBlock    *block.BlockBuilder
+	indexBuilder *block.BlockBuilder
+	entries      int             // 点记录数
+	records      int             // 点记录和区间删除标记的总数
+	firstKey     kv.Key          // 第一条点记录的 key
+	filterHashes []uint64        // 不重复的点记录 key 的哈希值，构建完成时加入布隆过滤器
+	lastPair     kv.KeyValuePair // 最后一条点记录，不包含 value
 }
 
+// NewSSTable 创建一个空的 SSTable，ID、层级和文件路径由 Manager 分配
 func NewSSTable() *SSTable {
 	return &SSTable{
-		id:          idGenerator.Add(1),
-		IndexBlock:  block.NewIndexBlock(),
-		FilterBlock: bloom.DefaultBloomFilter(),
-		Footer:      block.NewFooter(),
-		Header:      block.NewHeader("", ""),
-		DataBlock:   block.NewDataBlock(),
+		Footer:            block.NewFooter(),
+		Header:            block.NewHeader("", ""),
+		RangeDelBlock:     block.NewRangeDelBlock(),
+		blockSize:         block.DefaultBlockSize,
+		bitsPerKey:        bloom.DefaultBitsPerKey,
+		pinIndexAndFilter: true, // 默认常驻内存，由 Manager 按配置修改
+		dataBlock:         block.NewBlockBuilder(block.DefaultRestartInterval),
+		indexBuilder:      block.NewBlockBuilder(1), // 索引项较少，每一项都是重启点
 	}
 }
 
 func NewRecoverSSTable(level int) *SSTable {
-	return &SSTable{
-		level:       level,
-		IndexBlock:  block.NewIndexBlock(),
-		FilterBlock: bloom.DefaultBloomFilter(),
-		Footer:      block.NewFooter(),
-		Header:      block.NewHeader("", ""),
-		DataBlock:   block.NewDataBlock(),
-	}
-}
-
-func NewSSTableWithLevel(level int) *SSTable {
 	table := NewSSTable()
 	table.level = level
-	table.filePath = sstableFilePath(table.id, level, config.GetSSTablePath())
 	return table
 }
 
-// DecodeFrom 从给定文件路径加载 SSTable 到内存中。不加载 DataBlock 的内容。
+// DecodeFrom 从给定文件路径加载 SSTable 到内存中。不加载数据块的内容。
 func (t *SSTable) DecodeFrom(filePath string) error {
-	file, err := os.Open(filePath)
+	t.filePath = filePath
+	file, err := t.files.acquire(t)
 	if err != nil {
-		log.Errorf("open file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("open file error: %w", err)
+		return err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Errorf("close file %s error: %s", filePath, err.Error())
-		}
-	}(file)
-	t.filePath = filePath
+	defer t.files.release(file)
 
-	if err = t.Header.DecodeFrom(file); err != nil {
+	// 定位到文件末尾，读取 Footer
+	if err = t.DecodeFooterFrom(file.File); err != nil {
+		log.Errorf("decode Footer from file %s error: %s", filePath, err.Error())
+		return fmt.Errorf("decode Footer failed: %w", err)
+	}
+
+	// 根据 Footer 定位其余各部分，元数据只在加载时读取一次，总是检查校验和
+	if err = t.decodeSection(file, t.Footer.HeaderHandle, func(r io.Reader, _ int64) error {
+		return t.Header.DecodeFrom(r)
+	}); err != nil {
 		log.Errorf("decode Header from file %s error: %s", filePath, err.Error())
 		return fmt.Errorf("decode Header failed: %w", err)
 	}
 
-	if err = t.FilterBlock.DecodeFrom(file); err != nil {
+	t.FilterBlock = new(bloom.Filter)
+	if err = t.decodeSection(file, t.Footer.FilterHandle, func(r io.Reader, _ int64) error {
+		return t.FilterBlock.DecodeFrom(r)
+	}); err != nil {
 		log.Errorf("decode FilterBlock from file %s error: %s", filePath, err.Error())
 		return fmt.Errorf("decode FilterBlock failed: %w", err)
 	}
 
-	// 定位到文件末尾，读取 Footer
-	if err = t.DecodeFooterFrom(file); err != nil {
-		log.Errorf("decode Footer from file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("decode Footer failed: %w", err)
+	if err = t.decodeSection(file, t.Footer.RangeDelHandle, t.RangeDelBlock.DecodeFrom); err != nil {
+		log.Errorf("decode RangeDelBlock from file %s error: %s", filePath, err.Error())
+		return fmt.Errorf("decode RangeDelBlock failed: %w", err)
 	}
 
-	// 根据 Footer 定位 IndexBlock
-	if _, err = file.Seek(t.Footer.IndexHandle.Offset, io.SeekStart); err != nil {
-		log.Errorf("seek to index block position in file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("seek to index block position failed: %w", err)
-	}
-	if err = t.IndexBlock.DecodeFrom(file, t.Footer.IndexHandle.Size); err != nil {
+	if t.IndexBlock, err = t.readBlock(file, t.Footer.IndexHandle, true); err != nil {
 		log.Errorf("decode IndexBlock from file %s error: %s", filePath, err.Error())
 		return fmt.Errorf("decode IndexBlock failed: %w", err)
 	}
 
+	// 加载的 SSTable 是只读的
+	t.dataBlock, t.indexBuilder = nil, nil
+	t.unpinIndexAndFilter()
 	return nil
 }
 
-// EncodeTo 将 SSTable 的各个部分（DataBlocks、IndexBlock、FilterBlock、Footer）依次写入文件中
+// EncodeTo 将已经在内存中构建的 SSTable 的各个部分（数据块、FilterBlock、RangeDelBlock、IndexBlock、Header、Footer）
+// 依次写入临时文件，落盘之后原子地重命名为 filePath。Header 按调用方设置的内容写入。
+// 数据块写入文件之后不再保留在内存中，已经写入的 SSTable 不能再写入其他路径
 func (t *SSTable) EncodeTo(filePath string) error {
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		log.Errorf("create directory %s error: %s", filepath.Dir(filePath), err.Error())
-		return fmt.Errorf("create directory failed: %w", err)
+	if t.size > 0 {
+		if filePath == t.filePath {
+			return nil
+		}
+		log.Errorf("sstable %s has already been written", t.filePath)
+		return fmt.Errorf("sstable %s has already been written", t.filePath)
 	}
-	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	t.filePath = filePath
+	w, err := NewTableWriter(t, 0)
 	if err != nil {
-		log.Errorf("open file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("open file error: %w", err)
+		return err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Errorf("close file %s error: %s", filePath, err.Error())
-		}
-	}(file)
-	t.filePath = filePath
+	return w.finish()
+}
 
-	if err = t.Header.EncodeTo(file); err != nil {
-		log.Errorf("encode Header to file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("encode Header failed: %w", err)
+// encodeMetaTo 在 offset 字节的数据块之后依次写入 FilterBlock、RangeDelBlock、IndexBlock、Header 和 Footer，
+// 记录各部分的位置和文件大小。调用之前 SSTable 必须已经构建完成
+func (t *SSTable) encodeMetaTo(w io.Writer, offset int64) error {
+	t.Footer.DataHandle = block.NewHandle(0, offset)
+	// writeSection 写入一个部分并返回它的位置
+	writeSection := func(data []byte) (block.Handle, error) {
+		if _, err := w.Write(data); err != nil {
+			return block.Handle{}, err
+		}
+		handle := block.NewHandle(offset, int64(len(data)))
+		offset += int64(len(data))
+		return handle, nil
 	}
 
-	if err = t.FilterBlock.EncodeTo(file); err != nil {
-		log.Errorf("encode FilterBlock to file %s error: %s", filePath, err.Error())
+	var err error
+	buf := &bytes.Buffer{}
+	if err = t.FilterBlock.EncodeTo(buf); err != nil {
+		log.Errorf("encode FilterBlock to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode FilterBlock failed: %w", err)
+	}
+	if t.Footer.FilterHandle, err = writeSection(block.EncodeContents(buf.Bytes(), nil)); err != nil {
+		log.Errorf("encode FilterBlock to file %s error: %s", t.filePath, err.Error())
 		return fmt.Errorf("encode FilterBlock failed: %w", err)
 	}
 
-	// 记录 DataBlock 的起始偏移量和大小
-	if t.Footer.DataHandle.Offset, err = file.Seek(0, io.SeekCurrent); err != nil {
-		log.Errorf("seek to index block position error: %s", err.Error())
-		return fmt.Errorf("seek to index block position failed: %w", err)
+	buf.Reset()
+	if _, err = t.RangeDelBlock.EncodeTo(buf); err != nil {
+		log.Errorf("encode RangeDelBlock to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode RangeDelBlock failed: %w", err)
 	}
-	for i, value := range t.DataBlock.Entries {
-		if t.IndexBlock.Indexes[i].Offset, err = file.Seek(0, io.SeekCurrent); err != nil {
-			log.Errorf("seek to current position error: %s", err.Error())
-			return fmt.Errorf("seek to current position failed: %w", err)
-		}
-		size, err := value.EncodeTo(file)
-		if err != nil {
-			log.Errorf("encode DataBlock to file %s error: %s", filePath, err.Error())
-			return fmt.Errorf("encode DataBlock failed: %w", err)
-		}
-		t.Footer.DataHandle.Size += size
+	if t.Footer.RangeDelHandle, err = writeSection(block.EncodeContents(buf.Bytes(), nil)); err != nil {
+		log.Errorf("encode RangeDelBlock to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode RangeDelBlock failed: %w", err)
 	}
 
-	// 记录 IndexBlock 的起始偏移量和大小
-	if t.Footer.IndexHandle.Offset, err = file.Seek(0, io.SeekCurrent); err != nil {
-		log.Errorf("seek to index block position error: %s", err.Error())
-		return fmt.Errorf("seek to index block position failed: %w", err)
-	}
-	if t.Footer.IndexHandle.Size, err = t.IndexBlock.Encode(file); err != nil {
-		log.Errorf("encode IndexBlock to file %s error: %s", filePath, err.Error())
+	if t.Footer.IndexHandle, err = writeSection(block.EncodeContents(t.IndexBlock.Data(), t.compressor)); err != nil {
+		log.Errorf("encode IndexBlock to file %s error: %s", t.filePath, err.Error())
 		return fmt.Errorf("encode IndexBlock failed: %w", err)
 	}
 
-	if err = t.Footer.EncodeTo(file); err != nil {
-		log.Errorf("encode Footer to file %s error: %s", filePath, err.Error())
-		return fmt.Errorf("encode Footer failed: %w", err)
+	buf.Reset()
+	if err = t.Header.EncodeTo(buf); err != nil {
+		log.Errorf("encode Header to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode Header failed: %w", err)
+	}
+	if t.Footer.HeaderHandle, err = writeSection(block.EncodeContents(buf.Bytes(), nil)); err != nil {
+		log.Errorf("encode Header to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode Header failed: %w", err)
 	}
 
+	if err = t.Footer.EncodeTo(w); err != nil {
+		log.Errorf("encode Footer to file %s error: %s", t.filePath, err.Error())
+		return fmt.Errorf("encode Footer failed: %w", err)
+	}
+	t.size = uint64(offset + block.FooterSize)
+	t.modTime = time.Now()
 	return nil
 }
 
@@ -199,109 +277,234 @@ func (t *SSTable) DecodeFooterFrom(file *os.File) error {
 		log.Errorf("get file info for %s error: %s", t.filePath, err.Error())
 		return fmt.Errorf("get file info failed: %w", err)
 	}
-	if _, err = file.Seek(fileInfo.Size()-block.FooterSize, io.SeekStart); err != nil {
-		log.Errorf("seek to footer position in file %s error: %s", t.filePath, err.Error())
-		return fmt.Errorf("seek to footer position failed: %w", err)
+	t.size = uint64(fileInfo.Size())
+	t.modTime = fileInfo.ModTime()
+	footerOffset := fileInfo.Size() - block.FooterSize
+	if footerOffset < 0 {
+		return t.corruption(0, fmt.Errorf("file is too small: %d bytes", fileInfo.Size()))
 	}
-	if err = t.Footer.DecodeFrom(file); err != nil {
-		log.Errorf("decode Footer from file %s error: %s", t.filePath, err.Error())
-		return fmt.Errorf("decode Footer failed: %w", err)
+	if err = t.Footer.DecodeFrom(io.NewSectionReader(file, footerOffset, block.FooterSize)); err != nil {
+		return t.corruption(footerOffset, err)
 	}
 
 	return nil
 }
 
-func (t *SSTable) DecodeDataBlock(file *os.File) error {
-	if _, err := file.Seek(t.Footer.DataHandle.Offset, io.SeekStart); err != nil {
-		log.Errorf("seek to IndexBlock position error: %s", err.Error())
-		return fmt.Errorf("seek to IndexBlock position failed: %w", err)
+// corruption 返回 t 的文件在 offset 处损坏的错误
+func (t *SSTable) corruption(offset int64, err error) error {
+	log.Errorf("file %s is corrupted at offset %d: %s", t.filePath, offset, err.Error())
+	return &CorruptionError{File: t.filePath, Offset: offset, Err: err}
+}
+
+// readContents 读取 handle 所指的块并返回解压后的内容，verifyChecksum 为 true 时检查校验和
+func (t *SSTable) readContents(r io.ReaderAt, handle block.Handle, verifyChecksum bool) ([]byte, error) {
+	if handle.Offset < 0 || handle.Size < block.TrailerSize || uint64(handle.Offset+handle.Size) > max(t.size, uint64(len(t.data))) {
+		return nil, t.corruption(handle.Offset, fmt.Errorf("block handle (%d, %d) is out of range", handle.Offset, handle.Size))
 	}
-	if err := t.DataBlock.DecodeFrom(file, t.Footer.DataHandle.Size); err != nil {
-		log.Errorf("decode DataBlock from file error: %s", err.Error())
-		return fmt.Errorf("decode DataBlock failed: %w", err)
+	data := make([]byte, handle.Size)
+	if _, err := r.ReadAt(data, handle.Offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			// 文件比 Footer 记录的短，说明被截断
+			return nil, t.corruption(handle.Offset, err)
+		}
+		log.Errorf("read block at offset %d of file %s error: %s", handle.Offset, t.filePath, err.Error())
+		return nil, fmt.Errorf("read block at offset %d failed: %w", handle.Offset, err)
 	}
+	raw, err := block.DecodeContents(data, verifyChecksum)
+	if err != nil {
+		return nil, t.corruption(handle.Offset, err)
+	}
+	return raw, nil
+}
 
-	return nil
+// readBlock 读取并解析 handle 所指的块
+func (t *SSTable) readBlock(r io.ReaderAt, handle block.Handle, verifyChecksum bool) (*block.Block, error) {
+	raw, err := t.readContents(r, handle, verifyChecksum)
+	if err != nil {
+		return nil, err
+	}
+	b, err := block.NewBlock(raw)
+	if err != nil {
+		return nil, t.corruption(handle.Offset, err)
+	}
+	return b, nil
 }
 
-func (t *SSTable) GetDataBlockFromFile(path string) ([]kv.KeyValuePair, error) {
-	file, err := os.Open(path)
+// decodeSection 检查 handle 所指元数据部分的校验和，然后使用 decode 解析其内容
+func (t *SSTable) decodeSection(r io.ReaderAt, handle block.Handle, decode func(r io.Reader, size int64) error) error {
+	raw, err := t.readContents(r, handle, true)
 	if err != nil {
-		log.Errorf("open file %s error: %s", path, err.Error())
-		return nil, fmt.Errorf("open file error: %w", err)
+		return err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Errorf("close file %s error: %s", path, err.Error())
-		}
-	}(file)
+	if err = decode(bytes.NewReader(raw), int64(len(raw))); err != nil {
+		return t.corruption(handle.Offset, err)
+	}
+	return nil
+}
 
-	if err = t.DecodeDataBlock(file); err != nil {
-		log.Errorf("decode DataBlock from file %s error: %s", path, err.Error())
-		return nil, fmt.Errorf("decode DataBlock failed: %w", err)
+// readDataBlock 读取 handle 所指的数据块。fillCache 为 true 时优先从块缓存中读取，未命中时读取文件并放入缓存；
+// 还保留着内存数据的 SSTable 不使用缓存。命中缓存时不再检查校验和
+func (t *SSTable) readDataBlock(r io.ReaderAt, handle block.Handle, verifyChecksum, fillCache bool) (*block.Block, error) {
+	if t.cache == nil || t.data != nil || !fillCache {
+		return t.readBlock(r, handle, verifyChecksum)
 	}
+	key := cache.Key{FileID: t.id, Offset: handle.Offset}
+	if value, ok := t.cache.Get(key); ok {
+		return value.(*block.Block), nil
+	}
+	b, err := t.readBlock(r, handle, verifyChecksum)
+	if err != nil {
+		return nil, err
+	}
+	t.cache.Insert(key, b, len(b.Data()))
+	return b, nil
+}
 
-	return t.GetKeyValuePairs()
+// index 返回索引块。尚未构建完成的 SSTable 返回 nil；索引块没有常驻内存时通过块缓存读取
+func (t *SSTable) index() (*block.Block, error) {
+	if t.IndexBlock != nil || t.indexBuilder != nil {
+		return t.IndexBlock, nil
+	}
+	value, err := t.readMetaBlock(t.Footer.IndexHandle, func(raw []byte) (any, error) {
+		return block.NewBlock(raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*block.Block), nil
 }
 
-func (t *SSTable) GetKeyValuePairs() ([]kv.KeyValuePair, error) {
-	// 校验 DataBlock 和 IndexBlock 是否匹配
-	if t.DataBlock.Len() == 0 || t.IndexBlock.Len() == 0 {
-		log.Warnf("SSTable %s has no data or index entries", t.filePath)
-		return nil, nil
+// filter 返回布隆过滤器。尚未构建完成的 SSTable 返回 nil；布隆过滤器没有常驻内存时通过块缓存读取
+func (t *SSTable) filter() (*bloom.Filter, error) {
+	if t.FilterBlock != nil || t.indexBuilder != nil {
+		return t.FilterBlock, nil
 	}
-	if t.DataBlock.Len() != t.IndexBlock.Len() {
-		log.Errorf("SSTable %s has mismatched DataBlock and IndexBlock entries", t.filePath)
-		return nil, errors.New("mismatched DataBlock and IndexBlock entries")
+	value, err := t.readMetaBlock(t.Footer.FilterHandle, func(raw []byte) (any, error) {
+		f := new(bloom.Filter)
+		return f, f.DecodeFrom(bytes.NewReader(raw))
+	})
+	if err != nil {
+		return nil, err
 	}
+	return value.(*bloom.Filter), nil
+}
 
-	pairs := make([]kv.KeyValuePair, 0)
-	for i, entry := range t.DataBlock.Entries {
-		pairs = append(pairs, kv.KeyValuePair{
-			Key:   t.IndexBlock.Indexes[i].Key,
-			Value: entry,
-		})
+// readMetaBlock 读取 handle 所指的索引块或布隆过滤器，优先从块缓存中读取，未命中时从文件中读取、
+// 使用 parse 解析并放入缓存。元数据总是检查校验和
+func (t *SSTable) readMetaBlock(handle block.Handle, parse func(raw []byte) (any, error)) (any, error) {
+	key := cache.Key{FileID: t.id, Offset: handle.Offset}
+	if t.cache != nil {
+		if value, ok := t.cache.Get(key); ok {
+			return value, nil
+		}
 	}
 
-	return pairs, nil
-}
+	file, err := t.files.acquire(t)
+	if err != nil {
+		return nil, err
+	}
+	defer t.files.release(file)
 
-// GetValueByOffset 根据给定的偏移量从 SSTable 中获取对应的 Value。
-func (t *SSTable) GetValueByOffset(offset int64) (kv.Value, error) {
-	file, err := os.Open(t.filePath)
+	raw, err := t.readContents(file, handle, true)
 	if err != nil {
-		log.Errorf("open file %s error: %s", t.filePath, err.Error())
-		return nil, fmt.Errorf("open file error: %w", err)
+		return nil, err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Errorf("close file %s error: %s", t.filePath, err.Error())
-		}
-	}(file)
+	value, err := parse(raw)
+	if err != nil {
+		return nil, t.corruption(handle.Offset, err)
+	}
+	if t.cache != nil {
+		t.cache.Insert(key, value, len(raw))
+	}
+	return value, nil
+}
 
-	if _, err = file.Seek(offset, io.SeekStart); err != nil {
-		log.Errorf("seek to offset error: %s", err.Error())
-		return nil, fmt.Errorf("seek to offset failed: %w", err)
+// unpinIndexAndFilter 在不需要常驻内存时释放索引块和布隆过滤器，只能在 SSTable 写入或加载文件之后调用
+func (t *SSTable) unpinIndexAndFilter() {
+	if !t.pinIndexAndFilter {
+		t.IndexBlock, t.FilterBlock = nil, nil
 	}
+}
 
-	value := kv.Value{}
-	if err = value.DecodeFrom(file); err != nil {
-		log.Errorf("decode value error: %s", err.Error())
-		return nil, fmt.Errorf("decode value failed: %w", err)
+// GetKeyValuePairs 返回 SSTable 中的所有记录，区间删除标记追加在点记录之后
+func (t *SSTable) GetKeyValuePairs() ([]kv.KeyValuePair, error) {
+	it := NewSSTableIterator(t)
+	defer it.Close()
+
+	pairs := make([]kv.KeyValuePair, 0)
+	for ; it.Valid(); it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, kv.KeyValuePair{
+			Key:   it.Key(),
+			Value: value,
+			Seq:   it.Seq(),
+			Kind:  it.Kind(),
+		})
 	}
+	if err := it.Error(); err != nil {
+		log.Errorf("read SSTable %s error: %s", t.filePath, err.Error())
+		return nil, fmt.Errorf("read SSTable %s failed: %w", t.filePath, err)
+	}
+	pairs = append(pairs, t.RangeDelBlock.Tombstones...)
 
-	return value, nil
+	return pairs, nil
 }
 
 // MayContain uses bloom filter to determine if the given key maybe present in the SSTable.
 // Returns true if the key MAYBE present, false otherwise.
 func (t *SSTable) MayContain(key kv.Key) bool {
-	if t.Header.MinKey > key || t.Header.MaxKey < key {
-		return false
+	return t.inKeyRange(key) && t.filterMayContain(key)
+}
+
+// inKeyRange 判断 key 是否在 Header 记录的 key 范围之内
+func (t *SSTable) inKeyRange(key kv.Key) bool {
+	return t.Header.MinKey <= key && key <= t.Header.MaxKey
+}
+
+// filterMayContain 使用布隆过滤器判断 key 是否可能存在，没有布隆过滤器时返回 true
+func (t *SSTable) filterMayContain(key kv.Key) bool {
+	f, err := t.filter()
+	if err != nil || f == nil {
+		// 无法判断时按可能存在处理，由之后的读取报告错误
+		return true
+	}
+	return f.MayContain(key)
+}
+
+// MaxSequence 返回 SSTable 中记录的最大序列号
+func (t *SSTable) MaxSequence() uint64 {
+	return t.Header.MaxSeq
+}
+
+// MinSequence 返回 SSTable 中记录的最小序列号，没有记录时返回 0
+func (t *SSTable) MinSequence() uint64 {
+	return t.Header.MinSeq
+}
+
+// Size 返回 SSTable 文件的大小（字节）
+func (t *SSTable) Size() uint64 {
+	return t.size
+}
+
+// Level 返回 SSTable 所在的层级
+func (t *SSTable) Level() int {
+	return t.level
+}
+
+// Meta 返回记录到 MANIFEST 中的元信息
+func (t *SSTable) Meta() FileMeta {
+	return FileMeta{
+		Level:  t.level,
+		ID:     t.id,
+		Size:   t.size,
+		MinKey: t.Header.MinKey,
+		MaxKey: t.Header.MaxKey,
+		MinSeq: t.MinSequence(),
+		MaxSeq: t.MaxSequence(),
 	}
-	return t.FilterBlock.MayContain(key)
 }
 
 // ID returns the id of SSTable.
@@ -309,8 +512,22 @@ func (t *SSTable) ID() uint64 {
 	return t.id
 }
 
-// Remove 释放 SSTable
+// ref 增加引用该 SSTable 的版本数量
+func (t *SSTable) ref() {
+	t.refs.Add(1)
+}
+
+// unref 减少引用该 SSTable 的版本数量，最后一个版本释放之后删除文件
+func (t *SSTable) unref() {
+	if t.refs.Add(-1) > 0 {
+		return
+	}
+	_ = t.Remove()
+}
+
+// Remove 释放 SSTable：关闭缓存的文件并删除文件
 func (t *SSTable) Remove() error {
+	t.files.evict(t.id)
 	if err := os.Remove(t.filePath); err != nil {
 		log.Errorf("remove file %s error: %s", t.filePath, err.Error())
 		return err
@@ -318,11 +535,79 @@ func (t *SSTable) Remove() error {
 	return nil
 }
 
-// Add 加入新的 KV 对到 SSTable
+// Add 加入新的 KV 对到 SSTable，区间删除标记单独存放在 RangeDelBlock 中。
+// 点记录必须按 Key 升序、同一 Key 内按 Seq 降序加入，当前数据块写满之后开始新的数据块。
 func (t *SSTable) Add(pair *kv.KeyValuePair) {
-	t.DataBlock.Add(pair.Value)
-	t.IndexBlock.Add(pair.Key, 0)
-	t.FilterBlock.Add([]byte(pair.Key))
+	t.updateSequenceRange(pair.Seq)
+	if pair.Kind == kv.KindRangeDelete {
+		t.RangeDelBlock.Add(*pair)
+		return
+	}
+
+	if t.entries == 0 {
+		t.firstKey = pair.Key
+	}
+	if t.entries == 0 || pair.Key != t.lastPair.Key {
+		t.filterHashes = append(t.filterHashes, bloom.KeyHash(pair.Key))
+	}
+	t.dataBlock.Add(pair.Key, pair.Seq, pair.Kind, pair.Value)
+	t.lastPair = kv.KeyValuePair{Key: pair.Key, Seq: pair.Seq, Kind: pair.Kind}
+	t.entries++
+
+	if t.dataBlock.EstimatedSize() >= t.blockSize {
+		t.flushDataBlock()
+	}
+}
+
+// updateSequenceRange 将 seq 计入 Header 中的序列号范围
+func (t *SSTable) updateSequenceRange(seq uint64) {
+	if t.records == 0 || seq < t.Header.MinSeq {
+		t.Header.MinSeq = seq
+	}
+	if t.records == 0 || seq > t.Header.MaxSeq {
+		t.Header.MaxSeq = seq
+	}
+	t.records++
+}
+
+// flushDataBlock 结束当前数据块，压缩之后追加到已编码的数据之后并为其添加索引项
+func (t *SSTable) flushDataBlock() {
+	if t.dataBlock.Empty() {
+		return
+	}
+	data := block.EncodeContents(t.dataBlock.Finish(), t.compressor)
+	handle := block.NewHandle(t.dataSize, int64(len(data)))
+	t.data = append(t.data, data...)
+	t.dataSize += int64(len(data))
+	t.indexBuilder.Add(t.lastPair.Key, t.lastPair.Seq, t.lastPair.Kind, handle.Bytes())
+	t.dataBlock.Reset()
+}
+
+// finish 结束最后一个数据块并生成索引块和布隆过滤器，之后不能再加入记录。已经完成或从文件加载的 SSTable 不做任何处理
+func (t *SSTable) finish() {
+	if t.indexBuilder == nil {
+		return
+	}
+	t.flushDataBlock()
+	// 自己编码的块一定可以解析
+	t.IndexBlock, _ = block.NewBlock(t.indexBuilder.Finish())
+	t.FilterBlock = bloom.NewWithBitsPerKey(uint(len(t.filterHashes)), t.bitsPerKey)
+	for _, h := range t.filterHashes {
+		t.FilterBlock.AddHash(h)
+	}
+	t.dataBlock, t.indexBuilder, t.filterHashes = nil, nil, nil
+}
+
+// releaseData 释放已经写入文件的数据块，之后的读取从文件中加载数据块。
+// 索引块和布隆过滤器不需要常驻内存时一并释放
+func (t *SSTable) releaseData() {
+	t.data = nil
+	t.unpinIndexAndFilter()
+}
+
+// RangeTombstones 返回 SSTable 中的所有区间删除标记
+func (t *SSTable) RangeTombstones() []kv.KeyValuePair {
+	return t.RangeDelBlock.Tombstones
 }
 
 func (t *SSTable) FilePath() string {
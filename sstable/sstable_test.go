@@ -1,15 +1,19 @@
 package sstable
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/sstable/block"
+	"github.com/xmh1011/go-lsm/sstable/compress"
 )
 
 func setupTestEnv(t *testing.T) string {
@@ -26,47 +30,60 @@ func cleanupTestEnv(t *testing.T, dir string) {
 	}
 }
 
-func createSampleSSTable(level int) *SSTable {
-	table := NewSSTableWithLevel(level)
+func createSampleSSTable(t *testing.T, level int) *SSTable {
+	table := newTestManager(t).newTable(level)
 
 	// Add some sample data
-	table.DataBlock.Entries = []kv.Value{
-		kv.Value("value1"),
-		kv.Value("value2"),
-	}
-
-	// Setup index block
-	table.IndexBlock.Indexes = []*block.IndexEntry{
-		{Key: "key1", Offset: 0},
-		{Key: "key2", Offset: 100},
-	}
+	table.Add(&kv.KeyValuePair{Key: "key1", Value: kv.Value("value1")})
+	table.Add(&kv.KeyValuePair{Key: "key2", Value: kv.Value("value2")})
 
 	// Setup header
-	table.Header = &block.Header{
-		MinKey: "key1",
-		MaxKey: "key2",
-	}
-
-	// Setup filter
-	table.FilterBlock.Add([]byte(("key1")))
-	table.FilterBlock.Add([]byte(("key2")))
+	table.Header.MinKey = "key1"
+	table.Header.MaxKey = "key2"
 
+	table.finish()
 	return table
 }
 
+// createMultiBlockSSTable 创建数据块很小的 SSTable，每个 key 有 versions 个版本，记录分布在多个数据块中
+func createMultiBlockSSTable(t *testing.T, keys, versions int) *SSTable {
+	builder := newTestManager(t).newBuilder(0)
+	builder.table.blockSize = 64
+	for i := 0; i < keys; i++ {
+		key := kv.Key(fmt.Sprintf("key%03d", i))
+		for seq := versions; seq > 0; seq-- {
+			builder.Add(&kv.KeyValuePair{Key: key, Value: kv.Value(fmt.Sprintf("%s-%d", key, seq)), Seq: uint64(seq)})
+		}
+	}
+	return builder.Build()
+}
+
 func TestNewSSTable(t *testing.T) {
 	table := NewSSTable()
-	assert.NotZero(t, table.id)
+	// ID 由 Manager 分配
+	assert.Zero(t, table.id)
+	assert.NotNil(t, table.Footer)
+	assert.NotNil(t, table.dataBlock)
+	// 索引块和布隆过滤器在构建完成之后才生成
+	assert.Nil(t, table.IndexBlock)
+	assert.Nil(t, table.FilterBlock)
+	table.finish()
 	assert.NotNil(t, table.IndexBlock)
 	assert.NotNil(t, table.FilterBlock)
-	assert.NotNil(t, table.Footer)
-	assert.NotNil(t, table.DataBlock)
+	assert.Nil(t, table.dataBlock)
 }
 
-func TestNewSSTableWithLevel(t *testing.T) {
-	table := NewSSTableWithLevel(1)
+func TestManagerNewTable(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewSSTableManager(DefaultOptions(dir))
+	table := manager.newTable(1)
 	assert.Equal(t, 1, table.level)
-	assert.NotEmpty(t, table.filePath)
+	assert.Equal(t, uint64(1), table.id)
+	assert.Equal(t, sstableFilePath(1, 1, dir), table.filePath)
+
+	// 每个 Manager 独立分配 ID
+	assert.Equal(t, uint64(2), manager.newTable(0).id)
+	assert.Equal(t, uint64(1), newTestManager(t).newTable(0).id)
 }
 
 func TestEncodeDecode(t *testing.T) {
@@ -74,7 +91,7 @@ func TestEncodeDecode(t *testing.T) {
 	defer cleanupTestEnv(t, tempDir)
 
 	// Create and encode
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 	assert.FileExists(t, table.filePath)
@@ -87,14 +104,20 @@ func TestEncodeDecode(t *testing.T) {
 	// Verify decoded data
 	assert.Equal(t, table.Header.MinKey, newTable.Header.MinKey)
 	assert.Equal(t, table.Header.MaxKey, newTable.Header.MaxKey)
-	assert.Equal(t, len(table.IndexBlock.Indexes), len(newTable.IndexBlock.Indexes))
+	assert.Equal(t, table.IndexBlock.Data(), newTable.IndexBlock.Data())
+
+	expected, err := table.GetKeyValuePairs()
+	assert.NoError(t, err)
+	pairs, err := newTable.GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, pairs)
 }
 
 func TestDecodeFooterFrom(t *testing.T) {
 	tempDir := setupTestEnv(t)
 	defer cleanupTestEnv(t, tempDir)
 
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 
@@ -112,93 +135,233 @@ func TestDecodeFooterFrom(t *testing.T) {
 	assert.NotZero(t, newTable.Footer.IndexHandle.Size)
 }
 
-func TestDecodeDataBlock(t *testing.T) {
-	tempDir := setupTestEnv(t)
-	defer cleanupTestEnv(t, tempDir)
-
-	table := createSampleSSTable(0)
-	err := table.EncodeTo(table.filePath)
+// TestGetKeyValuePairsRangeDelOnly 测试只包含区间删除标记的 SSTable 不会把后续的块当作数据读取
+func TestGetKeyValuePairsRangeDelOnly(t *testing.T) {
+	table := newTestManager(t).newTable(0)
+	tombstone := kv.KeyValuePair{Key: "a", Value: kv.Value("b"), Seq: 5, Kind: kv.KindRangeDelete}
+	table.RangeDelBlock.Add(tombstone)
+	table.Header = block.NewHeader("a", "b")
+	filePath := filepath.Join(t.TempDir(), "1.sst")
+	assert.NoError(t, table.EncodeTo(filePath))
+
+	decoded := NewRecoverSSTable(0)
+	assert.NoError(t, decoded.DecodeFrom(filePath))
+	pairs, err := decoded.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.Equal(t, []kv.KeyValuePair{tombstone}, pairs)
+}
 
-	file, err := os.Open(table.filePath)
-	assert.NoError(t, err)
-	defer func(file *os.File) {
-		err := file.Close()
-		assert.NoError(t, err)
-	}(file)
+func TestGetKeyValuePairs(t *testing.T) {
+	table := createSampleSSTable(t, 0)
 
-	newTable := NewRecoverSSTable(0)
-	// First decode footer to get data block position
-	err = newTable.DecodeFooterFrom(file)
+	// Test normal case
+	pairs, err := table.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pairs))
+	assert.Equal(t, kv.Key("key1"), pairs[0].Key)
+	assert.Equal(t, kv.Key("key2"), pairs[1].Key)
 
-	// Reset file pointer
-	_, err = file.Seek(0, 0)
+	assert.Equal(t, kv.Value("value1"), pairs[0].Value)
+
+	// 写入文件并释放内存数据之后从文件中读取
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	table.releaseData()
+	pairs, err = table.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pairs))
+	assert.Equal(t, kv.Value("value2"), pairs[1].Value)
 
-	// Decode data block
-	err = newTable.DecodeDataBlock(file)
+	// Test empty case
+	pairs, err = NewSSTable().GetKeyValuePairs()
 	assert.NoError(t, err)
-	assert.Equal(t, len(table.DataBlock.Entries), len(newTable.DataBlock.Entries))
+	assert.Empty(t, pairs)
 }
 
-func TestGetDataBlockFromFile(t *testing.T) {
-	tempDir := setupTestEnv(t)
-	defer cleanupTestEnv(t, tempDir)
+// TestMultipleDataBlocks 测试记录超过数据块大小时分成多个数据块，同一 key 的版本可以跨越数据块
+func TestMultipleDataBlocks(t *testing.T) {
+	table := createMultiBlockSSTable(t, 20, 3)
+	it := table.IndexBlock.NewIterator()
+	blocks := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		handle, err := block.ParseHandle(it.Value())
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, handle.Size, int64(2*table.blockSize))
+		blocks++
+	}
+	assert.Greater(t, blocks, 1)
 
-	table := createSampleSSTable(0)
-	err := table.EncodeTo(table.filePath)
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	decoded := NewRecoverSSTable(0)
+	assert.NoError(t, decoded.DecodeFrom(table.filePath))
+	pairs, err := decoded.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.Len(t, pairs, 60)
+	for i, pair := range pairs {
+		key := kv.Key(fmt.Sprintf("key%03d", i/3))
+		seq := uint64(3 - i%3)
+		assert.Equal(t, key, pair.Key)
+		assert.Equal(t, seq, pair.Seq)
+		assert.Equal(t, kv.Value(fmt.Sprintf("%s-%d", key, seq)), pair.Value)
+	}
 
-	err = table.DecodeFrom(table.filePath)
-	assert.NoError(t, err)
-	table.DataBlock.Entries = make([]kv.Value, 0)
+	// 每个 key 的所有版本都能按序列号读取
+	for i := 0; i < 20; i++ {
+		key := kv.Key(fmt.Sprintf("key%03d", i))
+		for seq := uint64(1); seq <= 3; seq++ {
+			pair, err := searchFromTable(decoded, key, seq)
+			assert.NoError(t, err)
+			assert.Equal(t, kv.Value(fmt.Sprintf("%s-%d", key, seq)), pair.Value)
+		}
+	}
+}
 
-	pairs, err := table.GetDataBlockFromFile(table.filePath)
+// firstDataBlockHandle 返回 table 中第一个数据块的 Handle
+func firstDataBlockHandle(t *testing.T, table *SSTable) block.Handle {
+	it := table.IndexBlock.NewIterator()
+	it.SeekToFirst()
+	handle, err := block.ParseHandle(it.Value())
 	assert.NoError(t, err)
-	assert.Equal(t, 2, len(pairs))
-	assert.Equal(t, kv.Key("key1"), pairs[0].Key)
-	assert.Equal(t, kv.Key("key2"), pairs[1].Key)
+	return handle
 }
 
-func TestGetKeyValuePairs(t *testing.T) {
-	table := createSampleSSTable(0)
+// TestReadCorruptedDataBlock 测试数据块损坏时读取返回指明文件和偏移量的 ErrCorruption
+func TestReadCorruptedDataBlock(t *testing.T) {
+	table := createMultiBlockSSTable(t, 20, 1)
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	handle := firstDataBlockHandle(t, table)
 
-	// Test normal case
-	pairs, err := table.GetKeyValuePairs()
+	data, err := os.ReadFile(table.filePath)
 	assert.NoError(t, err)
-	assert.Equal(t, 2, len(pairs))
-	assert.Equal(t, kv.Key("key1"), pairs[0].Key)
-	assert.Equal(t, kv.Key("key2"), pairs[1].Key)
+	// 第一个数据块内容的末尾是重启点数量，改成一个非法的值
+	end := handle.Offset + handle.Size - block.TrailerSize
+	copy(data[end-4:end], []byte{0xff, 0xff, 0xff, 0xff})
+	assert.NoError(t, os.WriteFile(table.filePath, data, 0644))
+
+	decoded := NewRecoverSSTable(0)
+	assert.NoError(t, decoded.DecodeFrom(table.filePath))
+	_, err = decoded.GetKeyValuePairs()
+	assert.ErrorIs(t, err, ErrCorruption)
+	var corruption *CorruptionError
+	assert.ErrorAs(t, err, &corruption)
+	assert.Equal(t, table.filePath, corruption.File)
+	assert.Equal(t, handle.Offset, corruption.Offset)
+}
 
-	// Test mismatched lengths
-	table.DataBlock.Entries = table.DataBlock.Entries[:1]
-	_, err = table.GetKeyValuePairs()
-	assert.Error(t, err)
+// TestVerifyChecksums 测试数据块中的比特翻转只在开启校验时被发现，合并总是检查校验和
+func TestVerifyChecksums(t *testing.T) {
+	table := createMultiBlockSSTable(t, 20, 1)
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	handle := firstDataBlockHandle(t, table)
 
-	// Test empty case
-	table.DataBlock.Entries = nil
-	table.IndexBlock.Indexes = nil
-	pairs, err = table.GetKeyValuePairs()
+	// 修改第一条记录 value 的最后一个字节，数据块仍然可以解析
+	data, err := os.ReadFile(table.filePath)
 	assert.NoError(t, err)
-	assert.Empty(t, pairs)
-}
-
-func TestGetValueByOffset(t *testing.T) {
-	tempDir := setupTestEnv(t)
-	defer cleanupTestEnv(t, tempDir)
+	original, err := block.DecodeContents(data[handle.Offset:handle.Offset+handle.Size], true)
+	assert.NoError(t, err)
+	pos := bytes.Index(original, []byte("key000-1")) + len("key000-1") - 1
+	data[handle.Offset+int64(pos)] ^= 0x01
+	assert.NoError(t, os.WriteFile(table.filePath, data, 0644))
 
-	table := createSampleSSTable(0)
-	err := table.EncodeTo(table.filePath)
+	decoded := NewRecoverSSTable(0)
+	assert.NoError(t, decoded.DecodeFrom(table.filePath))
+	pairs, err := decoded.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.NotEqual(t, kv.Value("key000-1"), pairs[0].Value)
+
+	decoded.verifyChecksums = true
+	_, err = decoded.GetKeyValuePairs()
+	assert.ErrorIs(t, err, ErrCorruption)
+	_, err = searchFromTable(decoded, "key000", 1)
+	assert.ErrorIs(t, err, ErrCorruption)
 
-	value, err := table.GetValueByOffset(table.IndexBlock.Indexes[0].Offset)
+	decoded.verifyChecksums = false
+	_, err = openTableIterator(decoded)
+	assert.ErrorIs(t, err, ErrCorruption)
+}
+
+// TestDecodeFromCorruptedFile 测试截断、魔数错误和元数据损坏的文件加载失败并返回 ErrCorruption
+func TestDecodeFromCorruptedFile(t *testing.T) {
+	table := createMultiBlockSSTable(t, 20, 1)
+	assert.NoError(t, table.EncodeTo(table.filePath))
+	data, err := os.ReadFile(table.filePath)
 	assert.NoError(t, err)
-	assert.Equal(t, table.DataBlock.Entries[0], value)
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+		offset int64
+	}{
+		{
+			name:   "truncated",
+			modify: func(data []byte) []byte { return data[:len(data)-1] },
+			offset: int64(len(data)) - 1 - block.FooterSize,
+		},
+		{
+			name:   "too small",
+			modify: func(data []byte) []byte { return data[:block.FooterSize-1] },
+			offset: 0,
+		},
+		{
+			name: "bad magic",
+			modify: func(data []byte) []byte {
+				data[len(data)-1] ^= 0xff
+				return data
+			},
+			offset: int64(len(data)) - block.FooterSize,
+		},
+		{
+			name: "corrupted footer handle",
+			modify: func(data []byte) []byte {
+				data[len(data)-block.FooterSize+block.HandleSize] ^= 0x01
+				return data
+			},
+			offset: int64(len(data)) - block.FooterSize,
+		},
+		{
+			name: "corrupted index block",
+			modify: func(data []byte) []byte {
+				data[table.Footer.IndexHandle.Offset] ^= 0x01
+				return data
+			},
+			offset: table.Footer.IndexHandle.Offset,
+		},
+		{
+			name: "corrupted header",
+			modify: func(data []byte) []byte {
+				data[table.Footer.HeaderHandle.Offset] ^= 0x01
+				return data
+			},
+			offset: table.Footer.HeaderHandle.Offset,
+		},
+		{
+			name: "handle out of range",
+			modify: func(data []byte) []byte {
+				// 保留 Footer，删除其之前的一部分元数据
+				footer := append([]byte{}, data[len(data)-block.FooterSize:]...)
+				return append(data[:table.Footer.HeaderHandle.Offset], footer...)
+			},
+			offset: table.Footer.HeaderHandle.Offset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "1.sst")
+			assert.NoError(t, os.WriteFile(filePath, tt.modify(append([]byte{}, data...)), 0644))
+
+			err := NewRecoverSSTable(0).DecodeFrom(filePath)
+			assert.ErrorIs(t, err, ErrCorruption)
+			var corruption *CorruptionError
+			if assert.ErrorAs(t, err, &corruption) {
+				assert.Equal(t, filePath, corruption.File)
+				assert.Equal(t, tt.offset, corruption.Offset)
+			}
+		})
+	}
 }
 
 func TestMayContain(t *testing.T) {
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 
 	assert.True(t, table.MayContain("key1"))
 	assert.True(t, table.MayContain("key2"))
@@ -206,7 +369,7 @@ func TestMayContain(t *testing.T) {
 }
 
 func TestIdAndFilePath(t *testing.T) {
-	table := NewSSTableWithLevel(1)
+	table := newTestManager(t).newTable(1)
 	assert.NotZero(t, table.ID())
 	assert.Contains(t, table.FilePath(), filepath.Join("1-level", strconv.FormatUint(table.ID(), 10)+".sst"))
 }
@@ -215,7 +378,7 @@ func TestRemove(t *testing.T) {
 	tempDir := setupTestEnv(t)
 	defer cleanupTestEnv(t, tempDir)
 
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 	assert.FileExists(t, table.filePath)
@@ -239,7 +402,7 @@ func TestEncodeDecode_EmptySSTable(t *testing.T) {
 	tempDir := setupTestEnv(t)
 	defer cleanupTestEnv(t, tempDir)
 
-	table := createSampleSSTable(minSSTableLevel)
+	table := createSampleSSTable(t, minSSTableLevel)
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 	assert.FileExists(t, table.filePath)
@@ -250,33 +413,21 @@ func TestEncodeDecode_EmptySSTable(t *testing.T) {
 
 	assert.Equal(t, table.Header.MinKey, newTable.Header.MinKey)
 	assert.Equal(t, table.Header.MaxKey, newTable.Header.MaxKey)
-	assert.Empty(t, newTable.DataBlock.Entries)
+	assert.Nil(t, newTable.data)
 }
 
 func TestEncodeDecode_WithRealData(t *testing.T) {
 	tempDir := setupTestEnv(t)
 	defer cleanupTestEnv(t, tempDir)
 
-	table := NewSSTableWithLevel(0)
-	table.Header = &block.Header{MinKey: "key1", MaxKey: "key3"}
+	table := newTestManager(t).newTable(0)
+	table.Header.MinKey, table.Header.MaxKey = "key1", "key3"
 
 	// 添加真实数据
-	table.DataBlock.Entries = []kv.Value{
-		kv.Value("value1"),
-		kv.Value("value2"),
-		kv.Value("value3"),
-	}
-
-	table.IndexBlock.Indexes = []*block.IndexEntry{
-		{Key: "key1", Offset: 0},
-		{Key: "key2", Offset: 100},
-		{Key: "key3", Offset: 200},
+	for i := 1; i <= 3; i++ {
+		table.Add(&kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("key%d", i)), Value: kv.Value(fmt.Sprintf("value%d", i)), Seq: uint64(i)})
 	}
 
-	table.FilterBlock.Add([]byte("key1"))
-	table.FilterBlock.Add([]byte("key2"))
-	table.FilterBlock.Add([]byte("key3"))
-
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 
@@ -286,13 +437,19 @@ func TestEncodeDecode_WithRealData(t *testing.T) {
 
 	assert.Equal(t, table.Header.MinKey, newTable.Header.MinKey)
 	assert.Equal(t, table.Header.MaxKey, newTable.Header.MaxKey)
-	assert.Equal(t, len(table.IndexBlock.Indexes), len(newTable.IndexBlock.Indexes))
-	assert.Equal(t, 0, len(newTable.DataBlock.Entries)) // DataBlock should be empty after decoding
+	assert.Equal(t, uint64(1), newTable.MinSequence())
+	assert.Equal(t, uint64(3), newTable.MaxSequence())
+	assert.Nil(t, newTable.data) // 加载时不读取数据块
+
+	pairs, err := newTable.GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 3)
+	assert.Equal(t, kv.Value("value3"), pairs[2].Value)
 }
 
 func TestEncodeTo_DirectoryCreationFailed(t *testing.T) {
 	// 使用不存在的根目录来模拟目录创建失败
-	table := NewSSTableWithLevel(0)
+	table := newTestManager(t).newTable(0)
 	table.filePath = "/nonexistent/path/123.sst"
 
 	err := table.EncodeTo(table.filePath)
@@ -319,38 +476,11 @@ func TestDecodeFrom_CorruptedHeader(t *testing.T) {
 	table := NewRecoverSSTable(0)
 	err = table.DecodeFrom(filePath)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "decode Header failed")
-}
-
-func TestGetKeyValuePairs_MismatchedLengths(t *testing.T) {
-	table := createSampleSSTable(0)
-
-	// 故意制造不匹配
-	table.DataBlock.Entries = table.DataBlock.Entries[:1] // 只有1个entry
-	// IndexBlock保持2个entry
-
-	pairs, err := table.GetKeyValuePairs()
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "mismatched DataBlock and IndexBlock entries")
-	assert.Empty(t, pairs)
-}
-
-func TestGetValueByOffset_InvalidOffset(t *testing.T) {
-	tempDir := setupTestEnv(t)
-	defer cleanupTestEnv(t, tempDir)
-
-	table := createSampleSSTable(0)
-	err := table.EncodeTo(table.filePath)
-	assert.NoError(t, err)
-
-	// 测试超出范围的偏移量
-	_, err = table.GetValueByOffset(999999)
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "decode value length")
+	assert.Contains(t, err.Error(), "decode Footer failed")
 }
 
 func TestMayContain_EdgeCases(t *testing.T) {
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 	table.Header.MinKey = "key1"
 	table.Header.MaxKey = "key2"
 
@@ -361,7 +491,7 @@ func TestMayContain_EdgeCases(t *testing.T) {
 }
 
 func TestRemove_FileNotExist(t *testing.T) {
-	table := NewSSTableWithLevel(0)
+	table := newTestManager(t).newTable(0)
 	table.filePath = "/nonexistent/file.sst"
 
 	// 删除不存在的文件应该返回错误
@@ -380,7 +510,7 @@ func TestConcurrentAccess(t *testing.T) {
 	tempDir := setupTestEnv(t)
 	defer cleanupTestEnv(t, tempDir)
 
-	table := createSampleSSTable(0)
+	table := createSampleSSTable(t, 0)
 	err := table.EncodeTo(table.filePath)
 	assert.NoError(t, err)
 
@@ -401,15 +531,93 @@ func TestConcurrentAccess(t *testing.T) {
 
 func TestEmptyDataBlock(t *testing.T) {
 	table := NewSSTable()
+	filePath := filepath.Join(t.TempDir(), "empty.sst")
 
 	// 测试空数据块编码解码
-	err := table.EncodeTo("empty.sst")
+	err := table.EncodeTo(filePath)
 	assert.NoError(t, err)
 
 	newTable := NewRecoverSSTable(0)
-	err = newTable.DecodeFrom("empty.sst")
+	err = newTable.DecodeFrom(filePath)
+	assert.NoError(t, err)
+
+	pairs, err := newTable.GetKeyValuePairs()
+	assert.NoError(t, err)
+	assert.Empty(t, pairs)
+}
+
+func TestEncodeDecode_KindsAndRangeTombstones(t *testing.T) {
+	tempDir := setupTestEnv(t)
+	defer cleanupTestEnv(t, tempDir)
+
+	builder := newTestManager(t).newBuilder(0)
+	for _, pair := range []kv.KeyValuePair{
+		{Key: "a", Value: kv.Value("1"), Seq: 1},
+		{Key: "b", Seq: 2, Kind: kv.KindDelete},
+		{Key: "c", Value: kv.Value("+1"), Seq: 3, Kind: kv.KindMerge},
+		{Key: "b", Value: kv.Value("z"), Seq: 4, Kind: kv.KindRangeDelete},
+	} {
+		builder.Add(&pair)
+	}
+	table := builder.Build()
+	assert.Equal(t, kv.Key("a"), table.Header.MinKey)
+	assert.Equal(t, kv.Key("z"), table.Header.MaxKey)
+	assert.Equal(t, uint64(4), table.MaxSequence())
+
+	filePath := filepath.Join(tempDir, "kinds.sst")
+	assert.NoError(t, table.EncodeTo(filePath))
+
+	decoded := NewRecoverSSTable(0)
+	assert.NoError(t, decoded.DecodeFrom(filePath))
+	assert.Equal(t, uint64(1), decoded.MinSequence())
+	assert.Equal(t, uint64(4), decoded.MaxSequence())
+	assert.Equal(t, []kv.KeyValuePair{
+		{Key: "b", Value: kv.Value("z"), Seq: 4, Kind: kv.KindRangeDelete},
+	}, decoded.RangeTombstones())
+
+	pairs, err := decoded.GetKeyValuePairs()
 	assert.NoError(t, err)
+	assert.Len(t, pairs, 4)
+	assert.Equal(t, kv.KindDelete, pairs[1].Kind)
+	assert.Nil(t, pairs[1].Value)
+	assert.Equal(t, kv.KindMerge, pairs[2].Kind)
+	assert.Equal(t, kv.KindRangeDelete, pairs[3].Kind)
+}
 
-	assert.Empty(t, newTable.DataBlock.Entries)
-	assert.Empty(t, newTable.IndexBlock.Indexes)
+// TestCompressedDataBlocks 测试数据块和索引块按 SSTable 的压缩算法写入，读取时根据块尾部解压
+func TestCompressedDataBlocks(t *testing.T) {
+	sizes := make(map[compress.Codec]uint64)
+	for _, codec := range []compress.Codec{compress.NoCompression, compress.SnappyCompression, compress.ZlibCompression} {
+		c, err := compress.Lookup(codec)
+		assert.NoError(t, err)
+		builder := newTestManager(t).newBuilder(0)
+		builder.table.compressor = c
+		builder.table.blockSize = 512
+		for i := 0; i < 200; i++ {
+			key := kv.Key(fmt.Sprintf("key%04d", i))
+			builder.Add(&kv.KeyValuePair{Key: key, Value: kv.Value(strings.Repeat(string(key), 8)), Seq: uint64(i + 1)})
+		}
+		table := builder.Build()
+		assert.NoError(t, table.EncodeTo(table.filePath))
+		sizes[codec] = table.Size()
+
+		// 每个数据块的尾部记录压缩算法
+		data, err := os.ReadFile(table.filePath)
+		assert.NoError(t, err)
+		it := table.IndexBlock.NewIterator()
+		for it.SeekToFirst(); it.Valid(); it.Next() {
+			handle, err := block.ParseHandle(it.Value())
+			assert.NoError(t, err)
+			assert.Equal(t, byte(codec), data[handle.Offset+handle.Size-block.TrailerSize])
+		}
+
+		decoded := NewRecoverSSTable(0)
+		assert.NoError(t, decoded.DecodeFrom(table.filePath))
+		pairs, err := decoded.GetKeyValuePairs()
+		assert.NoError(t, err)
+		assert.Len(t, pairs, 200)
+		assert.Equal(t, kv.Value(strings.Repeat("key0123", 8)), pairs[123].Value)
+	}
+	assert.Less(t, sizes[compress.SnappyCompression], sizes[compress.NoCompression])
+	assert.Less(t, sizes[compress.ZlibCompression], sizes[compress.NoCompression])
 }
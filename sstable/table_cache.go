@@ -0,0 +1,148 @@
+package sstable
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const defaultMaxOpenFiles = 1000
+
+// tableCache 按 SSTable 的 id 缓存打开的文件，被 Manager 中的所有 SSTable 共享。
+// 文件只通过 ReadAt 按位置读取，多个读者可以同时使用同一个文件而不共享读写位置。
+// 打开的文件数量超过 capacity 时关闭最久未使用的文件，正在使用的文件在最后一个使用者释放之后才关闭。
+// nil 的 tableCache 表示不缓存，每次都打开新的文件，释放时关闭。
+type tableCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // 表头是最近使用的文件
+	files    map[uint64]*list.Element
+}
+
+// tableFile 是 tableCache 中打开的文件，使用完之后调用 tableCache.release 释放
+type tableFile struct {
+	*os.File
+	id      uint64
+	refs    int  // 正在使用该文件的读者数量，由 tableCache.mu 保护
+	evicted bool // 已经从缓存中移除，最后一个读者释放之后关闭
+}
+
+// newTableCache 创建最多同时打开 capacity 个文件的 tableCache
+func newTableCache(capacity int) *tableCache {
+	return &tableCache{
+		capacity: capacity,
+		lru:      list.New(),
+		files:    make(map[uint64]*list.Element),
+	}
+}
+
+// acquire 返回 t 对应的打开的文件，文件不在缓存中时打开并放入缓存
+func (c *tableCache) acquire(t *SSTable) (*tableFile, error) {
+	if c != nil {
+		c.mu.Lock()
+		if elem, ok := c.files[t.id]; ok {
+			f := elem.Value.(*tableFile)
+			f.refs++
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return f, nil
+		}
+		c.mu.Unlock()
+	}
+
+	// 打开文件时不持有锁，避免阻塞其他 SSTable 的读取
+	file, err := os.Open(t.filePath)
+	if err != nil {
+		log.Errorf("open file %s error: %s", t.filePath, err.Error())
+		return nil, fmt.Errorf("open file error: %w", err)
+	}
+	f := &tableFile{File: file, id: t.id, refs: 1}
+	if c == nil {
+		f.evicted = true
+		return f, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.files[t.id]; ok {
+		// 其他读者已经打开了同一个文件，使用缓存中的文件
+		closeTableFile(f)
+		f = elem.Value.(*tableFile)
+		f.refs++
+		c.lru.MoveToFront(elem)
+		return f, nil
+	}
+	c.files[t.id] = c.lru.PushFront(f)
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return f, nil
+}
+
+// release 释放 acquire 返回的文件，已经从缓存中移除的文件在最后一个读者释放之后关闭
+func (c *tableCache) release(f *tableFile) {
+	if c == nil {
+		closeTableFile(f)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f.refs--
+	if f.refs == 0 && f.evicted {
+		closeTableFile(f)
+	}
+}
+
+// evict 从缓存中移除 id 对应的文件，在删除 SSTable 文件之前调用
+func (c *tableCache) evict(id uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.files[id]; ok {
+		c.remove(elem)
+	}
+}
+
+// close 移除缓存中的所有文件
+func (c *tableCache) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// openFiles 返回缓存中打开的文件数量
+func (c *tableCache) openFiles() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// remove 将文件从缓存中移除，没有读者使用时立即关闭，调用方需要持有 mu
+func (c *tableCache) remove(elem *list.Element) {
+	f := c.lru.Remove(elem).(*tableFile)
+	delete(c.files, f.id)
+	f.evicted = true
+	if f.refs == 0 {
+		closeTableFile(f)
+	}
+}
+
+// closeTableFile 关闭文件并记录错误
+func closeTableFile(f *tableFile) {
+	if err := f.Close(); err != nil {
+		log.Errorf("close file %s error: %s", f.Name(), err.Error())
+	}
+}
@@ -0,0 +1,122 @@
+package sstable
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// writeTestTables 使用 manager 的配置写入 n 个只包含一条记录的 SSTable
+func writeTestTables(t *testing.T, manager *Manager, n int) []*SSTable {
+	tables := make([]*SSTable, 0, n)
+	for i := 0; i < n; i++ {
+		key := kv.Key(fmt.Sprintf("key%d", i))
+		table := manager.newTable(0)
+		table.Add(&kv.KeyValuePair{Key: key, Value: kv.Value(key), Seq: 1})
+		table.Header.MinKey, table.Header.MaxKey = key, key
+		assert.NoError(t, table.EncodeTo(table.FilePath()))
+		table.releaseData()
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+func TestTableCacheAcquireRelease(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.MaxOpenFiles = 2
+	manager := NewSSTableManager(opts)
+	tables := writeTestTables(t, manager, 3)
+	c := manager.tableCache
+
+	// 同一个 SSTable 共享打开的文件
+	f1, err := c.acquire(tables[0])
+	assert.NoError(t, err)
+	f2, err := c.acquire(tables[0])
+	assert.NoError(t, err)
+	assert.Same(t, f1, f2)
+	assert.Equal(t, 1, c.openFiles())
+	c.release(f1)
+	c.release(f2)
+
+	// 超过容量时关闭最久未使用的文件
+	for _, table := range tables[1:] {
+		f, err := c.acquire(table)
+		assert.NoError(t, err)
+		c.release(f)
+	}
+	assert.Equal(t, 2, c.openFiles())
+	_, err = f1.Stat()
+	assert.Error(t, err, "evicted file should be closed")
+
+	// 正在使用的文件被移除之后仍然可以读取，最后一个读者释放之后关闭
+	f, err := c.acquire(tables[0])
+	assert.NoError(t, err)
+	c.evict(tables[0].id)
+	assert.Equal(t, 1, c.openFiles())
+	_, err = f.ReadAt(make([]byte, 1), 0)
+	assert.NoError(t, err)
+	c.release(f)
+	_, err = f.Stat()
+	assert.Error(t, err)
+
+	c.close()
+	assert.Zero(t, c.openFiles())
+
+	// 文件不存在时返回错误
+	missing := manager.newTable(0)
+	_, err = c.acquire(missing)
+	assert.Error(t, err)
+	assert.Zero(t, c.openFiles())
+}
+
+// TestTableCacheNil 测试不使用 tableCache 时每次打开新的文件
+func TestTableCacheNil(t *testing.T) {
+	tables := writeTestTables(t, newTestManager(t), 1)
+	var c *tableCache
+	f1, err := c.acquire(tables[0])
+	assert.NoError(t, err)
+	f2, err := c.acquire(tables[0])
+	assert.NoError(t, err)
+	assert.NotSame(t, f1, f2)
+	c.release(f1)
+	c.release(f2)
+	_, err = f1.Stat()
+	assert.Error(t, err)
+	assert.Zero(t, c.openFiles())
+}
+
+// TestTableCacheConcurrentReads 测试并发读取共享同一个文件，不会重复打开
+func TestTableCacheConcurrentReads(t *testing.T) {
+	opts := DefaultOptions(t.TempDir())
+	opts.MaxOpenFiles = 2
+	manager := NewSSTableManager(opts)
+	tables := writeTestTables(t, manager, 4)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				table := tables[(g+i)%len(tables)]
+				pair, err := searchFromTable(table, table.Header.MinKey, kv.MaxSequence)
+				assert.NoError(t, err)
+				if assert.NotNil(t, pair) {
+					assert.Equal(t, kv.Value(table.Header.MinKey), pair.Value)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, manager.tableCache.openFiles(), 2)
+
+	// 删除 SSTable 时关闭缓存的文件
+	for _, table := range tables {
+		assert.NoError(t, table.Remove())
+	}
+	assert.Zero(t, manager.tableCache.openFiles())
+}
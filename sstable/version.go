@@ -0,0 +1,231 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+// Version 是某一时刻各层级 SSTable 集合的不可变快照，flush 和合并通过生成新的 Version 替换当前版本。
+// 读取和合并时持有版本的引用，期间即使版本被替换，其中的文件也不会被删除，读取无需等待合并完成。
+// 每个 SSTable 记录引用它的版本数量，最后一个引用它的版本释放之后才删除文件。
+type Version struct {
+	// levels 保存各层级的 SSTable，每层内按 id 降序排序
+	levels [][]*SSTable
+
+	// 稀疏索引，按照 MinKey 排序 level 1 及以上的 SSTable，用于查找
+	sparseIndexes [][]*SSTable
+
+	// rangeDelTables 是包含区间删除标记的 SSTable，tombstones 是其中所有的区间删除标记，
+	// 版本不可变，生成版本时计算一次，读取时不再遍历所有文件
+	rangeDelTables []*SSTable
+	tombstones     []kv.KeyValuePair
+
+	refs atomic.Int32
+}
+
+// newVersion 创建一个空的版本，初始引用由 Manager 持有
+func newVersion(numLevels int) *Version {
+	v := &Version{
+		levels:        make([][]*SSTable, numLevels),
+		sparseIndexes: make([][]*SSTable, numLevels-1),
+	}
+	v.refs.Store(1)
+	return v
+}
+
+// Ref 增加版本的引用计数
+func (v *Version) Ref() {
+	v.refs.Add(1)
+}
+
+// Unref 释放一个引用，引用计数归零时释放版本中所有 SSTable 的引用
+func (v *Version) Unref() {
+	refs := v.refs.Add(-1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		log.Errorf("version is released more than once")
+		return
+	}
+	for _, tables := range v.levels {
+		for _, table := range tables {
+			table.unref()
+		}
+	}
+}
+
+// apply 基于 v 生成应用了 edit 的删除和 added 的新版本，新版本持有其中所有 SSTable 的引用
+func (v *Version) apply(edit *VersionEdit, added []*SSTable) *Version {
+	deleted := make(map[DeletedFile]bool)
+	if edit != nil {
+		for _, file := range edit.Deleted {
+			deleted[file] = true
+		}
+	}
+
+	next := newVersion(len(v.levels))
+	for level, tables := range v.levels {
+		for _, table := range tables {
+			if !deleted[DeletedFile{Level: level, ID: table.id}] {
+				next.levels[level] = append(next.levels[level], table)
+			}
+		}
+	}
+	for _, table := range added {
+		next.levels[table.level] = append(next.levels[table.level], table)
+	}
+
+	for level, tables := range next.levels {
+		sort.SliceStable(tables, func(i, j int) bool {
+			return tables[i].id > tables[j].id
+		})
+		if level > minSSTableLevel {
+			sparseIndexes := append([]*SSTable(nil), tables...)
+			sort.SliceStable(sparseIndexes, func(i, j int) bool {
+				return bytes.Compare([]byte(sparseIndexes[i].Header.MinKey), []byte(sparseIndexes[j].Header.MinKey)) < 0
+			})
+			next.sparseIndexes[level-1] = sparseIndexes
+		}
+		for _, table := range tables {
+			table.ref()
+			if len(table.RangeTombstones()) > 0 {
+				next.rangeDelTables = append(next.rangeDelTables, table)
+				next.tombstones = append(next.tombstones, table.RangeTombstones()...)
+			}
+		}
+	}
+	return next
+}
+
+// files 返回 level 中按 id 升序（从旧到新）排列的 SSTable
+func (v *Version) files(level int) []*SSTable {
+	tables := v.levels[level]
+	result := make([]*SSTable, len(tables))
+	for i, table := range tables {
+		result[len(tables)-1-i] = table
+	}
+	return result
+}
+
+// levelSize 返回 level 中所有 SSTable 文件的总大小（字节）
+func (v *Version) levelSize(level int) uint64 {
+	var size uint64
+	for _, table := range v.levels[level] {
+		size += table.Size()
+	}
+	return size
+}
+
+// searchPair 从低层级向高层级查找 key 的序列号不大于 seq 的最新版本，未找到时返回 (nil, nil)
+func (v *Version) searchPair(key kv.Key, seq uint64) (*kv.KeyValuePair, error) {
+	// 1. 先从 level 0 开始查找
+	pair, err := v.searchFromLevel0(key, seq)
+	if err != nil {
+		log.Errorf("search from level 0 failed: %s", err.Error())
+		return nil, fmt.Errorf("search from level 0 failed: %w", err)
+	}
+	if pair != nil {
+		return pair, nil
+	}
+
+	// 2. 再使用稀疏索引逐层查找
+	for level := minSSTableLevel + 1; level < len(v.levels); level++ {
+		pair, err = v.searchFromLevelWithSparseIndex(key, seq, level)
+		if err != nil {
+			log.Errorf("search from level %d failed: %s", level, err.Error())
+			return nil, fmt.Errorf("search from level %d failed: %w", level, err)
+		}
+		if pair != nil {
+			return pair, nil
+		}
+	}
+
+	// 3. 所有层级都未找到
+	return nil, nil
+}
+
+func (v *Version) searchFromLevel0(key kv.Key, seq uint64) (*kv.KeyValuePair, error) {
+	// 在当前层级中按表ID降序查找
+	for _, table := range v.levels[minSSTableLevel] {
+		pair, err := searchFromTable(table, key, seq)
+		if err != nil {
+			log.Errorf("search from table %s failed: %s", table.FilePath(), err.Error())
+			return nil, fmt.Errorf("search from table %s failed: %w", table.FilePath(), err)
+		}
+		if pair != nil {
+			return pair, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// searchFromLevelWithSparseIndex 使用稀疏索引在指定层级查找key
+func (v *Version) searchFromLevelWithSparseIndex(key kv.Key, seq uint64, level int) (*kv.KeyValuePair, error) {
+	// 1. 使用稀疏索引找到可能包含该key的SSTable范围
+	// 稀疏索引是按MinKey排序的，我们可以找到最后一个MinKey小于等于key的SSTable
+	sparseIndexes := v.sparseIndexes[level-1]
+	index := sort.Search(len(sparseIndexes), func(i int) bool {
+		return bytes.Compare([]byte(sparseIndexes[i].Header.MinKey), []byte(key)) > 0
+	})
+	if index > 0 {
+		index-- // 调整到最后一个 <= key 的位置
+	}
+
+	// 2. 在SSTable中查找key
+	if index < len(sparseIndexes) {
+		sst := sparseIndexes[index]
+		pair, err := searchFromTable(sst, key, seq)
+		if err != nil {
+			log.Errorf("search from table %s failed: %s", sst.FilePath(), err.Error())
+			return nil, fmt.Errorf("search from table %s failed: %w", sst.FilePath(), err)
+		}
+		if pair != nil {
+			return pair, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// searchFromTable 在单个 SSTable 中查找序列号不大于 seq 的最新版本，删除标记不读取 value
+func searchFromTable(sst *SSTable, key kv.Key, seq uint64) (*kv.KeyValuePair, error) {
+	if !sst.inKeyRange(key) {
+		return nil, nil
+	}
+	if !sst.filterMayContain(key) {
+		sst.filterStats.record(false, false)
+		return nil, nil
+	}
+
+	// 使用迭代器查找，同一 key 的版本按序列号降序排列。迭代器直接定位到 key，只加载包含 key 的数据块
+	it := newTableIterator(sst)
+	defer it.Close()
+
+	it.SeekGE(key)
+	if it.Error() == nil {
+		// 只要存在该 key 的任意版本，布隆过滤器的判断就是正确的
+		sst.filterStats.record(true, it.Valid() && it.Key() == key)
+	}
+	for ; it.Valid() && it.Key() == key; it.Next() {
+		if it.Seq() > seq {
+			continue
+		}
+		pair := &kv.KeyValuePair{Key: key, Seq: it.Seq(), Kind: it.Kind()}
+		if !pair.IsDeleted() {
+			value, err := it.Value()
+			if err != nil {
+				return nil, err
+			}
+			pair.Value = value
+		}
+		return pair, nil
+	}
+	return nil, it.Error()
+}
@@ -0,0 +1,145 @@
+package sstable
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/memtable"
+	"github.com/xmh1011/go-lsm/sstable/block"
+)
+
+func TestVersionApply(t *testing.T) {
+	base := newVersion(3)
+	tables := make([]*SSTable, 0)
+	for i, keys := range [][2]kv.Key{{"m", "p"}, {"a", "c"}, {"x", "z"}} {
+		sst := NewSSTable()
+		sst.id = uint64(i + 1)
+		sst.level = 1
+		sst.Header = block.NewHeader(keys[0], keys[1])
+		tables = append(tables, sst)
+	}
+
+	v1 := base.apply(nil, tables)
+	assert.Equal(t, []*SSTable{tables[2], tables[1], tables[0]}, v1.levels[1])
+	assert.Equal(t, []*SSTable{tables[1], tables[0], tables[2]}, v1.sparseIndexes[0])
+	assert.Equal(t, []*SSTable{tables[0], tables[1], tables[2]}, v1.files(1))
+
+	edit := &VersionEdit{}
+	edit.DeleteFile(1, tables[1].id)
+	v2 := v1.apply(edit, nil)
+	assert.Equal(t, []*SSTable{tables[2], tables[0]}, v2.levels[1])
+	// 旧版本不受影响
+	assert.Len(t, v1.levels[1], 3)
+
+	// 每个 SSTable 的引用计数等于包含它的版本数量
+	assert.Equal(t, int32(2), tables[0].refs.Load())
+	assert.Equal(t, int32(1), tables[1].refs.Load())
+	v1.Unref()
+	assert.Equal(t, int32(1), tables[0].refs.Load())
+	assert.Equal(t, int32(0), tables[1].refs.Load())
+}
+
+// TestVersionPinsFilesDuringCompaction 测试合并删除的文件在持有旧版本的迭代器关闭之后才被删除
+func TestVersionPinsFilesDuringCompaction(t *testing.T) {
+	mgr := newTestManager(t)
+	flushTestPairs(t, mgr, []string{"a", "c"}, []string{"b"}, []string{"d"})
+	oldFiles := mgr.getFilesByLevel(minSSTableLevel)
+
+	iters := mgr.NewIterators("", "")
+	assert.Len(t, iters, 3)
+
+	// 第四个 Level0 文件触发合并，旧文件从当前版本中移除
+	flushTestPairs(t, mgr, []string{"e"})
+	assert.Empty(t, mgr.getFilesByLevel(minSSTableLevel))
+	assert.NotEmpty(t, mgr.getFilesByLevel(1))
+
+	// 迭代器仍然可以读取旧文件
+	for _, f := range oldFiles {
+		assert.FileExists(t, f)
+	}
+	keys := make([]string, 0)
+	for _, it := range iters {
+		for ; it.Valid(); it.Next() {
+			value, err := it.Value()
+			assert.NoError(t, err)
+			assert.Equal(t, kv.Value(it.Key()), value)
+			keys = append(keys, string(it.Key()))
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "c", "d"}, keys)
+
+	// 最后一个持有旧版本的迭代器关闭之后删除文件
+	iters[0].Close()
+	iters[1].Close()
+	for _, f := range oldFiles {
+		assert.FileExists(t, f)
+	}
+	iters[2].Close()
+	for _, f := range oldFiles {
+		assert.NoFileExists(t, f)
+	}
+
+	val, err := mgr.Search("a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), val)
+}
+
+// TestSearchDoesNotWaitForCompaction 测试查找不会等待正在进行的合并
+func TestSearchDoesNotWaitForCompaction(t *testing.T) {
+	mgr := newTestManager(t)
+	flushTestPairs(t, mgr, []string{"key"})
+
+	// 模拟所有层级都在合并
+	mgr.mu.Lock()
+	for level := minSSTableLevel; level <= mgr.maxLevel(); level++ {
+		mgr.compactingLevels[level] = true
+	}
+	mgr.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			val, err := mgr.Search(kv.Key(fmt.Sprintf("key%d", i)))
+			assert.NoError(t, err)
+			assert.Nil(t, val)
+		}
+		val, err := mgr.Search("key")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("key"), val)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("search is blocked by compaction")
+	}
+}
+
+// TestVersionRangeTombstones 测试版本缓存区间删除标记，并且只检查 key 范围包含 key 的 SSTable
+func TestVersionRangeTombstones(t *testing.T) {
+	mgr := newTestManager(t)
+	flushTestPairs(t, mgr, []string{"a", "b"})
+
+	mem := memtable.NewMemTable(0, t.TempDir())
+	assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: "m", Value: []byte("m"), Seq: 1}))
+	assert.NoError(t, mem.Insert(kv.KeyValuePair{Key: "m", Value: kv.Value("p"), Seq: 5, Kind: kv.KindRangeDelete}))
+	assert.NoError(t, mgr.CreateNewSSTable(memtable.NewIMemTable(mem)))
+
+	tombstones := mgr.RangeTombstones()
+	assert.Len(t, tombstones, 1)
+	assert.Equal(t, kv.Key("m"), tombstones[0].Key)
+
+	v := mgr.currentVersion()
+	assert.Len(t, v.rangeDelTables, 1)
+	v.Unref()
+
+	assert.Equal(t, uint64(5), mgr.CoveringSequence("n", 10))
+	assert.Equal(t, uint64(0), mgr.CoveringSequence("n", 4))
+	assert.Equal(t, uint64(0), mgr.CoveringSequence("p", 10))
+	assert.Equal(t, uint64(0), mgr.CoveringSequence("a", 10))
+}
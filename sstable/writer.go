@@ -0,0 +1,147 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+const tmpFileSuffix = "tmp"
+
+// TableWriter 流式写入一个 SSTable：数据块写满之后立即追加到临时文件，内存中只保留正在构建的数据块、索引块和布隆过滤器的 key。
+// Finish 写入其余各部分并落盘，然后原子地重命名为 SSTable 的文件路径，目标路径上只会出现完整的文件。
+// 出错之后调用 Abort 删除临时文件。
+type TableWriter struct {
+	builder *Builder
+	file    *os.File
+	tmpPath string
+	offset  int64 // 已经写入临时文件的字节数
+}
+
+// NewTableWriter 创建写入 table 的 TableWriter，在 table 的文件路径旁创建临时文件，table 中已经编码的数据块一并写入。
+// 写入的数据达到 maxSize 时 ShouldFlush 返回 true
+func NewTableWriter(table *SSTable, maxSize uint64) (*TableWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(table.filePath), 0755); err != nil {
+		log.Errorf("create directory %s error: %s", filepath.Dir(table.filePath), err.Error())
+		return nil, fmt.Errorf("create directory failed: %w", err)
+	}
+	tmpPath := fmt.Sprintf("%s.%s", table.filePath, tmpFileSuffix)
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Errorf("open file %s error: %s", tmpPath, err.Error())
+		return nil, fmt.Errorf("open file error: %w", err)
+	}
+
+	w := &TableWriter{
+		builder: NewSSTableBuilder(table, maxSize),
+		file:    file,
+		tmpPath: tmpPath,
+	}
+	if err = w.flush(); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// newTableWriter 创建向指定层级的新 SSTable 流式写入数据的 TableWriter
+func (m *Manager) newTableWriter(level int) (*TableWriter, error) {
+	return NewTableWriter(m.newTable(level), m.opts.TableSize)
+}
+
+// Add 添加一条记录，当前数据块写满之后写入临时文件
+func (w *TableWriter) Add(pair *kv.KeyValuePair) error {
+	w.builder.Add(pair)
+	return w.flush()
+}
+
+// ShouldFlush 判断写入的数据是否已经达到目标大小，应当结束当前 SSTable
+func (w *TableWriter) ShouldFlush() bool {
+	return w.builder.ShouldFlush()
+}
+
+// Empty 判断是否还没有写入任何记录
+func (w *TableWriter) Empty() bool {
+	return w.builder.size == 0
+}
+
+// Table 返回正在写入的 SSTable，Finish 成功之后可以用于读取
+func (w *TableWriter) Table() *SSTable {
+	return w.builder.table
+}
+
+// Finish 按写入的记录填充 Header，结束最后一个数据块并写入元数据部分，落盘之后重命名为目标文件，返回文件的元数据。
+// 出错时删除临时文件
+func (w *TableWriter) Finish() (FileMeta, error) {
+	w.builder.Finalize()
+	if err := w.finish(); err != nil {
+		return FileMeta{}, err
+	}
+	return w.builder.table.Meta(), nil
+}
+
+// finish 结束 SSTable 并写入元数据部分，落盘之后关闭临时文件并重命名为目标文件，出错时删除临时文件
+func (w *TableWriter) finish() error {
+	table := w.builder.table
+	table.finish()
+	if err := w.flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := table.encodeMetaTo(w.file, w.offset); err != nil {
+		w.Abort()
+		return err
+	}
+
+	// 文件落盘之后才能记录到 MANIFEST 中
+	if err := w.file.Sync(); err != nil {
+		log.Errorf("sync file %s error: %s", w.tmpPath, err.Error())
+		w.Abort()
+		return fmt.Errorf("sync file failed: %w", err)
+	}
+	err := w.file.Close()
+	w.file = nil
+	if err != nil {
+		log.Errorf("close file %s error: %s", w.tmpPath, err.Error())
+		w.Abort()
+		return fmt.Errorf("close file failed: %w", err)
+	}
+	// 目录项由 logAndApply 在记录 MANIFEST 之前落盘
+	if err = os.Rename(w.tmpPath, table.filePath); err != nil {
+		log.Errorf("rename %s to %s error: %s", w.tmpPath, table.filePath, err.Error())
+		w.Abort()
+		return fmt.Errorf("rename file failed: %w", err)
+	}
+	return nil
+}
+
+// Abort 放弃写入，关闭并删除临时文件
+func (w *TableWriter) Abort() {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			log.Errorf("close file %s error: %s", w.tmpPath, err.Error())
+		}
+		w.file = nil
+	}
+	if err := os.Remove(w.tmpPath); err != nil && !os.IsNotExist(err) {
+		log.Errorf("remove file %s error: %s", w.tmpPath, err.Error())
+	}
+}
+
+// flush 将已经编码的数据块追加到临时文件并释放
+func (w *TableWriter) flush() error {
+	table := w.builder.table
+	if len(table.data) == 0 {
+		return nil
+	}
+	if _, err := w.file.Write(table.data); err != nil {
+		log.Errorf("write data blocks to file %s error: %s", w.tmpPath, err.Error())
+		return fmt.Errorf("write data blocks failed: %w", err)
+	}
+	w.offset += int64(len(table.data))
+	table.data = nil
+	return nil
+}
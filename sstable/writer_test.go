@@ -0,0 +1,86 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+)
+
+// TestTableWriterStreaming 测试写满的数据块立即写入临时文件，Finish 之后目标文件完整可读
+func TestTableWriterStreaming(t *testing.T) {
+	manager := newTestManager(t)
+	w, err := manager.newTableWriter(minSSTableLevel)
+	assert.NoError(t, err)
+	table := w.Table()
+	assert.True(t, w.Empty())
+
+	value := make([]byte, 1024)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, w.Add(&kv.KeyValuePair{Key: kv.Key(fmt.Sprintf("key%03d", i)), Value: value, Seq: uint64(i + 1)}))
+		// 内存中不保留已经写满的数据块
+		assert.Empty(t, table.data)
+	}
+	assert.False(t, w.Empty())
+	assert.Greater(t, w.offset, int64(0))
+
+	// Finish 之前目标路径上没有文件
+	assert.NoFileExists(t, table.FilePath())
+	assert.FileExists(t, w.tmpPath)
+
+	meta, err := w.Finish()
+	assert.NoError(t, err)
+	assert.Equal(t, kv.Key("key000"), meta.MinKey)
+	assert.Equal(t, kv.Key("key099"), meta.MaxKey)
+	assert.Equal(t, uint64(1), meta.MinSeq)
+	assert.Equal(t, uint64(100), meta.MaxSeq)
+	assert.NoFileExists(t, w.tmpPath)
+
+	info, err := os.Stat(table.FilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(info.Size()), meta.Size)
+
+	recovered := NewRecoverSSTable(minSSTableLevel)
+	assert.NoError(t, recovered.DecodeFrom(table.FilePath()))
+	pair, err := searchFromTable(recovered, "key042", kv.MaxSequence)
+	assert.NoError(t, err)
+	if assert.NotNil(t, pair) {
+		assert.Equal(t, kv.Value(value), pair.Value)
+	}
+}
+
+// TestTableWriterAbort 测试 Abort 删除临时文件且不会生成目标文件
+func TestTableWriterAbort(t *testing.T) {
+	manager := newTestManager(t)
+	w, err := manager.newTableWriter(minSSTableLevel)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Add(&kv.KeyValuePair{Key: "key", Value: kv.Value("value"), Seq: 1}))
+
+	w.Abort()
+	assert.NoFileExists(t, w.tmpPath)
+	assert.NoFileExists(t, w.Table().FilePath())
+}
+
+// TestTableWriterTmpFileIgnoredOnRecover 测试恢复时忽略崩溃遗留的临时文件
+func TestTableWriterTmpFileIgnoredOnRecover(t *testing.T) {
+	dir := t.TempDir()
+	manager := NewSSTableManager(DefaultOptions(dir))
+	w, err := manager.newTableWriter(minSSTableLevel)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Add(&kv.KeyValuePair{Key: "key", Value: kv.Value("value"), Seq: 1}))
+	assert.NoError(t, w.flush())
+	// 模拟写入过程中崩溃：临时文件没有被重命名
+	tmpPath := w.tmpPath
+	assert.NoError(t, w.file.Close())
+
+	recovered := NewSSTableManager(DefaultOptions(dir))
+	assert.NoError(t, recovered.recoverFromLevelDirs())
+	assert.Equal(t, 0, recovered.Level0FileCount())
+	// 只读恢复不删除临时文件
+	assert.FileExists(t, tmpPath)
+	assert.Equal(t, filepath.Dir(tmpPath), sstableLevelPath(minSSTableLevel, dir))
+}
@@ -0,0 +1,163 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/log"
+)
+
+// RecoveryMode 决定恢复 WAL 时如何处理损坏或不完整的记录
+type RecoveryMode int
+
+const (
+	// TolerateCorruptedTailRecords 容忍文件末尾损坏或不完整的记录（通常由写入时掉电造成），
+	// 丢弃并截断末尾的损坏数据；文件中间出现损坏时返回错误。默认模式。
+	// 损坏的数据之后不再有任何有效的记录时视为末尾，包括不完整的记录、被清零的区域和无效的记录头。
+	TolerateCorruptedTailRecords RecoveryMode = iota
+	// AbsoluteConsistency 任何损坏或不完整的记录都会导致恢复失败
+	AbsoluteConsistency
+	// PointInTimeRecovery 在第一条损坏的记录处停止，之后的数据（包括更新的 WAL 文件）全部丢弃，
+	// 恢复到损坏发生前的一致状态
+	PointInTimeRecovery
+	// SkipAnyCorruptedRecords 跳过所有能确定边界的损坏记录并继续重放，尽可能多地恢复数据
+	SkipAnyCorruptedRecords
+)
+
+func (m RecoveryMode) String() string {
+	switch m {
+	case TolerateCorruptedTailRecords:
+		return "TolerateCorruptedTailRecords"
+	case AbsoluteConsistency:
+		return "AbsoluteConsistency"
+	case PointInTimeRecovery:
+		return "PointInTimeRecovery"
+	case SkipAnyCorruptedRecords:
+		return "SkipAnyCorruptedRecords"
+	default:
+		return fmt.Sprintf("RecoveryMode(%d)", int(m))
+	}
+}
+
+// RecoveryReport 记录一个 WAL 文件的恢复结果
+type RecoveryReport struct {
+	Path             string
+	RecoveredRecords int   // 成功重放的记录数
+	DroppedRecords   int   // 被丢弃的损坏记录数，截断时之后无法解析的数据按一条计
+	DroppedBytes     int64 // 被丢弃的字节数
+	Truncated        bool  // 是否丢弃了 TruncatedAt 之后的所有数据
+	TruncatedAt      int64 // 截断位置，仅在 Truncated 为 true 时有效
+}
+
+// Dropped 返回恢复过程中是否丢弃了数据
+func (r *RecoveryReport) Dropped() bool {
+	return r.DroppedBytes > 0
+}
+
+// Recover reads the WAL file and calls the callback function for each KeyValuePair.
+// 使用默认的 TolerateCorruptedTailRecords 模式。
+func Recover(path string, callback func(pair kv.KeyValuePair)) (*WAL, error) {
+	w, _, err := RecoverWithMode(path, TolerateCorruptedTailRecords, callback)
+	return w, err
+}
+
+// RecoverWithMode 按指定模式重放 WAL 文件，每条完整且校验通过的记录中的所有 KeyValuePair 依次回调。
+// 末尾被丢弃的数据会从文件中截断，保证之后追加的记录可以被正常恢复。
+func RecoverWithMode(path string, mode RecoveryMode, callback func(pair kv.KeyValuePair)) (*WAL, *RecoveryReport, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, defaultWALFileMode)
+	if err != nil {
+		log.Errorf("open wal file failed: %s", err.Error())
+		return nil, nil, fmt.Errorf("open wal file failed: %w", err)
+	}
+	report, err := replay(file, path, mode, callback)
+	if err != nil {
+		_ = file.Close()
+		return nil, report, err
+	}
+
+	if report.Truncated {
+		if err = file.Truncate(report.TruncatedAt); err != nil {
+			_ = file.Close()
+			log.Errorf("truncate wal %s failed: %s", path, err.Error())
+			return nil, report, fmt.Errorf("truncate wal %s failed: %w", path, err)
+		}
+	}
+
+	return &WAL{file: file, path: path}, report, nil
+}
+
+// Replay 以只读方式按指定模式重放 WAL 文件，不修改文件，也不保留文件句柄。
+// 末尾被丢弃的数据只体现在恢复报告中，文件本身保持原样。
+func Replay(path string, mode RecoveryMode, callback func(pair kv.KeyValuePair)) (*RecoveryReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Errorf("open wal file failed: %s", err.Error())
+		return nil, fmt.Errorf("open wal file failed: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return replay(file, path, mode, callback)
+}
+
+// replay 读取 r 中的全部记录并按模式回调，返回恢复报告
+func replay(r io.Reader, path string, mode RecoveryMode, callback func(pair kv.KeyValuePair)) (*RecoveryReport, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		log.Errorf("read wal file failed: %s", err.Error())
+		return nil, fmt.Errorf("read wal file failed: %w", err)
+	}
+
+	report := &RecoveryReport{Path: path}
+	offset := 0
+	for offset < len(raw) {
+		pairs, size, err := decodeRecord(raw[offset:])
+		if err == nil {
+			// 整条记录解码成功后才回调处理有效数据
+			for _, pair := range pairs {
+				callback(pair)
+			}
+			report.RecoveredRecords++
+			offset += size
+			continue
+		}
+
+		// 损坏的记录之后没有有效的记录，视为掉电留下的末尾
+		tail := !validRecordFollows(raw, offset+1)
+		if mode == AbsoluteConsistency || (mode == TolerateCorruptedTailRecords && !tail) {
+			log.Errorf("corrupted wal %s at offset %d: %s", path, offset, err.Error())
+			return report, fmt.Errorf("%w: %s at offset %d: %s", ErrCorruption, path, offset, err.Error())
+		}
+		if mode == SkipAnyCorruptedRecords && size > 0 {
+			log.Warnf("skip corrupted wal record %s at offset %d: %s", path, offset, err.Error())
+			report.DroppedRecords++
+			report.DroppedBytes += int64(size)
+			offset += size
+			continue
+		}
+
+		log.Warnf("drop wal %s from offset %d: %s", path, offset, err.Error())
+		report.DroppedRecords++
+		report.DroppedBytes += int64(len(raw) - offset)
+		report.Truncated = true
+		report.TruncatedAt = int64(offset)
+		break
+	}
+
+	return report, nil
+}
+
+// validRecordFollows 判断 data 中从 from 开始的任意位置能否解码出完整且校验通过的记录。
+// 掉电可能在文件末尾留下被清零的区域、任意的长度字段或者只写入一半的记录，其后不会再有有效的记录；
+// 文件中间的损坏之后仍然有正常写入的记录。记录头可能已经损坏，因此逐字节查找而不依赖记录长度
+func validRecordFollows(data []byte, from int) bool {
+	for offset := from; offset+recordHeaderSize <= len(data); offset++ {
+		if _, _, err := decodeRecord(data[offset:]); err == nil {
+			return true
+		}
+	}
+	return false
+}
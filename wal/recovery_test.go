@@ -0,0 +1,210 @@
+package wal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+// writeRecords 写入若干条单记录的 WAL，返回 WAL 路径和每条记录的结束位置
+func writeRecords(t *testing.T, keys ...string) (string, []int64) {
+	dir := t.TempDir()
+	w, err := wal.NewWAL(1, dir)
+	assert.NoError(t, err)
+
+	path := filepath.Join(dir, "1.wal")
+	ends := make([]int64, 0, len(keys))
+	for i, key := range keys {
+		assert.NoError(t, w.Append(kv.KeyValuePair{Key: kv.Key(key), Value: []byte("v"), Seq: uint64(i + 1)}))
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		ends = append(ends, info.Size())
+	}
+	assert.NoError(t, w.Close())
+	return path, ends
+}
+
+// corruptByte 翻转文件中指定位置的一个字节
+func corruptByte(t *testing.T, path string, offset int64) {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[offset] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+}
+
+func recoverKeys(t *testing.T, path string, mode wal.RecoveryMode) ([]string, *wal.RecoveryReport, error) {
+	var keys []string
+	w, report, err := wal.RecoverWithMode(path, mode, func(pair kv.KeyValuePair) {
+		keys = append(keys, string(pair.Key))
+	})
+	if w != nil {
+		assert.NoError(t, w.Close())
+	}
+	return keys, report, err
+}
+
+func TestRecoverTornTail(t *testing.T) {
+	path, ends := writeRecords(t, "a", "b", "c")
+	// 模拟最后一条记录只写入了一半
+	assert.NoError(t, os.Truncate(path, ends[2]-3))
+
+	_, _, err := recoverKeys(t, path, wal.AbsoluteConsistency)
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+
+	for _, mode := range []wal.RecoveryMode{wal.TolerateCorruptedTailRecords, wal.PointInTimeRecovery, wal.SkipAnyCorruptedRecords} {
+		keys, report, err := recoverKeys(t, path, mode)
+		assert.NoError(t, err, mode.String())
+		assert.Equal(t, []string{"a", "b"}, keys, mode.String())
+		assert.Equal(t, 2, report.RecoveredRecords)
+		if report.Dropped() {
+			// 只有第一次恢复会截断，之后文件已经是干净的
+			assert.True(t, report.Truncated)
+			assert.Equal(t, ends[1], report.TruncatedAt)
+			assert.Equal(t, ends[2]-3-ends[1], report.DroppedBytes)
+		}
+	}
+
+	// 截断后追加的记录可以被正常恢复
+	w, err := wal.NewWAL(1, filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Append(kv.KeyValuePair{Key: "d", Value: []byte("v")}))
+	assert.NoError(t, w.Close())
+
+	keys, report, err := recoverKeys(t, path, wal.AbsoluteConsistency)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "d"}, keys)
+	assert.False(t, report.Dropped())
+}
+
+func TestRecoverCorruptedMiddleRecord(t *testing.T) {
+	newCorrupted := func() (string, []int64) {
+		path, ends := writeRecords(t, "a", "b", "c")
+		// 破坏第二条记录的 payload，长度字段保持完好
+		corruptByte(t, path, ends[1]-1)
+		return path, ends
+	}
+
+	path, _ := newCorrupted()
+	_, _, err := recoverKeys(t, path, wal.AbsoluteConsistency)
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+
+	// 损坏不在末尾，默认模式不能把它当作掉电造成的残缺记录
+	path, _ = newCorrupted()
+	_, _, err = recoverKeys(t, path, wal.TolerateCorruptedTailRecords)
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+
+	path, ends := newCorrupted()
+	keys, report, err := recoverKeys(t, path, wal.SkipAnyCorruptedRecords)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keys)
+	assert.Equal(t, 1, report.DroppedRecords)
+	assert.Equal(t, ends[1]-ends[0], report.DroppedBytes)
+	assert.False(t, report.Truncated)
+
+	path, ends = newCorrupted()
+	keys, report, err = recoverKeys(t, path, wal.PointInTimeRecovery)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, ends[0], report.TruncatedAt)
+	assert.Equal(t, ends[2]-ends[0], report.DroppedBytes)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ends[0], info.Size())
+}
+
+func TestRecoverCorruptedLastRecord(t *testing.T) {
+	path, ends := writeRecords(t, "a", "b")
+	// 最后一条记录完整但校验失败，仍视为末尾损坏
+	corruptByte(t, path, ends[1]-1)
+
+	keys, report, err := recoverKeys(t, path, wal.TolerateCorruptedTailRecords)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, 1, report.DroppedRecords)
+	assert.Equal(t, ends[0], report.TruncatedAt)
+}
+
+// TestReplayDoesNotModifyFile 测试只读重放报告末尾的损坏数据，但不截断文件
+func TestReplayDoesNotModifyFile(t *testing.T) {
+	path, ends := writeRecords(t, "a", "b", "c")
+	assert.NoError(t, os.Truncate(path, ends[2]-3))
+
+	var keys []string
+	report, err := wal.Replay(path, wal.TolerateCorruptedTailRecords, func(pair kv.KeyValuePair) {
+		keys = append(keys, string(pair.Key))
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.True(t, report.Truncated)
+	assert.Equal(t, ends[1], report.TruncatedAt)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ends[2]-3, info.Size())
+
+	_, err = wal.Replay(path, wal.AbsoluteConsistency, func(kv.KeyValuePair) {})
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+
+	_, err = wal.Replay(filepath.Join(t.TempDir(), "missing.wal"), wal.TolerateCorruptedTailRecords, func(kv.KeyValuePair) {})
+	assert.Error(t, err)
+}
+
+// appendBytes 在文件末尾追加 data
+func appendBytes(t *testing.T, path string, data []byte) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.NoError(t, err)
+	_, err = file.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+}
+
+// TestRecoverGarbageTail 测试末尾被清零的区域或无效的记录头视为掉电留下的末尾，默认模式下截断而不是报错
+func TestRecoverGarbageTail(t *testing.T) {
+	tails := map[string][]byte{
+		"zero filled":    make([]byte, 64),
+		"garbage length": {0xff, 0xff, 0xff, 0xff, 0x12, 0x34, 0x56, 0x78, 0x01, 0x02},
+		"short header":   {0x05, 0x00},
+	}
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			path, ends := writeRecords(t, "a", "b")
+			appendBytes(t, path, tail)
+
+			_, _, err := recoverKeys(t, path, wal.AbsoluteConsistency)
+			assert.ErrorIs(t, err, wal.ErrCorruption)
+
+			keys, report, err := recoverKeys(t, path, wal.TolerateCorruptedTailRecords)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, keys)
+			assert.True(t, report.Truncated)
+			assert.Equal(t, ends[1], report.TruncatedAt)
+			assert.Equal(t, int64(len(tail)), report.DroppedBytes)
+
+			info, err := os.Stat(path)
+			assert.NoError(t, err)
+			assert.Equal(t, ends[1], info.Size())
+		})
+	}
+}
+
+// TestRecoverCorruptedMiddleLength 测试文件中间的记录长度损坏时，之后仍有有效的记录，默认模式不能把它当作末尾
+func TestRecoverCorruptedMiddleLength(t *testing.T) {
+	path, ends := writeRecords(t, "a", "b", "c")
+	// 第二条记录的长度字段变得超出文件末尾
+	corruptByte(t, path, ends[0]+3)
+
+	_, _, err := recoverKeys(t, path, wal.TolerateCorruptedTailRecords)
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+
+	keys, report, err := recoverKeys(t, path, wal.PointInTimeRecovery)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, ends[0], report.TruncatedAt)
+}
@@ -0,0 +1,79 @@
+package wal
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncMode 决定 WAL 在写入之后何时调用 fsync 将数据持久化到磁盘
+type SyncMode int
+
+const (
+	// SyncNever 从不主动 fsync，由操作系统决定何时落盘，进程崩溃不丢数据，但掉电可能丢失最近的写入
+	SyncNever SyncMode = iota
+	// SyncEveryWrite 每次写入之后都 fsync
+	SyncEveryWrite
+	// SyncInterval 由后台每隔 Interval 时间 fsync 一次
+	SyncInterval
+	// SyncBytes 累计写入 Bytes 字节未落盘的数据之后 fsync 一次
+	SyncBytes
+)
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncNever:
+		return "Never"
+	case SyncEveryWrite:
+		return "EveryWrite"
+	case SyncInterval:
+		return "Interval"
+	case SyncBytes:
+		return "Bytes"
+	default:
+		return fmt.Sprintf("SyncMode(%d)", int(m))
+	}
+}
+
+// SyncPolicy 是数据库级别的 WAL 落盘策略。单次写入可以通过 Sync 选项强制 fsync，不受策略限制。
+type SyncPolicy struct {
+	Mode     SyncMode
+	Interval time.Duration // SyncInterval 模式下两次 fsync 的间隔
+	Bytes    int64         // SyncBytes 模式下触发 fsync 的未落盘字节数
+}
+
+// DefaultSyncPolicy 与之前的行为保持一致，从不主动 fsync
+func DefaultSyncPolicy() SyncPolicy {
+	return SyncPolicy{Mode: SyncNever}
+}
+
+// Validate 检查策略参数是否合法
+func (p SyncPolicy) Validate() error {
+	switch p.Mode {
+	case SyncNever, SyncEveryWrite:
+		return nil
+	case SyncInterval:
+		if p.Interval <= 0 {
+			return fmt.Errorf("invalid sync interval: %s", p.Interval)
+		}
+		return nil
+	case SyncBytes:
+		if p.Bytes <= 0 {
+			return fmt.Errorf("invalid sync bytes: %d", p.Bytes)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown sync mode: %s", p.Mode)
+	}
+}
+
+// shouldSync 判断追加写入之后是否需要立即 fsync，SyncInterval 模式由后台定时落盘
+func (p SyncPolicy) shouldSync(unsynced int64) bool {
+	switch p.Mode {
+	case SyncEveryWrite:
+		return true
+	case SyncBytes:
+		return unsynced >= p.Bytes
+	default:
+		return false
+	}
+}
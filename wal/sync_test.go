@@ -0,0 +1,77 @@
+package wal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/xmh1011/go-lsm/kv"
+	"github.com/xmh1011/go-lsm/wal"
+)
+
+func TestSyncPolicyValidate(t *testing.T) {
+	assert.NoError(t, wal.DefaultSyncPolicy().Validate())
+	assert.NoError(t, wal.SyncPolicy{Mode: wal.SyncEveryWrite}.Validate())
+	assert.NoError(t, wal.SyncPolicy{Mode: wal.SyncInterval, Interval: time.Millisecond}.Validate())
+	assert.NoError(t, wal.SyncPolicy{Mode: wal.SyncBytes, Bytes: 1}.Validate())
+
+	assert.Error(t, wal.SyncPolicy{Mode: wal.SyncInterval}.Validate())
+	assert.Error(t, wal.SyncPolicy{Mode: wal.SyncBytes}.Validate())
+	assert.Error(t, wal.SyncPolicy{Mode: wal.SyncMode(99)}.Validate())
+	assert.Equal(t, "SyncMode(99)", wal.SyncMode(99).String())
+}
+
+func TestWALSyncPolicies(t *testing.T) {
+	pair := []kv.KeyValuePair{{Key: "k", Value: []byte("v")}}
+
+	tests := []struct {
+		name   string
+		policy wal.SyncPolicy
+		synced bool // 写入之后是否立即落盘
+	}{
+		{"never", wal.SyncPolicy{Mode: wal.SyncNever}, false},
+		{"every write", wal.SyncPolicy{Mode: wal.SyncEveryWrite}, true},
+		{"interval", wal.SyncPolicy{Mode: wal.SyncInterval, Interval: time.Hour}, false},
+		{"bytes", wal.SyncPolicy{Mode: wal.SyncBytes, Bytes: 1 << 20}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := wal.NewWAL(1, t.TempDir())
+			assert.NoError(t, err)
+			defer w.Close()
+			w.SetSyncPolicy(tt.policy)
+
+			assert.NoError(t, w.AppendBatch(pair))
+			assert.NoError(t, w.AppendBatch(pair))
+			assert.Equal(t, tt.synced, w.UnsyncedBytes() == 0)
+
+			// 强制落盘不受策略限制
+			assert.NoError(t, w.AppendBatchWithSync(pair, true))
+			assert.Zero(t, w.UnsyncedBytes())
+		})
+	}
+}
+
+func TestWALSyncBytesThreshold(t *testing.T) {
+	w, err := wal.NewWAL(1, t.TempDir())
+	assert.NoError(t, err)
+	defer w.Close()
+
+	pair := []kv.KeyValuePair{{Key: "k", Value: []byte("v")}}
+	assert.NoError(t, w.AppendBatch(pair))
+	recordSize := w.UnsyncedBytes()
+	assert.NoError(t, w.SyncIfDirty())
+	assert.Zero(t, w.UnsyncedBytes())
+
+	// 累计超过三条记录的大小之后落盘
+	w.SetSyncPolicy(wal.SyncPolicy{Mode: wal.SyncBytes, Bytes: 3 * recordSize})
+	assert.NoError(t, w.AppendBatch(pair))
+	assert.NoError(t, w.AppendBatch(pair))
+	assert.Equal(t, 2*recordSize, w.UnsyncedBytes())
+	assert.NoError(t, w.AppendBatch(pair))
+	assert.Zero(t, w.UnsyncedBytes())
+
+	// 没有未落盘的数据时 SyncIfDirty 什么都不做
+	assert.NoError(t, w.SyncIfDirty())
+}
@@ -9,17 +9,28 @@
 // Once the data in memory (MemTable) is flushed to disk in a more structured format (like an SSTable),
 // the corresponding WAL file can be safely deleted. In LSM-based systems,
 // WAL plays a crucial role in achieving durability, fault tolerance, and write efficiency.
+//
+// WAL 文件由若干条记录组成，每条记录对应一次原子写入（单条写入或一个 WriteBatch），
+// 恢复时一条记录要么整体重放，要么整体丢弃，不会出现批量写入只生效一部分的情况。
+/*
+┌──────────────┬─────────────┬───────────┬───────────────────────────────────────┐
+│ length (4B)  │ CRC32C (4B) │ type (1B) │ payload: count (4B) | pair 1 | ...    │
+└──────────────┴─────────────┴───────────┴───────────────────────────────────────┘
+length 为 payload 的长度，CRC32C 覆盖 type 和 payload，整数均为小端编码
+*/
 
 package wal
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
+	"hash/crc32"
 	"os"
 	"path/filepath"
+	"sync"
 
-	"github.com/xmh1011/go-lsm/config"
 	"github.com/xmh1011/go-lsm/kv"
 	"github.com/xmh1011/go-lsm/log"
 )
@@ -27,27 +38,39 @@ import (
 const (
 	defaultWALFileMode   = 0666
 	defaultWALFileSuffix = "wal"
+	recordHeaderSize     = 4 + 4 + 1
+	maxRecordSize        = 1 << 31
+)
+
+// 记录类型。0 不是合法类型，用于识别预分配或被清零的文件区域
+const recordTypeBatch byte = 1
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrCorruption 表示 WAL 中存在校验失败、不完整或无法解析的记录
+	ErrCorruption = errors.New("wal: corrupted record")
+
+	// errIncompleteRecord 表示记录超出了文件末尾，通常是写入时掉电造成的
+	errIncompleteRecord = errors.New("incomplete record")
 )
 
 // WAL implementation
-// 在项目设计中，每个memtable拥有独立WAL且单线程写入，因此不需要考虑加锁的问题
-// 如果WAL是单实例、全局持久化日志文件（common to all MemTables），多个协程可能同时写入或重放数据，必须加锁。
+// 在项目设计中，每个memtable拥有独立WAL，写入由上层串行化（组提交的 leader 独占写入）；
+// 但后台的定时落盘可能与写入并发执行，因此落盘状态由 mu 保护。
 type WAL struct {
 	file *os.File
 	path string
-}
 
-func init() {
-	// Create the WAL directory if it doesn't exist
-	if err := os.MkdirAll(config.GetWALPath(), os.ModePerm); err != nil {
-		log.Errorf("failed to create WAL directory: %s", err.Error())
-	}
+	mu       sync.Mutex
+	policy   SyncPolicy
+	unsynced int64 // 最近一次 fsync 之后写入的字节数
 }
 
 // NewWAL creates a new instance of WAL for the specified memtable id and a directory path.
 // This implementation has WAL for each memtable.
 // Every write to memtable involves writing every key/value pair from the batch to WAL.
-// This implementation writes every key/value pair from the batch to WAL individually.
+// This implementation writes all key/value pairs of a batch to WAL as a single record.
 func NewWAL(id uint64, path string) (*WAL, error) {
 	wal := &WAL{
 		path: CreateWalPath(id, path),
@@ -68,7 +91,46 @@ func CreateWalPath(id uint64, walDirectoryPath string) string {
 
 // Sync flushes the file to disk.
 func (w *WAL) Sync() error {
-	return w.file.Sync()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if err := w.file.Sync(); err != nil {
+		log.Errorf("failed to sync wal %s: %s", w.path, err.Error())
+		return fmt.Errorf("failed to sync wal %s: %w", w.path, err)
+	}
+	w.unsynced = 0
+	return nil
+}
+
+// SyncIfDirty 在存在未落盘的数据时 fsync，用于定时落盘
+func (w *WAL) SyncIfDirty() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.unsynced == 0 {
+		return nil
+	}
+	return w.syncLocked()
+}
+
+// SetSyncPolicy 设置写入之后的落盘策略
+func (w *WAL) SetSyncPolicy(policy SyncPolicy) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.policy = policy
+}
+
+// UnsyncedBytes 返回最近一次 fsync 之后写入的字节数
+func (w *WAL) UnsyncedBytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.unsynced
 }
 
 // Close closes the WAL file.
@@ -81,41 +143,90 @@ func (w *WAL) DeleteFile() error {
 	return os.Remove(w.path)
 }
 
-// Append writes a KeyValuePair in JSON format to the WAL file.
+// Append writes a KeyValuePair to the WAL file as a single record.
 func (w *WAL) Append(pair kv.KeyValuePair) error {
-	if err := pair.EncodeTo(w.file); err != nil {
-		log.Errorf("failed to write wal, key: %s, error: %s", pair.Key, err.Error())
-		return fmt.Errorf("failed to write wal, key: %s: %w", pair.Key, err)
+	return w.AppendBatch([]kv.KeyValuePair{pair})
+}
+
+// AppendBatch 将一组 KeyValuePair 编码为一条记录后一次性写入 WAL 文件，恢复时整体重放。
+// 写入之后按照落盘策略决定是否 fsync。
+func (w *WAL) AppendBatch(pairs []kv.KeyValuePair) error {
+	return w.AppendBatchWithSync(pairs, false)
+}
+
+// AppendBatchWithSync 与 AppendBatch 相同，sync 为 true 时无论落盘策略如何都在写入之后 fsync。
+func (w *WAL) AppendBatchWithSync(pairs []kv.KeyValuePair, sync bool) error {
+	payload := new(bytes.Buffer)
+	if err := binary.Write(payload, binary.LittleEndian, uint32(len(pairs))); err != nil {
+		log.Errorf("failed to encode wal record count: %s", err.Error())
+		return fmt.Errorf("failed to encode wal record count: %w", err)
+	}
+	for _, pair := range pairs {
+		if err := pair.EncodeTo(payload); err != nil {
+			log.Errorf("failed to encode wal record, key: %s, error: %s", pair.Key, err.Error())
+			return fmt.Errorf("failed to encode wal record, key: %s: %w", pair.Key, err)
+		}
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	record[8] = recordTypeBatch
+	record = append(record, payload.Bytes()...)
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], crcTable))
+	// 整条记录通过一次 Write 追加，避免与其他记录交错
+	if _, err := w.file.Write(record); err != nil {
+		log.Errorf("failed to write wal, record count: %d, error: %s", len(pairs), err.Error())
+		return fmt.Errorf("failed to write wal, record count: %d: %w", len(pairs), err)
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.unsynced += int64(len(record))
+	if sync || w.policy.shouldSync(w.unsynced) {
+		return w.syncLocked()
+	}
 	return nil
 }
 
-// Recover reads the WAL file and calls the callback function for each KeyValuePair.
-func Recover(path string, callback func(pair kv.KeyValuePair)) (*WAL, error) {
-	file, err := os.OpenFile(path, os.O_RDONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Errorf("open wal file failed: %s", err.Error())
-		return nil, fmt.Errorf("open wal file failed: %w", err)
+// decodeRecord 从 data 的起始位置解码一条完整的记录。
+// 返回值 size 为记录占用的字节数；无法确定记录边界时 size 为 0，调用方无法跳过该记录。
+func decodeRecord(data []byte) (pairs []kv.KeyValuePair, size int, err error) {
+	if len(data) < recordHeaderSize {
+		return nil, 0, errIncompleteRecord
 	}
-	raw, err := io.ReadAll(file)
-	if err != nil {
-		log.Errorf("read wal file failed: %s", err.Error())
-		return nil, fmt.Errorf("read wal file failed: %w", err)
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("invalid record length: %d", length)
+	}
+	if uint64(length) > uint64(len(data)-recordHeaderSize) {
+		return nil, 0, errIncompleteRecord
+	}
+	size = recordHeaderSize + int(length)
+
+	checksum := binary.LittleEndian.Uint32(data[4:8])
+	if actual := crc32.Checksum(data[8:size], crcTable); actual != checksum {
+		return nil, size, fmt.Errorf("checksum mismatch: expected %08x, actual %08x", checksum, actual)
+	}
+	if recordType := data[8]; recordType != recordTypeBatch {
+		return nil, size, fmt.Errorf("unknown record type: %d", recordType)
 	}
 
-	buf := bytes.NewReader(raw)
-	for buf.Len() > 0 {
+	body := bytes.NewReader(data[recordHeaderSize:size])
+	var count uint32
+	if err = binary.Read(body, binary.LittleEndian, &count); err != nil {
+		return nil, size, fmt.Errorf("decode record count: %w", err)
+	}
+	pairs = make([]kv.KeyValuePair, 0)
+	for i := uint32(0); i < count; i++ {
 		var pair kv.KeyValuePair
-		err := pair.DecodeFrom(buf)
-		if err != nil {
-			log.Errorf("failed to read wal %s, error: %s", file.Name(), err.Error())
-			return nil, fmt.Errorf("failed to read wal %s: %w", file.Name(), err)
+		if err = pair.DecodeFrom(body); err != nil {
+			return nil, size, fmt.Errorf("decode record pair %d: %w", i, err)
 		}
-
-		// 回调处理有效数据
-		callback(pair)
+		pairs = append(pairs, pair)
+	}
+	if body.Len() != 0 {
+		return nil, size, fmt.Errorf("record has %d trailing bytes", body.Len())
 	}
 
-	return &WAL{file: file, path: path}, nil
+	return pairs, size, nil
 }
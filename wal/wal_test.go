@@ -21,7 +21,7 @@ func TestWALAppendAndRecover(t *testing.T) {
 	// 准备写入的测试数据
 	records := []kv.KeyValuePair{
 		{Key: "k1", Value: []byte("v1")},
-		{Key: "k2", Value: kv.DeletedValue},
+		{Key: "k2", Kind: kv.KindDelete},
 		{Key: "k3", Value: []byte("v3")},
 	}
 
@@ -54,3 +54,40 @@ func TestWALAppendAndRecover(t *testing.T) {
 	_, statErr := os.Stat(walPath)
 	assert.True(t, os.IsNotExist(statErr), "WAL file should be deleted")
 }
+
+func TestWALAppendBatchIsAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+
+	w, err := wal.NewWAL(2, tempDir)
+	assert.NoError(t, err)
+
+	first := []kv.KeyValuePair{{Key: "k1", Value: []byte("v1"), Seq: 1}}
+	batch := []kv.KeyValuePair{
+		{Key: "k2", Value: []byte("v2"), Seq: 2},
+		{Key: "k3", Seq: 3, Kind: kv.KindDelete},
+	}
+	assert.NoError(t, w.AppendBatch(first))
+	assert.NoError(t, w.AppendBatch(batch))
+	assert.NoError(t, w.Close())
+
+	walPath := filepath.Join(tempDir, "2.wal")
+	var recovered []kv.KeyValuePair
+	recoveredWAL, err := wal.Recover(walPath, func(pair kv.KeyValuePair) {
+		recovered = append(recovered, pair)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, recoveredWAL.Close())
+	assert.Equal(t, append(first, batch...), recovered)
+
+	// 截断最后一条记录，批次中的任何一条数据都不应被重放
+	info, err := os.Stat(walPath)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(walPath, info.Size()-1))
+
+	recovered = nil
+	_, _, err = wal.RecoverWithMode(walPath, wal.AbsoluteConsistency, func(pair kv.KeyValuePair) {
+		recovered = append(recovered, pair)
+	})
+	assert.ErrorIs(t, err, wal.ErrCorruption)
+	assert.Equal(t, first, recovered)
+}